@@ -0,0 +1,359 @@
+// Lambda server for .NET handlers. One dotnet process lives as long as the
+// sandbox and loads the handler assemblies once, so an invocation is a method
+// call. Like the Python server, it serves HTTP on a unix socket in the host
+// directory of the sandbox; each POST body is the JSON event and the response
+// body is the JSON result of the handler.
+//
+// The handler is named by the "entrypoint" field of the handler metadata, as
+// <type>.<method> with the full name of the type, e.g. "Acme.Hello.Handle".
+// By default it is the Handle method of the only type of the handler
+// assemblies (the *.dll files of /handler) that declares one. The method must
+// be public, of the form
+//
+//     string Handle(string input)
+//
+// taking and returning JSON; it may also return Task<string>. An instance
+// method needs a public parameterless constructor, and the instance is created
+// once and reused.
+using System;
+using System.Collections.Generic;
+using System.Diagnostics;
+using System.IO;
+using System.Linq;
+using System.Net.Sockets;
+using System.Reflection;
+using System.Runtime.Loader;
+using System.Text;
+using System.Text.Json;
+using System.Text.RegularExpressions;
+using System.Threading.Tasks;
+
+public static class Dispatcher
+{
+    const string HostPath = "/host";
+    const string SockPath = HostPath + "/ol.sock";
+    const string StdoutPath = HostPath + "/stdout";
+    const string StderrPath = HostPath + "/stderr";
+    const string HandlerDir = "/handler";
+    const string MetaPath = HandlerDir + "/lambda-config.json";
+    const string DefaultMethod = "Handle";
+
+    // handler output of a single request is captured when the worker sets
+    // CaptureHeader, and returned base64 encoded in LogsHeader
+    const string CaptureHeader = "x-ol-capture-logs";
+    const string LogsHeader = "X-OL-Logs";
+    const int MaxCapture = 64 * 1024;
+
+    // time spent loading the handler is reported in InitHeader on the first
+    // response
+    const string InitHeader = "X-OL-Init";
+
+    // trace context and ID of the current request are exposed to handler
+    // code through these environment variables
+    static readonly Dictionary<string, string> TraceEnv = new Dictionary<string, string>
+    {
+        ["traceparent"] = "TRACEPARENT",
+        ["tracestate"] = "TRACESTATE",
+        ["x-request-id"] = "OL_REQUEST_ID",
+    };
+
+    static readonly Dictionary<int, string> Reasons = new Dictionary<int, string>
+    {
+        [200] = "OK",
+        [400] = "Bad Request",
+        [500] = "Internal Server Error",
+    };
+
+    static object handler;
+    static MethodInfo handle;
+    static string initError;
+    static string initReport;
+    static Tee stdout, stderr;
+
+    // writes to an output file, and to a capture buffer while one is set
+    class Tee : TextWriter
+    {
+        readonly TextWriter output;
+        public StringBuilder Capture;
+
+        public Tee(TextWriter output)
+        {
+            this.output = output;
+        }
+
+        public override Encoding Encoding => output.Encoding;
+
+        public override void Write(char value)
+        {
+            lock (this)
+            {
+                output.Write(value);
+                Capture?.Append(value);
+            }
+        }
+
+        public override void Write(string value)
+        {
+            lock (this)
+            {
+                output.Write(value);
+                Capture?.Append(value);
+            }
+        }
+
+        public override void Flush()
+        {
+            output.Flush();
+        }
+    }
+
+    // assemblies referenced by the handler are looked up next to it
+    static Assembly Resolve(AssemblyLoadContext context, AssemblyName name)
+    {
+        string path = Path.Combine(HandlerDir, name.Name + ".dll");
+        return File.Exists(path) ? context.LoadFromAssemblyPath(path) : null;
+    }
+
+    static void Load()
+    {
+        var watch = Stopwatch.StartNew();
+
+        string[] files = Directory.GetFiles(HandlerDir, "*.dll");
+        if (files.Length == 0)
+        {
+            throw new InvalidOperationException("no assembly in " + HandlerDir);
+        }
+        Array.Sort(files, StringComparer.Ordinal);
+        AssemblyLoadContext.Default.Resolving += Resolve;
+        var assemblies = files.Select(f => AssemblyLoadContext.Default.LoadFromAssemblyPath(f)).ToList();
+
+        Type type;
+        string methodName = DefaultMethod;
+        string entrypoint = Entrypoint();
+        if (entrypoint != null)
+        {
+            int i = entrypoint.LastIndexOf('.');
+            string typeName = entrypoint.Substring(0, i);
+            methodName = entrypoint.Substring(i + 1);
+            type = assemblies.Select(a => a.GetType(typeName)).FirstOrDefault(t => t != null);
+            if (type == null)
+            {
+                throw new InvalidOperationException("entrypoint not found: no type " + typeName + " in the assemblies of the handler");
+            }
+        }
+        else
+        {
+            var types = assemblies.SelectMany(a => a.GetExportedTypes())
+                .Where(t => t.GetMethod(DefaultMethod, new[] { typeof(string) }) != null)
+                .ToList();
+            if (types.Count != 1)
+            {
+                throw new InvalidOperationException(string.Format(
+                    "{0} types declare {1}(string); set the entrypoint in lambda-config.json", types.Count, DefaultMethod));
+            }
+            type = types[0];
+        }
+
+        handle = type.GetMethod(methodName, new[] { typeof(string) });
+        if (handle == null)
+        {
+            throw new InvalidOperationException("entrypoint not found: type " + type.FullName +
+                " has no method " + methodName + "(string)");
+        }
+        if (!handle.IsStatic)
+        {
+            handler = Activator.CreateInstance(type);
+        }
+
+        initReport = string.Format(System.Globalization.CultureInfo.InvariantCulture,
+            "{{\"init_ms\": {0}, \"imports\": []}}", watch.Elapsed.TotalMilliseconds);
+    }
+
+    // returns the entrypoint in the handler metadata, or null
+    static string Entrypoint()
+    {
+        if (!File.Exists(MetaPath))
+        {
+            return null;
+        }
+        var m = Regex.Match(File.ReadAllText(MetaPath), "\"entrypoint\"\\s*:\\s*\"([^\"]+)\"");
+        return m.Success ? m.Groups[1].Value : null;
+    }
+
+    static string Invoke(string body)
+    {
+        object result = handle.Invoke(handler, new object[] { body });
+        if (result is Task<string> task)
+        {
+            return task.GetAwaiter().GetResult();
+        }
+        return (string)result;
+    }
+
+    static void SetTraceEnv(Dictionary<string, string> headers)
+    {
+        foreach (var p in TraceEnv)
+        {
+            headers.TryGetValue(p.Key, out string value);
+            Environment.SetEnvironmentVariable(p.Value, string.IsNullOrEmpty(value) ? null : value);
+        }
+    }
+
+    static (int, string) Run(Dictionary<string, string> headers, string body)
+    {
+        if (initError != null)
+        {
+            return (500, initError);
+        }
+        try
+        {
+            JsonDocument.Parse(body).Dispose();
+        }
+        catch (JsonException)
+        {
+            return (400, "bad POST data: \"" + body + "\"");
+        }
+
+        SetTraceEnv(headers);
+        try
+        {
+            return (200, Invoke(body));
+        }
+        catch (TargetInvocationException e)
+        {
+            return (500, e.InnerException.ToString());
+        }
+        catch (Exception e)
+        {
+            return (500, e.ToString());
+        }
+        finally
+        {
+            Console.Out.Flush();
+            Console.Error.Flush();
+        }
+    }
+
+    static string ReadLine(Stream stream)
+    {
+        var line = new List<byte>();
+        int b;
+        while ((b = stream.ReadByte()) != -1 && b != '\n')
+        {
+            line.Add((byte)b);
+        }
+        if (b == -1 && line.Count == 0)
+        {
+            return null;
+        }
+        return Encoding.ASCII.GetString(line.ToArray()).TrimEnd('\r');
+    }
+
+    // serves one request per connection
+    static void Serve(Stream stream)
+    {
+        if (ReadLine(stream) == null)
+        {
+            return;
+        }
+        var headers = new Dictionary<string, string>();
+        string line;
+        while (!string.IsNullOrEmpty(line = ReadLine(stream)))
+        {
+            int i = line.IndexOf(':');
+            if (i > 0)
+            {
+                headers[line.Substring(0, i).Trim().ToLowerInvariant()] = line.Substring(i + 1).Trim();
+            }
+        }
+        headers.TryGetValue("content-length", out string length);
+        var body = new byte[length == null ? 0 : int.Parse(length)];
+        for (int n = 0, k; n < body.Length; n += k)
+        {
+            if ((k = stream.Read(body, n, body.Length - n)) == 0)
+            {
+                throw new EndOfStreamException("request body cut short");
+            }
+        }
+
+        var respHeaders = new Dictionary<string, string>();
+        if (headers.ContainsKey(CaptureHeader))
+        {
+            stdout.Capture = stderr.Capture = new StringBuilder();
+        }
+        var (code, result) = Run(headers, Encoding.UTF8.GetString(body));
+        if (stdout.Capture != null)
+        {
+            byte[] logs = Encoding.UTF8.GetBytes(stdout.Capture.ToString());
+            int from = Math.Max(0, logs.Length - MaxCapture);
+            respHeaders[LogsHeader] = Convert.ToBase64String(logs, from, logs.Length - from);
+            stdout.Capture = stderr.Capture = null;
+        }
+        if (initReport != null)
+        {
+            respHeaders[InitHeader] = initReport;
+            initReport = null;
+        }
+
+        byte[] bytes = Encoding.UTF8.GetBytes(result ?? "null");
+        respHeaders["Content-Type"] = "application/json";
+        respHeaders["Content-Length"] = bytes.Length.ToString();
+        respHeaders["Connection"] = "close";
+
+        var head = new StringBuilder();
+        head.AppendFormat("HTTP/1.1 {0} {1}\r\n", code, Reasons[code]);
+        foreach (var h in respHeaders)
+        {
+            head.AppendFormat("{0}: {1}\r\n", h.Key, h.Value);
+        }
+        head.Append("\r\n");
+        byte[] headBytes = Encoding.ASCII.GetBytes(head.ToString());
+        stream.Write(headBytes, 0, headBytes.Length);
+        stream.Write(bytes, 0, bytes.Length);
+    }
+
+    public static void Main(string[] args)
+    {
+        stdout = new Tee(new StreamWriter(StdoutPath) { AutoFlush = true });
+        stderr = new Tee(new StreamWriter(StderrPath) { AutoFlush = true });
+        Console.SetOut(stdout);
+        Console.SetError(stderr);
+
+        // a broken handler is reported on every request rather than by a
+        // sandbox that never comes up
+        try
+        {
+            Load();
+        }
+        catch (Exception e)
+        {
+            initError = (e is TargetInvocationException ? e.InnerException : e).ToString();
+            Console.Error.WriteLine(initError);
+        }
+
+        File.Delete(SockPath);
+        using (var server = new Socket(AddressFamily.Unix, SocketType.Stream, ProtocolType.Unspecified))
+        {
+            server.Bind(new UnixDomainSocketEndPoint(SockPath));
+            server.Listen(128);
+
+            // requests are served one at a time, so that captured output and
+            // trace variables belong to a single request
+            while (true)
+            {
+                using (var conn = server.Accept())
+                using (var stream = new NetworkStream(conn))
+                {
+                    try
+                    {
+                        Serve(stream);
+                    }
+                    catch (Exception e) when (e is IOException || e is SocketException || e is FormatException)
+                    {
+                        Console.Error.WriteLine("connection failed: " + e.Message);
+                    }
+                }
+            }
+        }
+    }
+}
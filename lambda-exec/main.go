@@ -0,0 +1,42 @@
+// ol-exec is the lambda server of the exec runtime. For each request, it runs
+// the handler executable of the bundle with the event on its stdin, and
+// returns what the executable writes to stdout as the result. A non-zero exit
+// status fails the invocation with the stderr of the executable.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/open-lambda/open-lambda/lambda-go/lambda"
+)
+
+// EXECUTABLE is run for each request.
+const EXECUTABLE = "/handler/handler"
+
+func handle(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, EXECUTABLE)
+	cmd.Dir = "/handler"
+	cmd.Stdin = bytes.NewReader(event)
+	cmd.Stdout = &stdout
+	cmd.Stderr = io.MultiWriter(&stderr, os.Stderr)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr.Bytes())
+	}
+
+	result := bytes.TrimSpace(stdout.Bytes())
+	if !json.Valid(result) {
+		return nil, fmt.Errorf("handler output is not JSON: %q", result)
+	}
+	return json.RawMessage(result), nil
+}
+
+func main() {
+	lambda.Start(handle)
+}
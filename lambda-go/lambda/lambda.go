@@ -0,0 +1,142 @@
+// lambda package is the runtime shim for OpenLambda handlers written in Go.
+// A handler is a main package that passes its function to Start:
+//
+//	package main
+//
+//	import (
+//		"context"
+//		"encoding/json"
+//
+//		"github.com/open-lambda/open-lambda/lambda-go/lambda"
+//	)
+//
+//	func handle(ctx context.Context, event json.RawMessage) (interface{}, error) {
+//		return "hello", nil
+//	}
+//
+//	func main() {
+//		lambda.Start(handle)
+//	}
+//
+// The bundle ships either the compiled binary, named "handler", or the
+// source, which the worker builds when it pulls the code.
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+)
+
+const (
+	HOST_PATH   = "/host"
+	SOCK_PATH   = HOST_PATH + "/ol.sock"
+	STDOUT_PATH = HOST_PATH + "/stdout"
+	STDERR_PATH = HOST_PATH + "/stderr"
+)
+
+// trace context and ID of the current request are exposed through these
+// environment variables, as in the other runtimes
+var traceEnv = map[string]string{
+	"Traceparent":  "TRACEPARENT",
+	"Tracestate":   "TRACESTATE",
+	"X-Request-Id": "OL_REQUEST_ID",
+}
+
+// Handler handles one invocation. The event is the JSON body of the request,
+// and the result is encoded to JSON as the body of the response. A non-nil
+// error fails the invocation with a 500.
+type Handler func(ctx context.Context, event json.RawMessage) (interface{}, error)
+
+type contextKey int
+
+const requestIdKey contextKey = 0
+
+// RequestId returns the ID of the request being handled in ctx.
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey).(string)
+	return id
+}
+
+// server serves a Handler. Requests are handled one at a time, like in the
+// other runtimes, so the environment belongs to a single request.
+type server struct {
+	mutex   sync.Mutex
+	handler Handler
+}
+
+// NewServer returns an http.Handler speaking the sandbox protocol on behalf
+// of h. Most handlers call Start instead.
+func NewServer(h Handler) http.Handler {
+	return &server{handler: h}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !json.Valid(data) {
+		http.Error(w, fmt.Sprintf("bad POST data: %q", data), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for header, env := range traceEnv {
+		if value := r.Header.Get(header); value != "" {
+			os.Setenv(env, value)
+		} else {
+			os.Unsetenv(env)
+		}
+	}
+	ctx := context.WithValue(r.Context(), requestIdKey, r.Header.Get("X-Request-Id"))
+
+	result, err := s.call(ctx, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(body)
+}
+
+// call runs the handler, turning a panic into an error.
+func (s *server) call(ctx context.Context, event json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return s.handler(ctx, event)
+}
+
+// Start serves h on the socket of the sandbox. It never returns.
+func Start(h Handler) {
+	if f, err := os.OpenFile(STDOUT_PATH, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err == nil {
+		os.Stdout = f
+	}
+	if f, err := os.OpenFile(STDERR_PATH, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err == nil {
+		os.Stderr = f
+		log.SetOutput(f)
+	}
+
+	os.Remove(SOCK_PATH)
+	l, err := net.Listen("unix", SOCK_PATH)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(http.Serve(l, NewServer(h)))
+}
@@ -0,0 +1,52 @@
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func invoke(h Handler, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("X-Request-Id", "req-1")
+	w := httptest.NewRecorder()
+	NewServer(h).ServeHTTP(w, r)
+	return w
+}
+
+func TestServer(t *testing.T) {
+	echo := func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		var v map[string]interface{}
+		if err := json.Unmarshal(event, &v); err != nil {
+			return nil, err
+		}
+		v["id"] = RequestId(ctx)
+		return v, nil
+	}
+	w := invoke(echo, `{"name": "Alice"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"id":"req-1","name":"Alice"}` {
+		t.Fatalf("unexpected response %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := invoke(echo, `{`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for bad JSON, got %d", w.Code)
+	}
+
+	fail := func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		return nil, errors.New("boom")
+	}
+	if w := invoke(fail, `{}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 on error, got %d", w.Code)
+	}
+
+	crash := func(ctx context.Context, event json.RawMessage) (interface{}, error) {
+		panic("oops")
+	}
+	if w := invoke(crash, `{}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 on panic, got %d", w.Code)
+	}
+}
@@ -0,0 +1,244 @@
+import com.sun.net.httpserver.HttpExchange;
+import com.sun.net.httpserver.HttpServer;
+
+import java.io.ByteArrayOutputStream;
+import java.io.File;
+import java.io.FileOutputStream;
+import java.io.IOException;
+import java.io.InputStream;
+import java.io.OutputStream;
+import java.io.PrintStream;
+import java.io.PrintWriter;
+import java.io.StringWriter;
+import java.lang.reflect.InvocationTargetException;
+import java.lang.reflect.Method;
+import java.net.InetSocketAddress;
+import java.net.URL;
+import java.net.URLClassLoader;
+import java.nio.charset.StandardCharsets;
+import java.util.ArrayList;
+import java.util.Base64;
+import java.util.List;
+import java.util.concurrent.Executors;
+import java.util.jar.JarFile;
+
+/**
+ * Lambda server for Java handlers. One JVM lives as long as the sandbox and
+ * loads the handler JARs once, so an invocation is a method call.
+ *
+ * The handler is named by the "entrypoint" field of the handler metadata, as
+ * <class>.<method>; by default it is the "handle" method of the Main-Class of
+ * the first JAR in /handler. The class must have a public no-argument
+ * constructor and the method must be public, of the form
+ *
+ *     String handle(String event)
+ *
+ * taking and returning JSON. The instance is created once and reused.
+ *
+ * The JVM cannot listen on a unix socket, so the dispatcher serves HTTP on a
+ * loopback port and start.sh relays /host/ol.sock to it.
+ */
+public class Dispatcher {
+    static final String HANDLER_DIR = "/handler";
+    static final String META_PATH = HANDLER_DIR + "/lambda-config.json";
+    static final String STDOUT_PATH = "/host/stdout";
+    static final String STDERR_PATH = "/host/stderr";
+
+    // handler output of a single request is captured when the worker sets
+    // CAPTURE_HEADER, and returned base64 encoded in LOGS_HEADER
+    static final String CAPTURE_HEADER = "X-OL-Capture-Logs";
+    static final String LOGS_HEADER = "X-OL-Logs";
+    static final int MAX_CAPTURE = 64 * 1024;
+
+    // time spent loading the handler is reported in INIT_HEADER on the
+    // first response
+    static final String INIT_HEADER = "X-OL-Init";
+
+    // trace context and ID of the current request are exposed to handler
+    // code as system properties (the environment of a JVM is read-only)
+    static final String[][] TRACE_PROPERTIES = {
+        {"traceparent", "ol.traceparent"},
+        {"tracestate", "ol.tracestate"},
+        {"X-Request-Id", "ol.request_id"},
+    };
+
+    static Object handler;
+    static Method handle;
+    static String initReport;
+    static Tee stdout, stderr;
+
+    /** Writes to an output file, and to a capture buffer while one is set. */
+    static class Tee extends OutputStream {
+        final OutputStream out;
+        ByteArrayOutputStream capture;
+
+        Tee(OutputStream out) {
+            this.out = out;
+        }
+
+        public synchronized void write(int b) throws IOException {
+            out.write(b);
+            if (capture != null) {
+                capture.write(b);
+            }
+        }
+
+        public synchronized void write(byte[] b, int off, int len) throws IOException {
+            out.write(b, off, len);
+            if (capture != null) {
+                capture.write(b, off, len);
+            }
+        }
+
+        public void flush() throws IOException {
+            out.flush();
+        }
+    }
+
+    static void load() throws Exception {
+        long start = System.nanoTime();
+
+        File[] files = new File(HANDLER_DIR).listFiles((dir, name) -> name.endsWith(".jar"));
+        if (files == null || files.length == 0) {
+            throw new IllegalStateException("no JAR in " + HANDLER_DIR);
+        }
+        java.util.Arrays.sort(files);
+        List<URL> urls = new ArrayList<>();
+        for (File f : files) {
+            urls.add(f.toURI().toURL());
+        }
+
+        String className = null;
+        String methodName = "handle";
+        String entrypoint = entrypoint();
+        if (entrypoint != null) {
+            int i = entrypoint.lastIndexOf('.');
+            className = entrypoint.substring(0, i);
+            methodName = entrypoint.substring(i + 1);
+        } else {
+            try (JarFile jar = new JarFile(files[0])) {
+                className = jar.getManifest() == null ? null
+                    : jar.getManifest().getMainAttributes().getValue("Main-Class");
+            }
+            if (className == null) {
+                throw new IllegalStateException(files[0] + " has no Main-Class");
+            }
+        }
+
+        ClassLoader loader = new URLClassLoader(urls.toArray(new URL[0]), Dispatcher.class.getClassLoader());
+        Class<?> cls = Class.forName(className, true, loader);
+        handler = cls.getConstructor().newInstance();
+        try {
+            handle = cls.getMethod(methodName, String.class);
+        } catch (NoSuchMethodException e) {
+            throw new IllegalStateException("entrypoint not found: class " + className
+                + " has no method " + methodName + "(String)");
+        }
+
+        double ms = (System.nanoTime() - start) / 1e6;
+        initReport = "{\"init_ms\": " + ms + ", \"imports\": []}";
+    }
+
+    /** Returns the entrypoint in the handler metadata, or null. */
+    static String entrypoint() throws IOException {
+        File meta = new File(META_PATH);
+        if (!meta.exists()) {
+            return null;
+        }
+        String json = new String(java.nio.file.Files.readAllBytes(meta.toPath()), StandardCharsets.UTF_8);
+        java.util.regex.Matcher m = java.util.regex.Pattern
+            .compile("\"entrypoint\"\\s*:\\s*\"([^\"]+)\"").matcher(json);
+        return m.find() ? m.group(1) : null;
+    }
+
+    static void reply(HttpExchange ex, int code, String body) throws IOException {
+        if (initReport != null) {
+            ex.getResponseHeaders().set(INIT_HEADER, initReport);
+            initReport = null;
+        }
+        if (stdout.capture != null) {
+            byte[] logs = stdout.capture.toByteArray();
+            int from = Math.max(0, logs.length - MAX_CAPTURE);
+            byte[] tail = java.util.Arrays.copyOfRange(logs, from, logs.length);
+            ex.getResponseHeaders().set(LOGS_HEADER, Base64.getEncoder().encodeToString(tail));
+            stdout.capture = stderr.capture = null;
+        }
+        byte[] bytes = body.getBytes(StandardCharsets.UTF_8);
+        ex.sendResponseHeaders(code, bytes.length);
+        try (OutputStream os = ex.getResponseBody()) {
+            os.write(bytes);
+        }
+    }
+
+    static String stackTrace(Throwable t) {
+        StringWriter sw = new StringWriter();
+        t.printStackTrace(new PrintWriter(sw));
+        return sw.toString();
+    }
+
+    static void serve(HttpExchange ex) throws IOException {
+        ByteArrayOutputStream body = new ByteArrayOutputStream();
+        try (InputStream is = ex.getRequestBody()) {
+            byte[] buf = new byte[8192];
+            for (int n; (n = is.read(buf)) > 0; ) {
+                body.write(buf, 0, n);
+            }
+        }
+
+        if (ex.getRequestHeaders().getFirst(CAPTURE_HEADER) != null) {
+            ByteArrayOutputStream capture = new ByteArrayOutputStream();
+            stdout.capture = stderr.capture = capture;
+        }
+        for (String[] p : TRACE_PROPERTIES) {
+            String value = ex.getRequestHeaders().getFirst(p[0]);
+            if (value != null) {
+                System.setProperty(p[1], value);
+            } else {
+                System.clearProperty(p[1]);
+            }
+        }
+
+        try {
+            Object result = handle.invoke(handler, body.toString("UTF-8"));
+            System.out.flush();
+            System.err.flush();
+            reply(ex, 200, String.valueOf(result));
+        } catch (InvocationTargetException e) {
+            reply(ex, 500, stackTrace(e.getCause()));
+        } catch (Exception e) {
+            reply(ex, 500, stackTrace(e));
+        }
+    }
+
+    public static void main(String[] args) throws Exception {
+        int port = Integer.parseInt(args[0]);
+
+        stdout = new Tee(new FileOutputStream(STDOUT_PATH));
+        stderr = new Tee(new FileOutputStream(STDERR_PATH));
+        System.setOut(new PrintStream(stdout, true));
+        System.setErr(new PrintStream(stderr, true));
+
+        // a broken handler is reported on every request rather than by a
+        // sandbox that never comes up
+        Exception loadError = null;
+        try {
+            load();
+        } catch (Exception e) {
+            loadError = e;
+            e.printStackTrace();
+        }
+        final String loadFailure = loadError == null ? null : stackTrace(loadError);
+
+        HttpServer server = HttpServer.create(new InetSocketAddress("127.0.0.1", port), 0);
+        server.createContext("/", ex -> {
+            if (loadFailure != null) {
+                reply(ex, 500, loadFailure);
+            } else {
+                serve(ex);
+            }
+        });
+        // requests are served one at a time, like in the other runtimes
+        server.setExecutor(Executors.newSingleThreadExecutor());
+        server.start();
+    }
+}
@@ -0,0 +1,18 @@
+#!/bin/sh
+# Starts the dispatcher JVM and relays the sandbox socket to it.
+#
+# The sandbox is frozen between requests, which stops every JVM thread
+# wherever it is. The flags keep that cheap and predictable: the serial
+# collector has no concurrent GC threads to freeze mid-cycle, adaptive
+# sizing is off so time spent frozen does not skew heap sizing, and C1-only
+# compilation keeps JIT work short so little of it is frozen in flight.
+# PerfDisableSharedMem avoids the hsperfdata mmap on the host mount.
+
+PORT=9000
+SOCK=/host/ol.sock
+
+java -XX:+UseSerialGC -XX:-UseAdaptiveSizePolicy -XX:TieredStopAtLevel=1 \
+    -XX:+PerfDisableSharedMem $JAVA_OPTS -cp /opt/ol Dispatcher $PORT &
+
+rm -f $SOCK
+exec socat UNIX-LISTEN:$SOCK,fork TCP:127.0.0.1:$PORT
@@ -0,0 +1,161 @@
+// Lambda server for Node.js handlers. Like the Python server, it serves HTTP
+// on a unix socket in the host directory of the sandbox; each POST body is
+// the JSON event and the response body is the JSON result of the handler.
+//
+// The handler is the function named by the "entrypoint" field of the handler
+// metadata, as <module>.<function> (index.handler by default, i.e.
+//
+//     exports.handler = function(event) { ... }
+//
+// in /handler/index.js), returning either the result or a promise of it.
+'use strict';
+
+const fs = require('fs');
+const http = require('http');
+const util = require('util');
+
+const HOST_PATH = '/host';
+const SOCK_PATH = HOST_PATH + '/ol.sock';
+const STDOUT_PATH = HOST_PATH + '/stdout';
+const STDERR_PATH = HOST_PATH + '/stderr';
+const HANDLER_DIR = '/handler';
+const META_PATH = HANDLER_DIR + '/lambda-config.json';
+const DEFAULT_ENTRYPOINT = 'index.handler';
+
+// handler output of a single request is captured when the worker sets
+// CAPTURE_HEADER, and returned base64 encoded in LOGS_HEADER
+const CAPTURE_HEADER = 'x-ol-capture-logs';
+const LOGS_HEADER = 'X-OL-Logs';
+const MAX_CAPTURE = 64 * 1024;
+
+// time spent loading the handler is reported in INIT_HEADER on the first
+// response
+const INIT_HEADER = 'X-OL-Init';
+
+// trace context and ID of the current request are exposed to handler code
+// through these environment variables
+const TRACE_ENV = {
+  'traceparent': 'TRACEPARENT',
+  'tracestate': 'TRACESTATE',
+  'x-request-id': 'OL_REQUEST_ID',
+};
+
+let initReport = null;
+let capture = null;
+
+// redirect output to the host directory, copying it to the capture buffer
+// of the current request if there is one
+function redirect(stream, path) {
+  const out = fs.createWriteStream(path);
+  stream.write = function(chunk, encoding, cb) {
+    if (capture !== null) {
+      capture.push(Buffer.from(chunk, encoding));
+    }
+    return out.write(chunk, encoding, cb);
+  };
+}
+redirect(process.stdout, STDOUT_PATH);
+redirect(process.stderr, STDERR_PATH);
+
+function loadEntrypoint() {
+  let entrypoint = DEFAULT_ENTRYPOINT;
+  if (fs.existsSync(META_PATH)) {
+    entrypoint = JSON.parse(fs.readFileSync(META_PATH)).entrypoint || DEFAULT_ENTRYPOINT;
+  }
+  const i = entrypoint.lastIndexOf('.');
+  const module = entrypoint.slice(0, i);
+  const name = entrypoint.slice(i + 1);
+  const fn = require(HANDLER_DIR + '/' + module)[name];
+  if (typeof fn !== 'function') {
+    throw new Error('entrypoint not found: module ' + module + ' has no function ' + name);
+  }
+  return fn;
+}
+
+// a broken handler is reported on every request rather than by a sandbox
+// that never comes up
+const start = process.hrtime();
+let handler = null;
+let loadError = null;
+try {
+  handler = loadEntrypoint();
+} catch (e) {
+  loadError = e.stack || String(e);
+  console.error(loadError);
+}
+const elapsed = process.hrtime(start);
+initReport = JSON.stringify({
+  init_ms: elapsed[0] * 1e3 + elapsed[1] / 1e6,
+  imports: [],
+});
+
+function setTraceEnv(headers) {
+  Object.keys(TRACE_ENV).forEach(function(header) {
+    if (headers[header]) {
+      process.env[TRACE_ENV[header]] = headers[header];
+    } else {
+      delete process.env[TRACE_ENV[header]];
+    }
+  });
+}
+
+function reply(res, code, body) {
+  if (initReport !== null) {
+    res.setHeader(INIT_HEADER, initReport);
+    initReport = null;
+  }
+  if (capture !== null) {
+    const logs = Buffer.concat(capture);
+    res.setHeader(LOGS_HEADER, logs.slice(-MAX_CAPTURE).toString('base64'));
+    capture = null;
+  }
+  res.writeHead(code);
+  res.end(body);
+}
+
+// requests are served one at a time, like the Python server, so that
+// captured output and trace variables belong to a single request
+let queue = Promise.resolve();
+
+function serve(req, res, data) {
+  if (loadError !== null) {
+    reply(res, 500, loadError);
+    return Promise.resolve();
+  }
+
+  let event;
+  try {
+    event = JSON.parse(data);
+  } catch (e) {
+    reply(res, 400, 'bad POST data: "' + data + '"');
+    return Promise.resolve();
+  }
+
+  if (req.headers[CAPTURE_HEADER]) {
+    capture = [];
+  }
+  setTraceEnv(req.headers);
+  return Promise.resolve()
+    .then(function() { return handler(event); })
+    .then(function(result) {
+      reply(res, 200, JSON.stringify(result === undefined ? null : result));
+    }, function(err) {
+      reply(res, 500, err && err.stack ? err.stack : util.inspect(err));
+    });
+}
+
+const server = http.createServer(function(req, res) {
+  const chunks = [];
+  req.on('data', function(chunk) { chunks.push(chunk); });
+  req.on('end', function() {
+    const data = Buffer.concat(chunks).toString();
+    queue = queue.then(function() { return serve(req, res, data); });
+  });
+});
+
+try {
+  fs.unlinkSync(SOCK_PATH);
+} catch (e) {
+  // no stale socket
+}
+server.listen(SOCK_PATH);
@@ -0,0 +1,217 @@
+/*
+ * Forkserver of the Ruby pool, the counterpart of server-pool/nsmodule.
+ *
+ * NS.fdlisten(path) listens on a unix socket at path for the namespace fds of
+ * a sandbox, sent by the worker, forks a server into those namespaces and
+ * answers with its PID. It only returns in the forked server, with 0.
+ *
+ * Unlike the Python forkserver, the listening process cannot join the
+ * namespaces itself: setns fails for the mount namespace of a multithreaded
+ * process, and the Ruby VM runs a timer thread. A single-threaded child joins
+ * them instead, and forks the server, which thus also lands in the PID
+ * namespace of the sandbox.
+ */
+#define _GNU_SOURCE
+#include <ruby.h>
+#include <errno.h>
+#include <sched.h>
+#include <stdio.h>
+#include <string.h>
+#include <unistd.h>
+#include <sys/socket.h>
+#include <sys/types.h>
+#include <sys/un.h>
+#include <sys/wait.h>
+#include <stdlib.h>
+#include <linux/capability.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
+
+/* namespaces to be merged (all but 'user') - MUST merge 'mnt' last */
+#define NUM_NS 6
+
+/* capabilities the forked server drops (see dropprivs) */
+#define DROP_CAPS_ENV "OL_FORK_DROP_CAPS"
+
+static int
+recvfd(int s)
+{
+	int n, fd;
+	char cms[CMSG_SPACE(sizeof(int))], buf[1];
+	struct iovec iov;
+	struct msghdr msg;
+	struct cmsghdr *cmsg;
+
+	iov.iov_base = buf;
+	iov.iov_len = 1;
+
+	memset(&msg, 0, sizeof msg);
+	msg.msg_iov = &iov;
+	msg.msg_iovlen = 1;
+	msg.msg_control = cms;
+	msg.msg_controllen = sizeof cms;
+
+	if ((n = recvmsg(s, &msg, 0)) <= 0)
+		return -1;
+
+	cmsg = CMSG_FIRSTHDR(&msg);
+	if (cmsg == NULL || cmsg->cmsg_type != SCM_RIGHTS)
+		return -1;
+	memmove(&fd, CMSG_DATA(cmsg), sizeof(int));
+
+	return fd;
+}
+
+static void
+closefds(int *fds, int n)
+{
+	int k;
+
+	for (k = 0; k < n; k++)
+		close(fds[k]);
+}
+
+/*
+ * dropprivs runs in the forked server before it runs any handler code. It
+ * drops the capabilities the pool container only has to join the namespaces
+ * of sandboxes, given by the worker as numbers in DROP_CAPS_ENV (with SETPCAP,
+ * needed to shrink the bounding set, last), and sets no_new_privs, so that
+ * handler code runs with the privileges of a sandbox.
+ */
+static int
+dropprivs(void)
+{
+	struct __user_cap_header_struct hdr;
+	struct __user_cap_data_struct data[2];
+	const char *list = getenv(DROP_CAPS_ENV);
+	char *end;
+	long cap;
+
+	memset(&hdr, 0, sizeof hdr);
+	hdr.version = _LINUX_CAPABILITY_VERSION_3;
+	if (syscall(SYS_capget, &hdr, data) == -1)
+		return -1;
+
+	while (list != NULL && *list != '\0') {
+		cap = strtol(list, &end, 10);
+		if (end == list || cap < 0 || cap >= 64)
+			return -1;
+		if (prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) == -1)
+			return -1;
+		data[cap / 32].effective &= ~(1u << (cap % 32));
+		data[cap / 32].permitted &= ~(1u << (cap % 32));
+		data[cap / 32].inheritable &= ~(1u << (cap % 32));
+		list = *end == ',' ? end + 1 : end;
+	}
+
+	if (syscall(SYS_capset, &hdr, data) == -1)
+		return -1;
+	return prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0);
+}
+
+/* runs in the child forked for a request, and never returns there */
+static void
+forkenter(int conn, int *nsfds)
+{
+	char pid[50];
+	pid_t server;
+	int k;
+
+	for (k = 0; k < NUM_NS; k++) {
+		if (setns(nsfds[k], 0) == -1) {
+			perror("setns");
+			_exit(1);
+		}
+	}
+
+	if ((server = fork()) == -1) {
+		perror("fork");
+		_exit(1);
+	}
+	if (server == 0) {
+		if (dropprivs() == -1) {
+			perror("dropprivs");
+			_exit(1);
+		}
+		return;
+	}
+
+	/* the worker reads the PID like that of the Python forkserver */
+	memset(pid, 0, sizeof pid);
+	snprintf(pid, sizeof pid, "%d", server);
+	if (send(conn, pid, sizeof pid, 0) == -1)
+		perror("send");
+	_exit(0);
+}
+
+static VALUE
+ns_fdlisten(VALUE self, VALUE path)
+{
+	struct sockaddr_un local;
+	const char *sockpath = StringValueCStr(path);
+	int s, conn, k, nsfds[NUM_NS];
+	pid_t child;
+
+	if (strlen(sockpath) >= sizeof local.sun_path)
+		rb_raise(rb_eArgError, "socket path too long: %s", sockpath);
+
+	if ((s = socket(AF_UNIX, SOCK_STREAM, 0)) == -1)
+		rb_sys_fail("socket");
+
+	memset(&local, 0, sizeof local);
+	local.sun_family = AF_UNIX;
+	strcpy(local.sun_path, sockpath);
+	unlink(sockpath);
+
+	if (bind(s, (struct sockaddr *)&local, sizeof local) == -1)
+		rb_sys_fail("bind");
+	if (listen(s, 5) == -1)
+		rb_sys_fail("listen");
+
+	for (;;) {
+		if ((conn = accept(s, NULL, NULL)) == -1) {
+			if (errno == EINTR)
+				continue;
+			rb_sys_fail("accept");
+		}
+
+		for (k = 0; k < NUM_NS; k++) {
+			if ((nsfds[k] = recvfd(conn)) == -1)
+				break;
+		}
+		if (k < NUM_NS) {
+			fprintf(stderr, "got %d of %d namespace fds\n", k, NUM_NS);
+			closefds(nsfds, k);
+			close(conn);
+			continue;
+		}
+
+		/* Ruby's fork would restart the VM threads in the child */
+		if ((child = fork()) == -1) {
+			perror("fork");
+		} else if (child == 0) {
+			forkenter(conn, nsfds);
+
+			/* the server: leave the forkserver behind */
+			setsid();
+			closefds(nsfds, NUM_NS);
+			close(conn);
+			close(s);
+			rb_thread_atfork();
+			return INT2FIX(0);
+		}
+
+		closefds(nsfds, NUM_NS);
+		close(conn);
+		if (child > 0)
+			waitpid(child, NULL, 0);
+	}
+}
+
+void
+Init_ns(void)
+{
+	VALUE mNS = rb_define_module("NS");
+
+	rb_define_module_function(mNS, "fdlisten", ns_fdlisten, 1);
+}
@@ -0,0 +1,301 @@
+# Lambda server for Ruby handlers. Like the Python server, it serves HTTP on a
+# unix socket in the host directory of the sandbox; each POST body is the JSON
+# event and the response body is the JSON result of the handler.
+#
+# The handler is the method named by the "entrypoint" field of the handler
+# metadata, as <file>.<method> (lambda_func.handler by default, i.e.
+#
+#     def handler(event)
+#       ...
+#     end
+#
+# in /handler/lambda_func.rb).
+#
+# The libraries listed in OL_PRELOAD are required before the handler. Run as
+# "server.rb --pool <num>", the server is the Ruby pool instead: it preloads
+# them once, then starts <num> forkservers that fork servers into sandboxes
+# (see ns/ns.c), so that those servers start with the libraries loaded.
+require 'base64'
+require 'json'
+require 'socket'
+require 'stringio'
+
+HOST_PATH = '/host'
+SOCK_PATH = "#{HOST_PATH}/ol.sock"
+STDOUT_PATH = "#{HOST_PATH}/stdout"
+STDERR_PATH = "#{HOST_PATH}/stderr"
+HANDLER_DIR = '/handler'
+META_PATH = "#{HANDLER_DIR}/lambda-config.json"
+DEFAULT_ENTRYPOINT = 'lambda_func.handler'
+
+# gems vendored with "bundle install --standalone" are set up by this file
+BUNDLE_SETUP = "#{HANDLER_DIR}/bundle/bundler/setup.rb"
+
+# handler output of a single request is captured when the worker sets
+# CAPTURE_HEADER, and returned base64 encoded in LOGS_HEADER
+CAPTURE_HEADER = 'x-ol-capture-logs'
+LOGS_HEADER = 'X-OL-Logs'
+MAX_CAPTURE = 64 * 1024
+
+# time spent in init, and in each file it required, is reported in
+# INIT_HEADER on the first response after init
+INIT_HEADER = 'X-OL-Init'
+MAX_IMPORTS = 50
+
+# trace context and ID of the current request are exposed to handler code
+# through these environment variables
+TRACE_ENV = {
+  'traceparent' => 'TRACEPARENT',
+  'tracestate' => 'TRACESTATE',
+  'x-request-id' => 'OL_REQUEST_ID',
+}
+
+REASONS = {200 => 'OK', 400 => 'Bad Request', 500 => 'Internal Server Error'}
+
+$handler = nil
+$init_error = nil
+$init_report = nil
+
+def preload
+  (ENV['OL_PRELOAD'] || '').split(',').each do |name|
+    begin
+      require name
+    rescue LoadError => e
+      $stderr.puts "could not preload #{name}: #{e.message}"
+    end
+  end
+end
+
+# times the files required while it is active. The self time of a file
+# excludes the files it required in turn, so the report points at the
+# dependency that is actually slow.
+module RequireTimer
+  @times = {} # name -> [self seconds, total seconds]
+  @stack = nil
+
+  def self.measure
+    @stack = []
+    yield
+  ensure
+    @stack = nil
+  end
+
+  def self.timed(name)
+    return yield if @stack.nil?
+
+    @stack.push(0.0)
+    start = Time.now
+    loaded = false
+    begin
+      loaded = yield
+    ensure
+      total = Time.now - start
+      children = @stack.pop
+      @stack[-1] += total unless @stack.empty?
+      # files that were already loaded do not count
+      @times[name] = [total - children, total] if loaded
+    end
+  end
+
+  def self.report(init_seconds)
+    slowest = @times.sort_by { |_, (s, _)| -s }.first(MAX_IMPORTS)
+    JSON.generate(
+      'init_ms' => init_seconds * 1000,
+      'imports' => slowest.map do |name, (s, t)|
+        {'module' => name, 'self_ms' => s * 1000, 'total_ms' => t * 1000}
+      end
+    )
+  end
+end
+
+module Kernel
+  alias_method :ol_untimed_require, :require
+
+  def require(name)
+    RequireTimer.timed(name) { ol_untimed_require(name) }
+  end
+  private :require
+end
+
+def load_entrypoint
+  entrypoint = DEFAULT_ENTRYPOINT
+  if File.exist?(META_PATH)
+    entrypoint = JSON.parse(File.read(META_PATH))['entrypoint'] || DEFAULT_ENTRYPOINT
+  end
+  i = entrypoint.rindex('.')
+  file, name = entrypoint[0...i], entrypoint[i + 1..-1]
+
+  require BUNDLE_SETUP if File.exist?(BUNDLE_SETUP)
+  require File.join(HANDLER_DIR, file)
+  unless respond_to?(name, true)
+    raise "entrypoint not found: #{file}.rb has no method #{name}"
+  end
+  method(name)
+end
+
+def format_error(e)
+  (["#{e.class}: #{e.message}"] + (e.backtrace || [])).join("\n")
+end
+
+# run once, in the sandbox
+def init
+  $stdout.reopen(STDOUT_PATH, 'w')
+  $stderr.reopen(STDERR_PATH, 'w')
+  $stdout.sync = $stderr.sync = true
+
+  $LOAD_PATH.unshift(HANDLER_DIR)
+  # layers are mounted into the sandbox, but unlike servers started by the
+  # worker, forked servers do not get RUBYLIB pointing at them
+  $LOAD_PATH.concat(Dir.glob('/opt/layers/*/ruby').sort - $LOAD_PATH)
+
+  start = Time.now
+  RequireTimer.measure { $handler = load_entrypoint }
+  $init_report = RequireTimer.report(Time.now - start)
+rescue StandardError, ScriptError => e
+  # a broken handler is reported on every request rather than by a
+  # sandbox that never comes up
+  $init_error = format_error(e)
+  $stderr.puts $init_error
+end
+
+def set_trace_env(headers)
+  TRACE_ENV.each do |header, var|
+    if headers[header] && !headers[header].empty?
+      ENV[var] = headers[header]
+    else
+      ENV.delete(var)
+    end
+  end
+end
+
+# writes to both the regular output and the capture buffer. It only derives
+# from StringIO for puts, print and the like, which all go through write.
+class Tee < StringIO
+  def initialize(out, buf)
+    super()
+    @out = out
+    @buf = buf
+  end
+
+  def write(s)
+    @buf.write(s)
+    @out.write(s)
+  end
+
+  def flush
+    @out.flush
+    self
+  end
+end
+
+def invoke(headers, body)
+  return [500, $init_error] if $init_error
+
+  begin
+    event = JSON.parse(body)
+  rescue JSON::ParserError
+    return [400, %(bad POST data: "#{body}")]
+  end
+
+  set_trace_env(headers)
+  begin
+    [200, JSON.generate($handler.call(event))]
+  rescue StandardError, ScriptError => e
+    [500, format_error(e)]
+  end
+end
+
+def read_request(conn)
+  line = conn.gets("\r\n")
+  return nil if line.nil?
+
+  headers = {}
+  while (line = conn.gets("\r\n")) && line != "\r\n"
+    name, value = line.split(':', 2)
+    headers[name.strip.downcase] = value.to_s.strip
+  end
+  [headers, conn.read(headers['content-length'].to_i) || '']
+end
+
+def respond(conn, code, body, headers)
+  if $init_report
+    headers[INIT_HEADER] = $init_report
+    $init_report = nil
+  end
+  headers['Content-Type'] = 'application/json'
+  headers['Content-Length'] = body.bytesize
+  headers['Connection'] = 'close'
+
+  conn.write("HTTP/1.1 #{code} #{REASONS[code]}\r\n")
+  headers.each { |name, value| conn.write("#{name}: #{value}\r\n") }
+  conn.write("\r\n")
+  conn.write(body)
+end
+
+# serves one request per connection; requests are served one at a time, like
+# the Python server, so that captured output and trace variables belong to a
+# single request
+def serve(conn)
+  headers, body = read_request(conn)
+  return if headers.nil?
+
+  unless headers[CAPTURE_HEADER]
+    code, result = invoke(headers, body)
+    respond(conn, code, result, {})
+    return
+  end
+
+  buf = StringIO.new
+  stdout, stderr = $stdout, $stderr
+  $stdout, $stderr = Tee.new(stdout, buf), Tee.new(stderr, buf)
+  begin
+    code, result = invoke(headers, body)
+  ensure
+    $stdout, $stderr = stdout, stderr
+  end
+  logs = buf.string.b[-MAX_CAPTURE..-1] || buf.string.b
+  respond(conn, code, result, LOGS_HEADER => Base64.strict_encode64(logs))
+end
+
+def lambda_server
+  init
+  File.unlink(SOCK_PATH) if File.exist?(SOCK_PATH)
+  server = UNIXServer.new(SOCK_PATH)
+  loop do
+    conn = server.accept
+    begin
+      serve(conn)
+    rescue IOError, SystemCallError => e
+      $stderr.puts "connection failed: #{e.message}"
+    ensure
+      conn.close
+    end
+  end
+end
+
+# starts num forkservers, each in /host/fs<k> like those of the Python pool
+def pool(num)
+  preload
+  require 'ns'
+
+  pids = (0...num).map do |k|
+    dir = File.join(HOST_PATH, "fs#{k}")
+    Dir.mkdir(dir) unless Dir.exist?(dir)
+    fork do
+      $stdout.reopen(File.join(dir, 'stdout'), 'w')
+      $stderr.reopen(File.join(dir, 'stderr'), 'w')
+      # only returns in a server forked into a sandbox
+      NS.fdlisten(File.join(dir, 'fs.sock'))
+      lambda_server
+    end
+  end
+  File.write(File.join(HOST_PATH, 'fspids'), pids.map { |pid| "#{pid}\n" }.join)
+  Process.waitall
+end
+
+if ARGV[0] == '--pool'
+  pool(Integer(ARGV[1] || 1))
+else
+  preload
+  lambda_server
+end
@@ -1,5 +1,12 @@
 #!/usr/bin/python
-import traceback, json, sys, socket, os
+# runs under both Python 2 and 3; the image selects the version
+import traceback, json, sys, socket, os, base64, time
+try:
+    from StringIO import StringIO
+    import __builtin__ as builtins
+except ImportError:
+    from io import StringIO
+    import builtins
 import rethinkdb
 import tornado.ioloop
 import tornado.web
@@ -11,6 +18,43 @@ SOCK_PATH = '%s/ol.sock' % HOST_PATH
 STDOUT_PATH = '%s/stdout' % HOST_PATH
 STDERR_PATH = '%s/stderr' % HOST_PATH
 
+# handler output of a single request is captured when the worker sets
+# CAPTURE_HEADER, and returned base64 encoded in LOGS_HEADER
+CAPTURE_HEADER = 'X-OL-Capture-Logs'
+LOGS_HEADER = 'X-OL-Logs'
+MAX_CAPTURE = 64 * 1024
+
+# trace context and ID of the current request are exposed to handler code
+# through these environment variables
+TRACE_ENV = {
+    'traceparent': 'TRACEPARENT',
+    'tracestate': 'TRACESTATE',
+    'X-Request-Id': 'OL_REQUEST_ID',
+}
+
+# time spent in init, and in each module it imported, is reported in
+# INIT_HEADER on the first response after init
+INIT_HEADER = 'X-OL-Init'
+MAX_IMPORTS = 50
+init_report = None
+
+
+# the function serving requests is named by the "entrypoint" field of the
+# handler metadata, as <module>.<function>
+META_PATH = '/handler/lambda-config.json'
+DEFAULT_ENTRYPOINT = 'lambda_func.handler'
+
+def load_entrypoint():
+    entrypoint = DEFAULT_ENTRYPOINT
+    if os.path.exists(META_PATH):
+        with open(META_PATH) as f:
+            entrypoint = json.load(f).get('entrypoint') or DEFAULT_ENTRYPOINT
+    module, _, function = entrypoint.rpartition('.')
+    __import__(module) # rather than importlib, so ImportTimer sees it
+    f = getattr(sys.modules[module], function, None)
+    if not callable(f):
+        raise Exception('entrypoint not found: module %s has no function %s' % (module, function))
+    return f
 
 PROCESSES_DEFAULT = 10
 initialized = False
@@ -19,29 +63,123 @@ db_conn = None
 
 # run once per process
 def init():
-    global initialized, config, db_conn, lambda_func
+    global initialized, config, db_conn, handler_func, init_report
     if initialized:
         return
 
     sys.stdout = open(STDOUT_PATH, 'w')
     sys.stderr = open(STDERR_PATH, 'w')
 
-    config = json.loads(os.environ['ol.config'])
-    if config.get('db', None) == 'rethinkdb':
-        host = config.get('rethinkdb.host', 'localhost')
-        port = config.get('rethinkdb.port', 28015)
-        print 'Connect to %s:%d' % (host, port)
-        db_conn = rethinkdb.connect(host, port)
+    start = time.time()
+    with ImportTimer() as timer:
+        config = json.loads(os.environ['ol.config'])
+        if config.get('db', None) == 'rethinkdb':
+            host = config.get('rethinkdb.host', 'localhost')
+            port = config.get('rethinkdb.port', 28015)
+            print('Connect to %s:%d' % (host, port))
+            db_conn = rethinkdb.connect(host, port)
 
-    sys.path.append('/handler')
-    import lambda_func # assume submitted .py file is /handler/lambda_func.py
+        sys.path.append('/handler')
+        handler_func = load_entrypoint()
+    init_report = timer.report(time.time() - start)
 
     initialized = True
 
+# times the modules imported while it is active. The self time of a module
+# excludes the modules it imported in turn, so the report points at the
+# dependency that is actually slow.
+class ImportTimer:
+    def __init__(self):
+        self.times = {} # module -> (self seconds, total seconds)
+        self.stack = []
+
+    def __enter__(self):
+        self.orig = builtins.__import__
+        builtins.__import__ = self.timed_import
+        return self
+
+    def __exit__(self, *exc):
+        builtins.__import__ = self.orig
+
+    def timed_import(self, name, *args, **kwargs):
+        if name in sys.modules:
+            return self.orig(name, *args, **kwargs)
+
+        self.stack.append(0.0)
+        start = time.time()
+        try:
+            return self.orig(name, *args, **kwargs)
+        finally:
+            total = time.time() - start
+            children = self.stack.pop()
+            if self.stack:
+                self.stack[-1] += total
+            self.times[name] = (total - children, total)
+
+    def report(self, init_seconds):
+        slowest = sorted(self.times.items(), key=lambda kv: -kv[1][0])[:MAX_IMPORTS]
+        return json.dumps({
+            'init_ms': init_seconds * 1000,
+            'imports': [{'module': name, 'self_ms': s * 1000, 'total_ms': t * 1000}
+                        for name, (s, t) in slowest],
+        })
+
+def send_init_report(handler):
+    global init_report
+    if init_report:
+        handler.set_header(INIT_HEADER, init_report)
+        init_report = None
+
+def set_trace_env(headers):
+    for header, var in TRACE_ENV.items():
+        value = headers.get(header)
+        if value:
+            os.environ[var] = value
+        else:
+            os.environ.pop(var, None)
+
+# writes to both the regular output file and the capture buffer
+class Tee:
+    def __init__(self, f, buf):
+        self.f = f
+        self.buf = buf
+
+    def write(self, s):
+        self.f.write(s)
+        self.buf.write(s)
+
+    def flush(self):
+        self.f.flush()
+
 class SockFileHandler(tornado.web.RequestHandler):
     def post(self):
+        # init first, as it replaces stdout and stderr
         try:
             init()
+        except Exception:
+            self.set_status(500) # internal error
+            self.write(traceback.format_exc())
+            return
+        send_init_report(self)
+
+        if not self.request.headers.get(CAPTURE_HEADER):
+            self.run()
+            return
+
+        buf = StringIO()
+        stdout, stderr = sys.stdout, sys.stderr
+        sys.stdout, sys.stderr = Tee(stdout, buf), Tee(stderr, buf)
+        try:
+            self.run()
+        finally:
+            sys.stdout, sys.stderr = stdout, stderr
+            logs = buf.getvalue()[-MAX_CAPTURE:]
+            if not isinstance(logs, bytes):
+                logs = logs.encode('utf-8')
+            self.set_header(LOGS_HEADER, base64.b64encode(logs))
+
+    def run(self):
+        try:
             data = self.request.body
             try :
                 event = json.loads(data)
@@ -49,7 +187,8 @@ class SockFileHandler(tornado.web.RequestHandler):
                 self.set_status(400)
                 self.write('bad POST data: "%s"'%str(data))
                 return
-            self.write(json.dumps(lambda_func.handler(db_conn, event)))
+            set_trace_env(self.request.headers)
+            self.write(json.dumps(handler_func(db_conn, event)))
         except Exception:
             self.set_status(500) # internal error
             self.write(traceback.format_exc())
@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"crypto/tls"
 	"log"
 
 	r "gopkg.in/dancannon/gorethink.v2"
@@ -19,12 +20,19 @@ func (c *PullClient) Pull(name string) map[string]interface{} {
 }
 
 func InitPullClient(cluster []string, db string, table string) *PullClient {
+	return InitPullClientTLS(cluster, db, table, nil)
+}
+
+// InitPullClientTLS is InitPullClient over TLS, with the given config (nil
+// means plain connections).
+func InitPullClientTLS(cluster []string, db string, table string, tlsConfig *tls.Config) *PullClient {
 	c := new(PullClient)
 	c.Table = table
 
 	session, err := r.Connect(r.ConnectOpts{
 		Addresses: cluster,
 		Database:  db,
+		TLSConfig: tlsConfig,
 	})
 	check(err)
 
@@ -11,6 +11,12 @@
 #include <errno.h>
 #include <time.h>
 #include <signal.h>
+#include <linux/capability.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
+
+/* capabilities the forked server drops (see dropprivs) */
+#define DROP_CAPS_ENV "OL_FORK_DROP_CAPS"
 
 static PyObject *ns_fdlisten(PyObject *self, PyObject *args);
 
@@ -65,6 +71,44 @@ recvfd(int s)
 	return fd;
 }
 
+/*
+ * dropprivs runs in the forked server before it runs any handler code. It
+ * drops the capabilities the pool container only has to join the namespaces
+ * of sandboxes, given by the worker as numbers in DROP_CAPS_ENV (with SETPCAP,
+ * needed to shrink the bounding set, last), and sets no_new_privs, so that
+ * handler code runs with the privileges of a sandbox.
+ */
+static int
+dropprivs(void)
+{
+    struct __user_cap_header_struct hdr;
+    struct __user_cap_data_struct data[2];
+    const char *list = getenv(DROP_CAPS_ENV);
+    char *end;
+    long cap;
+
+    memset(&hdr, 0, sizeof hdr);
+    hdr.version = _LINUX_CAPABILITY_VERSION_3;
+    if (syscall(SYS_capget, &hdr, data) == -1)
+        return -1;
+
+    while (list != NULL && *list != '\0') {
+        cap = strtol(list, &end, 10);
+        if (end == list || cap < 0 || cap >= 64)
+            return -1;
+        if (prctl(PR_CAPBSET_DROP, cap, 0, 0, 0) == -1)
+            return -1;
+        data[cap / 32].effective &= ~(1u << (cap % 32));
+        data[cap / 32].permitted &= ~(1u << (cap % 32));
+        data[cap / 32].inheritable &= ~(1u << (cap % 32));
+        list = *end == ',' ? end + 1 : end;
+    }
+
+    if (syscall(SYS_capset, &hdr, data) == -1)
+        return -1;
+    return prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0);
+}
+
 static PyObject *ns_fdlisten(PyObject *self, PyObject *args)
 {
     PyObject *ret;
@@ -172,6 +216,11 @@ static PyObject *ns_fdlisten(PyObject *self, PyObject *args)
                 return NULL;
             }
 
+            if (dropprivs() == -1) {
+                perror("dropprivs");
+                _exit(1);
+            }
+
             if (close(s2) == -1) {
                 PyErr_SetString(PyExc_RuntimeError, "Child failed to close socket connection (s2).");
                 return NULL;
@@ -1,5 +1,6 @@
 #!/usr/bin/python
-import traceback, json, sys, socket, os
+import traceback, json, sys, socket, os, base64, time, glob, __builtin__
+from StringIO import StringIO
 import rethinkdb
 import tornado.ioloop
 import tornado.web
@@ -13,22 +14,66 @@ SOCK_PATH = '%s/ol.sock' % HOST_PATH
 STDOUT_PATH = '%s/stdout' % HOST_PATH
 STDERR_PATH = '%s/stderr' % HOST_PATH
 
+# handler output of a single request is captured when the worker sets
+# CAPTURE_HEADER, and returned base64 encoded in LOGS_HEADER
+CAPTURE_HEADER = 'X-OL-Capture-Logs'
+LOGS_HEADER = 'X-OL-Logs'
+MAX_CAPTURE = 64 * 1024
+
+# trace context and ID of the current request are exposed to handler code
+# through these environment variables
+TRACE_ENV = {
+    'traceparent': 'TRACEPARENT',
+    'tracestate': 'TRACESTATE',
+    'X-Request-Id': 'OL_REQUEST_ID',
+}
+
+# time spent in init, and in each module it imported, is reported in
+# INIT_HEADER on the first response after init
+INIT_HEADER = 'X-OL-Init'
+MAX_IMPORTS = 50
+init_report = None
+
+
+# the function serving requests is named by the "entrypoint" field of the
+# handler metadata, as <module>.<function>
+META_PATH = '/handler/lambda-config.json'
+DEFAULT_ENTRYPOINT = 'lambda_func.handler'
+
+def load_entrypoint():
+    entrypoint = DEFAULT_ENTRYPOINT
+    if os.path.exists(META_PATH):
+        with open(META_PATH) as f:
+            entrypoint = json.load(f).get('entrypoint') or DEFAULT_ENTRYPOINT
+    module, _, function = entrypoint.rpartition('.')
+    __import__(module) # rather than importlib, so ImportTimer sees it
+    f = getattr(sys.modules[module], function, None)
+    if not callable(f):
+        raise Exception('entrypoint not found: module %s has no function %s' % (module, function))
+    return f
 
 PROCESSES_DEFAULT = 10
 initialized = False
 config = None
 db_conn = None
+init_error = None
 
 # run after forking into sandbox
 def init():
-    global initialized, config, db_conn, lambda_func
+    global initialized, config, db_conn, handler_func, init_report
 
     sys.stdout = open(STDOUT_PATH, 'w')
     sys.stderr = open(STDERR_PATH, 'w')
 
-    # assume submitted .py file is /handler/lambda_func.py
     sys.path.append('/handler')
-    import lambda_func 
+
+    # layers are mounted into the sandbox, but unlike sandboxes started by
+    # the worker, forked servers do not get PYTHONPATH pointing at them
+    sys.path.extend(sorted(glob.glob('/opt/layers/*/python')))
+    start = time.time()
+    with ImportTimer() as timer:
+        handler_func = load_entrypoint()
+    init_report = timer.report(time.time() - start)
 
     # need alternate config mechanism
     if False:
@@ -39,8 +84,94 @@ def init():
             print 'Connect to %s:%d' % (host, port)
             db_conn = rethinkdb.connect(host, port)
 
+# times the modules imported while it is active. The self time of a module
+# excludes the modules it imported in turn, so the report points at the
+# dependency that is actually slow.
+class ImportTimer:
+    def __init__(self):
+        self.times = {} # module -> (self seconds, total seconds)
+        self.stack = []
+
+    def __enter__(self):
+        self.orig = __builtin__.__import__
+        __builtin__.__import__ = self.timed_import
+        return self
+
+    def __exit__(self, *exc):
+        __builtin__.__import__ = self.orig
+
+    def timed_import(self, name, *args, **kwargs):
+        if name in sys.modules:
+            return self.orig(name, *args, **kwargs)
+
+        self.stack.append(0.0)
+        start = time.time()
+        try:
+            return self.orig(name, *args, **kwargs)
+        finally:
+            total = time.time() - start
+            children = self.stack.pop()
+            if self.stack:
+                self.stack[-1] += total
+            self.times[name] = (total - children, total)
+
+    def report(self, init_seconds):
+        slowest = sorted(self.times.items(), key=lambda kv: -kv[1][0])[:MAX_IMPORTS]
+        return json.dumps({
+            'init_ms': init_seconds * 1000,
+            'imports': [{'module': name, 'self_ms': s * 1000, 'total_ms': t * 1000}
+                        for name, (s, t) in slowest],
+        })
+
+def send_init_report(handler):
+    global init_report
+    if init_report:
+        handler.set_header(INIT_HEADER, init_report)
+        init_report = None
+
+def set_trace_env(headers):
+    for header, var in TRACE_ENV.items():
+        value = headers.get(header)
+        if value:
+            os.environ[var] = value
+        else:
+            os.environ.pop(var, None)
+
+# writes to both the regular output file and the capture buffer
+class Tee:
+    def __init__(self, f, buf):
+        self.f = f
+        self.buf = buf
+
+    def write(self, s):
+        self.f.write(s)
+        self.buf.write(s)
+
+    def flush(self):
+        self.f.flush()
+
 class SockFileHandler(tornado.web.RequestHandler):
     def post(self):
+        if init_error:
+            self.set_status(500) # internal error
+            self.write(init_error)
+            return
+        send_init_report(self)
+
+        if not self.request.headers.get(CAPTURE_HEADER):
+            self.run()
+            return
+
+        buf = StringIO()
+        stdout, stderr = sys.stdout, sys.stderr
+        sys.stdout, sys.stderr = Tee(stdout, buf), Tee(stderr, buf)
+        try:
+            self.run()
+        finally:
+            sys.stdout, sys.stderr = stdout, stderr
+            self.set_header(LOGS_HEADER, base64.b64encode(buf.getvalue()[-MAX_CAPTURE:]))
+
+    def run(self):
         try:
             data = self.request.body
             try :
@@ -49,7 +180,8 @@ class SockFileHandler(tornado.web.RequestHandler):
                 self.set_status(400)
                 self.write('bad POST data: "%s"'%str(data))
                 return
-            self.write(json.dumps(lambda_func.handler(db_conn, event)))
+            set_trace_env(self.request.headers)
+            self.write(json.dumps(handler_func(db_conn, event)))
         except Exception:
             self.set_status(500) # internal error
             self.write(traceback.format_exc())
@@ -77,7 +209,13 @@ def fdlisten(path):
 
     # child
     if r == 0:
-        init()
+        # report a broken handler on every request, rather than leaving
+        # the sandbox without a server
+        global init_error
+        try:
+            init()
+        except Exception:
+            init_error = traceback.format_exc()
         lambda_server()
 
 if __name__ == '__main__':
@@ -0,0 +1,8 @@
+// Built into Hello.dll by the Makefile.
+public class Hello
+{
+    public string Handle(string input)
+    {
+        return "\"hello\"";
+    }
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/open-lambda/open-lambda/lambda-go/lambda"
+)
+
+func handle(ctx context.Context, event json.RawMessage) (interface{}, error) {
+	return "hello", nil
+}
+
+func main() {
+	lambda.Start(handle)
+}
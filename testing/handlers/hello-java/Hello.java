@@ -0,0 +1,6 @@
+// Built into hello.jar, with Hello as its Main-Class, by the Makefile.
+public class Hello {
+    public String handle(String event) {
+        return "\"hello\"";
+    }
+}
@@ -0,0 +1,3 @@
+exports.handler = function(event) {
+    return 'hello';
+};
@@ -0,0 +1,3 @@
+def handler(event)
+  'hello'
+end
@@ -0,0 +1,178 @@
+// accounting package meters the usage of handlers, as the basis for
+// chargeback.
+//
+// Each invocation is charged its wall time multiplied by the memory
+// configured for the handler, in GB-seconds. Usage is aggregated in memory
+// per handler and tenant, and periodically flushed as Records to a file (one
+// JSON object per line) and/or POSTed as a JSON array to an HTTP endpoint.
+package accounting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// Record is the usage of one handler of one tenant over a period.
+type Record struct {
+	Worker      string    `json:"worker"`
+	Handler     string    `json:"handler"`
+	Tenant      string    `json:"tenant"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Invocations int64     `json:"invocations"`
+	Seconds     float64   `json:"seconds"`
+	GBSeconds   float64   `json:"gb_seconds"`
+}
+
+type key struct {
+	handler string
+	tenant  string
+}
+
+// Meter aggregates usage until it is flushed. A nil *Meter is valid and
+// discards all usage.
+type Meter struct {
+	mutex  sync.Mutex
+	worker string
+	file   string
+	url    string
+	client *http.Client
+	start  time.Time
+	usage  map[key]*Record
+}
+
+// NewMeter creates a Meter according to config, or returns nil if
+// accounting is not configured.
+func NewMeter(conf *config.Config) *Meter {
+	if conf.Accounting_file == "" && conf.Accounting_url == "" {
+		return nil
+	}
+	return &Meter{
+		worker: conf.WorkerId(),
+		file:   conf.Accounting_file,
+		url:    conf.Accounting_url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		start:  time.Now(),
+		usage:  make(map[key]*Record),
+	}
+}
+
+// Add charges one invocation of a handler that ran for d with memoryMB of
+// memory.
+func (m *Meter) Add(handler, tenant string, d time.Duration, memoryMB int) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	k := key{handler, tenant}
+	r := m.usage[k]
+	if r == nil {
+		r = &Record{Worker: m.worker, Handler: handler, Tenant: tenant}
+		m.usage[k] = r
+	}
+	r.Invocations += 1
+	r.Seconds += d.Seconds()
+	r.GBSeconds += d.Seconds() * float64(memoryMB) / 1024
+}
+
+// take returns the usage since the last call and resets it.
+func (m *Meter) take() []Record {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	records := make([]Record, 0, len(m.usage))
+	for _, r := range m.usage {
+		r.Start = m.start
+		r.End = now
+		records = append(records, *r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Tenant != records[j].Tenant {
+			return records[i].Tenant < records[j].Tenant
+		}
+		return records[i].Handler < records[j].Handler
+	})
+	m.usage = make(map[key]*Record)
+	m.start = now
+	return records
+}
+
+// Flush writes the usage since the last flush to the configured file and
+// endpoint. Usage that could not be delivered is lost, but the error is
+// returned so it can be reported.
+func (m *Meter) Flush() error {
+	if m == nil {
+		return nil
+	}
+
+	records := m.take()
+	if len(records) == 0 {
+		return nil
+	}
+
+	if m.file != "" {
+		if err := m.writeFile(records); err != nil {
+			return err
+		}
+	}
+	if m.url != "" {
+		if err := m.post(records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Meter) writeFile(records []Record) error {
+	f, err := os.OpenFile(m.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return f.Sync()
+}
+
+func (m *Meter) post(records []Record) error {
+	body, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	resp, err := m.client.Post(m.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("accounting endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Run flushes the usage every interval. It never returns.
+func (m *Meter) Run(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := m.Flush(); err != nil {
+			log.WithError(err).Error("could not flush usage records")
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package accounting
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+func TestFlushToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "usage.log")
+	m := NewMeter(&config.Config{Worker_id: "w1", Accounting_file: path})
+	m.Add("hello", "acme", 2*time.Second, 512)
+	m.Add("hello", "acme", time.Second, 512)
+	m.Add("echo", "", 500*time.Millisecond, 1024)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing new to flush
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records := []Record{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %+v", records)
+	}
+	if r := records[0]; r.Handler != "echo" || r.Invocations != 1 || r.GBSeconds != 0.5 {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if r := records[1]; r.Handler != "hello" || r.Tenant != "acme" || r.Invocations != 2 || r.GBSeconds != 1.5 || r.Worker != "w1" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+}
+
+func TestNilMeter(t *testing.T) {
+	m := NewMeter(&config.Config{})
+	m.Add("hello", "", time.Second, 128)
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+}
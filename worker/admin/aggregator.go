@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler"
+	"github.com/open-lambda/open-lambda/worker/server"
+	"github.com/open-lambda/open-lambda/worker/tlsutil"
+	"github.com/urfave/cli"
+)
+
+// clusterWorkers finds the address (host:port) of every worker started in
+// the cluster, using the pid files written by the "workers" command.
+func clusterWorkers(cluster string) ([]string, error) {
+	logs, err := ioutil.ReadDir(path.Join(cluster, "logs"))
+	if err != nil {
+		return nil, err
+	}
+
+	workers := []string{}
+	for _, fi := range logs {
+		if strings.HasSuffix(fi.Name(), ".pid") && strings.HasPrefix(fi.Name(), "worker-") {
+			name := fi.Name()[:len(fi.Name())-4]
+			c, err := config.ParseConfig(configPath(cluster, name))
+			if err != nil {
+				return nil, err
+			}
+			workers = append(workers, fmt.Sprintf("localhost:%s", c.Worker_port))
+		}
+	}
+
+	return workers, nil
+}
+
+// workerResponse is the result of forwarding a request to one worker.
+type workerResponse struct {
+	worker string
+	body   []byte
+	err    error
+}
+
+// aggregator fans requests out to all workers of a cluster and merges their
+// responses.
+type aggregator struct {
+	cluster string
+	workers []string
+	client  *http.Client
+	scheme  string
+	key     string // of the admin API of the workers, if set
+}
+
+// targets returns the static worker list if one was given, and otherwise
+// discovers the workers of the cluster on each call so that new workers are
+// picked up without restarting the aggregator.
+func (a *aggregator) targets() ([]string, error) {
+	if len(a.workers) > 0 {
+		return a.workers, nil
+	}
+	return clusterWorkers(a.cluster)
+}
+
+// fanOut sends a GET for urlPath to every worker concurrently. Responses are
+// returned sorted by worker address.
+func (a *aggregator) fanOut(urlPath string) ([]workerResponse, error) {
+	workers, err := a.targets()
+	if err != nil {
+		return nil, err
+	}
+
+	responses := make([]workerResponse, len(workers))
+	var wg sync.WaitGroup
+	for i, worker := range workers {
+		wg.Add(1)
+		go func(i int, worker string) {
+			defer wg.Done()
+			responses[i] = workerResponse{worker: worker}
+			req, err := http.NewRequest("GET", fmt.Sprintf("%s://%s%s", a.scheme, worker, urlPath), nil)
+			if err != nil {
+				responses[i].err = err
+				return
+			}
+			if a.key != "" {
+				req.Header.Set("Authorization", "Bearer "+a.key)
+			}
+			resp, err := a.client.Do(req)
+			if err != nil {
+				responses[i].err = err
+				return
+			}
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				responses[i].err = err
+			} else if resp.StatusCode != http.StatusOK {
+				responses[i].err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+			} else {
+				responses[i].body = body
+			}
+		}(i, worker)
+	}
+	wg.Wait()
+
+	sort.Slice(responses, func(i, j int) bool { return responses[i].worker < responses[j].worker })
+	return responses, nil
+}
+
+// writeJSON writes v as the JSON body of the response.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	body, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// Status reports the health of every worker. The response code is 503 if any
+// worker is unhealthy.
+func (a *aggregator) Status(w http.ResponseWriter, r *http.Request) {
+	responses, err := a.fanOut("/status")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	healthy := 0
+	workers := map[string]string{}
+	for _, resp := range responses {
+		if resp.err != nil {
+			workers[resp.worker] = resp.err.Error()
+		} else {
+			workers[resp.worker] = string(resp.body)
+			healthy += 1
+		}
+	}
+
+	code := http.StatusOK
+	if healthy < len(responses) {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"healthy": healthy,
+		"total":   len(responses),
+		"workers": workers,
+	})
+}
+
+// Stats returns the stats of every worker, keyed by worker address.
+func (a *aggregator) Stats(w http.ResponseWriter, r *http.Request) {
+	responses, err := a.fanOut("/stats")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	workers := map[string]server.WorkerStats{}
+	errors := map[string]string{}
+	for _, resp := range responses {
+		var stats server.WorkerStats
+		if resp.err != nil {
+			errors[resp.worker] = resp.err.Error()
+		} else if err := json.Unmarshal(resp.body, &stats); err != nil {
+			errors[resp.worker] = err.Error()
+		} else {
+			workers[resp.worker] = stats
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"workers": workers,
+		"errors":  errors,
+	})
+}
+
+// clusterHandlerInfo is the state of a handler on one worker.
+type clusterHandlerInfo struct {
+	Worker string `json:"worker"`
+	handler.HandlerInfo
+}
+
+// Handlers returns, for every handler name, its state on each worker that
+// knows about it.
+func (a *aggregator) Handlers(w http.ResponseWriter, r *http.Request) {
+	responses, err := a.fanOut("/stats")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	handlers := map[string][]clusterHandlerInfo{}
+	for _, resp := range responses {
+		var stats server.WorkerStats
+		if resp.err != nil || json.Unmarshal(resp.body, &stats) != nil {
+			continue
+		}
+		for _, info := range stats.Handlers {
+			handlers[info.Name] = append(handlers[info.Name], clusterHandlerInfo{resp.worker, info})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, handlers)
+}
+
+// Metrics concatenates the metrics of all workers, adding a "worker" label to
+// every sample, so the whole cluster can be scraped as one target. An
+// ol_worker_up gauge reports which workers could be scraped.
+func (a *aggregator) Metrics(w http.ResponseWriter, r *http.Request) {
+	responses, err := a.fanOut("/metrics")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# HELP ol_worker_up Whether the worker could be scraped.\n")
+	buf.WriteString("# TYPE ol_worker_up gauge\n")
+	for _, resp := range responses {
+		up := 1
+		if resp.err != nil {
+			log.Printf("could not scrape %s: %v\n", resp.worker, resp.err)
+			up = 0
+		}
+		fmt.Fprintf(&buf, "ol_worker_up{worker=%q} %d\n", resp.worker, up)
+	}
+
+	// HELP and TYPE lines must appear once per metric, so group samples
+	// of the same metric from different workers together
+	headers := map[string][]string{}
+	samples := map[string][]string{}
+	names := []string{}
+	for _, resp := range responses {
+		if resp.err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(resp.body))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+			if strings.HasPrefix(line, "#") {
+				fields := strings.Fields(line)
+				if len(fields) < 3 {
+					continue
+				}
+				name := fields[2]
+				if _, ok := headers[name]; !ok {
+					names = append(names, name)
+				}
+				if len(headers[name]) < 2 && len(samples[name]) == 0 {
+					headers[name] = append(headers[name], line)
+				}
+				continue
+			}
+			name, labeled := addLabel(line, "worker", resp.worker)
+			name = family(name, headers)
+			if _, ok := headers[name]; !ok {
+				headers[name] = []string{}
+				names = append(names, name)
+			}
+			samples[name] = append(samples[name], labeled)
+		}
+	}
+
+	for _, name := range names {
+		for _, line := range headers[name] {
+			buf.WriteString(line + "\n")
+		}
+		for _, line := range samples[name] {
+			buf.WriteString(line + "\n")
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// family returns the name of the metric a sample belongs to, mapping the
+// _bucket, _sum and _count series of histograms to the histogram.
+func family(name string, headers map[string][]string) string {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base := strings.TrimSuffix(name, suffix)
+		if _, ok := headers[base]; ok && base != name {
+			return base
+		}
+	}
+	return name
+}
+
+// addLabel inserts a label into a Prometheus sample line, returning the
+// metric name and the new line.
+func addLabel(line, label, value string) (string, string) {
+	pair := fmt.Sprintf("%s=%q", label, value)
+	if i := strings.IndexAny(line, "{ "); i < 0 {
+		return line, line
+	} else if line[i] == '{' {
+		sep := ","
+		if strings.HasPrefix(line[i+1:], "}") {
+			sep = ""
+		}
+		return line[:i], line[:i+1] + pair + sep + line[i+1:]
+	} else {
+		return line[:i], line[:i] + "{" + pair + "}" + line[i:]
+	}
+}
+
+// aggregate corresponds to the "aggregator" command of the admin tool.
+func aggregate(ctx *cli.Context) error {
+	cluster := parseCluster(ctx.String("cluster"), false)
+	port := ctx.Int("port")
+
+	// the workers of a cluster with mutual TLS only accept clients with a
+	// certificate
+	var reloader *tlsutil.Reloader
+	if cluster != "" {
+		c, err := config.ParseConfig(templatePath(cluster))
+		if err != nil {
+			return err
+		}
+		if reloader, err = tlsutil.New(c.Tls); err != nil {
+			return err
+		}
+	}
+
+	a := &aggregator{
+		cluster: cluster,
+		client:  reloader.Client(time.Duration(ctx.Int("timeout")) * time.Second),
+		scheme:  reloader.Scheme(),
+		key:     ctx.String("admin-key"),
+	}
+	if workers := ctx.String("workers"); workers != "" {
+		a.workers = strings.Split(workers, ",")
+	} else if cluster == "" {
+		return fmt.Errorf("please specify a cluster directory or a list of workers")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", a.Status)
+	mux.HandleFunc("/stats", a.Stats)
+	mux.HandleFunc("/handlers", a.Handlers)
+	mux.HandleFunc("/metrics", a.Metrics)
+
+	addr := fmt.Sprintf(":%d", port)
+	fmt.Printf("Aggregating /status, /stats, /handlers and /metrics on localhost%s\n", addr)
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/balancer"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/registry"
+	"github.com/open-lambda/open-lambda/worker/tlsutil"
+	"github.com/urfave/cli"
+)
+
+// how often the balancer looks for workers that started or stopped
+const rediscoverInterval = 10 * time.Second
+
+// balance corresponds to the "balancer" command of the admin tool.
+func balance(ctx *cli.Context) error {
+	cluster := parseCluster(ctx.String("cluster"), true)
+	port := ctx.Int("port")
+
+	c, err := config.ParseConfig(templatePath(cluster))
+	if err != nil {
+		return err
+	}
+
+	workers := []string{}
+	static := ctx.String("workers") != ""
+	if static {
+		workers = strings.Split(ctx.String("workers"), ",")
+	} else if workers, err = clusterWorkers(cluster); err != nil {
+		return err
+	}
+	rm, err := balancerRegistry(cluster, c)
+	if err != nil {
+		return err
+	}
+	b := balancer.NewBalancer(workers, rm)
+
+	// workers with mutual TLS only accept clients with a certificate
+	reloader, err := tlsutil.New(c.Tls)
+	if err != nil {
+		return err
+	} else if reloader != nil {
+		b.UseTLS(reloader.ClientConfig())
+	}
+
+	if !static {
+		go func() {
+			for range time.Tick(rediscoverInterval) {
+				if workers, err := clusterWorkers(cluster); err != nil {
+					log.Printf("could not discover workers: %v\n", err)
+				} else {
+					b.SetWorkers(workers)
+				}
+			}
+		}()
+	}
+
+	addr := fmt.Sprintf(":%d", port)
+	fmt.Printf("Balancing over %d worker(s) on localhost%s\n", len(workers), addr)
+	return http.ListenAndServe(addr, b)
+}
+
+// balancerRegistry creates the registry manager the balancer reads handler
+// metadata through, for the registry configured for the workers. Code pulled
+// from olstore goes to a directory of the balancer, apart from the workers'.
+func balancerRegistry(cluster string, c *config.Config) (registry.RegistryManager, error) {
+	switch c.Registry {
+	case registry.LOCAL:
+		return registry.NewLocalManager(c)
+	case registry.OLSTORE:
+		own := *c
+		own.Reg_dir = path.Join(cluster, "balancer", "registry")
+		return registry.NewOLStoreManager(&own)
+	default:
+		return nil, fmt.Errorf("cannot read handler metadata from registry %q", c.Registry)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/bench"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/tlsutil"
+	"github.com/urfave/cli"
+)
+
+// benchmark corresponds to the "bench" command of the admin tool.
+func benchmark(ctx *cli.Context) error {
+	cluster := parseCluster(ctx.String("cluster"), false)
+
+	handlers, err := bench.ParseMix(ctx.String("handlers"))
+	if err != nil {
+		return err
+	}
+	payloads := []int{}
+	for _, size := range strings.Split(ctx.String("payload-sizes"), ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad payload size %q", size)
+		}
+		payloads = append(payloads, n)
+	}
+
+	// without a target, the first worker of the cluster is benchmarked,
+	// with a client certificate if the cluster uses mutual TLS
+	var reloader *tlsutil.Reloader
+	target := ctx.String("target")
+	if cluster != "" {
+		c, err := config.ParseConfig(templatePath(cluster))
+		if err != nil {
+			return err
+		}
+		if reloader, err = tlsutil.New(c.Tls); err != nil {
+			return err
+		}
+		if target == "" {
+			workers, err := clusterWorkers(cluster)
+			if err != nil {
+				return err
+			} else if len(workers) == 0 {
+				return fmt.Errorf("no workers running in %s", cluster)
+			}
+			target = fmt.Sprintf("%s://%s", reloader.Scheme(), workers[0])
+		}
+	}
+	if target == "" {
+		return fmt.Errorf("please specify a cluster directory or a target")
+	}
+
+	opts := bench.Options{
+		Target:      target,
+		Handlers:    handlers,
+		Concurrency: ctx.Int("concurrency"),
+		Requests:    ctx.Int("requests"),
+		Duration:    time.Duration(ctx.Int("duration")) * time.Second,
+		Payloads:    payloads,
+		Seed:        ctx.Int64("seed"),
+		EvictEvery:  ctx.Int("evict-every"),
+		AdminKey:    ctx.String("admin-key"),
+		Client:      reloader.Client(time.Duration(ctx.Int("timeout")) * time.Second),
+	}
+	report, err := bench.Run(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+
+	if ctx.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	report.Print(os.Stdout)
+	return nil
+}
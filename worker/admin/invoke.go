@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/server"
+	"github.com/urfave/cli"
+)
+
+// invokeLocal corresponds to the "invoke-local" command of the admin tool.
+func invokeLocal(ctx *cli.Context) error {
+	confPath := ctx.String("config")
+	if confPath == "" {
+		cluster := parseCluster(ctx.String("cluster"), false)
+		if cluster == "" {
+			return fmt.Errorf("please specify a worker config or a cluster directory")
+		}
+		confPath = templatePath(cluster)
+	}
+	conf, err := config.ParseConfig(confPath)
+	if err != nil {
+		return err
+	}
+	if err := logging.Init(conf); err != nil {
+		return err
+	}
+
+	name, dir := ctx.String("handler"), ctx.String("dir")
+	if (name == "") == (dir == "") {
+		return fmt.Errorf("please specify either a handler or a code directory")
+	}
+
+	var input []byte
+	if payload := ctx.String("payload"); payload == "-" {
+		input, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		input, err = ioutil.ReadFile(payload)
+	}
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(ctx.Int("timeout")) * time.Second
+	cctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	result, err := server.InvokeLocal(cctx, conf, name, dir, input)
+	if err != nil {
+		return err
+	}
+
+	if ctx.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		// the response goes to stdout, to be piped; the rest to stderr
+		os.Stdout.WriteString(result.Body)
+		if result.Output != "" {
+			fmt.Fprintf(os.Stderr, "\n--- output\n%s", result.Output)
+		}
+		fmt.Fprintf(os.Stderr, "\n--- status %d, %s start\n", result.Status, result.Timings.Start)
+		for _, phase := range result.Timings.Phases {
+			fmt.Fprintf(os.Stderr, "%-12s %9.2f ms\n", phase.Name, phase.Seconds*1000)
+		}
+	}
+
+	if result.Status >= 300 {
+		return fmt.Errorf("handler returned status %d", result.Status)
+	}
+	return nil
+}
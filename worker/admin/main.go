@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"path"
 	"path/filepath"
@@ -17,6 +16,7 @@ import (
 	"github.com/open-lambda/open-lambda/registry"
 	"github.com/open-lambda/open-lambda/worker/config"
 	"github.com/open-lambda/open-lambda/worker/server"
+	"github.com/open-lambda/open-lambda/worker/tlsutil"
 	"github.com/urfave/cli"
 )
 
@@ -133,6 +133,7 @@ func newCluster(ctx *cli.Context) error {
 		Sandbox:        "docker",
 		Reg_dir:        registryPath(cluster),
 		Worker_dir:     workerPath(cluster, "default"),
+		Coord_dir:      path.Join(cluster, "coord"),
 		Sandbox_config: map[string]interface{}{"processes": 10},
 	}
 	if err := c.Defaults(); err != nil {
@@ -194,8 +195,12 @@ func status(ctx *cli.Context) error {
 					return err
 				}
 
-				url := fmt.Sprintf("http://localhost:%s/status", c.Worker_port)
-				response, err := http.Get(url)
+				reloader, err := tlsutil.New(c.Tls)
+				if err != nil {
+					return err
+				}
+				url := fmt.Sprintf("%s://localhost:%s/status", reloader.Scheme(), c.Worker_port)
+				response, err := reloader.Client(0).Get(url)
 				if err != nil {
 					fmt.Printf("  Could not send GET to %s\n", url)
 					continue
@@ -761,6 +766,42 @@ OPTIONS:
 			},
 			Action: worker_exec,
 		},
+		cli.Command{
+			Name:        "invoke-local",
+			Usage:       "Run one invocation of a handler without a worker",
+			UsageText:   "admin invoke-local (-c|--config=FILE|--cluster=NAME) (--handler=NAME|--dir=PATH) [--payload=FILE] [--json]",
+			Description: "Pull the handler (or read its code from a directory), create a sandbox with the configured backend, run one invocation with the payload, print the response, the handler output and the time spent in each phase, and remove everything. The worker and registry directories used are temporary, so a worker running with the same config is not disturbed. Exits with an error unless the handler succeeds.",
+			Flags: []cli.Flag{
+				clusterFlag,
+				cli.StringFlag{
+					Name:  "config, c",
+					Usage: "Load worker configuration from `FILE` (default: the template of the cluster)",
+				},
+				cli.StringFlag{
+					Name:  "handler",
+					Usage: "`NAME` of the handler to pull from the registry",
+				},
+				cli.StringFlag{
+					Name:  "dir",
+					Usage: "`PATH` to the code of the handler, instead of pulling it",
+				},
+				cli.StringFlag{
+					Name:  "payload",
+					Usage: "Read the request body from `FILE` (- for stdin)",
+					Value: "-",
+				},
+				cli.IntFlag{
+					Name:  "timeout",
+					Usage: "Give up after `SECONDS`",
+					Value: 120,
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the result as JSON",
+				},
+			},
+			Action: invokeLocal,
+		},
 		cli.Command{
 			Name:        "rethinkdb",
 			Usage:       "Start one or more rethinkdb nodes",
@@ -861,6 +902,112 @@ OPTIONS:
 			},
 			Action: cgroup_sandbox,
 		},
+		cli.Command{
+			Name:        "aggregator",
+			Usage:       "Serve cluster-wide handler lists, stats, health and metrics",
+			UsageText:   "admin aggregator [--cluster=NAME] [--workers=ADDRS] [-p|--port=PORT] [--admin-key=KEY]",
+			Description: "Start an HTTP service that fans requests out to every worker of the cluster and merges the results. Workers are discovered from the cluster directory unless a list is given.",
+			Flags: []cli.Flag{
+				clusterFlag,
+				cli.StringFlag{
+					Name:  "workers",
+					Usage: "comma-separated worker `ADDRS` (host:port) to use instead of discovery",
+				},
+				cli.IntFlag{
+					Name:  "port, p",
+					Usage: "Serve aggregated results at `PORT`",
+					Value: 9090,
+				},
+				cli.IntFlag{
+					Name:  "timeout",
+					Usage: "Give up on a worker after `SECONDS`",
+					Value: 5,
+				},
+				cli.StringFlag{
+					Name:  "admin-key",
+					Usage: "`KEY` of the admin API (viewer role), to read stats and metrics",
+				},
+			},
+			Action: aggregate,
+		},
+		cli.Command{
+			Name:        "balancer",
+			Usage:       "Start a load balancer in front of the workers",
+			UsageText:   "admin balancer --cluster=NAME [--workers=ADDRS] [-p|--port=PORT]",
+			Description: "Start a load balancer that spreads requests over the workers of the cluster. Requests to handlers with session_affinity in their lambda-config.json are routed to the same worker per session key.",
+			Flags: []cli.Flag{
+				clusterFlag,
+				cli.StringFlag{
+					Name:  "workers",
+					Usage: "comma-separated worker `ADDRS` (host:port) to use instead of discovery",
+				},
+				cli.IntFlag{
+					Name:  "port, p",
+					Usage: "Listen for requests at `PORT`",
+					Value: 9080,
+				},
+			},
+			Action: balance,
+		},
+		cli.Command{
+			Name:        "bench",
+			Usage:       "Benchmark a worker with a synthetic workload",
+			UsageText:   "admin bench (--cluster=NAME|--target=URL) --handlers=NAME[:WEIGHT],... [-c N] [-n N|--duration=SECONDS] [--json]",
+			Description: "Invoke a mix of handlers, with payloads of the given sizes, and report latency percentiles by type of start (cold, warm or hot), overall and per handler. The workload is drawn from the seed, so runs with the same flags send the same requests. With --evict-every, sandboxes are evicted now and then to measure cold starts.",
+			Flags: []cli.Flag{
+				clusterFlag,
+				cli.StringFlag{
+					Name:  "target",
+					Usage: "base `URL` of the worker or balancer (default: the first worker of the cluster)",
+				},
+				cli.StringFlag{
+					Name:  "handlers",
+					Usage: "comma-separated `NAME[:WEIGHT]` of the handlers to invoke",
+				},
+				cli.IntFlag{
+					Name:  "concurrency, c",
+					Usage: "Keep `N` invocations in flight",
+					Value: 1,
+				},
+				cli.IntFlag{
+					Name:  "requests, n",
+					Usage: "Send `N` invocations in all",
+					Value: 100,
+				},
+				cli.IntFlag{
+					Name:  "duration",
+					Usage: "Send invocations for `SECONDS` instead of a number of them",
+				},
+				cli.StringFlag{
+					Name:  "payload-sizes",
+					Usage: "comma-separated `SIZES` in bytes of the bodies sent",
+					Value: "64",
+				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Usage: "`SEED` of the workload",
+					Value: 1,
+				},
+				cli.IntFlag{
+					Name:  "evict-every",
+					Usage: "Evict the sandbox of a handler before every `N`th invocation of it",
+				},
+				cli.StringFlag{
+					Name:  "admin-key",
+					Usage: "`KEY` of the admin API, to evict sandboxes",
+				},
+				cli.IntFlag{
+					Name:  "timeout",
+					Usage: "Give up on an invocation after `SECONDS`",
+					Value: 30,
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the report as JSON",
+				},
+			},
+			Action: benchmark,
+		},
 		cli.Command{
 			Name:      "kill",
 			Usage:     "Kill containers and processes in a cluster",
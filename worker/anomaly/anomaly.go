@@ -0,0 +1,216 @@
+// anomaly package watches the outcome of invocations and raises an alert
+// when the error or timeout rate of a handler crosses a threshold.
+//
+// Rates are computed over a sliding window made of BUCKETS buckets. An alert
+// fires once when a rate reaches its threshold and is cleared only after the
+// rate drops below threshold*clear_ratio, so a rate hovering around the
+// threshold does not flap. Rates are evaluated on every invocation and on
+// every Tick, which also forgets handlers without requests in their window.
+package anomaly
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Outcomes of an invocation.
+const (
+	OK      = iota
+	ERROR   // failed, or returned a 5xx status
+	TIMEOUT // timed out
+)
+
+// Kinds of alerts.
+const (
+	ERRORS   = "errors"
+	TIMEOUTS = "timeouts"
+)
+
+// BUCKETS is the number of buckets per window.
+const BUCKETS = 10
+
+// Alert describes a change of the alert state of a handler.
+type Alert struct {
+	Handler   string
+	Kind      string // ERRORS or TIMEOUTS
+	Firing    bool   // false when the alert clears
+	Rate      float64
+	Threshold float64
+	Requests  int
+}
+
+func (a Alert) String() string {
+	state := "cleared"
+	if a.Firing {
+		state = "firing"
+	}
+	return fmt.Sprintf("%s rate of %s %s: %.2f (threshold %.2f, %d requests)",
+		a.Kind, a.Handler, state, a.Rate, a.Threshold, a.Requests)
+}
+
+// Thresholds configure a Detector. A zero rate disables that kind of alert.
+type Thresholds struct {
+	Window      time.Duration
+	MinRequests int
+	ErrorRate   float64
+	TimeoutRate float64
+	ClearRatio  float64
+}
+
+type bucket struct {
+	start    time.Time
+	requests int
+	errors   int
+	timeouts int
+}
+
+// window is the recent history of one handler.
+type window struct {
+	buckets [BUCKETS]bucket
+	firing  map[string]bool
+}
+
+// Detector tracks outcomes of every handler and calls notify on alert
+// changes.
+type Detector struct {
+	mutex   sync.Mutex
+	th      Thresholds
+	notify  func(Alert)
+	now     func() time.Time
+	windows map[string]*window
+}
+
+// NewDetector creates a Detector calling notify (synchronously, without
+// locks held) whenever an alert fires or clears.
+func NewDetector(th Thresholds, notify func(Alert)) *Detector {
+	return &Detector{
+		th:      th,
+		notify:  notify,
+		now:     time.Now,
+		windows: make(map[string]*window),
+	}
+}
+
+// Record notes the outcome of one invocation of handler.
+func (d *Detector) Record(handler string, outcome int) {
+	d.mutex.Lock()
+	w := d.windows[handler]
+	if w == nil {
+		w = &window{firing: make(map[string]bool)}
+		d.windows[handler] = w
+	}
+
+	// find the bucket of now, recycling it if it belongs to an older
+	// window
+	now := d.now()
+	width := d.th.Window / BUCKETS
+	slot := now.Truncate(width)
+	b := &w.buckets[(slot.UnixNano()/int64(width))%BUCKETS]
+	if !b.start.Equal(slot) {
+		*b = bucket{start: slot}
+	}
+	b.requests += 1
+	if outcome == ERROR {
+		b.errors += 1
+	} else if outcome == TIMEOUT {
+		b.timeouts += 1
+	}
+
+	alerts, _ := d.evaluate(w, handler, now)
+	d.mutex.Unlock()
+
+	for _, a := range alerts {
+		d.notify(a)
+	}
+}
+
+// Tick evaluates the window of every handler, so that the alerts of
+// handlers that stopped getting requests clear too, and forgets handlers
+// without requests in their window.
+func (d *Detector) Tick() {
+	d.mutex.Lock()
+	now := d.now()
+	alerts := []Alert{}
+	for handler, w := range d.windows {
+		changed, requests := d.evaluate(w, handler, now)
+		alerts = append(alerts, changed...)
+		if requests == 0 {
+			delete(d.windows, handler)
+		}
+	}
+	d.mutex.Unlock()
+
+	for _, a := range alerts {
+		d.notify(a)
+	}
+}
+
+// Run calls Tick every bucket, until stop is closed.
+func (d *Detector) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.th.Window / BUCKETS)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.Tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// evaluate updates the alert states of a handler from the buckets still in
+// its window, returning the alerts that changed and the number of requests
+// in the window. The caller must hold the mutex.
+func (d *Detector) evaluate(w *window, handler string, now time.Time) ([]Alert, int) {
+	requests, errors, timeouts := 0, 0, 0
+	for _, b := range w.buckets {
+		if now.Sub(b.start) < d.th.Window {
+			requests += b.requests
+			errors += b.errors
+			timeouts += b.timeouts
+		}
+	}
+
+	alerts := []Alert{}
+	if a := d.check(w, handler, ERRORS, d.th.ErrorRate, errors, requests); a != nil {
+		alerts = append(alerts, *a)
+	}
+	if a := d.check(w, handler, TIMEOUTS, d.th.TimeoutRate, timeouts, requests); a != nil {
+		alerts = append(alerts, *a)
+	}
+	return alerts, requests
+}
+
+// check updates the alert state of one kind, returning the alert if it
+// changed. The caller must hold the mutex.
+func (d *Detector) check(w *window, handler, kind string, threshold float64, count, requests int) *Alert {
+	if threshold == 0 {
+		return nil
+	}
+
+	// an empty window has a zero rate, which clears a firing alert
+	rate := 0.0
+	if requests > 0 {
+		rate = float64(count) / float64(requests)
+	}
+	firing := w.firing[kind]
+	if !firing && requests >= d.th.MinRequests && rate >= threshold {
+		firing = true
+	} else if firing && rate < threshold*d.th.ClearRatio {
+		firing = false
+	} else {
+		return nil
+	}
+
+	w.firing[kind] = firing
+	return &Alert{
+		Handler:   handler,
+		Kind:      kind,
+		Firing:    firing,
+		Rate:      rate,
+		Threshold: threshold,
+		Requests:  requests,
+	}
+}
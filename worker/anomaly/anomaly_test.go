@@ -0,0 +1,105 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHysteresis(t *testing.T) {
+	alerts := []Alert{}
+	d := NewDetector(Thresholds{
+		Window:      10 * time.Second,
+		MinRequests: 4,
+		ErrorRate:   0.5,
+		ClearRatio:  0.5,
+	}, func(a Alert) { alerts = append(alerts, a) })
+
+	now := time.Unix(1000, 0)
+	d.now = func() time.Time { return now }
+
+	// below the minimum number of requests, nothing fires
+	d.Record("hello", ERROR)
+	d.Record("hello", ERROR)
+	d.Record("hello", ERROR)
+	if len(alerts) != 0 {
+		t.Fatalf("fired too early: %v", alerts)
+	}
+
+	d.Record("hello", ERROR)
+	if len(alerts) != 1 || !alerts[0].Firing || alerts[0].Kind != ERRORS {
+		t.Fatalf("expected alert to fire, got %v", alerts)
+	}
+
+	// dropping just below the threshold does not clear it: 4/9 = 0.44
+	for i := 0; i < 5; i++ {
+		d.Record("hello", OK)
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("alert flapped: %v", alerts)
+	}
+
+	// another handler is tracked separately
+	d.Record("other", OK)
+	if len(alerts) != 1 {
+		t.Fatalf("unexpected alerts: %v", alerts)
+	}
+
+	// once the errors leave the window, the rate drops and it clears
+	now = now.Add(11 * time.Second)
+	d.Record("hello", OK)
+	if len(alerts) != 2 || alerts[1].Firing {
+		t.Fatalf("expected alert to clear, got %v", alerts)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	alerts := []Alert{}
+	d := NewDetector(Thresholds{
+		Window:      time.Minute,
+		MinRequests: 1,
+		TimeoutRate: 0.1,
+		ClearRatio:  0.5,
+	}, func(a Alert) { alerts = append(alerts, a) })
+
+	d.Record("hello", ERROR)
+	if len(alerts) != 0 {
+		t.Fatalf("error rate alert is disabled, got %v", alerts)
+	}
+	d.Record("hello", TIMEOUT)
+	if len(alerts) != 1 || alerts[0].Kind != TIMEOUTS {
+		t.Fatalf("expected timeout alert, got %v", alerts)
+	}
+}
+
+func TestTickClears(t *testing.T) {
+	alerts := []Alert{}
+	d := NewDetector(Thresholds{
+		Window:      10 * time.Second,
+		MinRequests: 1,
+		ErrorRate:   0.5,
+		ClearRatio:  0.5,
+	}, func(a Alert) { alerts = append(alerts, a) })
+
+	now := time.Unix(1000, 0)
+	d.now = func() time.Time { return now }
+
+	d.Record("hello", ERROR)
+	if len(alerts) != 1 || !alerts[0].Firing {
+		t.Fatalf("expected alert to fire, got %v", alerts)
+	}
+
+	// without further requests, the alert clears once the window is empty,
+	// and the handler is forgotten
+	d.Tick()
+	if len(alerts) != 1 || len(d.windows) != 1 {
+		t.Fatalf("cleared too early: %v", alerts)
+	}
+	now = now.Add(11 * time.Second)
+	d.Tick()
+	if len(alerts) != 2 || alerts[1].Firing {
+		t.Fatalf("expected alert to clear, got %v", alerts)
+	}
+	if len(d.windows) != 0 {
+		t.Fatalf("expected idle handler to be forgotten, got %d windows", len(d.windows))
+	}
+}
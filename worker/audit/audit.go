@@ -0,0 +1,144 @@
+// audit package keeps an append-only record of administrative actions taken
+// on a worker.
+//
+// Entries are stored one JSON object per line, so the log can be inspected
+// with standard tools and is never rewritten in place.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Actions recorded in the audit log.
+const (
+	EVICT         = "evict"
+	DRAIN         = "drain"
+	CONFIG_CHANGE = "config.change"
+	ALIAS_UPDATE  = "alias.update"
+	SECRET_CHANGE = "secret.change"
+	DEPLOY        = "deploy"
+	SYSCALL_AUDIT = "syscall.audit"
+	ACCESS        = "access" // authorization decision on an admin request
+)
+
+// Entry is one administrative action.
+type Entry struct {
+	Time   time.Time         `json:"time"`
+	Actor  string            `json:"actor"`
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+	Result string            `json:"result"` // "ok", or the error
+}
+
+// Log is an append-only audit log backed by a file.
+type Log struct {
+	mutex  sync.Mutex
+	path   string
+	file   *os.File
+	sink   string
+	client *http.Client
+}
+
+// Open opens the audit log at path, creating it if needed. If sink is not
+// empty, every entry is also POSTed there as JSON.
+func Open(path, sink string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{
+		path:   path,
+		file:   file,
+		sink:   sink,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Record appends an entry for an action. A nil error is recorded as "ok".
+func (l *Log) Record(actor, action string, params map[string]string, result error) error {
+	e := &Entry{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Action: action,
+		Params: params,
+		Result: "ok",
+	}
+	if result != nil {
+		e.Result = result.Error()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	_, err = l.file.Write(append(line, '\n'))
+	if err == nil {
+		err = l.file.Sync()
+	}
+	l.mutex.Unlock()
+	if err != nil {
+		log.WithError(err).WithField("action", action).Error("could not write audit log")
+		return err
+	}
+
+	if l.sink != "" {
+		go l.ship(line)
+	}
+	return nil
+}
+
+// ship sends one entry to the external sink.
+func (l *Log) ship(line []byte) {
+	resp, err := l.client.Post(l.sink, "application/json", bytes.NewReader(line))
+	if err != nil {
+		log.WithError(err).WithField("sink", l.sink).Warn("could not ship audit entry")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.WithField("sink", l.sink).Warnf("audit sink returned %s", resp.Status)
+	}
+}
+
+// Entries returns up to limit of the most recent entries, oldest first. A
+// limit of 0 returns all entries.
+func (l *Log) Entries(limit int) ([]Entry, error) {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	return entries, nil
+}
+
+// Close closes the file of the log.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
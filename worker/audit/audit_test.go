@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecordAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	l, err := Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record("alice", EVICT, map[string]string{"handler": "hello"}, nil)
+	l.Record("bob", DRAIN, nil, errors.New("already draining"))
+	l.Close()
+
+	// reopening appends rather than truncates
+	l, err = Open(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.Record("alice", EVICT, map[string]string{"handler": "echo"}, nil)
+
+	entries, err := l.Entries(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if entries[1].Actor != "bob" || entries[1].Result != "already draining" {
+		t.Fatalf("unexpected entry %+v", entries[1])
+	}
+
+	entries, err = l.Entries(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Params["handler"] != "echo" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+}
@@ -0,0 +1,221 @@
+// balancer package implements an HTTP load balancer that spreads run lambda
+// requests over the workers of a cluster.
+package balancer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/registry"
+)
+
+// how long handler metadata is cached before being re-read
+const metaTTL = 10 * time.Second
+
+// how long pulling the code of a handler to read its metadata may take
+const metaPullTimeout = 10 * time.Second
+
+// metaEntry is a cached HandlerMeta.
+type metaEntry struct {
+	meta     *meta.HandlerMeta
+	loadedAt time.Time
+}
+
+// Balancer forwards run lambda requests to workers. Requests to handlers
+// marked session-affine are routed by session key with rendezvous hashing,
+// so a key keeps going to the same worker as long as it is up; other
+// requests are spread round robin.
+type Balancer struct {
+	mutex   sync.Mutex
+	workers []string
+	rm      registry.RegistryManager
+	cache   map[string]*metaEntry
+	next    uint32
+	client  *http.Client
+	scheme  string
+}
+
+// NewBalancer creates a Balancer that reads handler metadata from the code
+// pulled by rm, i.e., from the registry the workers pull from.
+func NewBalancer(workers []string, rm registry.RegistryManager) *Balancer {
+	return &Balancer{
+		workers: workers,
+		rm:      rm,
+		cache:   make(map[string]*metaEntry),
+		client:  &http.Client{},
+		scheme:  "http",
+	}
+}
+
+// UseTLS makes the Balancer connect to workers over HTTPS with the given
+// config, e.g. to present a client certificate to workers requiring one.
+func (b *Balancer) UseTLS(conf *tls.Config) {
+	b.client = &http.Client{Transport: &http.Transport{TLSClientConfig: conf}}
+	b.scheme = "https"
+}
+
+// SetWorkers replaces the set of workers (host:port) to balance over.
+func (b *Balancer) SetWorkers(workers []string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.workers = workers
+}
+
+// handlerMeta returns the (possibly cached) metadata of a handler.
+func (b *Balancer) handlerMeta(name string) (*meta.HandlerMeta, error) {
+	b.mutex.Lock()
+	entry := b.cache[name]
+	b.mutex.Unlock()
+	if entry != nil && time.Since(entry.loadedAt) < metaTTL {
+		return entry.meta, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), metaPullTimeout)
+	defer cancel()
+	dir, err := b.rm.Pull(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	m, err := meta.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mutex.Lock()
+	b.cache[name] = &metaEntry{meta: m, loadedAt: time.Now()}
+	b.mutex.Unlock()
+	return m, nil
+}
+
+// SessionKey extracts the session key of a request according to the
+// affinity settings, returning "" if the request has none.
+func SessionKey(affinity *meta.SessionAffinity, r *http.Request, body []byte) string {
+	if affinity.Header != "" {
+		if key := r.Header.Get(affinity.Header); key != "" {
+			return key
+		}
+	}
+
+	if affinity.Field != "" {
+		var event map[string]interface{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			return ""
+		}
+		if val, ok := event[affinity.Field]; ok && val != nil {
+			return fmt.Sprintf("%v", val)
+		}
+	}
+
+	return ""
+}
+
+// RendezvousOrder sorts workers by their highest-random-weight score for the
+// key. The first worker is the preferred one; the rest are fallbacks in
+// order. Adding or removing a worker only moves the keys that hash to it.
+func RendezvousOrder(key string, workers []string) []string {
+	scores := make(map[string]uint64, len(workers))
+	for _, worker := range workers {
+		h := fnv.New64a()
+		io.WriteString(h, key)
+		io.WriteString(h, "/")
+		io.WriteString(h, worker)
+		scores[worker] = h.Sum64()
+	}
+
+	ordered := append([]string{}, workers...)
+	sort.Slice(ordered, func(i, j int) bool {
+		return scores[ordered[i]] > scores[ordered[j]]
+	})
+	return ordered
+}
+
+// candidates returns the workers to try for a request, in order.
+func (b *Balancer) candidates(r *http.Request, body []byte) []string {
+	b.mutex.Lock()
+	workers := b.workers
+	b.mutex.Unlock()
+
+	if len(workers) == 0 {
+		return nil
+	}
+
+	// components represent runLambda[0]/<name_of_sandbox>[1]/<extra_things>...
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) >= 2 && parts[0] == "runLambda" {
+		if m, err := b.handlerMeta(parts[1]); err != nil {
+			log.WithError(err).WithField("handler", parts[1]).Warn("could not load metadata")
+		} else if m.Session_affinity != nil {
+			if key := SessionKey(m.Session_affinity, r, body); key != "" {
+				return RendezvousOrder(key, workers)
+			}
+		}
+	}
+
+	// round robin, falling back to the following workers
+	start := int(atomic.AddUint32(&b.next, 1)) % len(workers)
+	return append(append([]string{}, workers[start:]...), workers[:start]...)
+}
+
+// ServeHTTP forwards a request to a worker. If a worker cannot be reached,
+// the next candidate is tried.
+func (b *Balancer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := []byte{}
+	if r.Body != nil {
+		defer r.Body.Close()
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	workers := b.candidates(r, body)
+	if len(workers) == 0 {
+		http.Error(w, "no workers available", http.StatusServiceUnavailable)
+		return
+	}
+
+	for _, worker := range workers {
+		url := fmt.Sprintf("%s://%s%s", b.scheme, worker, r.URL.RequestURI())
+		r2, err := http.NewRequest(r.Method, url, bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for k, vals := range r.Header {
+			r2.Header[k] = vals
+		}
+
+		w2, err := b.client.Do(r2)
+		if err != nil {
+			log.WithError(err).WithField("worker", worker).Warn("could not reach worker")
+			continue
+		}
+		defer w2.Body.Close()
+
+		for k, vals := range w2.Header {
+			w.Header()[k] = vals
+		}
+		w.WriteHeader(w2.StatusCode)
+		if _, err := io.Copy(w, w2.Body); err != nil {
+			log.WithError(err).WithField("worker", worker).Warn("could not copy response from worker")
+		}
+		return
+	}
+
+	http.Error(w, "could not reach any worker", http.StatusBadGateway)
+}
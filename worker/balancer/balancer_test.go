@@ -0,0 +1,85 @@
+package balancer
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/registry"
+)
+
+func TestRendezvousStable(t *testing.T) {
+	workers := []string{"a:8080", "b:8080", "c:8080", "d:8080"}
+	first := RendezvousOrder("session-1", workers)[0]
+	for i := 0; i < 10; i++ {
+		if w := RendezvousOrder("session-1", workers)[0]; w != first {
+			t.Fatalf("key moved from %s to %s", first, w)
+		}
+	}
+
+	// removing a worker other than the preferred one must not move the key
+	remaining := []string{}
+	removed := false
+	for _, w := range workers {
+		if w != first && !removed {
+			removed = true
+			continue
+		}
+		remaining = append(remaining, w)
+	}
+	if w := RendezvousOrder("session-1", remaining)[0]; w != first {
+		t.Fatalf("key moved from %s to %s after removing another worker", first, w)
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	affinity := &meta.SessionAffinity{Header: "X-Session", Field: "user"}
+
+	r, _ := http.NewRequest("POST", "/runLambda/chat", nil)
+	if key := SessionKey(affinity, r, []byte(`{"user": "alice"}`)); key != "alice" {
+		t.Fatalf("Unexpected key from field: %q", key)
+	}
+
+	r.Header.Set("X-Session", "s1")
+	if key := SessionKey(affinity, r, []byte(`{"user": "alice"}`)); key != "s1" {
+		t.Fatalf("Unexpected key from header: %q", key)
+	}
+
+	r.Header.Del("X-Session")
+	if key := SessionKey(affinity, r, []byte(`not json`)); key != "" {
+		t.Fatalf("Unexpected key from bad body: %q", key)
+	}
+}
+
+func TestCandidatesAffinity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "balancer_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "chat"), 0755)
+	conf := []byte(`{"session_affinity": {"header": "X-Session"}}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, "chat", "lambda-config.json"), conf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rm, err := registry.NewLocalManager(&config.Config{Reg_dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	workers := []string{"a:8080", "b:8080", "c:8080"}
+	b := NewBalancer(workers, rm)
+
+	r, _ := http.NewRequest("POST", "/runLambda/chat", nil)
+	r.Header.Set("X-Session", "s1")
+	want := RendezvousOrder("s1", workers)[0]
+	for i := 0; i < len(workers); i++ {
+		if got := b.candidates(r, nil)[0]; got != want {
+			t.Fatalf("expected session to go to %s, got %s", want, got)
+		}
+	}
+}
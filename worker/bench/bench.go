@@ -0,0 +1,381 @@
+// bench package drives synthetic invocation workloads against a worker (or a
+// balancer), and reports their latency percentiles by type of start, so that
+// the performance of the sandbox and handler path can be compared across
+// changes.
+//
+// The sequence of invocations (which handler, with which payload) is drawn
+// from a seeded random source, so that runs with the same options send the
+// same workload.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+)
+
+// UNKNOWN is the type of start of invocations whose response does not say
+// (e.g., failed ones).
+const UNKNOWN = "unknown"
+
+// Weighted is a handler of the mix, invoked in proportion to its weight.
+type Weighted struct {
+	Handler string
+	Weight  int
+}
+
+// Options describe a workload.
+type Options struct {
+	Target      string     // base URL of the worker or balancer
+	Handlers    []Weighted // mix of handlers invoked
+	Concurrency int        // invocations in flight at once
+	Requests    int        // number of invocations, unless Duration is set
+	Duration    time.Duration
+	Payloads    []int // sizes of the bodies sent, in bytes, picked at random
+	Seed        int64
+
+	// evict the sandbox of a handler (through the admin API, with
+	// AdminKey if set) before every EvictEvery-th invocation of it, so
+	// that cold starts are measured too; works best with a concurrency
+	// of 1, as only paused sandboxes can be evicted
+	EvictEvery int
+	AdminKey   string
+
+	Client *http.Client // defaults to http.DefaultClient
+}
+
+// Stats summarizes the latencies of a set of invocations, in milliseconds.
+// Failed invocations are counted, but their latencies left out.
+type Stats struct {
+	Count  int     `json:"count"`
+	Errors int     `json:"errors"`
+	Mean   float64 `json:"mean_ms"`
+	P50    float64 `json:"p50_ms"`
+	P90    float64 `json:"p90_ms"`
+	P99    float64 `json:"p99_ms"`
+	Max    float64 `json:"max_ms"`
+}
+
+// Report is the outcome of a workload.
+type Report struct {
+	Requests   int                          `json:"requests"`
+	Errors     int                          `json:"errors"`
+	Seconds    float64                      `json:"seconds"`
+	Throughput float64                      `json:"throughput"` // invocations per second
+	ByStart    map[string]*Stats            `json:"by_start"`
+	ByHandler  map[string]map[string]*Stats `json:"by_handler"` // by handler, then type of start
+}
+
+// result is the outcome of one invocation.
+type result struct {
+	handler string
+	start   string
+	latency time.Duration
+	err     error
+}
+
+// invocation is one step of the workload.
+type invocation struct {
+	handler string
+	payload []byte
+	evict   bool
+}
+
+// schedule draws the invocations of a workload from its seed.
+type schedule struct {
+	mutex  sync.Mutex
+	opts   *Options
+	rand   *rand.Rand
+	total  int
+	counts map[string]int
+	sent   int
+}
+
+func newSchedule(opts *Options) *schedule {
+	total := 0
+	for _, h := range opts.Handlers {
+		total += h.Weight
+	}
+	return &schedule{
+		opts:   opts,
+		rand:   rand.New(rand.NewSource(opts.Seed)),
+		total:  total,
+		counts: make(map[string]int),
+	}
+}
+
+// next returns the next invocation, or false once the workload is over.
+func (s *schedule) next(deadline time.Time) (*invocation, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.opts.Duration > 0 && !time.Now().Before(deadline) {
+		return nil, false
+	} else if s.opts.Duration == 0 && s.sent >= s.opts.Requests {
+		return nil, false
+	}
+	s.sent++
+
+	n := s.rand.Intn(s.total)
+	inv := &invocation{}
+	for _, h := range s.opts.Handlers {
+		if n < h.Weight {
+			inv.handler = h.Handler
+			break
+		}
+		n -= h.Weight
+	}
+	size := 0
+	if len(s.opts.Payloads) > 0 {
+		size = s.opts.Payloads[s.rand.Intn(len(s.opts.Payloads))]
+	}
+	inv.payload = Payload(size)
+
+	count := s.counts[inv.handler]
+	s.counts[inv.handler]++
+	inv.evict = s.opts.EvictEvery > 0 && count > 0 && count%s.opts.EvictEvery == 0
+	return inv, true
+}
+
+// Payload returns a JSON object of about size bytes (at least 2).
+func Payload(size int) []byte {
+	const wrapper = `{"data":""}`
+	if size <= len(wrapper) {
+		return []byte("{}")
+	}
+	return []byte(`{"data":"` + strings.Repeat("x", size-len(wrapper)) + `"}`)
+}
+
+// Run sends the workload, and reports on it once it is over or ctx is done.
+func Run(ctx context.Context, opts Options) (*Report, error) {
+	if len(opts.Handlers) == 0 {
+		return nil, fmt.Errorf("no handlers to invoke")
+	}
+	for _, h := range opts.Handlers {
+		if h.Weight <= 0 {
+			return nil, fmt.Errorf("weight of %s must be positive", h.Handler)
+		}
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Duration == 0 && opts.Requests <= 0 {
+		return nil, fmt.Errorf("need a number of requests or a duration")
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	opts.Target = strings.TrimSuffix(opts.Target, "/")
+
+	sched := newSchedule(&opts)
+	results := make(chan *result, opts.Concurrency)
+	begin := time.Now()
+	deadline := begin.Add(opts.Duration)
+
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				inv, ok := sched.next(deadline)
+				if !ok {
+					return
+				}
+				if inv.evict {
+					evict(ctx, &opts, inv.handler)
+				}
+				results <- invoke(ctx, &opts, inv)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	all := []*result{}
+	for r := range results {
+		all = append(all, r)
+	}
+	return summarize(all, time.Since(begin)), nil
+}
+
+// invoke runs one invocation, and times it.
+func invoke(ctx context.Context, opts *Options, inv *invocation) *result {
+	r := &result{handler: inv.handler, start: UNKNOWN}
+	req, err := http.NewRequest("POST", opts.Target+"/runLambda/"+inv.handler, bytes.NewReader(inv.payload))
+	if err != nil {
+		r.err = err
+		return r
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	begin := time.Now()
+	resp, err := opts.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		r.err = err
+		return r
+	}
+	_, err = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	r.latency = time.Since(begin)
+
+	if start := resp.Header.Get(startup.HEADER); start != "" {
+		r.start = start
+	}
+	if err != nil {
+		r.err = err
+	} else if resp.StatusCode >= 300 {
+		r.err = fmt.Errorf("%s returned %s", inv.handler, resp.Status)
+	}
+	return r
+}
+
+// evict asks the worker to evict the sandbox of the handler. Failures (e.g.,
+// the sandbox is not paused) only mean the next start is not cold.
+func evict(ctx context.Context, opts *Options, handler string) {
+	req, err := http.NewRequest("POST", opts.Target+"/admin/evict/"+handler, nil)
+	if err != nil {
+		return
+	}
+	if opts.AdminKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.AdminKey)
+	}
+	if resp, err := opts.Client.Do(req.WithContext(ctx)); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// summarize computes the report of the results of a workload that took the
+// given time.
+func summarize(results []*result, elapsed time.Duration) *Report {
+	report := &Report{
+		Requests:  len(results),
+		Seconds:   elapsed.Seconds(),
+		ByStart:   make(map[string]*Stats),
+		ByHandler: make(map[string]map[string]*Stats),
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(len(results)) / elapsed.Seconds()
+	}
+
+	type key struct{ handler, start string }
+	byStart := make(map[string][]*result)
+	byHandler := make(map[key][]*result)
+	for _, r := range results {
+		if r.err != nil {
+			report.Errors++
+		}
+		byStart[r.start] = append(byStart[r.start], r)
+		byHandler[key{r.handler, r.start}] = append(byHandler[key{r.handler, r.start}], r)
+	}
+	for start, rs := range byStart {
+		report.ByStart[start] = newStats(rs)
+	}
+	for k, rs := range byHandler {
+		if report.ByHandler[k.handler] == nil {
+			report.ByHandler[k.handler] = make(map[string]*Stats)
+		}
+		report.ByHandler[k.handler][k.start] = newStats(rs)
+	}
+	return report
+}
+
+// newStats summarizes the latencies of the results.
+func newStats(results []*result) *Stats {
+	s := &Stats{Count: len(results)}
+	ms := []float64{}
+	for _, r := range results {
+		if r.err != nil {
+			s.Errors++
+			continue
+		}
+		ms = append(ms, float64(r.latency)/float64(time.Millisecond))
+	}
+	if len(ms) == 0 {
+		return s
+	}
+	sort.Float64s(ms)
+	sum := 0.0
+	for _, v := range ms {
+		sum += v
+	}
+	s.Mean = sum / float64(len(ms))
+	s.P50 = percentile(ms, 50)
+	s.P90 = percentile(ms, 90)
+	s.P99 = percentile(ms, 99)
+	s.Max = ms[len(ms)-1]
+	return s
+}
+
+// percentile returns the p-th percentile of sorted values, by nearest rank.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// ParseMix parses a mix of handlers given as "name[:weight],...", weights
+// defaulting to 1.
+func ParseMix(mix string) ([]Weighted, error) {
+	handlers := []Weighted{}
+	for _, item := range strings.Split(mix, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		w := Weighted{Handler: item, Weight: 1}
+		if i := strings.LastIndex(item, ":"); i >= 0 {
+			weight, err := strconv.Atoi(item[i+1:])
+			if err != nil || weight <= 0 {
+				return nil, fmt.Errorf("bad weight in %q", item)
+			}
+			w = Weighted{Handler: item[:i], Weight: weight}
+		}
+		handlers = append(handlers, w)
+	}
+	return handlers, nil
+}
+
+// Print writes the report as tables, overall and by handler.
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "%d requests in %.1fs (%.1f/s), %d errors\n\n", r.Requests, r.Seconds, r.Throughput, r.Errors)
+	printStats(w, "start", r.ByStart)
+
+	handlers := []string{}
+	for handler := range r.ByHandler {
+		handlers = append(handlers, handler)
+	}
+	sort.Strings(handlers)
+	for _, handler := range handlers {
+		fmt.Fprintf(w, "\n%s:\n", handler)
+		printStats(w, "start", r.ByHandler[handler])
+	}
+}
+
+// printStats writes one row of stats per type of start.
+func printStats(w io.Writer, label string, stats map[string]*Stats) {
+	fmt.Fprintf(w, "%-8s %8s %7s %9s %9s %9s %9s %9s\n", label, "count", "errors", "mean ms", "p50 ms", "p90 ms", "p99 ms", "max ms")
+	for _, start := range []string{startup.COLD, startup.WARM, startup.HOT, UNKNOWN} {
+		if s := stats[start]; s != nil {
+			fmt.Fprintf(w, "%-8s %8d %7d %9.2f %9.2f %9.2f %9.2f %9.2f\n",
+				start, s.Count, s.Errors, s.Mean, s.P50, s.P90, s.P99, s.Max)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+)
+
+// fakeWorker answers invocations the way a worker does, the first of each
+// handler being cold and the following warm, and fails those of "bad".
+type fakeWorker struct {
+	mutex   sync.Mutex
+	seen    map[string]bool
+	evicted []string
+	bodies  []int
+}
+
+func (f *fakeWorker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/admin/evict/") {
+		name := strings.TrimPrefix(r.URL.Path, "/admin/evict/")
+		f.evicted = append(f.evicted, name)
+		delete(f.seen, name)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/runLambda/")
+	body, _ := ioutil.ReadAll(r.Body)
+	f.bodies = append(f.bodies, len(body))
+	if name == "bad" {
+		http.Error(w, "failed", http.StatusInternalServerError)
+		return
+	}
+	if f.seen[name] {
+		w.Header().Set(startup.HEADER, startup.WARM)
+	} else {
+		w.Header().Set(startup.HEADER, startup.COLD)
+		f.seen[name] = true
+	}
+	w.Write([]byte("{}"))
+}
+
+func TestRun(t *testing.T) {
+	f := &fakeWorker{seen: make(map[string]bool)}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	report, err := Run(context.Background(), Options{
+		Target:      ts.URL,
+		Handlers:    []Weighted{{"f", 3}, {"g", 1}, {"bad", 1}},
+		Concurrency: 4,
+		Requests:    50,
+		Payloads:    []int{100, 1000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Requests != 50 {
+		t.Fatalf("expected 50 requests, got %d", report.Requests)
+	}
+	bad := report.ByHandler["bad"][UNKNOWN]
+	if bad == nil || bad.Errors != bad.Count || bad.Errors != report.Errors {
+		t.Fatalf("expected every invocation of bad to fail, got %+v", report.ByHandler["bad"])
+	}
+	if s := report.ByHandler["f"][startup.COLD]; s == nil || s.Count != 1 {
+		t.Fatalf("expected one cold start of f, got %+v", s)
+	}
+	if s := report.ByStart[startup.WARM]; s == nil || s.Count != 50-bad.Count-2 || s.P50 > s.P99 || s.P99 > s.Max {
+		t.Fatalf("unexpected warm stats %+v", s)
+	}
+	for _, size := range f.bodies {
+		if size != 100 && size != 1000 {
+			t.Fatalf("unexpected payload of %d bytes", size)
+		}
+	}
+}
+
+func TestReproducible(t *testing.T) {
+	opts := &Options{
+		Handlers: []Weighted{{"f", 1}, {"g", 2}},
+		Requests: 20,
+		Payloads: []int{10, 20, 30},
+		Seed:     42,
+	}
+	draw := func() []invocation {
+		s := newSchedule(opts)
+		invs := []invocation{}
+		for inv, ok := s.next(time.Time{}); ok; inv, ok = s.next(time.Time{}) {
+			invs = append(invs, *inv)
+		}
+		return invs
+	}
+	first, second := draw(), draw()
+	if len(first) != 20 || !reflect.DeepEqual(first, second) {
+		t.Fatal("workloads of the same seed differ")
+	}
+	opts.Seed = 43
+	if reflect.DeepEqual(first, draw()) {
+		t.Fatal("workloads of different seeds are the same")
+	}
+}
+
+func TestEvict(t *testing.T) {
+	f := &fakeWorker{seen: make(map[string]bool)}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	report, err := Run(context.Background(), Options{
+		Target:     ts.URL,
+		Handlers:   []Weighted{{"f", 1}},
+		Requests:   9,
+		EvictEvery: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.evicted) != 2 {
+		t.Fatalf("expected 2 evictions, got %v", f.evicted)
+	}
+	if s := report.ByStart[startup.COLD]; s == nil || s.Count != 3 {
+		t.Fatalf("expected 3 cold starts, got %+v", s)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{}
+	for i := 1; i <= 100; i++ {
+		values = append(values, float64(i))
+	}
+	for p, expected := range map[float64]float64{50: 50, 90: 90, 99: 99, 100: 100} {
+		if v := percentile(values, p); v != expected {
+			t.Fatalf("expected p%v of 1..100 to be %v, got %v", p, expected, v)
+		}
+	}
+	if v := percentile([]float64{7}, 99); v != 7 {
+		t.Fatalf("expected p99 of a single value to be it, got %v", v)
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	mix, err := ParseMix("f, g:3,h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(mix, []Weighted{{"f", 1}, {"g", 3}, {"h", 1}}) {
+		t.Fatalf("unexpected mix %v", mix)
+	}
+	for _, bad := range []string{"f:0", "f:x"} {
+		if _, err := ParseMix(bad); err == nil {
+			t.Fatalf("expected %q to be refused", bad)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	for _, size := range []int{0, 5, 100, 4096} {
+		p := Payload(size)
+		if size > 2 && len(p) != size && !bytes.Equal(p, []byte("{}")) {
+			t.Fatalf("payload of %d bytes has %d", size, len(p))
+		}
+	}
+}
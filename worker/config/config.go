@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -25,6 +26,34 @@ type Config struct {
 	Pool_dir        string `json:"pool_dir"`
 	Num_forkservers int    `json:"num_forkservers"`
 
+	// runtimes whose servers are forked into sandboxes from a pool
+	// (defaults to python); adding "ruby" or "r" starts a pool of Ruby or
+	// R forkservers, with the libraries of ruby_preload or r_preload
+	// already loaded
+	Pool_runtimes []string `json:"pool_runtimes"`
+
+	// libraries required by the Ruby server before it loads a handler
+	// (defaults to common gems of Rails code: json, time, bigdecimal,
+	// active_support and its core extensions); in a pool, they are
+	// loaded once and shared by all the servers forked from it
+	Ruby_preload []string `json:"ruby_preload"`
+
+	// R packages attached by the R server before it loads a handler,
+	// shared by the servers forked from the R pool like ruby_preload.
+	// They must be installed in the lambda-r image.
+	R_preload []string `json:"r_preload"`
+
+	// where layers (shared read-only bundles mounted into sandboxes) are
+	// pulled from: a directory or an http(s) URL, holding
+	// <name>/<version>.tar.gz for each layer. Pulled layers are cached
+	// in <worker_dir>/layers.
+	Layer_registry string `json:"layer_registry"`
+
+	// Python versions (e.g. "3.6") available to handlers besides the
+	// default 2.7, each provided by a lambda-python<version> image and,
+	// with a pool, a server-pool-python<version> image
+	Python_versions []string `json:"python_versions"`
+
 	// olregistry
 	Reg_cluster []string `json:"reg_cluster"`
 
@@ -38,6 +67,222 @@ type Config struct {
 	// sandbox factory
 	Sandbox_buffer int `json:"sandbox_buffer"`
 
+	// seconds between samples of sandbox resource usage; defaults to 10,
+	// negative disables sampling
+	Sandbox_stats_interval int `json:"sandbox_stats_interval"`
+
+	// seconds between checks for leaked sandboxes, i.e. sandboxes of the
+	// worker that no handler owns, and handlers whose sandbox is gone;
+	// defaults to 60, negative disables the checks
+	Leak_check_interval int `json:"leak_check_interval"`
+
+	// seconds between checks of the watchdog for handlers stuck in a state
+	// that does not match their sandbox; defaults to 30, negative disables
+	// the checks
+	Watchdog_interval int `json:"watchdog_interval"`
+
+	// percentage of the filesystem of worker_dir that must stay free;
+	// below it, the worker evicts idle handlers, prunes cached layers and
+	// truncates sandbox logs, and refuses to deploy new handlers. Defaults
+	// to 5, negative disables the checks
+	Disk_min_free int `json:"disk_min_free"`
+
+	// percentage of the open file limit of the worker process it may use
+	// before it warns and closes the idle connections to paused sandboxes;
+	// defaults to 80, negative disables the checks
+	Fd_budget int `json:"fd_budget"`
+
+	// largest scratch area (scratch_mb) a handler may ask for, in MB;
+	// handlers asking for more fail to deploy. Defaults to 4096
+	Max_scratch_mb int `json:"max_scratch_mb"`
+
+	// Linux capabilities kept by sandbox containers, all others being
+	// dropped; processes of sandboxes also cannot gain privileges (e.g.
+	// through setuid binaries). Defaults to a small set most handlers
+	// get by with; an explicitly empty list drops every capability
+	Sandbox_caps []string `json:"sandbox_caps"`
+
+	// let handlers keep more capabilities, or gain privileges, through
+	// the privileges field of their metadata; otherwise such handlers
+	// fail to deploy
+	Allow_privilege_opt_out bool `json:"allow_privilege_opt_out"`
+
+	// AppArmor profile and SELinux label (as <field>:<value>, e.g.
+	// "type:container_t") applied to docker sandboxes whose handlers do
+	// not ask for their own; the worker refuses to start if the host
+	// cannot apply them
+	Default_apparmor_profile string `json:"default_apparmor_profile"`
+	Default_selinux_label    string `json:"default_selinux_label"`
+
+	// the other profiles and labels handlers may ask for; handlers asking
+	// for any other fail to deploy. Lifting confinement ("unconfined",
+	// "disable") must be listed too, and needs allow_privilege_opt_out
+	Allowed_apparmor_profiles []string `json:"allowed_apparmor_profiles"`
+	Allowed_selinux_labels    []string `json:"allowed_selinux_labels"`
+
+	// external scanner vetting handler code after it is pulled and before
+	// it runs (see the scan package): exec:<path> runs a command, an
+	// http(s) URL is POSTed the code. Verdicts are cached by the digest
+	// of the code. Scans taking longer than scan_timeout seconds
+	// (defaults to 30) fail, and so does the code, unless scan_fail_open
+	Scanner        string `json:"scanner"`
+	Scan_timeout   int    `json:"scan_timeout"`
+	Scan_fail_open bool   `json:"scan_fail_open"`
+
+	// tenant of each handler, by handler name; "*" applies to handlers
+	// not listed, and handlers without a tenant belong to "default".
+	// Handlers cannot choose their tenant in their own metadata, as it
+	// decides their cgroup and who is billed for their usage
+	Handler_tenants map[string]string `json:"handler_tenants"`
+
+	// place the cgroups of docker sandboxes under a parent cgroup per
+	// tenant (see handler_tenants), at
+	// <tenant_cgroup_root>/<tenant> (defaults to /openlambda), so that
+	// tenants share the CPU fairly and tenant_limits cap each one in
+	// aggregate, by tenant name; "*" applies to tenants not listed.
+	// Handlers without a tenant are placed under "default". Requires
+	// cgroup v1 and docker's cgroupfs driver
+	Tenant_cgroups     bool                    `json:"tenant_cgroups"`
+	Tenant_cgroup_root string                  `json:"tenant_cgroup_root"`
+	Tenant_limits      map[string]TenantLimits `json:"tenant_limits"`
+
+	// kernel log that the seccomp records of sandboxes in syscall audit
+	// mode are read from (defaults to /dev/kmsg; the audit.log of auditd,
+	// if it runs, as it then receives them instead), and the number of
+	// records kept per handler (defaults to 1000)
+	Syscall_audit_log    string `json:"syscall_audit_log"`
+	Syscall_audit_events int    `json:"syscall_audit_events"`
+
+	// seconds each stage of the shutdown of the worker (see
+	// server.SHUTDOWN_STAGES) may take before it is abandoned, by stage
+	// name; stages not listed keep their default
+	Shutdown_timeouts map[string]int `json:"shutdown_timeouts"`
+
+	// leave paused sandboxes in place at shutdown, for the next run of
+	// the worker to adopt, rather than removing every sandbox
+	Shutdown_keep_sandboxes bool `json:"shutdown_keep_sandboxes"`
+
+	// continuous profiling of handler processes with py-spy: every
+	// profile_interval seconds (defaults to 60) each running sandbox is
+	// recorded for profile_duration seconds (defaults to 5). Profiling can
+	// also be turned on and off at runtime through the admin API.
+	Profiling        bool `json:"profiling"`
+	Profile_interval int  `json:"profile_interval"`
+	Profile_duration int  `json:"profile_duration"`
+
+	// let handlers have stack dumps of their slow requests taken with
+	// py-spy (profile_slow), which runs on the host. Dumps of a handler are
+	// taken one at a time, at most every slow_profile_interval seconds
+	// (defaults to 60), and only the latest max_slow_profiles (defaults to
+	// 20) are kept
+	Allow_profile_slow    bool `json:"allow_profile_slow"`
+	Slow_profile_interval int  `json:"slow_profile_interval"`
+	Max_slow_profiles     int  `json:"max_slow_profiles"`
+
+	// cluster coordination (leader election, membership); unset disables it
+	Coord_dir string `json:"coord_dir"`
+	Worker_id string `json:"worker_id"` // defaults to <hostname>:<worker_port>
+
+	// logging: level is one of debug, info, warn, error; format is
+	// text or json
+	Log_level  string `json:"log_level"`
+	Log_format string `json:"log_format"`
+
+	// external systems that worker logs and captured handler output are
+	// forwarded to
+	Log_sinks []LogSink `json:"log_sinks"`
+
+	// number of invocations whose handler output is kept for retrieval
+	// at /admin/logs/<invocation id>; 0 disables capture unless requested per call
+	Log_capture int `json:"log_capture"`
+
+	// let any client get the output of the handler on the response with
+	// the X-OL-Debug header; otherwise only actors of the admin API with
+	// the operator role can
+	Allow_debug_header bool `json:"allow_debug_header"`
+
+	// mutual TLS between the components of the cluster: the worker API is
+	// served over HTTPS, to clients presenting a certificate signed by
+	// the CAs (e.g. the balancer), and the worker presents its
+	// certificate to the registry and other workers. Rotated files are
+	// picked up without a restart
+	Tls *TLSConfig `json:"tls"`
+
+	// admin API: maps each accepted bearer key to the name of the actor
+	// recorded in the audit log; unset (without admin_jwt) restricts the
+	// API to localhost
+	Admin_keys map[string]string `json:"admin_keys"`
+
+	// role of each actor of admin_keys: viewer (reads state), operator
+	// (also acts on handlers, e.g. evicts or deploys them) or admin (also
+	// changes settings and reads the audit log). Unset gives every actor
+	// the admin role; once set, actors not listed are refused
+	Admin_roles map[string]string `json:"admin_roles"`
+
+	// also accept bearer JWTs signed with HS256, whose claims name the
+	// actor and its role
+	Admin_jwt *AdminJWT `json:"admin_jwt"`
+
+	// serve /metrics without authentication, e.g., to Prometheus scrapers
+	// that cannot present a key; otherwise it needs the viewer role, like
+	// /stats
+	Public_metrics bool `json:"public_metrics"`
+
+	// append-only log of admin actions; defaults to <worker_dir>/audit.log.
+	// Entries are also POSTed to audit_sink if set.
+	Audit_log  string `json:"audit_log"`
+	Audit_sink string `json:"audit_sink"`
+
+	// usage records for chargeback (see the accounting package), appended
+	// to accounting_file and/or POSTed to accounting_url every
+	// accounting_interval seconds (defaults to 60)
+	Accounting_file     string `json:"accounting_file"`
+	Accounting_url      string `json:"accounting_url"`
+	Accounting_interval int    `json:"accounting_interval"`
+
+	// push metrics to a statsd (or, with statsd_dogstatsd, DogStatsD)
+	// server at statsd_addr every statsd_interval seconds (defaults to 10);
+	// tags are "key:value" pairs and only sent to DogStatsD
+	Statsd_addr      string   `json:"statsd_addr"`
+	Statsd_prefix    string   `json:"statsd_prefix"`
+	Statsd_tags      []string `json:"statsd_tags"`
+	Statsd_dogstatsd bool     `json:"statsd_dogstatsd"`
+	Statsd_interval  int      `json:"statsd_interval"`
+
+	// alert (as handler.anomaly webhook events) when the share of failed
+	// or timed out invocations of a handler over the last alert_window
+	// seconds (default 60) reaches alert_error_rate or alert_timeout_rate,
+	// given at least alert_min_requests (default 20); the alert clears
+	// below rate*alert_clear_ratio (default 0.5). Zero rates disable.
+	Alert_window       int     `json:"alert_window"`
+	Alert_min_requests int     `json:"alert_min_requests"`
+	Alert_error_rate   float64 `json:"alert_error_rate"`
+	Alert_timeout_rate float64 `json:"alert_timeout_rate"`
+	Alert_clear_ratio  float64 `json:"alert_clear_ratio"`
+
+	// circuit breaker: after breaker_failures consecutive failed or timed
+	// out invocations of a handler (0 disables), its requests fail fast
+	// with status 503 for breaker_cooldown seconds (default 30); then one
+	// probe request goes through, and closes the circuit if it succeeds.
+	// With breaker_recreate, the probe runs in a new sandbox.
+	Breaker_failures int  `json:"breaker_failures"`
+	Breaker_cooldown int  `json:"breaker_cooldown"`
+	Breaker_recreate bool `json:"breaker_recreate"`
+
+	// number of times creating, starting, unpausing or pausing a sandbox,
+	// or forking into it, is retried within a request when it fails
+	// (defaults to 2, negative disables); such failures are usually
+	// transient, and not the handler's fault
+	Infra_retries int `json:"infra_retries"`
+
+	// faults injected into registry pulls, sandbox operations and pool
+	// forks for chaos testing (see the faults package); can be changed at
+	// runtime through the admin API
+	Faults FaultInjection `json:"faults"`
+
+	// lifecycle event notifications (see the webhook package)
+	Webhooks []Webhook `json:"webhooks"`
+
 	// for unit testing to skip pull path
 	Skip_pull_existing bool `json:"Skip_pull_existing"`
 
@@ -45,6 +290,59 @@ type Config struct {
 	Sandbox_config interface{} `json:"sandbox_config"`
 }
 
+// LogSink is an external system logs are forwarded to. Type is one of:
+//
+//	syslog:  addr is network://host:port, or empty for the local daemon
+//	fluentd: addr is host:port of a forward input
+//	http:    addr is a URL receiving POSTs of newline-delimited JSON
+type LogSink struct {
+	Type string `json:"type"`
+	Addr string `json:"addr"`
+	Tag  string `json:"tag"` // syslog and fluentd tag; defaults to openlambda
+}
+
+// Webhook is an endpoint notified of worker events.
+type Webhook struct {
+	Url         string   `json:"url"`
+	Secret      string   `json:"secret"`      // HMAC key; unsigned if empty
+	Events      []string `json:"events"`      // event types to send; all if empty
+	Max_retries int      `json:"max_retries"` // defaults to 3
+}
+
+// TLSConfig locates the PEM files of a component secured with mutual TLS.
+type TLSConfig struct {
+	Cert            string `json:"cert"`            // certificate (chain) of the component
+	Key             string `json:"key"`             // private key of the certificate
+	Ca              string `json:"ca"`              // CA certificates that sign those of peers
+	Reload_interval int    `json:"reload_interval"` // seconds between checks for rotated files; defaults to 60
+}
+
+// AdminJWT configures the JWTs accepted by the admin API.
+type AdminJWT struct {
+	Secret      string `json:"secret"`      // HMAC key the tokens are signed with
+	Issuer      string `json:"issuer"`      // required iss claim, if set
+	Audience    string `json:"audience"`    // required aud claim, if set
+	Actor_claim string `json:"actor_claim"` // claim naming the actor; defaults to sub
+	Role_claim  string `json:"role_claim"`  // claim holding the role(s); defaults to role
+}
+
+// TenantLimits caps the resources of all the sandboxes of a tenant together.
+type TenantLimits struct {
+	Memory_mb  int     `json:"memory_mb"`  // 0 means unlimited
+	Cpus       float64 `json:"cpus"`       // CPU time, in CPUs; 0 means unlimited
+	Cpu_shares int     `json:"cpu_shares"` // weight against other tenants; defaults to 1024
+}
+
+// FaultInjection controls which faults are injected for chaos testing, and
+// how often.
+type FaultInjection struct {
+	Enabled    bool     `json:"enabled"`
+	Error_rate float64  `json:"error_rate"` // probability that an operation fails
+	Delay_rate float64  `json:"delay_rate"` // probability that an operation is delayed
+	Delay_ms   int      `json:"delay_ms"`   // maximum delay, uniformly distributed
+	Points     []string `json:"points"`     // pull, sandbox and/or fork; all if empty
+}
+
 // SandboxConfJson marshals the Sandbox_config of the Config into a JSON string.
 func (c *Config) SandboxConfJson() string {
 	s, err := json.Marshal(c.Sandbox_config)
@@ -92,10 +390,165 @@ func (c *Config) Defaults() error {
 		c.Worker_port = "8080"
 	}
 
+	if c.Alert_window == 0 {
+		c.Alert_window = 60
+	}
+	if c.Alert_min_requests == 0 {
+		c.Alert_min_requests = 20
+	}
+	if c.Alert_clear_ratio == 0 {
+		c.Alert_clear_ratio = 0.5
+	} else if c.Alert_clear_ratio < 0 || c.Alert_clear_ratio > 1 {
+		return fmt.Errorf("alert_clear_ratio must be in [0, 1]")
+	}
+
+	if c.Infra_retries == 0 {
+		c.Infra_retries = 2
+	}
+
+	if c.Breaker_cooldown == 0 {
+		c.Breaker_cooldown = 30
+	}
+
+	if c.Statsd_interval == 0 {
+		c.Statsd_interval = 10
+	}
+
+	if c.Accounting_interval == 0 {
+		c.Accounting_interval = 60
+	}
+
+	if c.Profile_interval == 0 {
+		c.Profile_interval = 60
+	}
+	if c.Profile_duration == 0 {
+		c.Profile_duration = 5
+	}
+	if c.Profile_duration >= c.Profile_interval {
+		return fmt.Errorf("profile_duration must be shorter than profile_interval")
+	}
+	if c.Slow_profile_interval == 0 {
+		c.Slow_profile_interval = 60
+	} else if c.Slow_profile_interval < 0 {
+		return fmt.Errorf("slow_profile_interval cannot be negative, got %v", c.Slow_profile_interval)
+	}
+	if c.Max_slow_profiles == 0 {
+		c.Max_slow_profiles = 20
+	} else if c.Max_slow_profiles < 0 {
+		return fmt.Errorf("max_slow_profiles cannot be negative, got %v", c.Max_slow_profiles)
+	}
+
+	if c.Sandbox_stats_interval == 0 {
+		c.Sandbox_stats_interval = 10
+	}
+
+	if c.Leak_check_interval == 0 {
+		c.Leak_check_interval = 60
+	}
+
+	if c.Watchdog_interval == 0 {
+		c.Watchdog_interval = 30
+	}
+
+	if c.Disk_min_free == 0 {
+		c.Disk_min_free = 5
+	}
+
+	if c.Fd_budget == 0 {
+		c.Fd_budget = 80
+	}
+
+	if c.Max_scratch_mb == 0 {
+		c.Max_scratch_mb = 4096
+	} else if c.Max_scratch_mb < 0 {
+		return fmt.Errorf("max_scratch_mb cannot be negative, got %v", c.Max_scratch_mb)
+	}
+
+	if c.Tenant_cgroup_root == "" {
+		c.Tenant_cgroup_root = "/openlambda"
+	} else if !path.IsAbs(c.Tenant_cgroup_root) {
+		return fmt.Errorf("tenant_cgroup_root must be absolute, got %q", c.Tenant_cgroup_root)
+	}
+	for tenant, l := range c.Tenant_limits {
+		if l.Memory_mb < 0 || l.Cpus < 0 || l.Cpu_shares < 0 {
+			return fmt.Errorf("tenant_limits of %s cannot be negative", tenant)
+		}
+	}
+	for handler, tenant := range c.Handler_tenants {
+		if tenant == "" {
+			return fmt.Errorf("handler_tenants of %s cannot be empty", handler)
+		}
+	}
+
+	if c.Tls != nil {
+		files := []*string{&c.Tls.Cert, &c.Tls.Key, &c.Tls.Ca}
+		for _, file := range files {
+			if *file == "" {
+				return fmt.Errorf("tls requires cert, key and ca")
+			} else if !path.IsAbs(*file) {
+				if c.path == "" {
+					return fmt.Errorf("tls files cannot be relative, unless config is loaded from file")
+				}
+				abs, err := filepath.Abs(path.Join(path.Dir(c.path), *file))
+				if err != nil {
+					return err
+				}
+				*file = abs
+			}
+		}
+		if c.Tls.Reload_interval == 0 {
+			c.Tls.Reload_interval = 60
+		}
+	}
+
+	if c.Scan_timeout == 0 {
+		c.Scan_timeout = 30
+	}
+
+	if c.Syscall_audit_log == "" {
+		c.Syscall_audit_log = "/dev/kmsg"
+	}
+	if c.Syscall_audit_events == 0 {
+		c.Syscall_audit_events = 1000
+	}
+
+	// an explicitly empty list drops every capability
+	if c.Sandbox_caps == nil {
+		c.Sandbox_caps = []string{"CHOWN", "DAC_OVERRIDE", "FOWNER", "KILL", "SETGID", "SETUID", "NET_BIND_SERVICE"}
+	}
+
+	for i := range c.Webhooks {
+		if c.Webhooks[i].Url == "" {
+			return fmt.Errorf("webhook %d has no url", i)
+		}
+		if c.Webhooks[i].Max_retries == 0 {
+			c.Webhooks[i].Max_retries = 3
+		}
+	}
+
+	if c.Log_level == "" {
+		c.Log_level = "info"
+	}
+
+	if c.Log_format == "" {
+		c.Log_format = "text"
+	} else if c.Log_format != "text" && c.Log_format != "json" {
+		return fmt.Errorf("log_format must be text or json")
+	}
+
 	if c.Num_forkservers == 0 {
 		c.Num_forkservers = 1
 	}
 
+	if len(c.Pool_runtimes) == 0 {
+		c.Pool_runtimes = []string{"python"}
+	}
+
+	// an explicitly empty list disables preloading
+	if c.Ruby_preload == nil {
+		c.Ruby_preload = []string{"json", "time", "bigdecimal", "active_support", "active_support/core_ext"}
+	}
+
 	if c.Registry == "olregistry" && len(c.Reg_cluster) == 0 {
 		return fmt.Errorf("must specify reg_cluster")
 	}
@@ -184,6 +637,74 @@ func (c *Config) Defaults() error {
 		}
 	}
 
+	// coordination dir
+	if c.Coord_dir != "" && !path.IsAbs(c.Coord_dir) {
+		if c.path == "" {
+			return fmt.Errorf("Coord_dir cannot be relative, unless config is loaded from file")
+		}
+		path, err := filepath.Abs(path.Join(path.Dir(c.path), c.Coord_dir))
+		if err != nil {
+			return err
+		}
+		c.Coord_dir = path
+	}
+
+	// admin roles
+	for actor, role := range c.Admin_roles {
+		if role != "viewer" && role != "operator" && role != "admin" {
+			return fmt.Errorf("role of %s must be viewer, operator or admin, not %q", actor, role)
+		}
+	}
+	if c.Admin_jwt != nil {
+		if c.Admin_jwt.Secret == "" {
+			return fmt.Errorf("admin_jwt requires a secret")
+		}
+		if c.Admin_jwt.Actor_claim == "" {
+			c.Admin_jwt.Actor_claim = "sub"
+		}
+		if c.Admin_jwt.Role_claim == "" {
+			c.Admin_jwt.Role_claim = "role"
+		}
+	}
+
+	// audit log
+	if c.Audit_log == "" {
+		c.Audit_log = path.Join(c.Worker_dir, "audit.log")
+	} else if !path.IsAbs(c.Audit_log) {
+		if c.path == "" {
+			return fmt.Errorf("Audit_log cannot be relative, unless config is loaded from file")
+		}
+		path, err := filepath.Abs(path.Join(path.Dir(c.path), c.Audit_log))
+		if err != nil {
+			return err
+		}
+		c.Audit_log = path
+	}
+
+	// layer registry
+	if c.Layer_registry != "" && !strings.Contains(c.Layer_registry, "://") && !path.IsAbs(c.Layer_registry) {
+		if c.path == "" {
+			return fmt.Errorf("Layer_registry cannot be relative, unless config is loaded from file")
+		}
+		path, err := filepath.Abs(path.Join(path.Dir(c.path), c.Layer_registry))
+		if err != nil {
+			return err
+		}
+		c.Layer_registry = path
+	}
+
+	// accounting file
+	if c.Accounting_file != "" && !path.IsAbs(c.Accounting_file) {
+		if c.path == "" {
+			return fmt.Errorf("Accounting_file cannot be relative, unless config is loaded from file")
+		}
+		path, err := filepath.Abs(path.Join(path.Dir(c.path), c.Accounting_file))
+		if err != nil {
+			return err
+		}
+		c.Accounting_file = path
+	}
+
 	// daemon
 	if c.Docker_host == "" {
 		client, err := docker.NewClientFromEnv()
@@ -208,6 +729,27 @@ func (c *Config) Defaults() error {
 	return nil
 }
 
+// TenantOf returns the tenant of the named handler according to
+// handler_tenants, or "" if it has none.
+func (c *Config) TenantOf(handler string) string {
+	if tenant, ok := c.Handler_tenants[handler]; ok {
+		return tenant
+	}
+	return c.Handler_tenants["*"]
+}
+
+// WorkerId returns the id identifying this worker within its cluster.
+func (c *Config) WorkerId() string {
+	if c.Worker_id != "" {
+		return c.Worker_id
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return fmt.Sprintf("%s:%s", hostname, c.Worker_port)
+}
+
 // ParseConfig reads a file and tries to parse it as a JSON string to a Config
 // instance.
 func ParseConfig(path string) (*Config, error) {
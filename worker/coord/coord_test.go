@@ -0,0 +1,164 @@
+package coord
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func tempStores(t *testing.T, ids ...string) ([]*Store, func()) {
+	dir, err := ioutil.TempDir("", "coord_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := []*Store{}
+	for _, id := range ids {
+		s, err := NewStore(dir, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores = append(stores, s)
+	}
+	return stores, func() { os.RemoveAll(dir) }
+}
+
+func TestLeaseExclusive(t *testing.T) {
+	stores, cleanup := tempStores(t, "a", "b")
+	defer cleanup()
+	a, b := stores[0], stores[1]
+
+	if ok, err := a.TryAcquire("l", time.Minute); err != nil || !ok {
+		t.Fatalf("a could not acquire free lease: %v", err)
+	}
+	if ok, err := b.TryAcquire("l", time.Minute); err != nil || ok {
+		t.Fatalf("b acquired lease held by a: %v", err)
+	}
+	if ok, err := a.TryAcquire("l", time.Minute); err != nil || !ok {
+		t.Fatalf("a could not renew its lease: %v", err)
+	}
+
+	if err := a.Release("l"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryAcquire("l", time.Minute); err != nil || !ok {
+		t.Fatalf("b could not acquire released lease: %v", err)
+	}
+}
+
+func TestLeaseExpires(t *testing.T) {
+	stores, cleanup := tempStores(t, "a", "b")
+	defer cleanup()
+	a, b := stores[0], stores[1]
+
+	if ok, _ := a.TryAcquire("l", 10*time.Millisecond); !ok {
+		t.Fatal("a could not acquire free lease")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := b.TryAcquire("l", time.Minute); !ok {
+		t.Fatal("b could not take over expired lease")
+	}
+	if holder, _ := a.Holder("l"); holder != "b" {
+		t.Fatalf("Unexpected holder: %q", holder)
+	}
+}
+
+func TestElectorFailover(t *testing.T) {
+	stores, cleanup := tempStores(t, "a", "b")
+	defer cleanup()
+
+	ttl := 60 * time.Millisecond
+	ea := NewElector(stores[0], ttl)
+	eb := NewElector(stores[1], ttl)
+
+	running := make(chan string, 10)
+	for _, e := range []*Elector{ea, eb} {
+		id := e.store.Id()
+		e.Register("task", func(stop <-chan struct{}) {
+			running <- id
+			<-stop
+		})
+	}
+
+	stopA := make(chan struct{})
+	go ea.Run(stopA)
+	if id := <-running; id != "a" {
+		t.Fatalf("Unexpected first leader: %s", id)
+	}
+
+	stopB := make(chan struct{})
+	defer close(stopB)
+	go eb.Run(stopB)
+	time.Sleep(ttl)
+	if eb.IsLeader() {
+		t.Fatal("b became leader while a holds the lease")
+	}
+
+	close(stopA)
+	select {
+	case id := <-running:
+		if id != "b" {
+			t.Fatalf("Unexpected new leader: %s", id)
+		}
+	case <-time.After(5 * ttl):
+		t.Fatal("b did not take over leadership")
+	}
+}
+
+func TestSemaphoreLimit(t *testing.T) {
+	stores, cleanup := tempStores(t, "a", "b")
+	defer cleanup()
+	sa := NewSemaphore(stores[0], time.Minute)
+	sb := NewSemaphore(stores[1], time.Minute)
+
+	slot, err := sa.Acquire("h", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sb.Acquire("h", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sb.Acquire("h", 2); err != ErrLimitReached {
+		t.Fatalf("expected limit to be reached, got %v", err)
+	}
+
+	// a released slot stays a local lease of a
+	sa.Release("h", slot)
+	if _, err := sb.Acquire("h", 2); err != ErrLimitReached {
+		t.Fatalf("expected local lease to be kept by a, got %v", err)
+	}
+	if again, err := sa.Acquire("h", 2); err != nil || again != slot {
+		t.Fatalf("expected a to reuse slot %d, got %d (%v)", slot, again, err)
+	}
+}
+
+func TestSemaphoreLostSlot(t *testing.T) {
+	stores, cleanup := tempStores(t, "a", "b")
+	defer cleanup()
+	sa := NewSemaphore(stores[0], time.Minute)
+
+	slot, err := sa.Acquire("h", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b takes the lease behind a's back (e.g., a stalled past the ttl)
+	lease := slotLease("h", slot)
+	data, _ := json.Marshal(&Lease{Owner: "b", Expires: time.Now().Add(time.Minute)})
+	if err := writeFile(stores[0].leasePath(lease), data); err != nil {
+		t.Fatal(err)
+	}
+	sa.renew()
+
+	// releasing the lost slot must not free b's lease
+	sa.Release("h", slot)
+	sa.renew()
+	if holder, err := stores[0].Holder(lease); err != nil || holder != "b" {
+		t.Fatalf("expected b to keep the lease, got %q (%v)", holder, err)
+	}
+	if _, err := sa.Acquire("h", 1); err != ErrLimitReached {
+		t.Fatalf("expected lost slot to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package coord
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// LEADER_LEASE is the name of the lease held by the cluster leader.
+const LEADER_LEASE = "leader"
+
+// Task is a cluster-singleton duty. It must return once stop is closed.
+type Task func(stop <-chan struct{})
+
+// Elector campaigns for cluster leadership and runs the registered singleton
+// tasks only while this member is the leader. If the leader dies, its lease
+// expires after ttl and another member takes over.
+type Elector struct {
+	mutex   sync.Mutex
+	store   *Store
+	ttl     time.Duration
+	tasks   map[string]Task
+	leader  bool
+	stopCh  chan struct{}
+	running sync.WaitGroup
+}
+
+// NewElector creates an Elector using the leader lease of the store.
+func NewElector(store *Store, ttl time.Duration) *Elector {
+	return &Elector{
+		store: store,
+		ttl:   ttl,
+		tasks: make(map[string]Task),
+	}
+}
+
+// Register adds a singleton task. Tasks must be registered before Run.
+func (e *Elector) Register(name string, task Task) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.tasks[name] = task
+}
+
+// IsLeader reports whether this member currently holds leadership.
+func (e *Elector) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.leader
+}
+
+// Run campaigns for (and renews) leadership until stop is closed, then
+// stops the singleton tasks and releases the lease.
+func (e *Elector) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		acquired, err := e.store.TryAcquire(LEADER_LEASE, e.ttl)
+		if err != nil {
+			log.WithError(err).Warn("leader election failed")
+			acquired = false
+		}
+
+		if acquired && !e.IsLeader() {
+			e.elected()
+		} else if !acquired && e.IsLeader() {
+			e.demoted()
+		}
+
+		select {
+		case <-stop:
+			if e.IsLeader() {
+				e.demoted()
+				if err := e.store.Release(LEADER_LEASE); err != nil {
+					log.WithError(err).Warn("could not release leadership")
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// elected starts every singleton task.
+func (e *Elector) elected() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	log.WithField("worker", e.store.Id()).Info("became cluster leader")
+	e.leader = true
+	e.stopCh = make(chan struct{})
+	for name, task := range e.tasks {
+		log.WithField("task", name).Info("start singleton task")
+		e.running.Add(1)
+		go func(task Task, stop <-chan struct{}) {
+			defer e.running.Done()
+			task(stop)
+		}(task, e.stopCh)
+	}
+}
+
+// demoted stops every singleton task and waits for them to return.
+func (e *Elector) demoted() {
+	e.mutex.Lock()
+	log.WithField("worker", e.store.Id()).Warn("lost cluster leadership")
+	e.leader = false
+	close(e.stopCh)
+	e.mutex.Unlock()
+
+	e.running.Wait()
+}
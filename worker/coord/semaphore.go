@@ -0,0 +1,178 @@
+package coord
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ErrLimitReached is returned when every slot of a semaphore is taken.
+var ErrLimitReached = errors.New("cluster-wide concurrency limit reached")
+
+// slotState tracks a slot this member holds in the store.
+type slotState struct {
+	inUse     bool
+	idleSince time.Time
+
+	// lost is set when this member may no longer hold the lease of the
+	// slot, because renewing it failed or it is being given back. A lost
+	// slot is not handed out again until its lease is reacquired.
+	lost bool
+}
+
+// renewal is the store operation the renewer does for one slot.
+type renewal struct {
+	name    string
+	slot    int
+	st      *slotState
+	release bool
+	ok      bool
+}
+
+// Semaphore bounds how many runs of a named resource may be in flight across
+// the whole cluster. Each of the limit slots is a lease in the store. A
+// member keeps the slots it acquires as local leases: released slots stay
+// reserved for a while so later runs reuse them without touching the store,
+// and a background loop renews held slots and returns idle ones.
+type Semaphore struct {
+	mutex sync.Mutex
+	store *Store
+	ttl   time.Duration
+	slots map[string]map[int]*slotState
+}
+
+// NewSemaphore creates a Semaphore whose slot leases last ttl, and starts
+// renewing them.
+func NewSemaphore(store *Store, ttl time.Duration) *Semaphore {
+	s := &Semaphore{
+		store: store,
+		ttl:   ttl,
+		slots: make(map[string]map[int]*slotState),
+	}
+	go s.renewer()
+	return s
+}
+
+// slotLease gets the lease name of a slot.
+func slotLease(name string, slot int) string {
+	return fmt.Sprintf("sem-%s-%d", name, slot)
+}
+
+// Acquire takes a slot of the named resource, which has limit slots in
+// total. It returns ErrLimitReached if all of them are in use.
+func (s *Semaphore) Acquire(name string, limit int) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	held := s.slots[name]
+	if held == nil {
+		held = make(map[int]*slotState)
+		s.slots[name] = held
+	}
+
+	// prefer a local lease we already hold
+	for slot, st := range held {
+		if !st.inUse && !st.lost && slot < limit {
+			st.inUse = true
+			return slot, nil
+		}
+	}
+
+	for slot := 0; slot < limit; slot++ {
+		if held[slot] != nil {
+			continue
+		}
+		ok, err := s.store.TryAcquire(slotLease(name, slot), s.ttl)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			held[slot] = &slotState{inUse: true}
+			return slot, nil
+		}
+	}
+
+	return 0, ErrLimitReached
+}
+
+// Release returns a slot. It stays reserved by this member until it has been
+// idle for half the lease ttl. A lost slot is forgotten, without touching its
+// lease, which may now belong to another member.
+func (s *Semaphore) Release(name string, slot int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if st := s.slots[name][slot]; st != nil {
+		if st.lost {
+			delete(s.slots[name], slot)
+			return
+		}
+		st.inUse = false
+		st.idleSince = time.Now()
+	}
+}
+
+// renewer periodically renews the slots in use and gives back the ones that
+// have been idle too long.
+func (s *Semaphore) renewer() {
+	for range time.Tick(s.ttl / 3) {
+		s.renew()
+	}
+}
+
+// renew does one round of the renewer. The slots are collected under the
+// mutex, but the store is only used outside of it, so that acquisitions and
+// releases never wait for the shared directory.
+func (s *Semaphore) renew() {
+	var work []renewal
+	s.mutex.Lock()
+	for name, held := range s.slots {
+		for slot, st := range held {
+			release := !st.inUse && time.Since(st.idleSince) > s.ttl/2
+			if release {
+				st.lost = true
+			}
+			work = append(work, renewal{name: name, slot: slot, st: st, release: release})
+		}
+	}
+	s.mutex.Unlock()
+
+	for i := range work {
+		w := &work[i]
+		lease := slotLease(w.name, w.slot)
+		if w.release {
+			if err := s.store.Release(lease); err != nil {
+				log.WithError(err).WithField("lease", lease).Warn("could not release slot")
+			}
+			continue
+		}
+		ok, err := s.store.TryAcquire(lease, s.ttl)
+		if err != nil || !ok {
+			log.WithError(err).WithField("lease", lease).Warn("lost slot")
+		}
+		w.ok = ok && err == nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, w := range work {
+		held := s.slots[w.name]
+		if held[w.slot] != w.st {
+			// released while lost, and maybe acquired again since
+			continue
+		}
+		switch {
+		case w.release:
+			delete(held, w.slot)
+		case w.ok:
+			w.st.lost = false
+		case w.st.inUse:
+			w.st.lost = true
+		default:
+			delete(held, w.slot)
+		}
+	}
+}
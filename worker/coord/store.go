@@ -0,0 +1,198 @@
+// coord package implements cluster coordination (leases, leader election and
+// membership) on top of a directory shared by all workers of a cluster, such
+// as the cluster directory or an NFS mount.
+package coord
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Lease records which member holds a named lease and until when.
+type Lease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// Store is a coordination store rooted at a shared directory. Every
+// read-modify-write of a lease happens under an exclusive flock, so members
+// on the same host (or on hosts sharing a filesystem with working locks)
+// never both believe they hold it.
+type Store struct {
+	dir string
+	id  string
+}
+
+// NewStore creates a Store in dir for the member with the given id.
+func NewStore(dir string, id string) (*Store, error) {
+	for _, sub := range []string{"leases", "members"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return nil, fmt.Errorf("fail to create directory at %s: %v", filepath.Join(dir, sub), err)
+		}
+	}
+	return &Store{dir: dir, id: id}, nil
+}
+
+// Id returns the member id of this Store.
+func (s *Store) Id() string {
+	return s.id
+}
+
+// leasePath gets the path of the file backing a lease.
+func (s *Store) leasePath(name string) string {
+	return filepath.Join(s.dir, "leases", strings.Replace(name, "/", "_", -1))
+}
+
+// withLock runs fn while holding an exclusive lock for the lease file.
+func (s *Store) withLock(path string, fn func() error) error {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	return fn()
+}
+
+// readLease reads a lease file. A missing file is an expired lease.
+func readLease(path string) (*Lease, error) {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Lease{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	lease := &Lease{}
+	if err := json.Unmarshal(raw, lease); err != nil {
+		// a torn or corrupt lease is treated as expired
+		return &Lease{}, nil
+	}
+	return lease, nil
+}
+
+// writeFile atomically replaces path with data.
+func writeFile(path string, data []byte) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// TryAcquire takes or renews the named lease for ttl if it is free, expired,
+// or already held by this member. It reports whether this member now holds
+// the lease.
+func (s *Store) TryAcquire(name string, ttl time.Duration) (bool, error) {
+	path := s.leasePath(name)
+	acquired := false
+	err := s.withLock(path, func() error {
+		lease, err := readLease(path)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if lease.Owner != s.id && now.Before(lease.Expires) {
+			return nil
+		}
+
+		data, err := json.Marshal(&Lease{Owner: s.id, Expires: now.Add(ttl)})
+		if err != nil {
+			return err
+		}
+		if err := writeFile(path, data); err != nil {
+			return err
+		}
+		acquired = true
+		return nil
+	})
+	return acquired, err
+}
+
+// Release gives up the named lease if this member holds it.
+func (s *Store) Release(name string) error {
+	path := s.leasePath(name)
+	return s.withLock(path, func() error {
+		lease, err := readLease(path)
+		if err != nil {
+			return err
+		}
+		if lease.Owner != s.id {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	})
+}
+
+// Holder returns the current (unexpired) holder of a lease, or "".
+func (s *Store) Holder(name string) (string, error) {
+	lease, err := readLease(s.leasePath(name))
+	if err != nil {
+		return "", err
+	}
+	if time.Now().After(lease.Expires) {
+		return "", nil
+	}
+	return lease.Owner, nil
+}
+
+// memberPath gets the path of the heartbeat file of a member.
+func (s *Store) memberPath(id string) string {
+	return filepath.Join(s.dir, "members", strings.Replace(id, "/", "_", -1))
+}
+
+// Heartbeat records that this member is alive for the next ttl.
+func (s *Store) Heartbeat(ttl time.Duration) error {
+	data, err := json.Marshal(&Lease{Owner: s.id, Expires: time.Now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	return writeFile(s.memberPath(s.id), data)
+}
+
+// Leave removes the heartbeat of this member.
+func (s *Store) Leave() error {
+	if err := os.Remove(s.memberPath(s.id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Members returns the heartbeat of every member that ever joined and has
+// not left, keyed by member id. Expired heartbeats belong to dead members.
+func (s *Store) Members() (map[string]*Lease, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(s.dir, "members"))
+	if err != nil {
+		return nil, err
+	}
+
+	members := map[string]*Lease{}
+	for _, fi := range entries {
+		if strings.HasSuffix(fi.Name(), ".tmp") {
+			continue
+		}
+		lease, err := readLease(filepath.Join(s.dir, "members", fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if lease.Owner != "" {
+			members[lease.Owner] = lease
+		}
+	}
+	return members, nil
+}
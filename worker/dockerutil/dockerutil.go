@@ -14,8 +14,18 @@ import (
 const (
 	DOCKER_LABEL_CLUSTER = "ol.cluster" // cluster name
 	DOCKER_LABEL_TYPE    = "ol.type"    // container type (sb, olstore, rethinkdb, etc)
+	DOCKER_LABEL_WORKER  = "ol.worker"  // id of the worker owning a sandbox
+	DOCKER_LABEL_SCRATCH = "ol.scratch" // kind of scratch volume of a sandbox, if any
 	SANDBOX              = "sandbox"
 	BASE_IMAGE           = "lambda"
+	NODEJS_IMAGE         = "lambda-nodejs"
+	GO_IMAGE             = "lambda-go"
+	JAVA_IMAGE           = "lambda-java"
+	DOTNET_IMAGE         = "lambda-dotnet"
+	CUSTOM_IMAGE         = "lambda-custom"
+	EXEC_IMAGE           = "lambda-exec"
+	RUBY_IMAGE           = "lambda-ruby" // also the image of the Ruby pool
+	R_IMAGE              = "lambda-r"    // also the image of the R pool
 	POOL                 = "pool"
 	POOL_IMAGE           = "server-pool"
 )
@@ -0,0 +1,134 @@
+// faults package injects failures and delays into the registry pulls, sandbox
+// operations and pool forks of the worker, so that operators can verify that
+// retries and alerts cope with realistic failures (chaos testing). Injection
+// is off unless enabled by the config or at runtime through the admin API.
+package faults
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+// injection points
+const (
+	PULL    = "pull"    // pulls of handler code from the registry
+	SANDBOX = "sandbox" // creating, starting, pausing and unpausing sandboxes
+	FORK    = "fork"    // forks from the pool into sandboxes
+)
+
+// kinds of injected faults
+const (
+	ERROR = "error"
+	DELAY = "delay"
+)
+
+var injectedTotal = metrics.NewCounter(
+	"ol_injected_faults_total",
+	"Number of faults injected for chaos testing.",
+	"point", "kind")
+
+// check verifies that the settings make sense.
+func check(s config.FaultInjection) error {
+	if s.Error_rate < 0 || s.Error_rate > 1 {
+		return fmt.Errorf("error_rate must be between 0 and 1")
+	}
+	if s.Delay_rate < 0 || s.Delay_rate > 1 {
+		return fmt.Errorf("delay_rate must be between 0 and 1")
+	}
+	if s.Delay_ms < 0 {
+		return fmt.Errorf("delay_ms must not be negative")
+	}
+	for _, point := range s.Points {
+		if point != PULL && point != SANDBOX && point != FORK {
+			return fmt.Errorf("unknown injection point %q (known: %s, %s, %s)", point, PULL, SANDBOX, FORK)
+		}
+	}
+	return nil
+}
+
+// InjectedError is the error of an operation failed on purpose.
+type InjectedError struct {
+	Point string
+	Op    string
+}
+
+func (e *InjectedError) Error() string {
+	return fmt.Sprintf("injected fault: %s %s failed", e.Point, e.Op)
+}
+
+var (
+	mutex    sync.Mutex
+	settings config.FaultInjection
+	rnd      = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Configure replaces the settings of fault injection, unless they are
+// invalid.
+func Configure(s config.FaultInjection) error {
+	if err := check(s); err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if s.Enabled && !settings.Enabled {
+		log.WithField("settings", fmt.Sprintf("%+v", s)).Warn("fault injection enabled")
+	} else if !s.Enabled && settings.Enabled {
+		log.Info("fault injection disabled")
+	}
+	settings = s
+	return nil
+}
+
+// Current returns the settings of fault injection.
+func Current() config.FaultInjection {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return settings
+}
+
+// Inject is called at an injection point before op is carried out. While
+// injection is enabled, it may sleep, and may return an InjectedError that
+// the caller should fail op with.
+func Inject(point, op string) error {
+	mutex.Lock()
+	if !settings.Enabled || !covers(settings, point) {
+		mutex.Unlock()
+		return nil
+	}
+	var delay time.Duration
+	if settings.Delay_ms > 0 && rnd.Float64() < settings.Delay_rate {
+		delay = time.Duration(rnd.Int63n(int64(settings.Delay_ms)+1)) * time.Millisecond
+	}
+	fail := rnd.Float64() < settings.Error_rate
+	mutex.Unlock()
+
+	if delay > 0 {
+		injectedTotal.Inc(point, DELAY)
+		time.Sleep(delay)
+	}
+	if fail {
+		injectedTotal.Inc(point, ERROR)
+		return &InjectedError{Point: point, Op: op}
+	}
+	return nil
+}
+
+// covers reports whether faults are injected at point.
+func covers(s config.FaultInjection, point string) bool {
+	if len(s.Points) == 0 {
+		return true
+	}
+	for _, p := range s.Points {
+		if p == point {
+			return true
+		}
+	}
+	return false
+}
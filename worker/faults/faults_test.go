@@ -0,0 +1,46 @@
+package faults
+
+import (
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+func TestInject(t *testing.T) {
+	defer Configure(config.FaultInjection{})
+
+	for _, c := range []struct {
+		settings config.FaultInjection
+		point    string
+		fails    bool
+	}{
+		{config.FaultInjection{Enabled: false, Error_rate: 1}, PULL, false},
+		{config.FaultInjection{Enabled: true, Error_rate: 0}, PULL, false},
+		{config.FaultInjection{Enabled: true, Error_rate: 1}, SANDBOX, true},
+		{config.FaultInjection{Enabled: true, Error_rate: 1, Points: []string{FORK}}, FORK, true},
+		{config.FaultInjection{Enabled: true, Error_rate: 1, Points: []string{FORK}}, PULL, false},
+	} {
+		if err := Configure(c.settings); err != nil {
+			t.Fatal(err)
+		}
+		err := Inject(c.point, "op")
+		if _, injected := err.(*InjectedError); injected != c.fails {
+			t.Fatalf("%+v at %s: expected failure %v, got %v", c.settings, c.point, c.fails, err)
+		}
+	}
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	defer Configure(config.FaultInjection{})
+
+	for _, s := range []config.FaultInjection{
+		{Error_rate: 1.5},
+		{Delay_rate: -0.1},
+		{Delay_ms: -1},
+		{Points: []string{"disk"}},
+	} {
+		if err := Configure(s); err == nil {
+			t.Fatalf("expected %+v to be rejected", s)
+		}
+	}
+}
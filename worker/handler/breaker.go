@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+)
+
+// states of the circuit breaker of a handler
+const (
+	CLOSED    = "closed"    // requests go through
+	OPEN      = "open"      // requests fail fast until the cool-down is over
+	HALF_OPEN = "half-open" // one probe request goes through
+)
+
+// CircuitOpenError is returned by RunStart while the circuit of a handler is
+// open, instead of running a handler that keeps failing.
+type CircuitOpenError struct {
+	Handler    string
+	Failures   int
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit of handler %s is open after %d consecutive failures; retry in %v",
+		e.Handler, e.Failures, e.RetryAfter)
+}
+
+// breaker counts the consecutive failures of the invocations of a handler.
+// Once they reach the threshold the circuit opens: requests fail fast for
+// the cool-down period, then a single probe request is let through, whose
+// outcome closes or reopens the circuit.
+type breaker struct {
+	state    string
+	failures int
+	opened   time.Time // when the circuit last opened
+	probed   time.Time // when the probe was let through, if half-open
+}
+
+// allow reports whether a request may run, and if so whether it is the
+// probe of a half-open circuit. Otherwise it returns how long until the
+// next probe.
+func (b *breaker) allow(now time.Time, cooldown time.Duration) (ok, probe bool, retryAfter time.Duration) {
+	switch b.state {
+	case OPEN:
+		if wait := b.opened.Add(cooldown).Sub(now); wait > 0 {
+			return false, false, wait
+		}
+	case HALF_OPEN:
+		// a probe that never reported (e.g., it failed before reaching
+		// the sandbox) is replaced after another cool-down
+		if wait := b.probed.Add(cooldown).Sub(now); wait > 0 {
+			return false, false, wait
+		}
+	default:
+		return true, false, 0
+	}
+	b.state = HALF_OPEN
+	b.probed = now
+	return true, true, 0
+}
+
+// record counts the outcome of an invocation, and reports whether it opened
+// the circuit.
+func (b *breaker) record(ok bool, now time.Time, threshold int) bool {
+	if ok {
+		b.state = CLOSED
+		b.failures = 0
+		return false
+	}
+
+	b.failures += 1
+	if b.state == HALF_OPEN || (b.state != OPEN && b.failures >= threshold) {
+		b.state = OPEN
+		b.opened = now
+		return true
+	}
+	return false
+}
+
+// current returns the state of the circuit.
+func (b *breaker) current() string {
+	if b.state == "" {
+		return CLOSED
+	}
+	return b.state
+}
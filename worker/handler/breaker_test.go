@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{}
+	now := time.Now()
+	cooldown := 10 * time.Second
+
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := b.allow(now, cooldown); !ok {
+			t.Fatalf("closed circuit refused request %d", i)
+		}
+		if opened := b.record(false, now, 3); opened != (i == 2) {
+			t.Fatalf("failure %d: opened = %v", i+1, opened)
+		}
+	}
+	if b.current() != OPEN {
+		t.Fatalf("expected open circuit, got %s", b.current())
+	}
+	if ok, _, wait := b.allow(now.Add(4*time.Second), cooldown); ok || wait != 6*time.Second {
+		t.Fatalf("open circuit: ok = %v, retry after %v", ok, wait)
+	}
+
+	// a single probe goes through after the cool-down, and reopens the
+	// circuit if it fails
+	later := now.Add(cooldown)
+	if ok, probe, _ := b.allow(later, cooldown); !ok || !probe {
+		t.Fatalf("expected a probe after the cool-down")
+	}
+	if ok, _, _ := b.allow(later, cooldown); ok {
+		t.Fatalf("half-open circuit let a second request through")
+	}
+	if !b.record(false, later, 3) || b.current() != OPEN {
+		t.Fatalf("failed probe did not reopen the circuit")
+	}
+
+	// a successful probe closes it
+	later = later.Add(cooldown)
+	if ok, probe, _ := b.allow(later, cooldown); !ok || !probe {
+		t.Fatalf("expected a second probe")
+	}
+	b.record(true, later, 3)
+	if b.current() != CLOSED || b.failures != 0 {
+		t.Fatalf("successful probe left circuit %s with %d failures", b.current(), b.failures)
+	}
+}
@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+	"github.com/open-lambda/open-lambda/worker/registry"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+	"github.com/open-lambda/open-lambda/worker/scan"
+	"github.com/open-lambda/open-lambda/worker/webhook"
+)
+
+// Results of a deploy.
+const (
+	DEPLOYED    = "deployed"    // the new version serves requests
+	ROLLED_BACK = "rolled_back" // the new version failed, the old one is back
+	FAILED      = "failed"      // the new version failed, and could not be rolled back
+)
+
+// DEPLOY_HISTORY is the number of recent deploys kept per HandlerSet.
+const DEPLOY_HISTORY = 100
+
+var deploysTotal = metrics.NewCounter(
+	"ol_deploys_total",
+	"Number of deploys of new versions of handler code, by result.",
+	"handler", "result")
+
+// Deploy describes one deploy of a new version of the code of a handler.
+type Deploy struct {
+	Handler string    `json:"handler"`
+	Time    time.Time `json:"time"`
+	Result  string    `json:"result"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// deployLog keeps the most recent deploys.
+type deployLog struct {
+	mutex  sync.Mutex
+	recent []Deploy
+}
+
+func (l *deployLog) record(d Deploy) {
+	deploysTotal.Inc(d.Handler, d.Result)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.recent) == DEPLOY_HISTORY {
+		l.recent = l.recent[1:]
+	}
+	l.recent = append(l.recent, d)
+}
+
+// Deploys returns the most recent deploys, oldest first.
+func (h *HandlerSet) Deploys() []Deploy {
+	h.deploys.mutex.Lock()
+	defer h.deploys.mutex.Unlock()
+	return append([]Deploy{}, h.deploys.recent...)
+}
+
+// Deploy updates the named handler to the latest version of its code in the
+// registry, transactionally: the old code and sandbox keep serving requests
+// until the new version passes a health probe in a sandbox of its own. The
+// probe passes once the lambda server of the new version listens, i.e., its
+// init succeeded, and, if probe is not nil, a synthetic invocation with
+// probe as body returns a status below 500.
+//
+// Once the new version passes, the old sandbox is discarded as soon as no
+// request runs in it, and the next request starts the new version cold. If
+// it fails, the registry, if it keeps versions (see registry.Versioned),
+// rolls the code back, and the failure is sent to webhooks. Either way, the
+// deploy is recorded (see Deploys) and returned.
+func (h *HandlerSet) Deploy(ctx context.Context, name string, probe []byte) Deploy {
+	handler := h.Get(name)
+	handler.deploying.Lock()
+	defer handler.deploying.Unlock()
+
+	d := Deploy{Handler: name, Time: time.Now(), Result: DEPLOYED}
+	pulled, err := handler.deploy(ctx, probe)
+	if err != nil {
+		d.Result, d.Error = FAILED, err.Error()
+		if pulled {
+			d.Result = handler.rollback(err)
+		}
+		h.notifier.Notify(webhook.DEPLOY_FAILED, name, map[string]string{
+			"error":  d.Error,
+			"result": d.Result,
+		})
+	}
+	h.deploys.record(d)
+	return d
+}
+
+// deploy pulls, probes and commits a new version of the code of the
+// Handler. It reports whether the new version was pulled, in which case it
+// must be rolled back on failure.
+func (h *Handler) deploy(ctx context.Context, probe []byte) (pulled bool, err error) {
+	t := startup.NewTimings(h.name)
+	t.Start = startup.COLD
+	codeDir, err := h.pullCode(ctx, t)
+	if err != nil {
+		return false, err
+	}
+	m, err := h.checkCode(ctx, codeDir, t)
+	if err != nil {
+		return true, err
+	}
+	if err := h.probe(ctx, codeDir, m, probe); err != nil {
+		return true, fmt.Errorf("health probe failed: %v", err)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	now := time.Now()
+	h.lastPull = &now
+	h.codeDir = codeDir
+	h.meta = m
+	if h.sandbox != nil {
+		if h.runners == 0 {
+			h.discardSandbox()
+		} else {
+			h.stale = true
+		}
+	}
+	h.log().WithField("dir", codeDir).Info("deployed new version")
+	h.hset.notifier.Notify(webhook.HANDLER_DEPLOYED, h.name, nil)
+	return true, nil
+}
+
+// rollback rolls the code of the Handler back after a deploy failed with
+// err, and returns the result of the deploy.
+func (h *Handler) rollback(err error) string {
+	h.mutex.Lock()
+	logger := h.log().WithError(err)
+	h.mutex.Unlock()
+
+	v, ok := h.hset.regMgr.(registry.Versioned)
+	if !ok {
+		logger.Error("deploy failed, and the registry cannot roll back")
+		return FAILED
+	}
+	if rerr := v.Rollback(h.name); rerr != nil {
+		logger.WithField("rollback_error", rerr.Error()).Error("deploy failed, and could not roll back")
+		return FAILED
+	}
+	logger.Warn("deploy failed, rolled back")
+	return ROLLED_BACK
+}
+
+// probe runs the code in codeDir in a sandbox of its own, which is removed
+// afterwards, and returns an error unless it passes the health probe of
+// Deploy.
+func (h *Handler) probe(ctx context.Context, codeDir string, m *meta.HandlerMeta, body []byte) error {
+	sandboxDir := path.Join(h.hset.config.Worker_dir, "handlers", h.name, "probe")
+	if err := os.MkdirAll(sandboxDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(sandboxDir)
+
+	sandbox, err := h.hset.sbFactory.Create(ctx, codeDir, sandboxDir, m)
+	if err != nil {
+		return err
+	}
+	defer removeSandbox(sandbox)
+
+	if st, err := sandbox.State(); err != nil {
+		return err
+	} else if st == state.Stopped {
+		err = sandbox.Start()
+	} else if st == state.Paused {
+		err = sandbox.Unpause()
+	}
+	if err != nil {
+		return err
+	}
+	if h.hset.poolMgr != nil && sb.Pooled(h.hset.config, m.Runtime) {
+		containerSB, ok := sandbox.(sb.ContainerSandbox)
+		if !ok {
+			return fmt.Errorf("forkenter only supported with ContainerSandbox")
+		}
+		if err := h.hset.poolMgr.ForkEnter(ctx, containerSB, m); err != nil {
+			return err
+		}
+	}
+
+	ch, err := sandbox.Channel()
+	if err != nil {
+		return err
+	}
+	if err := sb.WaitReady(ch, sb.READY_TIMEOUT); err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+
+	req, err := http.NewRequest("POST", ch.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: &ch.Transport}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("synthetic invocation returned %s", resp.Status)
+	}
+	return nil
+}
+
+// pullCode pulls the code of the Handler. It returns the directory of the
+// version pulled rather than the code directory of the handler, which points
+// to the latest version, so that sandboxes created later run the version the
+// Handler checked, even if another one was pulled since.
+func (h *Handler) pullCode(ctx context.Context, t *startup.Timings) (string, error) {
+	begin := time.Now()
+	codeDir, err := h.hset.regMgr.Pull(ctx, h.name)
+	if err != nil {
+		return "", err
+	}
+	t.Add(startup.PULL, begin)
+	return filepath.EvalSymlinks(codeDir)
+}
+
+// checkCode checks that the scanner, if any, allows the code in codeDir and
+// that the worker can run it, building it if it is shipped as source, and
+// returns its metadata.
+func (h *Handler) checkCode(ctx context.Context, codeDir string, t *startup.Timings) (*meta.HandlerMeta, error) {
+	begin := time.Now()
+	if err := h.hset.scanner.Check(ctx, h.name, codeDir); err != nil {
+		if verr, ok := err.(*scan.VetoError); ok {
+			h.hset.notifier.Notify(webhook.CODE_VETOED, h.name, map[string]string{
+				"digest": verr.Digest,
+				"reason": verr.Reason,
+			})
+		}
+		return nil, err
+	}
+	if h.hset.scanner != nil {
+		t.Observe(startup.SCAN, begin)
+	}
+
+	m, err := meta.Load(codeDir)
+	if err != nil {
+		return nil, err
+	}
+	m.Tenant = h.hset.config.TenantOf(h.name)
+	if err := m.CheckMirror(h.name); err != nil {
+		return nil, err
+	}
+	if m.Max_concurrency > 0 && h.hset.limiter == nil {
+		return nil, &meta.IncompatibleError{
+			Field:  "max_concurrency",
+			Value:  fmt.Sprintf("%d", m.Max_concurrency),
+			Reason: "cannot be enforced on this worker (no coord_dir)",
+		}
+	}
+	if err := h.hset.sbFactory.Check(m); err != nil {
+		return nil, err
+	}
+	if err := m.CheckEntrypoint(codeDir); err != nil {
+		return nil, err
+	}
+	if m.Runtime == meta.GO {
+		begin := time.Now()
+		if err := registry.Build(ctx, h.name, codeDir, m); err != nil {
+			return nil, err
+		}
+		t.Observe(startup.BUILD, begin)
+	}
+	return m, nil
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// fakeRegistry serves numbered versions of the code of a handler, and can
+// roll back to the previous one.
+type fakeRegistry struct {
+	dir     string
+	version int
+}
+
+func (r *fakeRegistry) Pull(ctx context.Context, name string) (string, error) {
+	r.version++
+	dir := filepath.Join(r.dir, fmt.Sprintf("v%d", r.version))
+	os.MkdirAll(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "lambda_func.py"), nil, 0644)
+	return dir, nil
+}
+
+func (r *fakeRegistry) Rollback(name string) error {
+	r.version--
+	return nil
+}
+
+// listeningFactory creates sandboxes whose channel leads to a server on a
+// unix socket.
+type listeningFactory struct {
+	fakeFactory
+	sock string
+}
+
+type listeningSandbox struct {
+	*fakeSandbox
+	sock string
+}
+
+func (f *listeningFactory) Create(ctx context.Context, handlerDir, sandboxDir string, m *meta.HandlerMeta) (sb.Sandbox, error) {
+	s, err := f.fakeFactory.Create(ctx, handlerDir, sandboxDir, m)
+	if err != nil {
+		return nil, err
+	}
+	return listeningSandbox{s.(*fakeSandbox), f.sock}, nil
+}
+
+func (s listeningSandbox) Channel() (*sb.SandboxChannel, error) {
+	dial := func(network, addr string) (net.Conn, error) {
+		return net.Dial("unix", s.sock)
+	}
+	return &sb.SandboxChannel{Url: "http://container/", Transport: http.Transport{Dial: dial}}, nil
+}
+
+func TestDeploy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "ol.sock")
+	listener, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	status := http.StatusOK
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+
+	reg := &fakeRegistry{dir: dir}
+	factory := &listeningFactory{sock: sock}
+	conf := &config.Config{Worker_dir: dir}
+	hset := NewHandlerSet(HandlerSetOpts{RegMgr: reg, SbFactory: factory, Config: conf, Lru: NewHandlerLRU(10)})
+	h := hset.Get("hello")
+	old := &fakeSandbox{id: "old", state: state.Paused}
+	h.sandbox, h.state, h.codeDir = old, state.Paused, "v0"
+
+	// a failing probe leaves the old version in place
+	status = http.StatusInternalServerError
+	if d := hset.Deploy(context.Background(), "hello", []byte("{}")); d.Result != ROLLED_BACK || d.Error == "" {
+		t.Fatalf("expected rollback, got %+v", d)
+	}
+	if reg.version != 0 || h.codeDir != "v0" || h.sandbox != old || old.removed {
+		t.Fatalf("old version not kept: registry at v%d, code %s", reg.version, h.codeDir)
+	}
+	if !factory.created[0].removed {
+		t.Fatal("probe sandbox not removed")
+	}
+
+	status = http.StatusOK
+	if d := hset.Deploy(context.Background(), "hello", []byte("{}")); d.Result != DEPLOYED {
+		t.Fatalf("expected deploy, got %+v", d)
+	}
+	if filepath.Base(h.codeDir) != "v1" || h.sandbox != nil || !old.removed {
+		t.Fatalf("new version not committed: code %s, sandbox %v", h.codeDir, h.sandbox)
+	}
+
+	if deploys := hset.Deploys(); len(deploys) != 2 || deploys[0].Result != ROLLED_BACK {
+		t.Fatalf("unexpected deploy history %+v", deploys)
+	}
+}
+
+// countingLimiter grants every slot.
+type countingLimiter struct{ acquired int }
+
+func (l *countingLimiter) Acquire(name string, limit int) (int, error) {
+	l.acquired++
+	return l.acquired, nil
+}
+
+func (l *countingLimiter) Release(name string, slot int) {}
+
+func TestCheckMaxConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deploy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "lambda_func.py"), nil, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "lambda-config.json"), []byte(`{"max_concurrency": 2}`), 0644)
+
+	// without a coord_dir, the limit could not be enforced
+	conf := &config.Config{Worker_dir: dir}
+	hset := NewHandlerSet(HandlerSetOpts{SbFactory: &fakeFactory{}, Config: conf, Lru: NewHandlerLRU(10)})
+	_, err = hset.Get("hello").checkCode(context.Background(), dir, &startup.Timings{})
+	if err, ok := err.(*meta.IncompatibleError); !ok || err.Field != "max_concurrency" {
+		t.Fatalf("expected max_concurrency to be refused, got %v", err)
+	}
+
+	hset = NewHandlerSet(HandlerSetOpts{SbFactory: &fakeFactory{}, Config: conf, Lru: NewHandlerLRU(10), Limiter: &countingLimiter{}})
+	if _, err := hset.Get("hello").checkCode(context.Background(), dir, &startup.Timings{}); err != nil {
+		t.Fatal(err)
+	}
+}
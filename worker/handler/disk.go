@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// DISK_CHECK_INTERVAL is how often the free space of the filesystem of the
+// worker directory is checked.
+const DISK_CHECK_INTERVAL = 10 * time.Second
+
+var (
+	diskFreeRatio = metrics.NewGauge(
+		"ol_disk_free_ratio",
+		"Share of the filesystem of the worker directory that is free.")
+	diskReliefsTotal = metrics.NewCounter(
+		"ol_disk_reliefs_total",
+		"Number of times space was freed because the worker directory was low on space.")
+)
+
+// DiskPressureError is returned by RunStart, rather than deploying a handler
+// whose code is not on the worker yet, while the filesystem of the worker
+// directory is low on space.
+type DiskPressureError struct {
+	Free float64 // share of the filesystem that is free
+}
+
+func (e *DiskPressureError) Error() string {
+	return fmt.Sprintf("worker is low on disk space (%.1f%% free), not deploying new handlers", e.Free*100)
+}
+
+// diskState is what the disk monitor found in its last check.
+type diskState struct {
+	mutex sync.Mutex
+	low   bool
+	free  float64
+}
+
+// MonitorDisk checks the free space of the filesystem of the worker directory
+// every DISK_CHECK_INTERVAL. While less than disk_min_free percent of it is
+// free, each check frees what it can (see relieveDisk), and new handlers are
+// not deployed. It never returns.
+func (h *HandlerSet) MonitorDisk() {
+	for range time.Tick(DISK_CHECK_INTERVAL) {
+		var fs syscall.Statfs_t
+		if err := syscall.Statfs(h.config.Worker_dir, &fs); err != nil {
+			log.WithError(err).Warn("could not check free disk space")
+			continue
+		}
+		h.checkDisk(float64(fs.Bavail) / float64(fs.Blocks))
+	}
+}
+
+// checkDisk records the share of the filesystem that is free, and frees
+// space if it is low.
+func (h *HandlerSet) checkDisk(free float64) {
+	diskFreeRatio.Set(free)
+	low := free*100 < float64(h.config.Disk_min_free)
+
+	h.disk.mutex.Lock()
+	was := h.disk.low
+	h.disk.low, h.disk.free = low, free
+	h.disk.mutex.Unlock()
+
+	logger := log.WithField("free", fmt.Sprintf("%.1f%%", free*100))
+	if low && !was {
+		logger.Warn("worker directory low on disk space, freeing space and refusing new handlers")
+	} else if !low && was {
+		logger.Info("worker directory has enough disk space again")
+	}
+	if low {
+		h.relieveDisk()
+	}
+}
+
+// diskPressure returns a DiskPressureError if the last check found the
+// filesystem of the worker directory low on space.
+func (h *HandlerSet) diskPressure() error {
+	h.disk.mutex.Lock()
+	defer h.disk.mutex.Unlock()
+	if h.disk.low {
+		return &DiskPressureError{Free: h.disk.free}
+	}
+	return nil
+}
+
+// relieveDisk frees space on the filesystem of the worker directory. Every
+// paused handler is evicted and its sandbox removed, as are sandboxes left
+// stopped by earlier evictions; the layers no remaining sandbox uses are
+// pruned from the cache; and the stdout and stderr files of the sandboxes
+// are truncated.
+func (h *HandlerSet) relieveDisk() {
+	diskReliefsTotal.Inc()
+
+	h.mutex.Lock()
+	handlers := make([]*Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler)
+	}
+	h.mutex.Unlock()
+
+	removed := 0
+	for _, handler := range handlers {
+		if handler.evictForDisk() {
+			removed++
+		}
+	}
+
+	pruned := 0
+	if lc, ok := h.sbFactory.(sb.LayerCache); ok {
+		keep := []string{}
+		for _, handler := range handlers {
+			handler.mutex.Lock()
+			if handler.sandbox != nil && handler.meta != nil {
+				keep = append(keep, handler.meta.Layers...)
+			}
+			handler.mutex.Unlock()
+		}
+		var err error
+		if pruned, err = lc.PruneLayers(keep); err != nil {
+			log.WithError(err).Warn("could not prune layers")
+		}
+	}
+
+	log.WithFields(log.Fields{
+		"sandboxes": removed,
+		"layers":    pruned,
+		"log_bytes": h.truncateLogs(),
+	}).Warn("freed disk space")
+}
+
+// evictForDisk evicts the Handler if it is paused, and removes its sandbox
+// if it is stopped. It reports whether a sandbox was removed.
+func (h *Handler) evictForDisk() bool {
+	h.mutex.Lock()
+	paused := h.state == state.Paused
+	h.mutex.Unlock()
+	if paused {
+		h.hset.lru.Remove(h)
+		if err := h.stopPaused(DISK_PRESSURE); err != nil {
+			return false
+		}
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.sandbox == nil || h.state != state.Stopped || h.runners > 0 {
+		return false
+	}
+	h.discardSandbox()
+	return true
+}
+
+// truncateLogs empties the stdout and stderr files the runtimes write in the
+// sandbox directories, and returns how many bytes that freed.
+func (h *HandlerSet) truncateLogs() int64 {
+	handlersDir := filepath.Join(h.config.Worker_dir, "handlers")
+	dirs, err := ioutil.ReadDir(handlersDir)
+	if err != nil {
+		return 0
+	}
+
+	var freed int64
+	for _, dir := range dirs {
+		for _, name := range []string{"stdout", "stderr"} {
+			path := filepath.Join(handlersDir, dir.Name(), "sandbox", name)
+			fi, err := os.Stat(path)
+			if err != nil || fi.Size() == 0 {
+				continue
+			}
+			if err := os.Truncate(path, 0); err != nil {
+				log.WithError(err).WithField("path", path).Warn("could not truncate sandbox log")
+				continue
+			}
+			freed += fi.Size()
+		}
+	}
+	return freed
+}
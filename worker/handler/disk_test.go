@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+func TestCheckDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &config.Config{Worker_dir: dir, Disk_min_free: 5}
+	hset := NewHandlerSet(HandlerSetOpts{SbFactory: &fakeFactory{}, Config: conf, Lru: NewHandlerLRU(10)})
+
+	idle := hset.Get("idle")
+	idleSandbox := &fakeSandbox{id: "1", state: state.Paused}
+	idle.sandbox, idle.state = idleSandbox, state.Paused
+	hset.lru.Add(idle)
+	busy := hset.Get("busy")
+	busySandbox := &fakeSandbox{id: "2", state: state.Running}
+	busy.sandbox, busy.state, busy.runners = busySandbox, state.Running, 1
+
+	logs := filepath.Join(dir, "handlers", "busy", "sandbox")
+	os.MkdirAll(logs, 0755)
+	ioutil.WriteFile(filepath.Join(logs, "stdout"), []byte("chatty handler\n"), 0644)
+
+	hset.checkDisk(0.5)
+	if idleSandbox.removed || hset.diskPressure() != nil {
+		t.Fatal("relieved disk with enough space")
+	}
+
+	hset.checkDisk(0.01)
+	if !idleSandbox.removed || idle.sandbox != nil {
+		t.Fatal("idle sandbox kept under disk pressure")
+	}
+	if busySandbox.removed || busy.sandbox != busySandbox {
+		t.Fatal("busy sandbox removed under disk pressure")
+	}
+	if fi, err := os.Stat(filepath.Join(logs, "stdout")); err != nil || fi.Size() != 0 {
+		t.Fatalf("sandbox log not truncated: %v", err)
+	}
+
+	// new handlers are not deployed until space is freed
+	if _, _, err := hset.Get("new").RunStart(context.Background()); err == nil {
+		t.Fatal("expected new handler to be refused")
+	} else if _, ok := err.(*DiskPressureError); !ok {
+		t.Fatalf("expected DiskPressureError, got %v", err)
+	}
+	hset.checkDisk(0.5)
+	if hset.diskPressure() != nil {
+		t.Fatal("still under disk pressure after space was freed")
+	}
+}
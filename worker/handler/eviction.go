@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+// Reasons for evicting a handler.
+const (
+	CAPACITY      = "capacity"      // the HandlerLRU exceeded its limit
+	DISK_PRESSURE = "disk_pressure" // the worker ran low on disk space
+	ADMIN         = "admin"         // requested through the admin API
+)
+
+// EVICTION_HISTORY is the number of recent evictions kept per HandlerSet.
+const EVICTION_HISTORY = 100
+
+var (
+	evictionsTotal = metrics.NewCounter(
+		"ol_evictions_total",
+		"Number of sandboxes stopped to evict their handler.",
+		"handler", "reason")
+	evictedAgeSeconds = metrics.NewHistogram(
+		"ol_evicted_age_seconds",
+		"Time from creation to eviction of evicted sandboxes.",
+		[]float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600},
+		"reason")
+	evictedIdleSeconds = metrics.NewHistogram(
+		"ol_evicted_idle_seconds",
+		"Time evicted sandboxes spent paused before eviction.",
+		[]float64{.1, 1, 10, 60, 300, 900, 3600, 4 * 3600},
+		"reason")
+)
+
+// Eviction describes one eviction of a handler.
+type Eviction struct {
+	Handler string    `json:"handler"`
+	Reason  string    `json:"reason"`
+	Time    time.Time `json:"time"`
+	Age     float64   `json:"age_seconds"`
+	Idle    float64   `json:"idle_seconds"`
+}
+
+// EvictionStats summarizes the evictions of a HandlerSet.
+type EvictionStats struct {
+	Counts map[string]int64 `json:"counts"` // by reason
+	Recent []Eviction       `json:"recent"` // oldest first
+}
+
+// evictionLog keeps counts by reason and the most recent evictions.
+type evictionLog struct {
+	mutex    sync.Mutex
+	capacity int
+	counts   map[string]int64
+	recent   []Eviction
+}
+
+func newEvictionLog(capacity int) *evictionLog {
+	return &evictionLog{
+		capacity: capacity,
+		counts:   make(map[string]int64),
+	}
+}
+
+// record notes the eviction of a handler whose sandbox was created and last
+// paused at the given times.
+func (l *evictionLog) record(name, reason string, created, paused time.Time) {
+	now := time.Now()
+	e := Eviction{
+		Handler: name,
+		Reason:  reason,
+		Time:    now,
+		Age:     now.Sub(created).Seconds(),
+		Idle:    now.Sub(paused).Seconds(),
+	}
+
+	evictionsTotal.Inc(name, reason)
+	evictedAgeSeconds.Observe(e.Age, reason)
+	evictedIdleSeconds.Observe(e.Idle, reason)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.counts[reason] += 1
+	if len(l.recent) == l.capacity {
+		l.recent = l.recent[1:]
+	}
+	l.recent = append(l.recent, e)
+}
+
+// Evictions returns eviction counts by reason and the most recent evictions.
+func (h *HandlerSet) Evictions() EvictionStats {
+	l := h.evictions
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	stats := EvictionStats{
+		Counts: make(map[string]int64),
+		Recent: append([]Eviction{}, l.recent...),
+	}
+	for reason, count := range l.counts {
+		stats.Counts[reason] = count
+	}
+	return stats
+}
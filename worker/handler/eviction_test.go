@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvictionLog(t *testing.T) {
+	hset := &HandlerSet{evictions: newEvictionLog(2)}
+	now := time.Now()
+	hset.evictions.record("a", CAPACITY, now.Add(-time.Minute), now.Add(-time.Second))
+	hset.evictions.record("b", ADMIN, now, now)
+	hset.evictions.record("c", CAPACITY, now, now)
+
+	stats := hset.Evictions()
+	if stats.Counts[CAPACITY] != 2 || stats.Counts[ADMIN] != 1 {
+		t.Fatalf("unexpected counts %v", stats.Counts)
+	}
+	if len(stats.Recent) != 2 || stats.Recent[0].Handler != "b" || stats.Recent[1].Handler != "c" {
+		t.Fatalf("unexpected recent evictions %+v", stats.Recent)
+	}
+}
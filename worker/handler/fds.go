@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// FD_CHECK_INTERVAL is how often the file descriptors open in the worker
+// process are counted.
+const FD_CHECK_INTERVAL = 10 * time.Second
+
+var (
+	openFds = metrics.NewGauge(
+		"ol_open_fds",
+		"Number of file descriptors open in the worker process.")
+	fdLimit = metrics.NewGauge(
+		"ol_fd_limit",
+		"Soft limit on the number of file descriptors of the worker process.")
+	fdBudgetExceededTotal = metrics.NewCounter(
+		"ol_fd_budget_exceeded_total",
+		"Number of checks that found the worker over its file descriptor budget.")
+	sandboxConns = metrics.NewGauge(
+		"ol_sandbox_conns",
+		"Number of connections open to the sandbox of a handler.",
+		"handler")
+)
+
+// MonitorFds counts the file descriptors open in the worker process every
+// FD_CHECK_INTERVAL. Above fd_budget percent of its limit, it warns, and
+// closes the idle connections to paused sandboxes, which are otherwise kept
+// for their next requests. It never returns.
+func (h *HandlerSet) MonitorFds() {
+	for range time.Tick(FD_CHECK_INTERVAL) {
+		open, limit, err := fdUsage()
+		if err != nil {
+			log.WithError(err).Warn("could not count open file descriptors")
+			continue
+		}
+		h.checkFds(open, limit)
+	}
+}
+
+// checkFds records the number of file descriptors open and their limit, and
+// closes idle connections to paused sandboxes if over budget. It returns
+// the number of connections closed.
+func (h *HandlerSet) checkFds(open, limit uint64) int64 {
+	openFds.Set(float64(open))
+	fdLimit.Set(float64(limit))
+	high := limit > 0 && open*100 >= limit*uint64(h.config.Fd_budget)
+
+	logger := log.WithFields(log.Fields{"open": open, "limit": limit})
+	if high && !h.fdsHigh {
+		logger.Warn("worker near its limit of open file descriptors, closing idle sandbox connections")
+	} else if !high && h.fdsHigh {
+		logger.Info("worker back within its file descriptor budget")
+	}
+	h.fdsHigh = high
+	if !high {
+		return 0
+	}
+	fdBudgetExceededTotal.Inc()
+
+	h.mutex.Lock()
+	handlers := make([]*Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler)
+	}
+	h.mutex.Unlock()
+
+	var closed int64
+	for _, handler := range handlers {
+		closed += handler.closeIdleConns()
+	}
+	return closed
+}
+
+// closeIdleConns closes the idle connections to the sandbox of the Handler
+// if it is paused, and returns how many were closed. The channel is kept;
+// the next request dials again.
+func (h *Handler) closeIdleConns() int64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.state != state.Paused || h.channel == nil {
+		return 0
+	}
+	before := atomic.LoadInt64(&h.conns)
+	h.channel.Transport.CloseIdleConnections()
+	return before - atomic.LoadInt64(&h.conns)
+}
+
+// fdUsage returns the number of file descriptors open in the worker process
+// and its soft limit on them.
+func fdUsage() (open, limit uint64, err error) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0, err
+	}
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, 0, err
+	}
+	return uint64(len(fds)), rlim.Cur, nil
+}
+
+// openChannel gets a channel to the sandbox whose connections are counted
+// against the file descriptor budget of the worker, for the requests to the
+// sandbox to share. The caller must hold the mutex.
+func (h *Handler) openChannel() (*sb.SandboxChannel, error) {
+	ch, err := h.sandbox.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	dial := ch.Transport.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	ch.Transport.Dial = func(network, addr string) (net.Conn, error) {
+		conn, err := dial(network, addr)
+		if err != nil {
+			return nil, fdError(err)
+		}
+		h.addConns(1)
+		return &countedConn{Conn: conn, handler: h}, nil
+	}
+	return ch, nil
+}
+
+// closeChannel closes the idle connections of the channel to the sandbox,
+// and drops it so that the next sandbox gets a new one. Connections still
+// in use are closed by their requests. The caller must hold the mutex.
+func (h *Handler) closeChannel() {
+	if h.channel != nil {
+		h.channel.Transport.CloseIdleConnections()
+		h.channel = nil
+	}
+}
+
+func (h *Handler) addConns(delta int64) {
+	atomic.AddInt64(&h.conns, delta)
+	sandboxConns.Add(float64(delta), h.name)
+}
+
+// countedConn is a connection to a sandbox, counted by its Handler while
+// open.
+type countedConn struct {
+	net.Conn
+	handler *Handler
+	once    sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() { c.handler.addConns(-1) })
+	return c.Conn.Close()
+}
+
+// fdError explains a failure to dial a sandbox caused by the worker running
+// out of file descriptors, which otherwise surfaces as a confusing error
+// from the request to the sandbox.
+func fdError(err error) error {
+	if !isEMFILE(err) {
+		return err
+	}
+	open, limit, uerr := fdUsage()
+	if uerr != nil {
+		return fmt.Errorf("worker out of file descriptors: %v", err)
+	}
+	return fmt.Errorf("worker out of file descriptors (%d open, limit %d): %v", open, limit, err)
+}
+
+func isEMFILE(err error) bool {
+	switch e := err.(type) {
+	case *net.OpError:
+		return isEMFILE(e.Err)
+	case *os.SyscallError:
+		return isEMFILE(e.Err)
+	}
+	return err == syscall.EMFILE
+}
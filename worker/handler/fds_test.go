@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+func TestCheckFds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	conf := &config.Config{Fd_budget: 80}
+	hset := NewHandlerSet(HandlerSetOpts{SbFactory: &fakeFactory{}, Config: conf, Lru: NewHandlerLRU(10)})
+	h := hset.Get("hello")
+	h.sandbox, h.state = &fakeSandbox{id: "1", state: state.Running}, state.Running
+
+	ch, err := h.openChannel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.channel = ch
+	client := &http.Client{Transport: &ch.Transport}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if h.Info().Conns != 1 {
+		t.Fatalf("expected requests to share 1 connection, got %d", h.Info().Conns)
+	}
+
+	// connections of running handlers are never closed
+	if closed := hset.checkFds(90, 100); closed != 0 {
+		t.Fatalf("closed %d connections of running handler", closed)
+	}
+
+	h.state = state.Paused
+	if closed := hset.checkFds(50, 100); closed != 0 || hset.fdsHigh {
+		t.Fatalf("closed %d connections within budget", closed)
+	}
+	if closed := hset.checkFds(90, 100); closed != 1 || !hset.fdsHigh {
+		t.Fatalf("expected 1 idle connection closed over budget, got %d", closed)
+	}
+	if h.Info().Conns != 0 {
+		t.Fatalf("expected no connections left, got %d", h.Info().Conns)
+	}
+
+	h.mutex.Lock()
+	h.closeChannel()
+	h.mutex.Unlock()
+	if h.channel != nil {
+		t.Fatal("channel kept after closing")
+	}
+}
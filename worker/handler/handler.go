@@ -3,19 +3,29 @@
 package handler
 
 import (
+	"context"
 	"errors"
-	"log"
+	"fmt"
 	"os"
 	"path"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
 	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/logging"
 	"github.com/open-lambda/open-lambda/worker/registry"
 
 	pmanager "github.com/open-lambda/open-lambda/worker/pool-manager"
 	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+	"github.com/open-lambda/open-lambda/worker/scan"
+	"github.com/open-lambda/open-lambda/worker/sysaudit"
+	"github.com/open-lambda/open-lambda/worker/webhook"
 )
 
 // HandlerSetOpts wraps parameters necessary to create a HandlerSet.
@@ -25,6 +35,20 @@ type HandlerSetOpts struct {
 	PoolMgr   pmanager.PoolManager
 	Config    *config.Config
 	Lru       *HandlerLRU
+	Limiter   ConcurrencyLimiter
+	Notifier  *webhook.Notifier
+	Scanner   *scan.Checker
+	Recorder  *sysaudit.Recorder
+}
+
+// ConcurrencyLimiter bounds the number of concurrent runs of a handler,
+// possibly across many workers.
+type ConcurrencyLimiter interface {
+	// Acquire takes one of limit slots for the named handler, or fails.
+	Acquire(name string, limit int) (slot int, err error)
+
+	// Release returns a slot taken by Acquire.
+	Release(name string, slot int)
 }
 
 // HandlerSet represents a collection of Handlers of a worker server. It
@@ -37,6 +61,15 @@ type HandlerSet struct {
 	poolMgr   pmanager.PoolManager
 	config    *config.Config
 	lru       *HandlerLRU
+	limiter   ConcurrencyLimiter
+	notifier  *webhook.Notifier
+	scanner   *scan.Checker
+	recorder  *sysaudit.Recorder
+	evictions *evictionLog
+	deploys   *deployLog
+	manifest  *manifest
+	disk      diskState
+	fdsHigh   bool // whether the last check of MonitorFds was over budget
 }
 
 // Handler handles requests to run a lambda on a worker server. It handles
@@ -52,6 +85,56 @@ type Handler struct {
 	runners  int
 	code     []byte
 	codeDir  string
+	meta     *meta.HandlerMeta
+
+	// slots held in the concurrency limiter, one per runner
+	slots []int
+
+	// number of times RunStart succeeded, and how many of those needed a
+	// new sandbox (cold) or a paused one (warm)
+	invocations int64
+	coldStarts  int64
+	warmStarts  int64
+
+	// when the current sandbox was created and last paused, for eviction
+	// statistics
+	created time.Time
+	paused  time.Time
+
+	// latest resource usage of the sandbox, if active
+	usage *Usage
+
+	// consecutive failures of invocations, if breaker_failures is set
+	breaker breaker
+
+	// channel to the current sandbox, reused by its requests, and the
+	// number of connections open on it (accessed atomically)
+	channel *sb.SandboxChannel
+	conns   int64
+
+	// the sandbox runs code replaced by a deploy, and is discarded once
+	// its requests finish
+	stale bool
+
+	// syscall audit mode set through the admin API, overriding the
+	// metadata, and whether the current sandbox runs in it
+	audit   *bool
+	audited bool
+
+	// held by Deploy, so that deploys of the Handler do not overlap
+	deploying sync.Mutex
+}
+
+// HandlerInfo is a snapshot of the state of a Handler.
+type HandlerInfo struct {
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Runners     int    `json:"runners"`
+	Invocations int64  `json:"invocations"`
+	ColdStarts  int64  `json:"cold_starts"`
+	WarmStarts  int64  `json:"warm_starts"`
+	Circuit     string `json:"circuit"`
+	Conns       int64  `json:"conns"`
 }
 
 // NewHandlerSet creates an empty HandlerSet
@@ -67,6 +150,13 @@ func NewHandlerSet(opts HandlerSetOpts) (handlerSet *HandlerSet) {
 		poolMgr:   opts.PoolMgr,
 		config:    opts.Config,
 		lru:       opts.Lru,
+		limiter:   opts.Limiter,
+		notifier:  opts.Notifier,
+		scanner:   opts.Scanner,
+		recorder:  opts.Recorder,
+		evictions: newEvictionLog(EVICTION_HISTORY),
+		deploys:   &deployLog{},
+		manifest:  loadManifest(path.Join(opts.Config.Worker_dir, MANIFEST_FILE)),
 	}
 }
 
@@ -89,127 +179,431 @@ func (h *HandlerSet) Get(name string) *Handler {
 	return handler
 }
 
+// Lookup returns the named Handler, or nil if there is none; unlike Get, it
+// does not create one.
+func (h *HandlerSet) Lookup(name string) *Handler {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.handlers[name]
+}
+
 // Dump prints the name and state of the Handlers currently in the HandlerSet.
 func (h *HandlerSet) Dump() {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
-	log.Printf("HANDLERS:\n")
+	log.Info("HANDLERS:")
 	for k, v := range h.handlers {
-		log.Printf("> %v: %v\n", k, v.state.String())
+		log.Infof("> %v: %v", k, v.state.String())
+	}
+}
+
+// Evict stops the sandbox of the named Handler now, rather than waiting for
+// the HandlerLRU. It fails if the Handler is unknown or not paused.
+func (h *HandlerSet) Evict(name string) error {
+	h.mutex.Lock()
+	handler := h.handlers[name]
+	h.mutex.Unlock()
+
+	if handler == nil {
+		return fmt.Errorf("no handler named %s", name)
 	}
+	h.lru.Remove(handler)
+	return handler.stopPaused(ADMIN)
+}
+
+// List returns a snapshot of every Handler in the HandlerSet, sorted by name.
+func (h *HandlerSet) List() []HandlerInfo {
+	h.mutex.Lock()
+	handlers := make([]*Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler)
+	}
+	h.mutex.Unlock()
+
+	infos := make([]HandlerInfo, 0, len(handlers))
+	for _, handler := range handlers {
+		infos = append(infos, handler.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
 }
 
 // RunStart runs the lambda handled by this Handler. It checks if the code has
 // been pulled, sandbox been created, and sandbox been started. The channel of
-// the sandbox of this lambda is returned.
-func (h *Handler) RunStart() (ch *sb.SandboxChannel, err error) {
+// the sandbox of this lambda is returned, along with the type of start it
+// took and the time spent in each phase of it. Failures of the sandbox
+// machinery are retried, with a new sandbox, up to infra_retries times.
+//
+// Once ctx is done (e.g., the client disconnected), RunStart gives up as soon
+// as the step in progress allows, returning ctx.Err(), so that requests
+// queued behind a slow cold start do not hold the Handler in turn.
+func (h *Handler) RunStart(ctx context.Context) (ch *sb.SandboxChannel, t *startup.Timings, err error) {
+	for i := 0; ; i++ {
+		ch, t, err = h.runStart(ctx)
+		ie, ok := err.(*InfraError)
+		if !ok || i >= h.hset.config.Infra_retries {
+			return ch, t, err
+		}
+		infraRetriesTotal.Inc(h.name, ie.Op)
+		select {
+		case <-time.After(time.Duration(i+1) * INFRA_RETRY_DELAY):
+		case <-ctx.Done():
+			return nil, t, ctx.Err()
+		}
+	}
+}
+
+// runStart makes one attempt at RunStart.
+func (h *Handler) runStart(ctx context.Context) (ch *sb.SandboxChannel, t *startup.Timings, err error) {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
+	defer h.repairAfterPanic(h.runners, len(h.slots), &err)
+
+	t = startup.NewTimings(h.name)
+
+	// the caller may have given up while waiting for the mutex
+	if err := ctx.Err(); err != nil {
+		return nil, t, err
+	}
+
+	// fail fast while the circuit of the handler is open
+	if h.hset.config.Breaker_failures > 0 {
+		cooldown := time.Duration(h.hset.config.Breaker_cooldown) * time.Second
+		ok, probe, retryAfter := h.breaker.allow(time.Now(), cooldown)
+		if !ok {
+			return nil, t, &CircuitOpenError{Handler: h.name, Failures: h.breaker.failures, RetryAfter: retryAfter}
+		}
+		if probe {
+			h.log().Info("circuit half-open, letting a probe through")
+			if h.hset.config.Breaker_recreate && h.sandbox != nil && h.runners == 0 {
+				h.discardSandbox()
+			}
+		}
+	}
 
 	// get code if needed
+	registry.CacheLookup(h.hset.config.Registry, h.lastPull != nil)
 	if h.lastPull == nil {
-		codeDir, err := h.hset.regMgr.Pull(h.name)
+		if err := h.hset.diskPressure(); err != nil {
+			return nil, t, err
+		}
+		t.Start = startup.COLD
+		codeDir, err := h.pullCode(ctx, t)
 		if err != nil {
-			return nil, err
+			return nil, t, err
+		}
+		m, err := h.checkCode(ctx, codeDir, t)
+		if err != nil {
+			return nil, t, err
 		}
 		now := time.Now()
 		h.lastPull = &now
 		h.codeDir = codeDir
+		h.meta = m
+		h.hset.notifier.Notify(webhook.HANDLER_DEPLOYED, h.name, nil)
+	}
+
+	// respect the cluster-wide concurrency limit of the handler
+	if h.meta.Max_concurrency > 0 && h.hset.limiter != nil {
+		slot, err := h.hset.limiter.Acquire(h.name, h.meta.Max_concurrency)
+		if err != nil {
+			return nil, t, err
+		}
+		h.slots = append(h.slots, slot)
+		defer func() {
+			if err != nil {
+				h.releaseSlot()
+			}
+		}()
 	}
 
 	// create sandbox if needed
 	if h.sandbox == nil {
+		t.Start = startup.COLD
+		begin := time.Now()
 		sandbox_dir := path.Join(h.hset.config.Worker_dir, "handlers", h.name, "sandbox")
 		if err := os.MkdirAll(sandbox_dir, 0666); err != nil {
-			return nil, err
+			return nil, t, err
 		}
 
-		sandbox, err := h.hset.sbFactory.Create(h.codeDir, sandbox_dir)
+		m, err := h.sandboxMeta()
 		if err != nil {
-			return nil, err
+			return nil, t, err
 		}
+		sandbox, err := h.hset.sbFactory.Create(ctx, h.codeDir, sandbox_dir, m)
+		if err != nil {
+			return nil, t, h.infraError(ctx, OP_CREATE, err)
+		}
+		t.Observe(startup.CREATE, begin)
 
 		h.sandbox = sandbox
+		h.watchSyscalls(m)
+		h.created = time.Now()
+		h.hset.manifest.set(h.name, ManifestEntry{
+			Sandbox_id:  sandbox.ID(),
+			Code_dir:    h.codeDir,
+			Sandbox_dir: sandbox_dir,
+			Created:     h.created,
+		})
 		if h.state, err = sandbox.State(); err != nil {
-			return nil, err
+			return nil, t, h.infraError(ctx, OP_CREATE, err)
 		}
+		h.log().Info("created sandbox")
 
 		// newly created sandbox could be in any state; let it run
+		begin = time.Now()
 		if h.state == state.Stopped {
 			if err := sandbox.Start(); err != nil {
-				return nil, err
+				return nil, t, h.infraError(ctx, OP_START, err)
 			}
 		} else if h.state == state.Paused {
 			if err := sandbox.Unpause(); err != nil {
-				return nil, err
+				return nil, t, h.infraError(ctx, OP_UNPAUSE, err)
+			}
+		}
+
+		// custom runtimes can be slow to come up, so wait for them to
+		// listen rather than relying on the retries of the caller
+		if h.meta.Runtime == meta.CUSTOM {
+			ch, err := sandbox.Channel()
+			if err != nil {
+				return nil, t, err
+			}
+			if err := sb.WaitReady(ch, sb.READY_TIMEOUT); err != nil {
+				return nil, t, err
 			}
 		}
+		t.Observe(startup.START, begin)
 
-		if h.hset.poolMgr != nil {
+		if h.hset.poolMgr != nil && sb.Pooled(h.hset.config, h.meta.Runtime) {
 			containerSB, ok := h.sandbox.(sb.ContainerSandbox)
 			if !ok {
-				return nil, errors.New("forkenter only supported with ContainerSandbox")
+				return nil, t, errors.New("forkenter only supported with ContainerSandbox")
 			}
 
-			h.hset.poolMgr.ForkEnter(containerSB)
+			begin = time.Now()
+			if err := h.hset.poolMgr.ForkEnter(ctx, containerSB, h.meta); err != nil {
+				return nil, t, h.infraError(ctx, OP_FORK, err)
+			}
+			t.Observe(startup.FORK, begin)
 		}
 	} else if h.state == state.Paused { // unpause if paused
+		t.Start = startup.WARM
+		begin := time.Now()
 		if err := h.sandbox.Unpause(); err != nil {
-			return nil, err
+			return nil, t, h.infraError(ctx, OP_UNPAUSE, err)
 		}
+		t.Observe(startup.START, begin)
 		h.hset.lru.Remove(h)
 	}
 
 	h.state = state.Running
 	h.runners += 1
+	h.invocations += 1
+	if t.Start == startup.COLD {
+		h.coldStarts += 1
+	} else if t.Start == startup.WARM {
+		h.warmStarts += 1
+	}
 
-	return h.sandbox.Channel()
+	if h.channel == nil {
+		if h.channel, err = h.openChannel(); err != nil {
+			return nil, t, err
+		}
+	}
+	return h.channel, t, nil
 }
 
 // RunFinish notifies that a request to run the lambda has completed. If no
 // request is being run in its sandbox, sandbox will be paused and the handler
-// be added to the HandlerLRU.
+// be added to the HandlerLRU. A sandbox that cannot be paused would keep
+// running for free, so it is discarded instead, and the next request starts
+// cold.
 func (h *Handler) RunFinish() {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
 	h.runners -= 1
+	h.releaseSlot()
 
-	// are we the last?
-	if h.runners == 0 {
-		if err := h.sandbox.Pause(); err != nil {
-			// TODO(tyler): better way to handle this?  If
-			// we can't pause, the handler gets to keep
-			// running for free...
-			log.Printf("Could not pause %v!  Error: %v\n", h.name, err)
+	// are we the last? (the sandbox is gone if the watchdog discarded
+	// it while the request was stuck)
+	if h.runners == 0 && h.sandbox != nil {
+		if h.stale {
+			h.log().Info("discarding sandbox running replaced code")
+			h.discardSandbox()
+			return
+		}
+		if err := h.pause(); err != nil {
+			pauseFailuresTotal.Inc(h.name)
+			h.log().WithError(err).Error("could not pause, discarding sandbox")
+			h.discardSandbox()
+			return
 		}
 		h.state = state.Paused
+		h.paused = time.Now()
 		h.hset.lru.Add(h)
 	}
 }
 
-// StopIfPaused stops the sandbox if it is paused.
+// pause pauses the sandbox, retrying up to infra_retries times. The caller
+// must hold the mutex.
+func (h *Handler) pause() error {
+	err := h.sandbox.Pause()
+	for i := 0; err != nil && i < h.hset.config.Infra_retries; i++ {
+		h.log().WithError(err).Warn("could not pause, retrying")
+		infraRetriesTotal.Inc(h.name, OP_PAUSE)
+		time.Sleep(time.Duration(i+1) * INFRA_RETRY_DELAY)
+		err = h.sandbox.Pause()
+	}
+	return err
+}
+
+// Report records the outcome of an invocation started by RunStart for the
+// circuit breaker of the Handler; ok is false if the invocation failed or
+// timed out.
+func (h *Handler) Report(ok bool) {
+	threshold := h.hset.config.Breaker_failures
+	if threshold <= 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	probing := h.breaker.current() == HALF_OPEN
+	if h.breaker.record(ok, time.Now(), threshold) {
+		h.log().WithField("failures", h.breaker.failures).Warn("circuit opened")
+		h.hset.notifier.Notify(webhook.CIRCUIT_OPENED, h.name, map[string]string{
+			"failures": fmt.Sprint(h.breaker.failures),
+		})
+	} else if probing && ok {
+		h.log().Info("circuit closed")
+	}
+}
+
+// discardSandbox stops and removes the sandbox, so that the next run gets a
+// new one. The caller must hold the mutex; requests still running in the
+// sandbox, if any, fail.
+func (h *Handler) discardSandbox() {
+	h.hset.lru.Remove(h)
+	if err := removeSandbox(h.sandbox); err != nil {
+		h.log().WithError(err).Warn("could not remove sandbox")
+	}
+	h.hset.manifest.remove(h.name)
+	h.closeChannel()
+	h.sandbox = nil
+	h.stale = false
+	h.state = state.Stopped
+}
+
+// releaseSlot returns one concurrency slot held by this Handler, if any. The
+// caller must hold the mutex.
+func (h *Handler) releaseSlot() {
+	if len(h.slots) == 0 {
+		return
+	}
+	slot := h.slots[len(h.slots)-1]
+	h.slots = h.slots[:len(h.slots)-1]
+	h.hset.limiter.Release(h.name, slot)
+}
+
+// StopIfPaused stops the sandbox if it is paused, to make room for others.
 func (h *Handler) StopIfPaused() {
+	h.stopPaused(CAPACITY)
+}
+
+// stopPaused evicts the sandbox for the given reason, failing if it is not
+// paused.
+func (h *Handler) stopPaused(reason string) error {
 	h.mutex.Lock()
 	defer h.mutex.Unlock()
 
 	if h.state != state.Paused {
-		return
+		return fmt.Errorf("handler %s is %s, not paused", h.name, h.state.String())
 	}
 
 	// TODO(tyler): why do we need to unpause in order to kill?
 	if err := h.sandbox.Unpause(); err != nil {
-		log.Printf("Could not unpause %v to kill it!  Error: %v\n", h.name, err)
+		h.log().WithError(err).Error("could not unpause to kill")
+		return err
 	} else if err := h.sandbox.Stop(); err != nil {
 		// TODO: a resource leak?
-		log.Printf("Could not kill %v after unpausing!  Error: %v\n", h.name, err)
-	} else {
-		h.state = state.Stopped
+		h.log().WithError(err).Error("could not kill after unpausing")
+		return err
+	}
+
+	h.state = state.Stopped
+	h.closeChannel()
+	h.hset.manifest.remove(h.name)
+	h.log().WithField("reason", reason).Info("stopped paused sandbox")
+	h.hset.evictions.record(h.name, reason, h.created, h.paused)
+	h.hset.notifier.Notify(webhook.HANDLER_EVICTED, h.name, map[string]string{"reason": reason})
+	return nil
+}
+
+// log returns a logger carrying the name, state and sandbox of this Handler.
+// The caller must hold the mutex.
+func (h *Handler) log() *log.Entry {
+	fields := log.Fields{
+		logging.HANDLER: h.name,
+		logging.STATE:   h.state.String(),
+	}
+	if h.sandbox != nil {
+		fields[logging.SANDBOX_ID] = h.sandbox.ID()
 	}
+	return log.WithFields(fields)
 }
 
-// Sandbox returns the sandbox of this Handler.
+// Info returns a snapshot of the state of this Handler.
+func (h *Handler) Info() HandlerInfo {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return HandlerInfo{
+		Name:        h.name,
+		State:       h.state.String(),
+		Runners:     h.runners,
+		Invocations: h.invocations,
+		ColdStarts:  h.coldStarts,
+		WarmStarts:  h.warmStarts,
+		Circuit:     h.breaker.current(),
+		Conns:       atomic.LoadInt64(&h.conns),
+	}
+}
+
+// Name returns the name of the lambda this Handler runs.
+func (h *Handler) Name() string {
+	return h.name
+}
+
+// Meta returns the metadata of this Handler, or nil if its code has not been
+// pulled yet.
+func (h *Handler) Meta() *meta.HandlerMeta {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.meta
+}
+
+// Sandbox returns the current sandbox of this Handler, or nil if it has
+// none. The sandbox may be replaced or discarded right after.
 func (h *Handler) Sandbox() sb.Sandbox {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 	return h.sandbox
 }
+
+// RunningPid returns the pid of the first process of the sandbox of this
+// Handler, as seen by the worker, if it is a running container sandbox, and
+// "" otherwise.
+func (h *Handler) RunningPid() string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	csb, ok := h.sandbox.(sb.ContainerSandbox)
+	if !ok || h.state != state.Running {
+		return ""
+	}
+	return csb.NSPid()
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"log"
 	"os"
 	"testing"
@@ -90,7 +91,7 @@ func TestHandlerHandlerPull(t *testing.T) {
 		t.Fatalf("Get should not pull %s", name)
 	}
 
-	_, err = h.RunStart()
+	_, _, err = h.RunStart(context.Background())
 	if err != nil {
 		t.Fatalf("RunStart failed with: %v", err.Error())
 	}
@@ -120,7 +121,7 @@ func TestHandlerRunCountOne(t *testing.T) {
 	handlers := NewHandlerSet(opts)
 	h := handlers.Get("hello2")
 
-	_, err := h.RunStart()
+	_, _, err := h.RunStart(context.Background())
 	if err != nil {
 		t.Fatalf("RunStart failed with: %v", err.Error())
 	}
@@ -146,7 +147,7 @@ func TestHandlerRunCountMany(t *testing.T) {
 
 	for i := 0; i < count; i++ {
 		log.Printf("Starting %v\n", i+1)
-		_, err := h.RunStart()
+		_, _, err := h.RunStart(context.Background())
 		if err != nil {
 			t.Fatalf("RunStart failed with: %v", err.Error())
 		}
@@ -178,7 +179,7 @@ func TestHandlerEvict(t *testing.T) {
 	opts.Lru = lru
 	handlers := NewHandlerSet(opts)
 	h := handlers.Get("hello2")
-	_, err := h.RunStart()
+	_, _, err := h.RunStart(context.Background())
 	if err != nil {
 		t.Fatalf("RunStart failed with: %v", err.Error())
 	}
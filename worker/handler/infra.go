@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+// INFRA_RETRY_DELAY is the delay before the first retry of an operation that
+// failed with an InfraError; each further retry waits one more delay.
+const INFRA_RETRY_DELAY = 100 * time.Millisecond
+
+var (
+	infraRetriesTotal = metrics.NewCounter(
+		"ol_infra_retries_total",
+		"Number of retries of operations of the sandbox machinery that failed.",
+		"handler", "op")
+	pauseFailuresTotal = metrics.NewCounter(
+		"ol_pause_failures_total",
+		"Number of sandboxes discarded because they could not be paused.",
+		"handler")
+)
+
+// operations of the sandbox machinery that can fail transiently
+const (
+	OP_CREATE  = "create"
+	OP_START   = "start"
+	OP_UNPAUSE = "unpause"
+	OP_PAUSE   = "pause"
+	OP_FORK    = "fork"
+)
+
+// InfraError is returned by RunStart when the sandbox machinery, rather than
+// the handler, failed: creating, starting or unpausing the sandbox, or
+// forking into it from the pool. Such failures are often transient (e.g.,
+// a race in the container runtime), so RunStart retries them up to
+// infra_retries times before returning one; the sandbox involved is
+// discarded, so each retry gets a new one.
+type InfraError struct {
+	Op  string
+	Err error
+}
+
+func (e *InfraError) Error() string {
+	return fmt.Sprintf("sandbox %s failed: %v", e.Op, e.Err)
+}
+
+// infraError wraps err as an InfraError of op. If the Handler has a sandbox,
+// it may be left in any state, so it is discarded. If ctx is done, op most
+// likely failed because it was interrupted, so ctx.Err() is returned instead,
+// and not retried. The caller must hold the mutex, and no request may be
+// running.
+func (h *Handler) infraError(ctx context.Context, op string, err error) error {
+	if h.sandbox != nil {
+		h.discardSandbox()
+	}
+	if ctx.Err() != nil {
+		h.log().WithField("op", op).Info("sandbox setup abandoned by the caller")
+		return ctx.Err()
+	}
+	h.log().WithError(err).WithField("op", op).Warn("sandbox machinery failed")
+	return &InfraError{Op: op, Err: err}
+}
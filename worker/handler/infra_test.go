@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+func TestRunStartInfraRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "infra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range []struct {
+		retries, failures int
+		ok                bool
+	}{
+		{retries: 2, failures: 2, ok: true},
+		{retries: 2, failures: 3, ok: false},
+		{retries: -1, failures: 1, ok: false},
+	} {
+		factory := &fakeFactory{failures: c.failures}
+		conf := &config.Config{Worker_dir: dir, Infra_retries: c.retries}
+		hset := NewHandlerSet(HandlerSetOpts{SbFactory: factory, Config: conf, Lru: NewHandlerLRU(10)})
+
+		// as if the code had been pulled
+		h := hset.Get("hello")
+		now := time.Now()
+		h.lastPull = &now
+		h.meta = meta.Default()
+
+		_, _, err := h.RunStart(context.Background())
+		if c.ok && err != nil {
+			t.Fatalf("%d failures with %d retries: %v", c.failures, c.retries, err)
+		} else if !c.ok {
+			if _, infra := err.(*InfraError); !infra {
+				t.Fatalf("%d failures with %d retries: expected an InfraError, got %v", c.failures, c.retries, err)
+			}
+		}
+	}
+}
+
+func TestRunStartCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "infra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	factory := &fakeFactory{}
+	conf := &config.Config{Worker_dir: dir, Infra_retries: 2}
+	hset := NewHandlerSet(HandlerSetOpts{SbFactory: factory, Config: conf, Lru: NewHandlerLRU(10)})
+	h := hset.Get("hello")
+	now := time.Now()
+	h.lastPull = &now
+	h.meta = meta.Default()
+
+	// the client is gone before the handler is free
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := h.RunStart(ctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(factory.created) != 0 || h.sandbox != nil {
+		t.Fatalf("sandbox created for a cancelled request")
+	}
+}
+
+func TestRunFinishPauseFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "infra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	factory := &fakeFactory{}
+	conf := &config.Config{Worker_dir: dir, Infra_retries: 1}
+	lru := NewHandlerLRU(10)
+	hset := NewHandlerSet(HandlerSetOpts{SbFactory: factory, Config: conf, Lru: lru})
+	h := hset.Get("hello")
+	now := time.Now()
+	h.lastPull = &now
+	h.meta = meta.Default()
+
+	if _, _, err := h.RunStart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sandbox := factory.created[0]
+	sandbox.pauseFails = true
+	h.RunFinish()
+
+	// rather than running for free, the sandbox is gone
+	if h.sandbox != nil || h.state != state.Stopped || !sandbox.removed || lru.Len() != 0 {
+		t.Fatalf("sandbox that could not pause kept: %v (removed: %v)", h.state, sandbox.removed)
+	}
+
+	// and the next request starts cold
+	_, timings, err := h.RunStart(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timings.Start != startup.COLD || len(factory.created) != 2 {
+		t.Fatalf("expected a cold start in a new sandbox, got %v start", timings.Start)
+	}
+}
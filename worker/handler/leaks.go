@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// kinds of leaks found by the leak detector
+const (
+	ORPHANED = "orphaned" // a sandbox of the worker that no Handler owns
+	VANISHED = "vanished" // the sandbox of a Handler is gone from the runtime
+)
+
+var sandboxLeaksTotal = metrics.NewCounter(
+	"ol_sandbox_leaks_total",
+	"Number of leaked sandboxes removed or forgotten by the leak detector, by kind.",
+	"kind")
+
+// DetectLeaks cross-checks the sandboxes of the Handlers against those of
+// the worker in the container runtime every interval. It never returns,
+// unless the SandboxFactory is not Recoverable, i.e. cannot list the
+// sandboxes it created.
+func (h *HandlerSet) DetectLeaks(interval time.Duration) {
+	if _, ok := h.sbFactory.(sb.Recoverable); !ok {
+		log.Info("sandbox factory cannot list its sandboxes, not detecting leaks")
+		return
+	}
+	suspects := make(map[string]bool)
+	for range time.Tick(interval) {
+		suspects = h.checkLeaks(suspects)
+	}
+}
+
+// checkLeaks makes one pass of the leak detector. A Handler whose sandbox is
+// gone forgets it, so that its next request starts cold. A sandbox that no
+// Handler owns is removed once it has been found in two consecutive passes,
+// so that sandboxes being created by a Handler are left alone; suspects are
+// those found by the previous pass, and the ones found by this pass are
+// returned.
+func (h *HandlerSet) checkLeaks(suspects map[string]bool) map[string]bool {
+	// sandboxes created after the snapshot are not owned in it, but are
+	// listed; only the second strike protects them
+	h.mutex.Lock()
+	handlers := make([]*Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler)
+	}
+	h.mutex.Unlock()
+
+	owners := make(map[string]*Handler)
+	for _, handler := range handlers {
+		handler.mutex.Lock()
+		if handler.sandbox != nil {
+			owners[handler.sandbox.ID()] = handler
+		}
+		handler.mutex.Unlock()
+	}
+
+	sandboxes, err := h.sbFactory.(sb.Recoverable).Leftovers()
+	if err != nil {
+		log.WithError(err).Warn("leak detector could not list sandboxes")
+		return suspects
+	}
+
+	for id, handler := range owners {
+		if sandboxes[id] == nil {
+			handler.forgetSandbox(id)
+		}
+	}
+
+	found := make(map[string]bool)
+	for id, sandbox := range sandboxes {
+		if owners[id] != nil {
+			continue
+		}
+		logger := log.WithField(logging.SANDBOX_ID, id)
+		if !suspects[id] {
+			logger.Info("sandbox not owned by any handler, removing it if it stays so")
+			found[id] = true
+			continue
+		}
+		if err := removeSandbox(sandbox); err != nil {
+			logger.WithError(err).Warn("could not remove orphaned sandbox")
+			found[id] = true // try again next time
+			continue
+		}
+		sandboxLeaksTotal.Inc(ORPHANED)
+		logger.Warn("removed orphaned sandbox")
+	}
+	return found
+}
+
+// forgetSandbox makes the Handler forget its sandbox, which is gone from the
+// container runtime, unless it has replaced it since, or requests are still
+// running in it (they will fail, and the next pass will try again).
+func (h *Handler) forgetSandbox(id string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.sandbox == nil || h.sandbox.ID() != id || h.runners > 0 {
+		return
+	}
+	sandboxLeaksTotal.Inc(VANISHED)
+	h.log().Warn("sandbox vanished, next request will start cold")
+	h.hset.lru.Remove(h)
+	h.hset.manifest.remove(h.name)
+	h.closeChannel()
+	h.sandbox = nil
+	h.stale = false
+	h.state = state.Stopped
+}
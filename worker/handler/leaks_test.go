@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+func TestCheckLeaks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	owned := &fakeSandbox{id: "1", state: state.Paused}
+	vanished := &fakeSandbox{id: "2", state: state.Paused}
+	orphan := &fakeSandbox{id: "3", state: state.Paused}
+	factory := &fakeFactory{leftovers: map[string]sb.Sandbox{"1": owned, "3": orphan}}
+	opts := HandlerSetOpts{SbFactory: factory, Config: &config.Config{Worker_dir: dir}, Lru: NewHandlerLRU(10)}
+	hset := NewHandlerSet(opts)
+
+	hello := hset.Get("hello")
+	hello.sandbox, hello.state = owned, state.Paused
+	gone := hset.Get("gone")
+	gone.sandbox, gone.state = vanished, state.Paused
+
+	// the orphan is only suspected at first
+	suspects := hset.checkLeaks(map[string]bool{})
+	if !suspects["3"] || len(suspects) != 1 || orphan.removed {
+		t.Fatalf("expected orphan to be suspected, got %v (removed: %v)", suspects, orphan.removed)
+	}
+	if gone.sandbox != nil || gone.state != state.Stopped {
+		t.Fatalf("vanished sandbox not forgotten: %v", gone.state)
+	}
+	if hello.sandbox != owned || owned.removed {
+		t.Fatalf("owned sandbox touched")
+	}
+
+	suspects = hset.checkLeaks(suspects)
+	if len(suspects) != 0 || !orphan.removed {
+		t.Fatalf("expected orphan to be removed, got %v (removed: %v)", suspects, orphan.removed)
+	}
+}
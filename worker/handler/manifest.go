@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// MANIFEST_FILE, in the worker directory, records the sandbox of each
+// handler, so that a restarted worker can tell its own sandboxes from those
+// it should clean up (see HandlerSet.Reconcile).
+const MANIFEST_FILE = "manifest.json"
+
+// ManifestEntry describes the sandbox of a handler.
+type ManifestEntry struct {
+	Sandbox_id  string    `json:"sandbox_id"`
+	Code_dir    string    `json:"code_dir"`
+	Sandbox_dir string    `json:"sandbox_dir"`
+	Created     time.Time `json:"created"`
+}
+
+// manifest is the persisted set of sandboxes of the handlers of a worker.
+// Every change is written through to the file.
+type manifest struct {
+	mutex   sync.Mutex
+	path    string
+	entries map[string]ManifestEntry
+}
+
+// loadManifest reads the manifest at path. A missing or corrupt file reads as
+// an empty manifest; anything it described is then cleaned up as unknown.
+func loadManifest(path string) *manifest {
+	m := &manifest{path: path, entries: make(map[string]ManifestEntry)}
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m
+	} else if err != nil {
+		log.WithError(err).Warn("could not read manifest")
+		return m
+	}
+	if err := json.Unmarshal(raw, &m.entries); err != nil {
+		log.WithError(err).Warn("ignoring corrupt manifest")
+		m.entries = make(map[string]ManifestEntry)
+	}
+	return m
+}
+
+// snapshot returns a copy of the entries.
+func (m *manifest) snapshot() map[string]ManifestEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entries := make(map[string]ManifestEntry, len(m.entries))
+	for name, entry := range m.entries {
+		entries[name] = entry
+	}
+	return entries
+}
+
+// set records the sandbox of the named handler.
+func (m *manifest) set(name string, entry ManifestEntry) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries[name] = entry
+	m.save()
+}
+
+// remove forgets the sandbox of the named handler.
+func (m *manifest) remove(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.entries[name]; !ok {
+		return
+	}
+	delete(m.entries, name)
+	m.save()
+}
+
+// save atomically replaces the file. The caller must hold the mutex. The
+// manifest only helps the next start, so failures are logged rather than
+// failing requests.
+func (m *manifest) save() {
+	data, err := json.MarshalIndent(m.entries, "", "    ")
+	if err == nil {
+		tmp := fmt.Sprintf("%s.%d.tmp", m.path, os.Getpid())
+		if err = ioutil.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, m.path)
+		}
+	}
+	if err != nil {
+		log.WithError(err).Warn("could not save manifest")
+	}
+}
@@ -0,0 +1,189 @@
+package meta
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// default entrypoints of the runtimes that support choosing one; Java
+// handlers default to the Main-Class of their first JAR (see CheckEntrypoint)
+var defaultEntrypoints = map[string]string{
+	PYTHON: "lambda_func.handler",
+	NODEJS: "index.handler",
+	RUBY:   "lambda_func.handler",
+	R:      "lambda_func.handler",
+}
+
+// SplitEntrypoint splits an entrypoint of the form <module>.<function> at its
+// last dot.
+func SplitEntrypoint(entrypoint string) (module, function string, err error) {
+	i := strings.LastIndex(entrypoint, ".")
+	if i <= 0 || i == len(entrypoint)-1 {
+		return "", "", fmt.Errorf("bad entrypoint %q, expected <module>.<function>", entrypoint)
+	}
+	return entrypoint[:i], entrypoint[i+1:], nil
+}
+
+// CheckEntrypoint verifies that the module of the entrypoint of the handler
+// exists in its code, so that a wrong entrypoint fails the first start with a
+// clear error rather than inside the sandbox. Whether the module defines the
+// function can only be checked by the runtime.
+//
+// Exec handlers have no entrypoint; their executable is checked instead, and
+// for .NET handlers, only that there are assemblies. Java handlers without
+// an entrypoint need a JAR whose manifest names their class, like the
+// dispatcher does.
+func (m *HandlerMeta) CheckEntrypoint(codeDir string) error {
+	if m.Runtime == EXEC && m.Entrypoint == "" {
+		fi, err := os.Stat(filepath.Join(codeDir, EXEC_BINARY))
+		if os.IsNotExist(err) {
+			return fmt.Errorf("entrypoint not found: no %s executable in the handler code", EXEC_BINARY)
+		} else if err != nil {
+			return err
+		} else if fi.Mode()&0111 == 0 {
+			return fmt.Errorf("%s in the handler code is not executable", EXEC_BINARY)
+		}
+		return nil
+	}
+
+	// the dispatcher resolves the type, which would take parsing the
+	// metadata of the assemblies here
+	if m.Runtime == DOTNET {
+		matches, err := filepath.Glob(filepath.Join(codeDir, "*.dll"))
+		if err != nil {
+			return err
+		} else if len(matches) == 0 {
+			return fmt.Errorf("entrypoint not found: no assembly (*.dll) in the handler code")
+		}
+		return nil
+	}
+
+	if m.Runtime == JAVA && m.Entrypoint == "" {
+		jar, class, err := mainClass(codeDir)
+		if err != nil {
+			return err
+		} else if jar == "" {
+			return fmt.Errorf("entrypoint not found: no JAR in the handler code")
+		} else if class == "" {
+			return fmt.Errorf("entrypoint not set, and %s has no Main-Class", jar)
+		}
+		if found, err := jarsContain(codeDir, strings.Replace(class, ".", "/", -1)+".class"); err != nil || found {
+			return err
+		}
+		return fmt.Errorf("entrypoint not found: no class %s in the JARs of the handler", class)
+	}
+
+	if m.Entrypoint == "" {
+		return nil
+	}
+	module, _, err := SplitEntrypoint(m.Entrypoint)
+	if err != nil {
+		return err
+	}
+
+	var candidates []string
+	switch m.Runtime {
+	case PYTHON:
+		base := filepath.Join(codeDir, filepath.FromSlash(strings.Replace(module, ".", "/", -1)))
+		candidates = []string{base + ".py", base + ".pyc", base + ".so", filepath.Join(base, "__init__.py")}
+	case NODEJS:
+		base := filepath.Join(codeDir, filepath.FromSlash(module))
+		candidates = []string{base, base + ".js", filepath.Join(base, "index.js"), filepath.Join(base, "package.json")}
+	case RUBY:
+		candidates = []string{filepath.Join(codeDir, filepath.FromSlash(module)) + ".rb"}
+	case R:
+		base := filepath.Join(codeDir, filepath.FromSlash(module))
+		candidates = []string{base + ".R", base + ".r"}
+	case JAVA:
+		found, err := jarsContain(codeDir, strings.Replace(module, ".", "/", -1)+".class")
+		if err != nil || found {
+			return err
+		}
+		return fmt.Errorf("entrypoint not found: no class %s in the JARs of the handler", module)
+	default:
+		return fmt.Errorf("the %s runtime does not support choosing an entrypoint", m.Runtime)
+	}
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("entrypoint not found: no module %s in the handler code", module)
+}
+
+// jarsContain returns true if one of the JARs in dir has an entry of the
+// given name.
+func jarsContain(dir, name string) (bool, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".jar") {
+			continue
+		}
+		jar, err := zip.OpenReader(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return false, fmt.Errorf("could not read %s: %v", fi.Name(), err)
+		}
+		for _, f := range jar.File {
+			if f.Name == name {
+				jar.Close()
+				return true, nil
+			}
+		}
+		jar.Close()
+	}
+	return false, nil
+}
+
+// mainClass returns the name of the first JAR in dir, by name, and the
+// Main-Class of its manifest, if any.
+func mainClass(dir string) (jar, class string, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), ".jar") {
+			jar = fi.Name()
+			break
+		}
+	}
+	if jar == "" {
+		return "", "", nil
+	}
+
+	r, err := zip.OpenReader(filepath.Join(dir, jar))
+	if err != nil {
+		return "", "", fmt.Errorf("could not read %s: %v", jar, err)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.Name != "META-INF/MANIFEST.MF" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", "", fmt.Errorf("could not read %s: %v", jar, err)
+		}
+		manifest, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", "", fmt.Errorf("could not read %s: %v", jar, err)
+		}
+		// long values continue on lines starting with a space
+		text := strings.Replace(strings.Replace(string(manifest), "\r\n", "\n", -1), "\n ", "", -1)
+		for _, line := range strings.Split(text, "\n") {
+			if strings.HasPrefix(line, "Main-Class:") {
+				return jar, strings.TrimSpace(strings.TrimPrefix(line, "Main-Class:")), nil
+			}
+		}
+	}
+	return jar, "", nil
+}
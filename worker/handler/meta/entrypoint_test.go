@@ -0,0 +1,100 @@
+package meta
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckEntrypoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "entrypoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "pkg"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "pkg", "app.py"), []byte("def handle(conn, event): pass\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, FILE), []byte(`{"entrypoint": "pkg.app.handle"}`), 0644)
+
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CheckEntrypoint(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m.Entrypoint = "pkg.missing.handle"
+	if err := m.CheckEntrypoint(dir); err == nil || !strings.Contains(err.Error(), "entrypoint not found") {
+		t.Fatalf("expected entrypoint not found, got %v", err)
+	}
+
+	// the default entrypoint is checked too
+	if err := Default().CheckEntrypoint(dir); err == nil {
+		t.Fatal("expected error for missing lambda_func.py")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, FILE), []byte(`{"entrypoint": "handle"}`), 0644)
+	if _, err := Load(dir); err == nil {
+		t.Fatal("expected error for entrypoint without module")
+	}
+}
+
+// writeJar writes a JAR to path with the given manifest and classes.
+func writeJar(t *testing.T, path, manifest string, classes ...string) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	if manifest != "" {
+		mf, _ := w.Create("META-INF/MANIFEST.MF")
+		mf.Write([]byte(manifest))
+	}
+	for _, class := range classes {
+		w.Create(class)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckEntrypointJava(t *testing.T) {
+	dir, err := ioutil.TempDir("", "entrypoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	m := &HandlerMeta{Runtime: JAVA}
+
+	if err := m.CheckEntrypoint(dir); err == nil || !strings.Contains(err.Error(), "no JAR") {
+		t.Fatalf("expected missing JAR to be refused, got %v", err)
+	}
+
+	// without an entrypoint, the Main-Class of the first JAR is used
+	writeJar(t, filepath.Join(dir, "a.jar"), "Manifest-Version: 1.0\r\n", "acme/Hello.class")
+	if err := m.CheckEntrypoint(dir); err == nil || !strings.Contains(err.Error(), "no Main-Class") {
+		t.Fatalf("expected JAR without Main-Class to be refused, got %v", err)
+	}
+	writeJar(t, filepath.Join(dir, "a.jar"), "Manifest-Version: 1.0\r\nMain-Class: acme.Missing\r\n", "acme/Hello.class")
+	if err := m.CheckEntrypoint(dir); err == nil || !strings.Contains(err.Error(), "no class acme.Missing") {
+		t.Fatalf("expected missing Main-Class to be refused, got %v", err)
+	}
+	writeJar(t, filepath.Join(dir, "a.jar"), "Manifest-Version: 1.0\r\nMain-Class: acme.He\r\n llo\r\n", "acme/Hello.class")
+	if err := m.CheckEntrypoint(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Entrypoint = "acme.Hello.handle"
+	if err := m.CheckEntrypoint(dir); err != nil {
+		t.Fatal(err)
+	}
+	m.Entrypoint = "acme.Bye.handle"
+	if err := m.CheckEntrypoint(dir); err == nil {
+		t.Fatal("expected missing class to be refused")
+	}
+}
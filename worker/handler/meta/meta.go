@@ -0,0 +1,350 @@
+// meta package defines the per-handler metadata that lambda authors ship
+// alongside their code.
+package meta
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+// FILE is the name of the metadata file in the root of a handler's code
+// directory. It is optional; a handler without one gets the defaults.
+const FILE = "lambda-config.json"
+
+// SessionAffinity describes where to find the session key of a request.
+// Requests with the same key are routed to the same worker (and thus the same
+// sandbox) by the balancer.
+type SessionAffinity struct {
+	// name of an HTTP header carrying the session key
+	Header string `json:"header"`
+
+	// name of a top-level field in the JSON request body carrying the
+	// session key, used if the header is absent
+	Field string `json:"field"`
+}
+
+// Mirror describes where copies of a handler's traffic are sent. Responses
+// to the copies are discarded; only failures are recorded.
+// At least one of Handler and Worker must be set, and the target must differ
+// from the handler itself (see CheckMirror).
+type Mirror struct {
+	// handler that receives the copies (defaults to the same handler,
+	// which needs a Worker)
+	Handler string `json:"handler"`
+
+	// host:port of the worker that receives the copies (defaults to the
+	// local worker, which needs a different Handler)
+	Worker string `json:"worker"`
+
+	// percentage of requests to copy, in [0, 100]
+	Percent float64 `json:"percent"`
+}
+
+// Privileges are exceptions to the hardening of the sandbox of a handler,
+// which by default keeps only the capabilities configured on the worker
+// (sandbox_caps) and cannot gain privileges, e.g. through setuid binaries.
+// Workers refuse handlers asking for them unless allow_privilege_opt_out is
+// set.
+type Privileges struct {
+	// Linux capabilities kept besides those of the worker, e.g. "NET_RAW"
+	Cap_add []string `json:"cap_add"`
+
+	// if set, processes of the sandbox may gain privileges
+	New_privileges bool `json:"new_privileges"`
+}
+
+// runtimes a handler can be written for
+const (
+	PYTHON = "python"
+	NODEJS = "nodejs"
+	GO     = "go"
+	JAVA   = "java"
+	CUSTOM = "custom"
+	EXEC   = "exec"
+	RUBY   = "ruby"
+	R      = "r"
+	DOTNET = "dotnet"
+)
+
+// runtimes lists the known runtimes.
+var runtimes = []string{PYTHON, NODEJS, GO, JAVA, CUSTOM, EXEC, RUBY, R, DOTNET}
+
+// SCHEMA_VERSION is the latest version of the metadata format understood by
+// this worker. Handlers relying on fields added later declare the version
+// that introduced them, so that older workers refuse them rather than
+// silently ignore the fields; declaring a version also makes unknown fields
+// an error.
+const SCHEMA_VERSION = 1
+
+// IncompatibleError is returned for handler metadata that the worker does not
+// understand, or cannot honor (e.g. a runtime it does not provide), so that
+// the handler fails to deploy rather than inside its sandbox.
+type IncompatibleError struct {
+	// metadata field at fault, e.g. "python_version"
+	Field string
+
+	// its value, if the field is known
+	Value string
+
+	// why it is not supported, e.g. "is not available on this worker"
+	Reason string
+}
+
+func (e *IncompatibleError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%s: field %q %s", FILE, e.Field, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s %q %s", FILE, e.Field, e.Value, e.Reason)
+}
+
+// HandlerMeta represents the metadata of a handler.
+type HandlerMeta struct {
+	// version of the metadata format (defaults to 1, at most
+	// SCHEMA_VERSION)
+	Schema_version int `json:"schema_version"`
+
+	// language runtime of the handler (defaults to PYTHON)
+	Runtime string `json:"runtime"`
+
+	// minor version of Python for PYTHON handlers, e.g. "3.6" (defaults
+	// to DEFAULT_PYTHON_VERSION)
+	Python_version string `json:"python_version"`
+
+	// function invoked for each request, as <module>.<function>, e.g.
+	// "app.handle" (defaults to lambda_func.handler for Python, Ruby
+	// and R, and index.handler for Node.js); for Java and .NET, the module
+	// is a class
+	Entrypoint string `json:"entrypoint"`
+
+	// layers mounted into the sandbox, as "<name>:<version>" (see the
+	// layers package)
+	Layers []string `json:"layers"`
+
+	// if set, route requests with the same session key to the same worker
+	Session_affinity *SessionAffinity `json:"session_affinity"`
+
+	// maximum number of concurrent runs across all workers of the
+	// cluster (0 means unlimited); enforced by workers with a coord_dir,
+	// and refused by others
+	Max_concurrency int `json:"max_concurrency"`
+
+	// if set, shadow a fraction of the traffic to another handler/worker
+	Mirror *Mirror `json:"mirror"`
+
+	// memory configured for the handler, in MB, used to meter usage in
+	// GB-seconds (defaults to DEFAULT_MEMORY_MB)
+	Memory_mb int `json:"memory_mb"`
+
+	// tenant of the handler, set by the worker from its handler_tenants
+	// config rather than read from the metadata, which the handler
+	// controls
+	Tenant string `json:"-"`
+
+	// requests taking longer than this (in milliseconds) are logged with
+	// a breakdown of where the time went (0 disables)
+	Slow_ms int `json:"slow_ms"`
+
+	// if set, the stacks of the sandbox processes of a Python handler are
+	// dumped with py-spy once a request passes slow_ms, if the worker
+	// allows it (allow_profile_slow); not supported for pooled runtimes
+	Profile_slow bool `json:"profile_slow"`
+
+	// exceptions to the hardening of the sandbox, if the worker allows
+	// them
+	Privileges *Privileges `json:"privileges"`
+
+	// AppArmor profile confining the sandbox, which must be loaded on
+	// the worker and allowed by it (defaults to the
+	// default_apparmor_profile of the worker)
+	Apparmor_profile string `json:"apparmor_profile"`
+
+	// SELinux label of the sandbox, as <field>:<value> where field is
+	// user, role, type or level, e.g. "type:container_t", which the
+	// worker must allow (defaults to the default_selinux_label of the
+	// worker)
+	Selinux_label string `json:"selinux_label"`
+
+	// if set, the sandbox gets a writable scratch area, also its TMPDIR,
+	// whose contents never touch the disk in plaintext: SCRATCH_TMPFS
+	// keeps them in memory, SCRATCH_DMCRYPT encrypts them with a key
+	// only the worker (and kernel) ever holds. Meant for regulated data
+	Scratch string `json:"scratch"`
+
+	// size of the scratch area, in MB (defaults to DEFAULT_SCRATCH_MB)
+	Scratch_mb int `json:"scratch_mb"`
+
+	// run the sandbox in syscall audit mode, recording the unusual
+	// syscalls (e.g. connect) it makes; also toggled at runtime through
+	// the admin API
+	Syscall_audit bool `json:"syscall_audit"`
+}
+
+// DEFAULT_PYTHON_VERSION is the Python version of handlers that do not
+// request one, and the one every worker provides.
+const DEFAULT_PYTHON_VERSION = "2.7"
+
+// GO_BINARY is the name of the executable of a Go handler in its code
+// directory, and EXEC_BINARY that of an exec handler.
+const (
+	GO_BINARY   = "handler"
+	EXEC_BINARY = "handler"
+)
+
+// CUSTOM_ENTRYPOINT is the name of the executable a custom runtime handler
+// ships in its code directory to serve requests.
+const CUSTOM_ENTRYPOINT = "bootstrap"
+
+// DEFAULT_MEMORY_MB is the memory of handlers that do not configure one.
+const DEFAULT_MEMORY_MB = 128
+
+// kinds of scratch areas
+const (
+	SCRATCH_TMPFS   = "tmpfs"
+	SCRATCH_DMCRYPT = "dm-crypt"
+)
+
+// DEFAULT_SCRATCH_MB is the size of the scratch area of handlers that ask
+// for one without a size.
+const DEFAULT_SCRATCH_MB = 256
+
+// Default returns the metadata of a handler that does not ship any.
+func Default() *HandlerMeta {
+	return &HandlerMeta{
+		Schema_version: SCHEMA_VERSION,
+		Runtime:        PYTHON,
+		Python_version: DEFAULT_PYTHON_VERSION,
+		Entrypoint:     defaultEntrypoints[PYTHON],
+		Memory_mb:      DEFAULT_MEMORY_MB,
+	}
+}
+
+// Load reads the metadata in a handler's code directory.
+func Load(codeDir string) (*HandlerMeta, error) {
+	m := Default()
+
+	raw, err := ioutil.ReadFile(filepath.Join(codeDir, FILE))
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(raw, m); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", FILE, err)
+	}
+	if err := checkSchema(raw, m); err != nil {
+		return nil, err
+	}
+
+	if m.Runtime == "" {
+		m.Runtime = PYTHON
+	}
+	known := false
+	for _, name := range runtimes {
+		known = known || m.Runtime == name
+	}
+	if !known {
+		return nil, &IncompatibleError{
+			Field:  "runtime",
+			Value:  m.Runtime,
+			Reason: "is not a known runtime (known: " + strings.Join(runtimes, ", ") + ")",
+		}
+	}
+	if m.Runtime == PYTHON && m.Python_version == "" {
+		m.Python_version = DEFAULT_PYTHON_VERSION
+	}
+	if m.Entrypoint != "" {
+		if _, _, err := SplitEntrypoint(m.Entrypoint); err != nil {
+			return nil, err
+		}
+	} else {
+		m.Entrypoint = defaultEntrypoints[m.Runtime]
+	}
+
+	if m.Memory_mb < 0 {
+		return nil, fmt.Errorf("memory_mb cannot be negative, got %v", m.Memory_mb)
+	} else if m.Memory_mb == 0 {
+		m.Memory_mb = DEFAULT_MEMORY_MB
+	}
+
+	if m.Scratch != "" && m.Scratch != SCRATCH_TMPFS && m.Scratch != SCRATCH_DMCRYPT {
+		return nil, &IncompatibleError{
+			Field:  "scratch",
+			Value:  m.Scratch,
+			Reason: fmt.Sprintf("is not a known kind of scratch area (known: %s, %s)", SCRATCH_TMPFS, SCRATCH_DMCRYPT),
+		}
+	}
+	if m.Scratch_mb < 0 {
+		return nil, fmt.Errorf("scratch_mb cannot be negative, got %v", m.Scratch_mb)
+	} else if m.Scratch != "" && m.Scratch_mb == 0 {
+		m.Scratch_mb = DEFAULT_SCRATCH_MB
+	}
+
+	if m.Slow_ms < 0 {
+		return nil, fmt.Errorf("slow_ms cannot be negative, got %v", m.Slow_ms)
+	}
+
+	if m.Mirror != nil && (m.Mirror.Percent < 0 || m.Mirror.Percent > 100) {
+		return nil, fmt.Errorf("mirror percent must be in [0, 100], got %v", m.Mirror.Percent)
+	}
+	if m.Mirror != nil && m.Mirror.Handler == "" && m.Mirror.Worker == "" {
+		return nil, fmt.Errorf("mirror must name the handler or worker receiving the copies")
+	}
+
+	return m, nil
+}
+
+// CheckMirror verifies that the mirror of the named handler, if any, does not
+// copy its requests back to itself on this worker.
+func (m *HandlerMeta) CheckMirror(name string) error {
+	if m.Mirror != nil && m.Mirror.Worker == "" && m.Mirror.Handler == name {
+		return fmt.Errorf("mirror of %s cannot target %s itself on this worker", name, name)
+	}
+	return nil
+}
+
+// checkSchema verifies that the worker understands the raw metadata: that its
+// schema version is not newer than SCHEMA_VERSION and, if it declares one,
+// that it has no fields unknown to the HandlerMeta (which are most likely
+// typos). Metadata predating schema_version may have other fields.
+func checkSchema(raw []byte, m *HandlerMeta) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return fmt.Errorf("could not parse %s: %v", FILE, err)
+	}
+
+	// encoding/json matches names case-insensitively, and so does this
+	declared := false
+	for name := range fields {
+		declared = declared || strings.ToLower(name) == "schema_version"
+	}
+	if !declared {
+		return nil
+	} else if m.Schema_version < 1 || m.Schema_version > SCHEMA_VERSION {
+		return &IncompatibleError{
+			Field:  "schema_version",
+			Value:  fmt.Sprintf("%d", m.Schema_version),
+			Reason: fmt.Sprintf("is not supported by this worker (latest: %d)", SCHEMA_VERSION),
+		}
+	}
+
+	known := make(map[string]bool)
+	t := reflect.TypeOf(*m)
+	for i := 0; i < t.NumField(); i++ {
+		known[strings.ToLower(strings.Split(t.Field(i).Tag.Get("json"), ",")[0])] = true
+	}
+	for name := range fields {
+		if !known[strings.ToLower(name)] {
+			return &IncompatibleError{
+				Field:  name,
+				Reason: fmt.Sprintf("is not part of schema_version %d", m.Schema_version),
+			}
+		}
+	}
+	return nil
+}
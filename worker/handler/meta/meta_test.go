@@ -0,0 +1,89 @@
+package meta
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadIncompatible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		config string
+		field  string // of the expected IncompatibleError, if any
+	}{
+		{`{"runtime": "nodejs"}`, ""},
+		{`{"runtime": "cobol"}`, "runtime"},
+		{`{"schema_version": 2}`, "schema_version"},
+		{`{"schema_version": 1, "Runtime": "nodejs"}`, ""},
+		{`{"schema_version": 1, "runtim": "nodejs"}`, "runtim"},
+		// metadata without a schema_version may carry other fields
+		{`{"debug": true}`, ""},
+		{`{"scratch": "dm-crypt"}`, ""},
+		{`{"scratch": "ramdisk"}`, "scratch"},
+	}
+	for _, c := range cases {
+		ioutil.WriteFile(filepath.Join(dir, FILE), []byte(c.config), 0644)
+		_, err := Load(dir)
+		ie, ok := err.(*IncompatibleError)
+		if c.field == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.config, err)
+		} else if c.field != "" && (!ok || ie.Field != c.field) {
+			t.Errorf("%s: expected IncompatibleError for %s, got %v", c.config, c.field, err)
+		}
+	}
+}
+
+func TestTenantIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the worker decides the tenant of a handler, not the handler
+	ioutil.WriteFile(filepath.Join(dir, FILE), []byte(`{"tenant": "acme"}`), 0644)
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Tenant != "" {
+		t.Fatalf("tenant %q taken from handler metadata", m.Tenant)
+	}
+}
+
+func TestMirrorTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, FILE), []byte(`{"mirror": {"percent": 10}}`), 0644)
+	if _, err := Load(dir); err == nil {
+		t.Fatal("expected mirror without a target to be refused")
+	}
+
+	cases := []struct {
+		mirror Mirror
+		ok     bool
+	}{
+		{Mirror{Handler: "hello-v2"}, true},
+		{Mirror{Worker: "other:8080"}, true},
+		{Mirror{Handler: "hello", Worker: "other:8080"}, true},
+		{Mirror{Handler: "hello"}, false},
+	}
+	for _, c := range cases {
+		mirror := c.mirror
+		m := &HandlerMeta{Mirror: &mirror}
+		if err := m.CheckMirror("hello"); (err == nil) != c.ok {
+			t.Errorf("%+v: unexpected result %v", c.mirror, err)
+		}
+	}
+}
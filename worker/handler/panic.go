@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is returned by RunStart when starting the handler panicked.
+// The bookkeeping of the Handler is repaired before it is returned, so that
+// the next request can start it afresh.
+type PanicError struct {
+	Handler string
+	Value   interface{} // what was passed to panic
+	Stack   []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic while starting handler %s: %v", e.Handler, e.Value)
+}
+
+// repairAfterPanic recovers a panic of runStart, and turns it into a
+// PanicError in *err. What the attempt did to the Handler is undone, given
+// the number of runners and concurrency slots it had before: the slot
+// acquired is released, the runner added is removed, and the sandbox, whose
+// state is unknown, is discarded unless requests are still running in it.
+// It must be deferred by runStart, and the caller must hold the mutex.
+func (h *Handler) repairAfterPanic(runners, slots int, err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+
+	perr := &PanicError{Handler: h.name, Value: p, Stack: debug.Stack()}
+	h.log().WithField("panic", fmt.Sprint(p)).Error("panic while starting handler, repairing it")
+	for len(h.slots) > slots {
+		h.releaseSlot()
+	}
+	h.runners = runners
+	if runners == 0 && h.sandbox != nil {
+		h.discardSandbox()
+	}
+	*err = perr
+}
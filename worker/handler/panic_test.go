@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// panickySandbox panics when asked for its channel.
+type panickySandbox struct {
+	*fakeSandbox
+}
+
+func (s panickySandbox) Channel() (*sb.SandboxChannel, error) {
+	panic("corrupted sandbox")
+}
+
+func TestRunStartPanic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "panic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &config.Config{Worker_dir: dir}
+	hset := NewHandlerSet(HandlerSetOpts{SbFactory: &fakeFactory{}, Config: conf, Lru: NewHandlerLRU(10)})
+	h := hset.Get("hello")
+	now := time.Now()
+	h.lastPull = &now
+	h.meta = meta.Default()
+
+	// a warm start panics after the runner is counted
+	sandbox := &fakeSandbox{id: "1", state: state.Paused}
+	h.sandbox, h.state = panickySandbox{sandbox}, state.Paused
+	hset.lru.Add(h)
+
+	_, _, err = h.RunStart(context.Background())
+	if _, ok := err.(*PanicError); !ok {
+		t.Fatalf("expected a PanicError, got %v", err)
+	}
+	if h.runners != 0 || h.state != state.Stopped || h.sandbox != nil || !sandbox.removed {
+		t.Fatalf("handler not repaired: %d runners, %s, sandbox %v", h.runners, h.state.String(), h.sandbox)
+	}
+
+	// the next request starts cold, and the mutex was released
+	if _, _, err := h.RunStart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if h.runners != 1 {
+		t.Fatalf("expected 1 runner, got %d", h.runners)
+	}
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// ReconcileReport lists what Reconcile did with the state left behind by a
+// previous run of the worker.
+type ReconcileReport struct {
+	Adopted []string `json:"adopted"` // handlers whose sandbox was adopted
+	Removed []string `json:"removed"` // IDs of the sandboxes removed
+	Cleaned []string `json:"cleaned"` // handler directories removed
+	Failed  []string `json:"failed"`  // what could not be cleaned up, and why
+}
+
+// Reconcile brings a new HandlerSet in line with what a previous run of the
+// worker left behind, before it serves requests. A sandbox recorded in the
+// manifest that is still running or paused, and whose code is still in
+// place, is adopted, paused, by its handler, as if it had just finished a
+// request. The other sandboxes of the worker are removed, and so are the
+// directories of handlers without a sandbox.
+//
+// Only sandboxes of a Recoverable factory can be adopted; with others, every
+// handler starts afresh.
+func (h *HandlerSet) Reconcile() (*ReconcileReport, error) {
+	leftovers := map[string]sb.Sandbox{}
+	if r, ok := h.sbFactory.(sb.Recoverable); ok {
+		var err error
+		if leftovers, err = r.Leftovers(); err != nil {
+			// without knowing which sandboxes exist, cleaning up
+			// could pull directories from under live ones
+			return nil, fmt.Errorf("could not list sandboxes: %v", err)
+		}
+	}
+
+	report := &ReconcileReport{}
+	adopted := make(map[string]bool)
+	entries := h.manifest.snapshot()
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entry := entries[name]
+		logger := log.WithFields(log.Fields{logging.HANDLER: name, logging.SANDBOX_ID: entry.Sandbox_id})
+		sandbox := leftovers[entry.Sandbox_id]
+		if sandbox == nil {
+			logger.Info("reconcile: sandbox is gone")
+			h.manifest.remove(name)
+			continue
+		}
+		if err := h.adopt(name, entry, sandbox); err != nil {
+			logger.WithError(err).Info("reconcile: not adopting sandbox")
+			h.manifest.remove(name)
+			continue
+		}
+		logger.Info("reconcile: adopted sandbox")
+		delete(leftovers, entry.Sandbox_id)
+		adopted[name] = true
+		report.Adopted = append(report.Adopted, name)
+	}
+
+	ids := make([]string, 0, len(leftovers))
+	for id := range leftovers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := removeSandbox(leftovers[id]); err != nil {
+			log.WithError(err).WithField(logging.SANDBOX_ID, id).Warn("reconcile: could not remove sandbox")
+			report.Failed = append(report.Failed, fmt.Sprintf("sandbox %s: %v", id, err))
+			continue
+		}
+		log.WithField(logging.SANDBOX_ID, id).Info("reconcile: removed sandbox")
+		report.Removed = append(report.Removed, id)
+	}
+
+	handlersDir := filepath.Join(h.config.Worker_dir, "handlers")
+	dirs, err := ioutil.ReadDir(handlersDir)
+	if err != nil && !os.IsNotExist(err) {
+		report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", handlersDir, err))
+	}
+	for _, fi := range dirs {
+		if adopted[fi.Name()] {
+			continue
+		}
+		dir := filepath.Join(handlersDir, fi.Name())
+		if err := os.RemoveAll(dir); err != nil {
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", dir, err))
+			continue
+		}
+		report.Cleaned = append(report.Cleaned, dir)
+	}
+
+	log.WithFields(log.Fields{
+		"adopted": len(report.Adopted),
+		"removed": len(report.Removed),
+		"cleaned": len(report.Cleaned),
+		"failed":  len(report.Failed),
+	}).Info("reconciled state left by the previous run")
+	return report, nil
+}
+
+// adopt makes sandbox, recorded by entry, the paused sandbox of the named
+// handler, or explains why it cannot be.
+func (h *HandlerSet) adopt(name string, entry ManifestEntry, sandbox sb.Sandbox) error {
+	st, err := sandbox.State()
+	if err != nil {
+		return err
+	} else if st == state.Stopped {
+		return fmt.Errorf("sandbox is stopped")
+	}
+	if _, err := os.Stat(entry.Code_dir); err != nil {
+		return fmt.Errorf("code is gone: %v", err)
+	}
+	m, err := meta.Load(entry.Code_dir)
+	if err != nil {
+		return err
+	}
+	m.Tenant = h.config.TenantOf(name)
+	// it was running a request when the worker went down
+	if st == state.Running {
+		if err := sandbox.Pause(); err != nil {
+			return err
+		}
+	}
+
+	handler := h.Get(name)
+	handler.mutex.Lock()
+	now := time.Now()
+	handler.sandbox = sandbox
+	handler.state = state.Paused
+	handler.lastPull = &now
+	handler.codeDir = entry.Code_dir
+	handler.meta = m
+	handler.created = entry.Created
+	handler.paused = now
+	handler.mutex.Unlock()
+
+	h.lru.Add(handler)
+	return nil
+}
+
+// removeSandbox stops and removes a sandbox in any state.
+func removeSandbox(sandbox sb.Sandbox) error {
+	st, err := sandbox.State()
+	if err != nil {
+		return err
+	}
+	if st == state.Paused {
+		if err := sandbox.Unpause(); err != nil {
+			return err
+		}
+	}
+	if st != state.Stopped {
+		if err := sandbox.Stop(); err != nil {
+			return err
+		}
+	}
+	return sandbox.Remove()
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+type fakeSandbox struct {
+	id         string
+	state      state.HandlerState
+	removed    bool
+	pauseFails bool
+}
+
+func (s *fakeSandbox) ID() string                           { return s.id }
+func (s *fakeSandbox) Start() error                         { s.state = state.Running; return nil }
+func (s *fakeSandbox) Stop() error                          { s.state = state.Stopped; return nil }
+func (s *fakeSandbox) Unpause() error                       { s.state = state.Running; return nil }
+func (s *fakeSandbox) Remove() error                        { s.removed = true; return nil }
+func (s *fakeSandbox) Logs() (string, error)                { return "", nil }
+func (s *fakeSandbox) State() (state.HandlerState, error)   { return s.state, nil }
+func (s *fakeSandbox) Channel() (*sb.SandboxChannel, error) { return &sb.SandboxChannel{}, nil }
+
+func (s *fakeSandbox) Pause() error {
+	if s.pauseFails {
+		return errors.New("pause failed")
+	}
+	s.state = state.Paused
+	return nil
+}
+
+type fakeFactory struct {
+	leftovers map[string]sb.Sandbox
+	failures  int // number of calls to Create that fail
+	created   []*fakeSandbox
+}
+
+func (f *fakeFactory) Create(ctx context.Context, handlerDir, sandboxDir string, m *meta.HandlerMeta) (sb.Sandbox, error) {
+	if f.failures > 0 {
+		f.failures -= 1
+		return nil, errors.New("create failed")
+	}
+	s := &fakeSandbox{id: fmt.Sprint(len(f.created)), state: state.Stopped}
+	f.created = append(f.created, s)
+	return s, nil
+}
+func (f *fakeFactory) Check(m *meta.HandlerMeta) error           { return nil }
+func (f *fakeFactory) Leftovers() (map[string]sb.Sandbox, error) { return f.leftovers, nil }
+
+func TestReconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	code := filepath.Join(dir, "registry", "hello")
+	os.MkdirAll(code, 0755)
+	for _, name := range []string{"hello", "stopped", "stale"} {
+		os.MkdirAll(filepath.Join(dir, "handlers", name, "sandbox"), 0755)
+	}
+
+	// hello survives, stopped has a stopped sandbox, gone has lost its
+	// sandbox, and orphan was never recorded
+	hello := &fakeSandbox{id: "1", state: state.Running}
+	stopped := &fakeSandbox{id: "2", state: state.Stopped}
+	orphan := &fakeSandbox{id: "3", state: state.Paused}
+	m := loadManifest(filepath.Join(dir, MANIFEST_FILE))
+	m.set("hello", ManifestEntry{Sandbox_id: "1", Code_dir: code, Created: time.Now()})
+	m.set("stopped", ManifestEntry{Sandbox_id: "2", Code_dir: code})
+	m.set("gone", ManifestEntry{Sandbox_id: "4", Code_dir: code})
+
+	factory := &fakeFactory{leftovers: map[string]sb.Sandbox{"1": hello, "2": stopped, "3": orphan}}
+	opts := HandlerSetOpts{SbFactory: factory, Config: &config.Config{Worker_dir: dir}, Lru: NewHandlerLRU(10)}
+	hset := NewHandlerSet(opts)
+	report, err := hset.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Adopted) != 1 || report.Adopted[0] != "hello" {
+		t.Fatalf("expected to adopt hello, got %v", report.Adopted)
+	}
+	if h := hset.Get("hello"); h.sandbox != hello || h.state != state.Paused || hello.state != state.Paused {
+		t.Fatalf("hello not adopted paused: %v, %v", h.state, hello.state)
+	}
+	if !stopped.removed || !orphan.removed || hello.removed {
+		t.Fatalf("unexpected removals: hello %v, stopped %v, orphan %v", hello.removed, stopped.removed, orphan.removed)
+	}
+	if len(report.Cleaned) != 2 {
+		t.Fatalf("expected to clean 2 directories, got %v", report.Cleaned)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "handlers", "hello")); err != nil {
+		t.Fatalf("directory of adopted handler removed: %v", err)
+	}
+
+	// only the adopted sandbox is left for the next start
+	entries := loadManifest(filepath.Join(dir, MANIFEST_FILE)).snapshot()
+	if len(entries) != 1 || entries["hello"].Sandbox_id != "1" {
+		t.Fatalf("unexpected manifest %v", entries)
+	}
+}
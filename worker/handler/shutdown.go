@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+// StopSandboxes removes the sandbox of every Handler, for the worker to
+// shut down, and returns how many it removed. With keepIdle, sandboxes that
+// are paused are left in place, still recorded in the manifest, for the
+// next run of the worker to adopt (see Reconcile). Requests still running
+// fail. It stops early once ctx is done.
+func (h *HandlerSet) StopSandboxes(ctx context.Context, keepIdle bool) (int, error) {
+	h.mutex.Lock()
+	handlers := make([]*Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler)
+	}
+	h.mutex.Unlock()
+
+	removed := 0
+	for _, handler := range handlers {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		if handler.stopSandbox(keepIdle) {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// stopSandbox removes the sandbox of the Handler, unless it has none, or it
+// is paused and keepIdle is set. It reports whether a sandbox was removed.
+func (h *Handler) stopSandbox(keepIdle bool) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.sandbox == nil || (keepIdle && h.state == state.Paused && h.runners == 0) {
+		return false
+	}
+	if h.runners > 0 {
+		h.log().WithField("runners", h.runners).Warn("removing sandbox with requests still running")
+	}
+	h.discardSandbox()
+	return true
+}
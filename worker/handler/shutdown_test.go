@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+func TestStopSandboxes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := &config.Config{Worker_dir: dir}
+	for _, keepIdle := range []bool{true, false} {
+		hset := NewHandlerSet(HandlerSetOpts{SbFactory: &fakeFactory{}, Config: conf, Lru: NewHandlerLRU(10)})
+		idle := hset.Get("idle")
+		idleSandbox := &fakeSandbox{id: "1", state: state.Paused}
+		idle.sandbox, idle.state = idleSandbox, state.Paused
+		busy := hset.Get("busy")
+		busySandbox := &fakeSandbox{id: "2", state: state.Running}
+		busy.sandbox, busy.state, busy.runners = busySandbox, state.Running, 1
+		hset.Get("cold")
+
+		removed, err := hset.StopSandboxes(context.Background(), keepIdle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !busySandbox.removed || busy.sandbox != nil {
+			t.Fatal("busy sandbox kept at shutdown")
+		}
+		if idleSandbox.removed == keepIdle {
+			t.Fatalf("keepIdle %v: idle sandbox removed %v", keepIdle, idleSandbox.removed)
+		}
+		if expected := map[bool]int{true: 1, false: 2}[keepIdle]; removed != expected {
+			t.Fatalf("keepIdle %v: expected %d sandboxes removed, got %d", keepIdle, expected, removed)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hset := NewHandlerSet(HandlerSetOpts{SbFactory: &fakeFactory{}, Config: conf, Lru: NewHandlerLRU(10)})
+	h := hset.Get("hello")
+	sandbox := &fakeSandbox{id: "1", state: state.Paused}
+	h.sandbox, h.state = sandbox, state.Paused
+	if _, err := hset.StopSandboxes(ctx, false); err == nil || sandbox.removed {
+		t.Fatal("expected cancelled shutdown to stop early")
+	}
+}
@@ -0,0 +1,107 @@
+// startup package records how long each phase of serving a lambda request
+// takes, so the cost of cold starts can be broken down.
+package startup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+// Phases of serving a request.
+const (
+	PULL       = "pull"       // fetch the handler code from the registry
+	EXTRACT    = "extract"    // unpack the handler code
+	SCAN       = "scan"       // have the scanner vet the handler code
+	BUILD      = "build"      // compile handler code shipped as source
+	CREATE     = "create"     // create the sandbox
+	START      = "start"      // start or unpause the sandbox
+	FORK       = "fork"       // forkenter a pooled interpreter into the sandbox
+	FIRST_BYTE = "first_byte" // forward the request until response headers arrive
+)
+
+// Types of start.
+const (
+	COLD = "cold" // a new sandbox was created
+	WARM = "warm" // a paused sandbox was resumed
+	HOT  = "hot"  // the sandbox was already running
+)
+
+// HEADER carries the type of start of a request on its response.
+const HEADER = "X-OL-Start"
+
+// TIMING_HEADER carries the phases of a request on its response, in the
+// format of the standard Server-Timing header (in milliseconds) that
+// browser tools display.
+const TIMING_HEADER = "Server-Timing"
+
+var phaseSeconds = metrics.NewHistogram(
+	"ol_start_phase_seconds",
+	"Time spent in each phase of serving a request, by start type.",
+	metrics.LATENCY_BUCKETS,
+	"handler", "start", "phase")
+
+// Observe records the time spent in phase since begin.
+func Observe(handler, start, phase string, begin time.Time) {
+	phaseSeconds.Observe(time.Since(begin).Seconds(), handler, start, phase)
+}
+
+// Phase is the time spent in one phase of a request.
+type Phase struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// Timings is the breakdown of one request into phases.
+type Timings struct {
+	Handler string  `json:"handler"`
+	Start   string  `json:"start"`
+	Phases  []Phase `json:"phases"`
+}
+
+// NewTimings creates an empty breakdown for a request to handler.
+func NewTimings(handler string) *Timings {
+	return &Timings{Handler: handler, Start: HOT}
+}
+
+// Observe records the time spent in phase since begin, both in t and in the
+// phase histogram.
+func (t *Timings) Observe(phase string, begin time.Time) {
+	d := time.Since(begin).Seconds()
+	phaseSeconds.Observe(d, t.Handler, t.Start, phase)
+	t.Phases = append(t.Phases, Phase{phase, d})
+}
+
+// Add records the time spent in phase in t only, for phases that lower
+// layers already record in the histogram in more detail.
+func (t *Timings) Add(phase string, begin time.Time) {
+	t.Phases = append(t.Phases, Phase{phase, time.Since(begin).Seconds()})
+}
+
+// ServerTiming formats the phases of t as the value of a TIMING_HEADER.
+func (t *Timings) ServerTiming() string {
+	parts := make([]string, 0, len(t.Phases))
+	for _, p := range t.Phases {
+		parts = append(parts, fmt.Sprintf("%s;dur=%.3f", p.Name, p.Seconds*1000))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseServerTiming returns the phases listed in the value of a
+// TIMING_HEADER, skipping those without a duration.
+func ParseServerTiming(value string) []Phase {
+	phases := []Phase{}
+	for _, entry := range strings.Split(value, ",") {
+		params := strings.Split(strings.TrimSpace(entry), ";")
+		for _, param := range params[1:] {
+			var ms float64
+			if _, err := fmt.Sscanf(strings.TrimSpace(param), "dur=%g", &ms); err == nil {
+				phases = append(phases, Phase{params[0], ms / 1000})
+				break
+			}
+		}
+	}
+	return phases
+}
@@ -0,0 +1,23 @@
+package startup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerTiming(t *testing.T) {
+	timings := &Timings{Handler: "f", Start: COLD, Phases: []Phase{{PULL, 0.5}, {CREATE, 0.25}, {FIRST_BYTE, 0.001}}}
+	value := timings.ServerTiming()
+	if value != "pull;dur=500.000, create;dur=250.000, first_byte;dur=1.000" {
+		t.Fatalf("unexpected header %q", value)
+	}
+	if phases := ParseServerTiming(value); !reflect.DeepEqual(phases, timings.Phases) {
+		t.Fatalf("expected %v, got %v", timings.Phases, phases)
+	}
+
+	// entries without a duration are skipped
+	phases := ParseServerTiming(`cache;desc="hit", db;desc=x;dur=2`)
+	if !reflect.DeepEqual(phases, []Phase{{"db", 0.002}}) {
+		t.Fatalf("unexpected phases %v", phases)
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/sysaudit"
+)
+
+// SyscallAudit is the syscall audit mode of a handler, and the syscalls
+// recorded in its sandboxes.
+type SyscallAudit struct {
+	Enabled bool              `json:"enabled"`
+	Events  []*sysaudit.Event `json:"events"`
+}
+
+// UnknownHandlerError is returned for the syscall audit mode of handlers the
+// worker has not run, so that requests for arbitrary names do not create
+// them.
+type UnknownHandlerError struct {
+	Name string
+}
+
+func (e *UnknownHandlerError) Error() string {
+	return fmt.Sprintf("no handler named %s", e.Name)
+}
+
+// SetSyscallAudit turns the syscall audit mode of the named Handler on or
+// off, overriding its metadata. The mode of a sandbox is set when it is
+// created, so the current sandbox is replaced: at once if it is idle,
+// otherwise once its requests finish. The handler stays available
+// throughout.
+func (h *HandlerSet) SetSyscallAudit(name string, enabled bool) error {
+	handler := h.Lookup(name)
+	if handler == nil {
+		return &UnknownHandlerError{name}
+	}
+
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+
+	if handler.meta != nil {
+		m := *handler.meta
+		m.Syscall_audit = enabled
+		if err := h.sbFactory.Check(&m); err != nil {
+			return err
+		}
+	}
+	handler.audit = &enabled
+
+	if handler.sandbox != nil && handler.audited != enabled {
+		if handler.runners == 0 {
+			handler.discardSandbox()
+		} else {
+			handler.stale = true
+		}
+	}
+	handler.log().WithField("enabled", enabled).Info("set syscall audit mode")
+	return nil
+}
+
+// SyscallAudit returns the syscall audit mode of the named Handler, and the
+// syscalls recorded in its sandboxes so far.
+func (h *HandlerSet) SyscallAudit(name string) (SyscallAudit, error) {
+	handler := h.Lookup(name)
+	if handler == nil {
+		return SyscallAudit{}, &UnknownHandlerError{name}
+	}
+
+	handler.mutex.Lock()
+	enabled := handler.auditMode()
+	handler.mutex.Unlock()
+
+	events := h.recorder.Events(name)
+	if events == nil {
+		events = []*sysaudit.Event{}
+	}
+	return SyscallAudit{Enabled: enabled, Events: events}, nil
+}
+
+// auditMode reports whether new sandboxes of the Handler run in syscall
+// audit mode. The caller must hold the mutex.
+func (h *Handler) auditMode() bool {
+	if h.audit != nil {
+		return *h.audit
+	}
+	return h.meta != nil && h.meta.Syscall_audit
+}
+
+// sandboxMeta returns the metadata to create a sandbox of the Handler with,
+// which differs from its own if its syscall audit mode was set at runtime.
+// The caller must hold the mutex.
+func (h *Handler) sandboxMeta() (*meta.HandlerMeta, error) {
+	enabled := h.auditMode()
+	if enabled == h.meta.Syscall_audit {
+		return h.meta, nil
+	}
+	m := *h.meta
+	m.Syscall_audit = enabled
+	if err := h.hset.sbFactory.Check(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// watchSyscalls has the syscalls of a new sandbox, created with the metadata
+// m, recorded if it runs in syscall audit mode. The caller must hold the
+// mutex.
+func (h *Handler) watchSyscalls(m *meta.HandlerMeta) {
+	h.audited = m.Syscall_audit
+	if h.audited {
+		h.hset.recorder.Watch(h.name, h.sandbox.ID())
+	} else {
+		h.hset.recorder.Unwatch(h.name)
+	}
+}
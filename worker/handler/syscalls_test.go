@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// metaFactory records the metadata its sandboxes are created with, and
+// refuses syscall audit mode if told to.
+type metaFactory struct {
+	fakeFactory
+	metas   []*meta.HandlerMeta
+	noAudit bool
+}
+
+func (f *metaFactory) Create(ctx context.Context, handlerDir, sandboxDir string, m *meta.HandlerMeta) (sb.Sandbox, error) {
+	f.metas = append(f.metas, m)
+	return f.fakeFactory.Create(ctx, handlerDir, sandboxDir, m)
+}
+
+func (f *metaFactory) Check(m *meta.HandlerMeta) error {
+	if m.Syscall_audit && f.noAudit {
+		return &meta.IncompatibleError{Field: "syscall_audit", Value: "true", Reason: "is not supported"}
+	}
+	return nil
+}
+
+func auditEnabled(t *testing.T, hset *HandlerSet, name string) bool {
+	mode, err := hset.SyscallAudit(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mode.Enabled
+}
+
+func TestSetSyscallAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syscalls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	factory := &metaFactory{}
+	conf := &config.Config{Worker_dir: dir}
+	hset := NewHandlerSet(HandlerSetOpts{SbFactory: factory, Config: conf, Lru: NewHandlerLRU(10)})
+	h := hset.Get("hello")
+	now := time.Now()
+	h.lastPull = &now
+	h.meta = meta.Default()
+
+	run := func() {
+		if _, _, err := h.RunStart(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		h.RunFinish()
+	}
+	run()
+	if factory.metas[0].Syscall_audit || auditEnabled(t, hset, "hello") {
+		t.Fatal("sandbox in syscall audit mode without asking")
+	}
+
+	// turning audit mode on replaces the idle sandbox, leaving the
+	// metadata of the handler alone
+	if err := hset.SetSyscallAudit("hello", true); err != nil {
+		t.Fatal(err)
+	}
+	if !factory.created[0].removed || h.sandbox != nil {
+		t.Fatal("idle sandbox not replaced")
+	}
+	run()
+	if !factory.metas[1].Syscall_audit || h.meta.Syscall_audit || !auditEnabled(t, hset, "hello") {
+		t.Fatal("new sandbox not in syscall audit mode")
+	}
+
+	// a busy sandbox is replaced once its requests finish
+	if _, _, err := h.RunStart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := hset.SetSyscallAudit("hello", false); err != nil {
+		t.Fatal(err)
+	}
+	if factory.created[1].removed {
+		t.Fatal("busy sandbox removed")
+	}
+	h.RunFinish()
+	if !factory.created[1].removed {
+		t.Fatal("sandbox not replaced once idle")
+	}
+	run()
+	if factory.metas[2].Syscall_audit {
+		t.Fatal("new sandbox still in syscall audit mode")
+	}
+
+	// handlers that cannot be audited are refused
+	factory.noAudit = true
+	if _, ok := hset.SetSyscallAudit("hello", true).(*meta.IncompatibleError); !ok {
+		t.Fatal("expected IncompatibleError")
+	}
+	if len(factory.created) != 3 || factory.created[2].removed {
+		t.Fatal("sandbox replaced after a refusal")
+	}
+
+	// unknown handlers are not created
+	if _, ok := hset.SetSyscallAudit("nope", true).(*UnknownHandlerError); !ok {
+		t.Fatal("expected UnknownHandlerError")
+	}
+	if _, err := hset.SyscallAudit("nope"); err == nil || hset.Lookup("nope") != nil {
+		t.Fatal("unknown handler created")
+	}
+}
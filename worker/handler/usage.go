@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"sort"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+var (
+	sandboxMemoryBytes = metrics.NewGauge(
+		"ol_sandbox_memory_bytes",
+		"Memory used by the sandbox of a handler.",
+		"handler")
+	sandboxCPUSeconds = metrics.NewGauge(
+		"ol_sandbox_cpu_seconds",
+		"CPU time used by the current sandbox of a handler.",
+		"handler")
+	sandboxPids = metrics.NewGauge(
+		"ol_sandbox_pids",
+		"Number of processes in the sandbox of a handler.",
+		"handler")
+)
+
+// Usage is the latest resource usage sample of the sandbox of a Handler.
+type Usage struct {
+	Handler string `json:"handler"`
+	State   string `json:"state"`
+	sb.Stats
+	CPURate float64   `json:"cpu_rate"` // cores used since the previous sample
+	Sampled time.Time `json:"sampled"`
+}
+
+// Usage orderings for TopUsage.
+const (
+	BY_MEMORY = "memory"
+	BY_CPU    = "cpu"
+)
+
+// CollectUsage samples the resource usage of every running or paused sandbox
+// every interval. It never returns.
+func (h *HandlerSet) CollectUsage(interval time.Duration) {
+	for range time.Tick(interval) {
+		h.mutex.Lock()
+		handlers := make([]*Handler, 0, len(h.handlers))
+		for _, handler := range h.handlers {
+			handlers = append(handlers, handler)
+		}
+		h.mutex.Unlock()
+
+		for _, handler := range handlers {
+			handler.sampleUsage()
+		}
+	}
+}
+
+// sampleUsage records the resource usage of the sandbox of this Handler, or
+// forgets it if the sandbox is not active.
+func (h *Handler) sampleUsage() {
+	h.mutex.Lock()
+	sandbox, ok := h.sandbox.(sb.StatsSandbox)
+	active := h.state == state.Running || h.state == state.Paused
+	hstate := h.state
+	h.mutex.Unlock()
+
+	var stats *sb.Stats
+	var err error
+	if ok && active {
+		stats, err = sandbox.Stats()
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if err != nil {
+		h.log().WithError(err).Debug("could not sample sandbox usage")
+	}
+	if stats == nil {
+		if h.usage != nil {
+			h.usage = nil
+			sandboxMemoryBytes.Delete(h.name)
+			sandboxCPUSeconds.Delete(h.name)
+			sandboxPids.Delete(h.name)
+		}
+		return
+	}
+
+	usage := &Usage{
+		Handler: h.name,
+		State:   hstate.String(),
+		Stats:   *stats,
+		Sampled: time.Now(),
+	}
+	if prev := h.usage; prev != nil && stats.CPUSeconds >= prev.CPUSeconds {
+		usage.CPURate = (stats.CPUSeconds - prev.CPUSeconds) / usage.Sampled.Sub(prev.Sampled).Seconds()
+	}
+	h.usage = usage
+
+	sandboxMemoryBytes.Set(float64(stats.MemoryBytes), h.name)
+	sandboxCPUSeconds.Set(stats.CPUSeconds, h.name)
+	sandboxPids.Set(float64(stats.Pids), h.name)
+}
+
+// TopUsage returns the latest usage of the n heaviest sandboxes, ordered by
+// memory or cpu (see BY_MEMORY and BY_CPU). If n is 0, all are returned.
+func (h *HandlerSet) TopUsage(n int, by string) []Usage {
+	h.mutex.Lock()
+	handlers := make([]*Handler, 0, len(h.handlers))
+	for _, handler := range h.handlers {
+		handlers = append(handlers, handler)
+	}
+	h.mutex.Unlock()
+
+	usages := []Usage{}
+	for _, handler := range handlers {
+		handler.mutex.Lock()
+		if handler.usage != nil {
+			usages = append(usages, *handler.usage)
+		}
+		handler.mutex.Unlock()
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		if by == BY_CPU {
+			return usages[i].CPURate > usages[j].CPURate
+		}
+		return usages[i].MemoryBytes > usages[j].MemoryBytes
+	})
+	if n > 0 && len(usages) > n {
+		usages = usages[:n]
+	}
+	return usages
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+var handlerRepairsTotal = metrics.NewCounter(
+	"ol_handler_repairs_total",
+	"Number of handlers found stuck by the watchdog and reset.",
+	"handler")
+
+// Watchdog checks every interval that the state of each Handler matches its
+// sandbox, and repairs those that do not. It never returns.
+func (h *HandlerSet) Watchdog(interval time.Duration) {
+	for range time.Tick(interval) {
+		h.mutex.Lock()
+		handlers := make([]*Handler, 0, len(h.handlers))
+		for _, handler := range h.handlers {
+			handlers = append(handlers, handler)
+		}
+		h.mutex.Unlock()
+
+		for _, handler := range handlers {
+			handler.checkState()
+		}
+	}
+}
+
+// checkState repairs the Handler if it is stuck in a state that does not
+// match its sandbox, e.g. because an unpause failed halfway. The sandbox is
+// discarded and the Handler reset, so that its next request starts cold
+// with a new sandbox; requests stuck in the old sandbox fail. It reports
+// whether the Handler was repaired.
+func (h *Handler) checkState() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	problem := h.stateProblem()
+	if problem == "" {
+		return false
+	}
+
+	handlerRepairsTotal.Inc(h.name)
+	h.log().WithField("problem", problem).WithField("runners", h.runners).Warn("watchdog resetting stuck handler")
+	if h.sandbox != nil {
+		h.discardSandbox()
+	}
+	h.state = state.Stopped
+	return true
+}
+
+// stateProblem describes how the state of the Handler does not match its
+// sandbox, if it does not. The caller must hold the mutex.
+func (h *Handler) stateProblem() string {
+	if h.sandbox == nil {
+		if h.state != state.Stopped {
+			return fmt.Sprintf("%s without a sandbox", h.state.String())
+		}
+		return ""
+	}
+
+	if h.state == state.Paused && h.runners > 0 {
+		return fmt.Sprintf("paused with %d requests running", h.runners)
+	}
+	if h.state != state.Running && h.state != state.Paused {
+		return "" // stopped by an eviction, restarted on demand
+	}
+
+	actual, err := h.sandbox.State()
+	if err != nil {
+		return fmt.Sprintf("sandbox state unknown: %v", err)
+	} else if actual != h.state {
+		return fmt.Sprintf("%s, but sandbox is %s", h.state.String(), actual.String())
+	}
+	return ""
+}
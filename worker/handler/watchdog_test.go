@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+)
+
+func TestWatchdog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watchdog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := HandlerSetOpts{SbFactory: &fakeFactory{}, Config: &config.Config{Worker_dir: dir}, Lru: NewHandlerLRU(10)}
+	hset := NewHandlerSet(opts)
+
+	for _, c := range []struct {
+		state   state.HandlerState // of the handler
+		actual  state.HandlerState // of its sandbox
+		runners int
+		stuck   bool
+	}{
+		{state: state.Running, actual: state.Running, runners: 1, stuck: false},
+		{state: state.Paused, actual: state.Paused, runners: 0, stuck: false},
+		{state: state.Stopped, actual: state.Stopped, runners: 0, stuck: false},
+		{state: state.Paused, actual: state.Paused, runners: 1, stuck: true},
+		{state: state.Running, actual: state.Stopped, runners: 1, stuck: true},
+		{state: state.Running, actual: state.Paused, runners: 1, stuck: true},
+	} {
+		h := hset.Get("hello")
+		sandbox := &fakeSandbox{id: "1", state: c.actual}
+		h.sandbox, h.state, h.runners = sandbox, c.state, c.runners
+
+		if repaired := h.checkState(); repaired != c.stuck {
+			t.Fatalf("%v handler with %v sandbox and %d runners: expected repaired %v",
+				c.state, c.actual, c.runners, c.stuck)
+		}
+		if c.stuck && (h.sandbox != nil || h.state != state.Stopped || !sandbox.removed) {
+			t.Fatalf("%v handler with %v sandbox not reset", c.state, c.actual)
+		}
+
+		// requests stuck in the discarded sandbox can still finish
+		for h.runners > 0 {
+			h.RunFinish()
+		}
+	}
+}
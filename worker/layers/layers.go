@@ -0,0 +1,312 @@
+// layers package pulls layers: named, versioned read-only bundles (shared
+// libraries, model files, vendored dependencies) that any number of handlers
+// can reference and that are mounted into their sandboxes.
+//
+// A layer is a tar.gz in the layer registry, at <name>/<version>.tar.gz.
+// Versions are immutable, so each is pulled once per worker. Pulled layers
+// are stored by the digest of their content, so identical layers published
+// under several names share one copy on disk.
+package layers
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// MOUNT_DIR is where layers are mounted in sandboxes, each in a directory
+// named after the layer.
+const MOUNT_DIR = "/opt/layers"
+
+// PRUNE_GRACE is how long pulled layers are protected from Prune.
+const PRUNE_GRACE = 10 * time.Minute
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// Layer is a pulled layer.
+type Layer struct {
+	Name    string
+	Version string
+	Digest  string
+	Dir     string // extracted content on the worker
+}
+
+// MountPoint returns where the layer is mounted in sandboxes.
+func (l *Layer) MountPoint() string {
+	return MOUNT_DIR + "/" + l.Name
+}
+
+// Store pulls layers from a registry into a local cache.
+type Store struct {
+	registry string
+	dir      string
+	client   *http.Client
+
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex // per reference, so pulls of different layers run concurrently
+}
+
+// NewStore creates a Store pulling from registry (a directory or an http(s)
+// URL) into dir.
+func NewStore(registry, dir string) *Store {
+	return &Store{
+		registry: registry,
+		dir:      dir,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+		locks:    make(map[string]*sync.Mutex),
+	}
+}
+
+// UseTLS makes the Store present a client certificate to, and verify, an
+// https registry with the given config.
+func (s *Store) UseTLS(conf *tls.Config) {
+	s.client.Transport = &http.Transport{TLSClientConfig: conf}
+}
+
+// ParseRef splits a layer reference of the form <name>:<version>.
+func ParseRef(ref string) (name, version string, err error) {
+	parts := strings.Split(ref, ":")
+	if len(parts) != 2 || !validName.MatchString(parts[0]) || !validName.MatchString(parts[1]) {
+		return "", "", fmt.Errorf("bad layer reference %q, expected <name>:<version>", ref)
+	}
+	return parts[0], parts[1], nil
+}
+
+// Resolve returns the layers of the given references, pulling those that are
+// not cached yet.
+func (s *Store) Resolve(refs []string) ([]*Layer, error) {
+	layers := make([]*Layer, 0, len(refs))
+	names := map[string]bool{}
+	for _, ref := range refs {
+		l, err := s.Get(ref)
+		if err != nil {
+			return nil, err
+		}
+		if names[l.Name] {
+			return nil, fmt.Errorf("layer %s referenced more than once", l.Name)
+		}
+		names[l.Name] = true
+		layers = append(layers, l)
+	}
+	return layers, nil
+}
+
+// Get returns the layer of the given reference, pulling it if needed.
+func (s *Store) Get(ref string) (*Layer, error) {
+	name, version, err := ParseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := s.lock(ref)
+	lock.Lock()
+	defer lock.Unlock()
+
+	refPath := filepath.Join(s.dir, "refs", name, version)
+	if raw, err := ioutil.ReadFile(refPath); err == nil {
+		digest := strings.TrimSpace(string(raw))
+		dir := filepath.Join(s.dir, "blobs", digest)
+		if _, err := os.Stat(dir); err == nil {
+			return &Layer{name, version, digest, dir}, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	begin := time.Now()
+	digest, dir, err := s.pull(name, version)
+	if err != nil {
+		return nil, fmt.Errorf("could not pull layer %s: %v", ref, err)
+	}
+	if err := writeAtomic(refPath, []byte(digest+"\n")); err != nil {
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"layer":   ref,
+		"digest":  digest,
+		"seconds": time.Since(begin).Seconds(),
+	}).Info("pulled layer")
+	return &Layer{name, version, digest, dir}, nil
+}
+
+func (s *Store) lock(ref string) *sync.Mutex {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.locks[ref] == nil {
+		s.locks[ref] = &sync.Mutex{}
+	}
+	return s.locks[ref]
+}
+
+// open returns the tar.gz of a layer from the registry.
+func (s *Store) open(name, version string) (io.ReadCloser, error) {
+	rel := name + "/" + version + ".tar.gz"
+	if !strings.Contains(s.registry, "://") {
+		return os.Open(filepath.Join(s.registry, filepath.FromSlash(rel)))
+	}
+
+	resp, err := s.client.Get(strings.TrimSuffix(s.registry, "/") + "/" + rel)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("registry returned %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// pull downloads a layer, and extracts it unless a layer with the same
+// content is already present. It returns the digest and extracted directory.
+func (s *Store) pull(name, version string) (string, string, error) {
+	tmpDir := filepath.Join(s.dir, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return "", "", err
+	}
+
+	src, err := s.open(name, version)
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	archive, err := ioutil.TempFile(tmpDir, "layer")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, hash), src); err != nil {
+		return "", "", err
+	}
+	digest := "sha256-" + hex.EncodeToString(hash.Sum(nil))
+
+	dir := filepath.Join(s.dir, "blobs", digest)
+	if _, err := os.Stat(dir); err == nil {
+		return digest, dir, nil
+	}
+
+	// extract next to the final location, then rename, so a partially
+	// extracted layer is never used
+	extracted, err := ioutil.TempDir(tmpDir, "extract")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.RemoveAll(extracted)
+	cmd := exec.Command("tar", "-xzf", archive.Name(), "--directory", extracted)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("could not extract: %v: %s", err, output)
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(extracted, dir); err != nil {
+		return "", "", err
+	}
+	os.Chmod(dir, 0755)
+	return digest, dir, nil
+}
+
+// Prune removes the layers not referenced by keep from the cache, to free
+// space, and returns how many it removed. Layers pulled less than
+// PRUNE_GRACE ago are kept, as they may be about to be mounted.
+func (s *Store) Prune(keep []string) (int, error) {
+	kept := make(map[string]bool)
+	for _, ref := range keep {
+		kept[ref] = true
+	}
+
+	refsDir := filepath.Join(s.dir, "refs")
+	names, err := ioutil.ReadDir(refsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	used := make(map[string]bool) // digests of the remaining refs
+	for _, name := range names {
+		versions, err := ioutil.ReadDir(filepath.Join(refsDir, name.Name()))
+		if err != nil {
+			return 0, err
+		}
+		for _, version := range versions {
+			ref := name.Name() + ":" + version.Name()
+			refPath := filepath.Join(refsDir, name.Name(), version.Name())
+			lock := s.lock(ref)
+			lock.Lock()
+			if !kept[ref] && time.Since(version.ModTime()) > PRUNE_GRACE {
+				os.Remove(refPath)
+			} else if raw, err := ioutil.ReadFile(refPath); err == nil {
+				used[strings.TrimSpace(string(raw))] = true
+			}
+			lock.Unlock()
+		}
+	}
+
+	blobsDir := filepath.Join(s.dir, "blobs")
+	blobs, err := ioutil.ReadDir(blobsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	removed := 0
+	for _, blob := range blobs {
+		if used[blob.Name()] || time.Since(blob.ModTime()) <= PRUNE_GRACE {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(blobsDir, blob.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// writeAtomic replaces the file at path with data.
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Env returns the environment that makes the content of layers visible to
+// the runtimes in a sandbox: python/ directories are added to PYTHONPATH,
+// node_modules/ to NODE_PATH, ruby/ to RUBYLIB, R/ to R_LIBS and lib/ to
+// LD_LIBRARY_PATH, in the order of the layers. OL_LAYERS lists the mount points.
+func Env(layers []*Layer) []string {
+	if len(layers) == 0 {
+		return nil
+	}
+	join := func(sub string) string {
+		dirs := make([]string, len(layers))
+		for i, l := range layers {
+			dirs[i] = l.MountPoint() + sub
+		}
+		return strings.Join(dirs, ":")
+	}
+	return []string{
+		"OL_LAYERS=" + join(""),
+		"PYTHONPATH=" + join("/python"),
+		"NODE_PATH=" + join("/node_modules"),
+		"RUBYLIB=" + join("/ruby"),
+		"R_LIBS=" + join("/R"),
+		"LD_LIBRARY_PATH=" + join("/lib"),
+	}
+}
@@ -0,0 +1,137 @@
+package layers
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// publish creates the tar.gz of a layer holding one file.
+func publish(t *testing.T, registry, name, version, content string) {
+	src, err := ioutil.TempDir("", "layer-src")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := ioutil.WriteFile(filepath.Join(src, "data.txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(registry, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// fixed mtime so identical content yields identical archives
+	cmd := exec.Command("tar", "--mtime=2017-01-01", "-czf", filepath.Join(dir, version+".tar.gz"), "-C", src, "data.txt")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+}
+
+func TestStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	registry := filepath.Join(root, "registry")
+	publish(t, registry, "numpy", "1", "numpy")
+
+	s := NewStore(registry, filepath.Join(root, "cache"))
+	l, err := s.Get("numpy:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(l.Dir, "data.txt"))
+	if err != nil || string(data) != "numpy" {
+		t.Fatalf("unexpected layer content %q (%v)", data, err)
+	}
+
+	// cached: removing the registry copy does not matter
+	os.RemoveAll(filepath.Join(registry, "numpy"))
+	if again, err := NewStore(registry, filepath.Join(root, "cache")).Get("numpy:1"); err != nil || again.Dir != l.Dir {
+		t.Fatalf("expected cached layer, got %v (%v)", again, err)
+	}
+
+	if _, err := s.Get("numpy:2"); err == nil {
+		t.Fatal("expected error for missing layer")
+	}
+	if _, err := s.Get("../etc:1"); err == nil {
+		t.Fatal("expected error for bad reference")
+	}
+}
+
+func TestStoreDedup(t *testing.T) {
+	root, err := ioutil.TempDir("", "layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	registry := filepath.Join(root, "registry")
+	publish(t, registry, "a", "1", "same")
+	publish(t, registry, "b", "1", "same")
+
+	s := NewStore(registry, filepath.Join(root, "cache"))
+	layers, err := s.Resolve([]string{"a:1", "b:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layers[0].Dir != layers[1].Dir {
+		t.Fatalf("identical layers not shared: %s, %s", layers[0].Dir, layers[1].Dir)
+	}
+
+	if _, err := s.Resolve([]string{"a:1", "a:1"}); err == nil {
+		t.Fatal("expected error for duplicate layer")
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	root, err := ioutil.TempDir("", "layers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	registry := filepath.Join(root, "registry")
+	publish(t, registry, "numpy", "1", "numpy")
+	publish(t, registry, "pandas", "1", "pandas")
+
+	s := NewStore(registry, filepath.Join(root, "cache"))
+	numpy, err := s.Get("numpy:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pandas, err := s.Get("pandas:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// recently pulled layers are spared
+	if n, err := s.Prune([]string{"numpy:1"}); err != nil || n != 0 {
+		t.Fatalf("expected nothing pruned within the grace period, got %d (%v)", n, err)
+	}
+
+	old := time.Now().Add(-2 * PRUNE_GRACE)
+	for _, path := range []string{
+		numpy.Dir, pandas.Dir,
+		filepath.Join(root, "cache", "refs", "numpy", "1"),
+		filepath.Join(root, "cache", "refs", "pandas", "1"),
+	} {
+		os.Chtimes(path, old, old)
+	}
+	if n, err := s.Prune([]string{"numpy:1"}); err != nil || n != 1 {
+		t.Fatalf("expected 1 layer pruned, got %d (%v)", n, err)
+	}
+	if _, err := os.Stat(numpy.Dir); err != nil {
+		t.Fatalf("kept layer removed: %v", err)
+	}
+	if _, err := os.Stat(pandas.Dir); !os.IsNotExist(err) {
+		t.Fatalf("unused layer kept")
+	}
+
+	// and pulled again when needed
+	if _, err := s.Get("pandas:1"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// fluentdSink sends records to fluentd (or fluent-bit) using the Forward
+// mode of the forward protocol: [tag, [[time, record], ...]], encoded with
+// MessagePack over TCP.
+type fluentdSink struct {
+	addr string
+	tag  string
+	conn net.Conn
+}
+
+func newFluentdSink(conf config.LogSink) *fluentdSink {
+	tag := conf.Tag
+	if tag == "" {
+		tag = "openlambda"
+	}
+	return &fluentdSink{addr: conf.Addr, tag: tag}
+}
+
+func (s *fluentdSink) Send(records []Record) error {
+	entries := make([]interface{}, len(records))
+	now := time.Now().Unix()
+	for i, r := range records {
+		entries[i] = []interface{}{now, map[string]interface{}(r)}
+	}
+
+	var buf bytes.Buffer
+	encodeMsgpack(&buf, []interface{}{s.tag, entries})
+
+	// reconnect once if the connection went stale
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			conn, err := net.DialTimeout("tcp", s.addr, 5*time.Second)
+			if err != nil {
+				return err
+			}
+			s.conn = conn
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := s.conn.Write(buf.Bytes()); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			if attempt == 1 {
+				return err
+			}
+			continue
+		}
+		return nil
+	}
+	return nil
+}
+
+// encodeMsgpack writes v in MessagePack. Only the types that appear in log
+// records are supported natively; anything else is sent as its string form.
+func encodeMsgpack(buf *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case int:
+		encodeInt(buf, int64(v))
+	case int64:
+		encodeInt(buf, v)
+	case float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case string:
+		n := len(v)
+		switch {
+		case n < 32:
+			buf.WriteByte(0xa0 | byte(n))
+		case n < 1<<8:
+			buf.WriteByte(0xd9)
+			buf.WriteByte(byte(n))
+		case n < 1<<16:
+			buf.WriteByte(0xda)
+			binary.Write(buf, binary.BigEndian, uint16(n))
+		default:
+			buf.WriteByte(0xdb)
+			binary.Write(buf, binary.BigEndian, uint32(n))
+		}
+		buf.WriteString(v)
+	case []interface{}:
+		encodeHeader(buf, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			encodeMsgpack(buf, item)
+		}
+	case map[string]interface{}:
+		encodeHeader(buf, len(v), 0x80, 0xde)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encodeMsgpack(buf, k)
+			encodeMsgpack(buf, v[k])
+		}
+	default:
+		encodeMsgpack(buf, fmt.Sprint(v))
+	}
+}
+
+// encodeHeader writes the header of an array or map of n items, given the
+// type byte of its fix and 16/32 bit forms.
+func encodeHeader(buf *bytes.Buffer, n int, fix, wide byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n < 1<<16:
+		buf.WriteByte(wide)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(wide + 1)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func encodeInt(buf *bytes.Buffer, v int64) {
+	switch {
+	case v >= 0 && v < 128:
+		buf.WriteByte(byte(v))
+	case v < 0 && v >= -32:
+		buf.WriteByte(byte(v))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
@@ -0,0 +1,83 @@
+// logging package configures the structured, leveled logger used throughout
+// the worker.
+//
+// Packages import logrus as "log" and attach context with fields, e.g.
+//
+//	log.WithFields(log.Fields{"handler": name, "sandboxID": id}).Info("paused")
+//
+// Common field names are defined here so logs can be filtered consistently.
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	stdlog "log"
+	"net/http"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// Field names shared across the worker.
+const (
+	HANDLER    = "handler"
+	REQUEST_ID = "requestID"
+	SANDBOX_ID = "sandboxID"
+	STATE      = "state"
+	TRACE_ID   = "traceID"
+)
+
+// REQUEST_HEADER carries the request ID from clients to the worker and from
+// the worker on to the sandbox.
+const REQUEST_HEADER = "X-Request-Id"
+
+// Init sets the level and format of the logger according to config. Output of
+// the standard library logger is routed through it as well, so libraries
+// that still use "log" produce lines in the same format. Recent lines are
+// kept for Recent, and all lines are forwarded to the configured sinks.
+func Init(conf *config.Config) error {
+	level, err := log.ParseLevel(conf.Log_level)
+	if err != nil {
+		return err
+	}
+
+	var formatter log.Formatter
+	switch conf.Log_format {
+	case "text":
+		formatter = &log.TextFormatter{FullTimestamp: true}
+	case "json":
+		formatter = &log.JSONFormatter{}
+	default:
+		return fmt.Errorf("invalid 'log_format' field in config: %v", conf.Log_format)
+	}
+
+	log.SetOutput(os.Stderr)
+	log.SetLevel(level)
+	log.SetFormatter(formatter)
+	log.AddHook(recent)
+	if err := startSinks(conf); err != nil {
+		return err
+	}
+
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.StandardLogger().Writer())
+	return nil
+}
+
+// RequestId returns the ID of r, taken from REQUEST_HEADER if the client
+// supplied one. Otherwise a random ID is generated and stored in the header
+// so it can be forwarded.
+func RequestId(r *http.Request) string {
+	if id := r.Header.Get(REQUEST_HEADER); id != "" {
+		return id
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return ""
+	}
+	id := hex.EncodeToString(buf)
+	r.Header.Set(REQUEST_HEADER, id)
+	return id
+}
@@ -0,0 +1,303 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+const (
+	// SINK_BUFFER is the number of records queued per sink; when it is
+	// full, new records are dropped rather than blocking the caller.
+	SINK_BUFFER = 4096
+
+	// records are sent in batches of up to SINK_BATCH, at least every
+	// SINK_FLUSH
+	SINK_BATCH = 256
+	SINK_FLUSH = time.Second
+)
+
+// Sources of forwarded records.
+const (
+	WORKER_SOURCE  = "worker"
+	HANDLER_SOURCE = "handler"
+)
+
+var sinkDroppedTotal = metrics.NewCounter(
+	"ol_log_sink_dropped_total",
+	"Number of log records dropped because a sink was slow or failing.",
+	"sink")
+
+// Record is a log line forwarded to sinks.
+type Record map[string]interface{}
+
+// Sink delivers batches of records to an external system.
+type Sink interface {
+	Send(records []Record) error
+}
+
+// forwarder buffers records for one sink and delivers them in the
+// background.
+type forwarder struct {
+	name  string
+	sink  Sink
+	queue chan Record
+	flush chan chan struct{}
+}
+
+var forwarders []*forwarder
+
+// newSink creates the sink described by conf.
+func newSink(conf config.LogSink) (Sink, error) {
+	switch conf.Type {
+	case "syslog":
+		return newSyslogSink(conf)
+	case "fluentd":
+		return newFluentdSink(conf), nil
+	case "http":
+		return &httpSink{url: conf.Addr, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	default:
+		return nil, fmt.Errorf("unknown log sink type %q", conf.Type)
+	}
+}
+
+// startSinks starts forwarding to every sink of the config.
+func startSinks(conf *config.Config) error {
+	for _, sc := range conf.Log_sinks {
+		sink, err := newSink(sc)
+		if err != nil {
+			return err
+		}
+		f := &forwarder{
+			name:  fmt.Sprintf("%s:%s", sc.Type, sc.Addr),
+			sink:  sink,
+			queue: make(chan Record, SINK_BUFFER),
+			flush: make(chan chan struct{}),
+		}
+		forwarders = append(forwarders, f)
+		go f.run()
+	}
+	if len(forwarders) > 0 {
+		log.AddHook(sinkHook{})
+	}
+	return nil
+}
+
+// enqueue hands a record to every forwarder without blocking.
+func enqueue(r Record) {
+	for _, f := range forwarders {
+		select {
+		case f.queue <- r:
+		default:
+			sinkDroppedTotal.Inc(f.name)
+		}
+	}
+}
+
+// run sends queued records in batches until the process exits.
+func (f *forwarder) run() {
+	batch := []Record{}
+	ticker := time.NewTicker(SINK_FLUSH)
+	defer ticker.Stop()
+
+	for {
+		var flushed chan struct{}
+		select {
+		case r := <-f.queue:
+			batch = append(batch, r)
+			if len(batch) < SINK_BATCH {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case flushed = <-f.flush:
+			batch = append(batch, f.drain()...)
+		}
+
+		// failures are counted, not logged, as logging would
+		// feed back into the failing sink
+		if len(batch) > 0 {
+			if err := f.sink.Send(batch); err != nil {
+				sinkDroppedTotal.Add(float64(len(batch)), f.name)
+			}
+		}
+		batch = []Record{}
+		if flushed != nil {
+			close(flushed)
+		}
+	}
+}
+
+// drain takes every record queued so far.
+func (f *forwarder) drain() []Record {
+	records := []Record{}
+	for {
+		select {
+		case r := <-f.queue:
+			records = append(records, r)
+		default:
+			return records
+		}
+	}
+}
+
+// Flush sends the records queued for every sink, waiting up to timeout for
+// them to be delivered. It is called before the worker exits, so that the
+// last records are not lost.
+func Flush(timeout time.Duration) {
+	deadline := time.After(timeout)
+	for _, f := range forwarders {
+		flushed := make(chan struct{})
+		select {
+		case f.flush <- flushed:
+		case <-deadline:
+			return
+		}
+		select {
+		case <-flushed:
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// sinkHook forwards worker log entries to the sinks.
+type sinkHook struct{}
+
+func (sinkHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (sinkHook) Fire(entry *log.Entry) error {
+	r := Record{}
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		r[k] = v
+	}
+	r["time"] = entry.Time.UTC().Format(time.RFC3339Nano)
+	r["level"] = entry.Level.String()
+	r["msg"] = entry.Message
+	r["source"] = WORKER_SOURCE
+	enqueue(r)
+	return nil
+}
+
+// ForwardingHandlerOutput returns true if captured handler output should be
+// passed to ForwardHandlerOutput.
+func ForwardingHandlerOutput() bool {
+	return len(forwarders) > 0
+}
+
+// ForwardHandlerOutput sends the output a handler produced while serving a
+// request to the sinks.
+func ForwardHandlerOutput(handler, requestId, output string) {
+	enqueue(Record{
+		"time":     time.Now().UTC().Format(time.RFC3339Nano),
+		"source":   HANDLER_SOURCE,
+		HANDLER:    handler,
+		REQUEST_ID: requestId,
+		"msg":      output,
+	})
+}
+
+// syslogSink writes records as JSON messages to a syslog daemon.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// newSyslogSink connects to the daemon at conf.Addr (e.g., udp://host:514),
+// or the local one if the address is empty.
+func newSyslogSink(conf config.LogSink) (*syslogSink, error) {
+	network, addr := "", ""
+	if conf.Addr != "" {
+		var err error
+		if network, addr, err = splitAddr(conf.Addr); err != nil {
+			return nil, err
+		}
+	}
+	tag := conf.Tag
+	if tag == "" {
+		tag = "openlambda"
+	}
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Send(records []Record) error {
+	for _, r := range records {
+		line, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		switch r["level"] {
+		case "panic", "fatal", "error":
+			err = s.w.Err(string(line))
+		case "warning":
+			err = s.w.Warning(string(line))
+		case "debug":
+			err = s.w.Debug(string(line))
+		default:
+			err = s.w.Info(string(line))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// httpSink POSTs batches as newline-delimited JSON.
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpSink) Send(records []Record) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	resp, err := s.client.Post(s.url, "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("log sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+// splitAddr splits "network://address" into its parts; a bare address is
+// assumed to be TCP.
+func splitAddr(s string) (network, addr string, err error) {
+	if i := strings.Index(s, "://"); i >= 0 {
+		network, addr = s[:i], s[i+3:]
+	} else {
+		network, addr = "tcp", s
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", fmt.Errorf("invalid log sink address %q: %v", s, err)
+	}
+	return network, addr, nil
+}
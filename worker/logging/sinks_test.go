@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+func TestMsgpack(t *testing.T) {
+	var buf bytes.Buffer
+	encodeMsgpack(&buf, []interface{}{"ol", map[string]interface{}{"a": 1, "b": true, "c": nil}})
+
+	expected := []byte{
+		0x92,           // array of 2
+		0xa2, 'o', 'l', // "ol"
+		0x83,            // map of 3
+		0xa1, 'a', 0x01, // "a": 1
+		0xa1, 'b', 0xc3, // "b": true
+		0xa1, 'c', 0xc0, // "c": nil
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("unexpected encoding % x", buf.Bytes())
+	}
+}
+
+func TestHTTPSink(t *testing.T) {
+	received := make(chan Record, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var rec Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				t.Error(err)
+			}
+			received <- rec
+		}
+	}))
+	defer ts.Close()
+
+	defer func() { forwarders = nil }()
+	if err := startSinks(&config.Config{Log_sinks: []config.LogSink{{Type: "http", Addr: ts.URL}}}); err != nil {
+		t.Fatal(err)
+	}
+	ForwardHandlerOutput("hello", "abc", "hi there\n")
+
+	select {
+	case rec := <-received:
+		if rec[HANDLER] != "hello" || rec[REQUEST_ID] != "abc" || rec["source"] != HANDLER_SOURCE {
+			t.Fatalf("unexpected record %v", rec)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("record not delivered")
+	}
+}
+
+func TestFullQueueDrops(t *testing.T) {
+	f := &forwarder{name: "test", queue: make(chan Record, 1)}
+	forwarders = []*forwarder{f}
+	defer func() { forwarders = nil }()
+
+	// nothing drains the queue, so the second record must be dropped
+	// rather than block
+	done := make(chan struct{})
+	go func() {
+		enqueue(Record{"msg": "1"})
+		enqueue(Record{"msg": "2"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("enqueue blocked on a full queue")
+	}
+	if len(f.queue) != 1 {
+		t.Fatalf("expected 1 queued record, got %d", len(f.queue))
+	}
+}
+
+// chanSink hands every batch sent to it to a channel.
+type chanSink chan []Record
+
+func (s chanSink) Send(records []Record) error {
+	s <- records
+	return nil
+}
+
+func TestFlush(t *testing.T) {
+	sink := make(chanSink, 10)
+	f := &forwarder{name: "test", sink: sink, queue: make(chan Record, 10), flush: make(chan chan struct{})}
+	forwarders = []*forwarder{f}
+	defer func() { forwarders = nil }()
+	go f.run()
+
+	enqueue(Record{"msg": "last words"})
+	Flush(5 * time.Second)
+	select {
+	case batch := <-sink:
+		if len(batch) != 1 || batch[0]["msg"] != "last words" {
+			t.Fatalf("unexpected batch %v", batch)
+		}
+	default:
+		t.Fatal("record not delivered by Flush")
+	}
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TAIL_SIZE is the number of recent log lines kept in memory.
+const TAIL_SIZE = 500
+
+// tail is a logrus hook that keeps the most recent log lines in memory, so
+// they can be shown without access to the worker's output.
+type tail struct {
+	mutex sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+var recent = &tail{lines: make([]string, TAIL_SIZE)}
+
+func (t *tail) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (t *tail) Fire(entry *log.Entry) error {
+	line, err := entry.String()
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+	if t.next == 0 {
+		t.full = true
+	}
+	return nil
+}
+
+// Recent returns up to n of the most recent log lines, oldest first.
+func Recent(n int) []string {
+	recent.mutex.Lock()
+	defer recent.mutex.Unlock()
+
+	lines := append([]string{}, recent.lines[:recent.next]...)
+	if recent.full {
+		lines = append(append([]string{}, recent.lines[recent.next:]...), lines...)
+	}
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
@@ -0,0 +1,361 @@
+// metrics package implements a small registry of labeled counters, gauges and
+// histograms that can be rendered in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of named metrics.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics map[string]metric
+}
+
+// metric is implemented by all metric types kept in a Registry.
+type metric interface {
+	write(w io.Writer)
+	points() []point
+}
+
+// point is the value of one series of a metric, for pushing to systems that
+// do not scrape the text format.
+type point struct {
+	name        string
+	labels      []string
+	labelValues []string
+	value       float64
+	counter     bool // cumulative, rather than a level
+}
+
+// Default is the registry used by the package-level constructors.
+var Default = NewRegistry()
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// register adds a metric to the registry. It is an error to register two
+// metrics with the same name.
+func (r *Registry) register(name string, m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if _, ok := r.metrics[name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.metrics[name] = m
+}
+
+// sorted returns the metrics of the registry, sorted by name.
+func (r *Registry) sorted() []metric {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ms := make([]metric, len(names))
+	for i, name := range names {
+		ms[i] = r.metrics[name]
+	}
+	return ms
+}
+
+// WriteText renders all metrics of the registry, sorted by name.
+func (r *Registry) WriteText(w io.Writer) {
+	for _, m := range r.sorted() {
+		m.write(w)
+	}
+}
+
+// points returns the current value of every series of the registry.
+func (r *Registry) points() []point {
+	points := []point{}
+	for _, m := range r.sorted() {
+		points = append(points, m.points()...)
+	}
+	return points
+}
+
+// ServeHTTP writes the metrics of the registry as a Prometheus scrape target.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+// sample is the value of a metric for one combination of label values.
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+// vec is the common part of all labeled metrics.
+type vec struct {
+	mutex   sync.Mutex
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	samples map[string]*sample
+}
+
+func newVec(name, help, typ string, labels []string) *vec {
+	return &vec{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		samples: make(map[string]*sample),
+	}
+}
+
+// get returns the sample for the given label values, creating it if
+// necessary. The caller must hold the mutex.
+func (v *vec) get(labelValues []string) *sample {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s := v.samples[key]
+	if s == nil {
+		s = &sample{labelValues: append([]string{}, labelValues...)}
+		v.samples[key] = s
+	}
+	return s
+}
+
+func (v *vec) add(delta float64, labelValues []string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.get(labelValues).value += delta
+}
+
+func (v *vec) set(value float64, labelValues []string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.get(labelValues).value = value
+}
+
+// Delete removes the sample for the given label values, e.g., once the thing
+// it describes is gone.
+func (v *vec) Delete(labelValues ...string) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	delete(v.samples, strings.Join(labelValues, "\xff"))
+}
+
+// sortedSamples returns the samples ordered by their label values. The caller
+// must hold the mutex.
+func (v *vec) sortedSamples() []*sample {
+	keys := make([]string, 0, len(v.samples))
+	for key := range v.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	samples := make([]*sample, len(keys))
+	for i, key := range keys {
+		samples[i] = v.samples[key]
+	}
+	return samples
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", v.name, v.typ)
+	for _, s := range v.sortedSamples() {
+		fmt.Fprintf(w, "%s%s %v\n", v.name, formatLabels(v.labels, s.labelValues), s.value)
+	}
+}
+
+func (v *vec) points() []point {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	points := []point{}
+	for _, s := range v.sortedSamples() {
+		points = append(points, point{v.name, v.labels, s.labelValues, s.value, v.typ == "counter"})
+	}
+	return points
+}
+
+// formatLabels renders label pairs as {k1="v1",k2="v2"}, or an empty string
+// if there are no labels.
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, "%s=%q", name, values[i])
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	*vec
+}
+
+// NewCounter creates a Counter and registers it with the registry.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newVec(name, help, "counter", labels)}
+	r.register(name, c)
+	return c
+}
+
+// NewCounter creates a Counter in the Default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return Default.NewCounter(name, help, labels...)
+}
+
+// Inc increments the counter for the given label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+// Add increments the counter for the given label values by delta, which must
+// not be negative.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("counter cannot decrease")
+	}
+	c.add(delta, labelValues)
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	*vec
+}
+
+// NewGauge creates a Gauge and registers it with the registry.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newVec(name, help, "gauge", labels)}
+	r.register(name, g)
+	return g
+}
+
+// NewGauge creates a Gauge in the Default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return Default.NewGauge(name, help, labels...)
+}
+
+// Set sets the gauge for the given label values.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+// Add adds delta (possibly negative) to the gauge for the given label values.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+// LATENCY_BUCKETS are histogram bucket bounds, in seconds, suited to request
+// and start-up latencies.
+var LATENCY_BUCKETS = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+// histSample holds the observations of a Histogram for one combination of
+// label values.
+type histSample struct {
+	counts []uint64 // cumulative count per bucket
+	count  uint64
+	sum    float64
+}
+
+// Histogram counts observations in buckets of configurable upper bounds.
+type Histogram struct {
+	*vec
+	buckets []float64
+	hists   map[string]*histSample
+}
+
+// NewHistogram creates a Histogram with the given upper bucket bounds, which
+// must be sorted in increasing order, and registers it with the registry.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		vec:     newVec(name, help, "histogram", labels),
+		buckets: buckets,
+		hists:   make(map[string]*histSample),
+	}
+	r.register(name, h)
+	return h
+}
+
+// NewHistogram creates a Histogram in the Default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labels...)
+}
+
+// Observe adds value to the histogram for the given label values.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// the sample of the vec only tracks which label values are in use
+	key := strings.Join(h.get(labelValues).labelValues, "\xff")
+	hs := h.hists[key]
+	if hs == nil {
+		hs = &histSample{counts: make([]uint64, len(h.buckets))}
+		h.hists[key] = hs
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			hs.counts[i]++
+		}
+	}
+	hs.count++
+	hs.sum += value
+}
+
+// points of a Histogram are its count and sum; buckets are not pushed.
+func (h *Histogram) points() []point {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	points := []point{}
+	for _, s := range h.sortedSamples() {
+		hs := h.hists[strings.Join(s.labelValues, "\xff")]
+		points = append(points,
+			point{h.name + "_count", h.labels, s.labelValues, float64(hs.count), true},
+			point{h.name + "_sum", h.labels, s.labelValues, hs.sum, true})
+	}
+	return points
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+	names := append(append([]string{}, h.labels...), "le")
+	for _, s := range h.sortedSamples() {
+		hs := h.hists[strings.Join(s.labelValues, "\xff")]
+		values := append(append([]string{}, s.labelValues...), "")
+		for i, bound := range h.buckets {
+			values[len(values)-1] = fmt.Sprintf("%v", bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, values), hs.counts[i])
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(names, values), hs.count)
+		fmt.Fprintf(w, "%s_sum%s %v\n", h.name, formatLabels(h.labels, s.labelValues), hs.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues), hs.count)
+	}
+}
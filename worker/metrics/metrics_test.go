@@ -0,0 +1,174 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCounterText(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("ol_test_total", "A test counter.", "handler")
+	c.Inc("b")
+	c.Inc("a")
+	c.Add(2, "a")
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+
+	expected := strings.Join([]string{
+		"# HELP ol_test_total A test counter.",
+		"# TYPE ol_test_total counter",
+		`ol_test_total{handler="a"} 3`,
+		`ol_test_total{handler="b"} 1`,
+		"",
+	}, "\n")
+	if buf.String() != expected {
+		t.Fatalf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestGaugeNoLabels(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGauge("ol_test_gauge", "A test gauge.")
+	g.Set(5)
+	g.Add(-2)
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+	if !strings.Contains(buf.String(), "ol_test_gauge 3\n") {
+		t.Fatalf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestDoubleRegister(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("ol_dup", "dup")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic on double register")
+		}
+	}()
+	r.NewGauge("ol_dup", "dup")
+}
+
+func TestHistogramText(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogram("ol_test_seconds", "A test histogram.", []float64{0.1, 1}, "phase")
+	h.Observe(0.05, "pull")
+	h.Observe(0.5, "pull")
+	h.Observe(2, "pull")
+
+	var buf bytes.Buffer
+	r.WriteText(&buf)
+
+	expected := strings.Join([]string{
+		"# HELP ol_test_seconds A test histogram.",
+		"# TYPE ol_test_seconds histogram",
+		`ol_test_seconds_bucket{phase="pull",le="0.1"} 1`,
+		`ol_test_seconds_bucket{phase="pull",le="1"} 2`,
+		`ol_test_seconds_bucket{phase="pull",le="+Inf"} 3`,
+		`ol_test_seconds_sum{phase="pull"} 2.55`,
+		`ol_test_seconds_count{phase="pull"} 3`,
+		"",
+	}, "\n")
+	if buf.String() != expected {
+		t.Fatalf("Unexpected output:\n%s", buf.String())
+	}
+}
+
+func TestStatsdPush(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := NewRegistry()
+	c := r.NewCounter("ol_test_total", "A test counter.", "handler")
+	g := r.NewGauge("ol_test_gauge", "A test gauge.")
+	c.Add(3, "a.b")
+	c.Add(2, "x,y|z#")
+	g.Set(7)
+
+	dog, err := r.NewStatsdPusher(conn.LocalAddr().String(), "ol", []string{"env:test"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := r.NewStatsdPusher(conn.LocalAddr().String(), "ol", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() string {
+		buf := make([]byte, MAX_PACKET)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	if err := dog.Push(); err != nil {
+		t.Fatal(err)
+	}
+	// label values cannot break the tags
+	expected := "ol.ol_test_gauge:7|g|#env:test\nol.ol_test_total:3|c|#env:test,handler:a_b\nol.ol_test_total:2|c|#env:test,handler:x_y_z_"
+	if got := read(); got != expected {
+		t.Fatalf("unexpected dogstatsd packet:\n%s", got)
+	}
+
+	// counters are sent as deltas
+	c.Inc("a.b")
+	if err := dog.Push(); err != nil {
+		t.Fatal(err)
+	}
+	expected = "ol.ol_test_gauge:7|g|#env:test\nol.ol_test_total:1|c|#env:test,handler:a_b"
+	if got := read(); got != expected {
+		t.Fatalf("unexpected dogstatsd packet:\n%s", got)
+	}
+
+	if err := plain.Push(); err != nil {
+		t.Fatal(err)
+	}
+	expected = "ol.ol_test_gauge:7|g\nol.ol_test_total.a_b:4|c\nol.ol_test_total.x_y_z_:2|c"
+	if got := read(); got != expected {
+		t.Fatalf("unexpected statsd packet:\n%s", got)
+	}
+}
+
+func TestStatsdRunStops(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := NewRegistry()
+	c := r.NewCounter("ol_test_total", "A test counter.", "handler")
+	p, err := r.NewStatsdPusher(conn.LocalAddr().String(), "ol", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a final push may overlap with those of Run
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		p.Run(time.Millisecond, stop)
+		close(done)
+	}()
+	for i := 0; i < 100; i++ {
+		c.Inc("a")
+		p.Push()
+	}
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not stop")
+	}
+}
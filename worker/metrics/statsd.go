@@ -0,0 +1,149 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// MAX_PACKET is the largest UDP payload sent to statsd, small enough to
+// avoid fragmentation on common networks.
+const MAX_PACKET = 1432
+
+// StatsdPusher periodically sends the metrics of a registry to a statsd or
+// DogStatsD server. Counters are sent as the increase since the previous
+// push, gauges as their current value.
+//
+// DogStatsD receives labels and the configured tags as tags. Plain statsd
+// has no tags, so label values are appended to the metric name instead
+// (e.g., prefix.ol_invocations_total.hello).
+type StatsdPusher struct {
+	registry  *Registry
+	conn      net.Conn
+	prefix    string
+	tags      []string
+	dogstatsd bool
+
+	// held by Push, which the final flush of the worker may call while
+	// Run is pushing
+	mutex sync.Mutex
+	last  map[string]float64
+}
+
+// NewStatsdPusher creates a pusher sending the metrics of r to addr
+// (host:port) over UDP.
+func (r *Registry) NewStatsdPusher(addr, prefix string, tags []string, dogstatsd bool) (*StatsdPusher, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &StatsdPusher{
+		registry:  r,
+		conn:      conn,
+		prefix:    prefix,
+		tags:      tags,
+		dogstatsd: dogstatsd,
+		last:      make(map[string]float64),
+	}, nil
+}
+
+// Run pushes the metrics every interval, until stop is closed.
+func (p *StatsdPusher) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.Push(); err != nil {
+				log.WithError(err).Warn("could not push metrics to statsd")
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Push sends the current metrics once.
+func (p *StatsdPusher) Push() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var packet bytes.Buffer
+	for _, pt := range p.registry.points() {
+		line := p.format(pt)
+		if line == "" {
+			continue
+		}
+		if packet.Len() > 0 && packet.Len()+1+len(line) > MAX_PACKET {
+			if _, err := p.conn.Write(packet.Bytes()); err != nil {
+				return err
+			}
+			packet.Reset()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	if packet.Len() > 0 {
+		if _, err := p.conn.Write(packet.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// format renders a point as a statsd line, or returns an empty string if a
+// counter did not change since the previous push.
+func (p *StatsdPusher) format(pt point) string {
+	name := p.prefix + pt.name
+	if !p.dogstatsd {
+		for _, v := range pt.labelValues {
+			name += "." + sanitize(v)
+		}
+	}
+
+	value, typ := pt.value, "g"
+	if pt.counter {
+		key := pt.name + "\xff" + strings.Join(pt.labelValues, "\xff")
+		value = pt.value - p.last[key]
+		p.last[key] = pt.value
+		if value == 0 {
+			return ""
+		}
+		typ = "c"
+	}
+
+	line := fmt.Sprintf("%s:%v|%s", name, value, typ)
+	if p.dogstatsd {
+		tags := append([]string{}, p.tags...)
+		for i, label := range pt.labels {
+			tags = append(tags, label+":"+sanitize(pt.labelValues[i]))
+		}
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+	}
+	return line
+}
+
+// sanitize makes s safe to use as a component of a dotted statsd name, or as
+// a DogStatsD tag value.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', ':', '|', '@', '#', ',', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -13,125 +13,311 @@ Handler code is mapped into the container by attaching a directory
 */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/open-lambda/open-lambda/worker/config"
 	dutil "github.com/open-lambda/open-lambda/worker/dockerutil"
+	"github.com/open-lambda/open-lambda/worker/faults"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/logging"
 	sb "github.com/open-lambda/open-lambda/worker/sandbox"
 )
 
+const (
+	// HEALTH_INTERVAL is the time between health checks of the pools.
+	HEALTH_INTERVAL = 10 * time.Second
+
+	// FORKSERVER_TIMEOUT is how long forkservers may take to listen
+	// after their pool is started; preloading libraries can be slow.
+	FORKSERVER_TIMEOUT = 30 * time.Second
+)
+
+// BasicManager runs a pool of forkservers per pooled Runtime: one per Python
+// version, and one per other pooled runtime (Ruby and R).
 type BasicManager struct {
+	pools      map[string]*pool // by Runtime name
+	versions   []string
+	numServers int
+	stop       chan struct{}
+}
+
+// pool is the container of forkservers of a Runtime. It is replaced when
+// it fails its health check.
+type pool struct {
+	rt  Runtime
+	dir string
+
+	mutex   sync.Mutex
 	servers []*ForkServer
-	poolDir string
 	cid     string
+	stopped bool
 }
 
 func NewBasicManager(opts *config.Config) (bm *BasicManager, err error) {
-	poolDir := opts.Pool_dir
-	numServers := opts.Num_forkservers
-
-	cid, err := initPoolContainer(poolDir, opts.Cluster_name, numServers)
+	client, err := docker.NewClientFromEnv()
 	if err != nil {
 		return nil, err
 	}
 
-	servers := make([]*ForkServer, numServers, numServers)
-	for k := 0; k < numServers; k++ {
-		sockPath := fmt.Sprintf("%s/fs%d/fs.sock", poolDir, k)
-
-		start := time.Now()
-		// wait up to 5s for server to initialize
-		for os.IsNotExist(err) {
-			_, err = os.Stat(sockPath)
-			if time.Since(start).Seconds() > 5 {
-				return nil, errors.New("forkservers failed to initialize")
-			}
+	bm = &BasicManager{
+		pools:      make(map[string]*pool),
+		versions:   sb.PythonVersions(opts),
+		numServers: opts.Num_forkservers,
+		stop:       make(chan struct{}),
+	}
+
+	for _, p := range pools(opts, client) {
+		if err := p.start(bm.numServers); err != nil {
+			return nil, fmt.Errorf("could not start %s pool: %v", p.rt.Name(), err)
 		}
+		bm.pools[p.rt.Name()] = p
+	}
 
-		servers[k] = &ForkServer{
-			sockPath: sockPath,
-			packages: []string{},
+	go bm.monitor()
+
+	return bm, nil
+}
+
+// pools returns the (unstarted) pools of the runtimes pooled according to the
+// config.
+func pools(opts *config.Config, client *docker.Client) (ps []*pool) {
+	caps, securityOpt, forkEnv := sb.PoolPrivileges(opts)
+	add := func(name, dir, image string, cmd, env []string) {
+		rt := &dockerRuntime{
+			name:        name,
+			image:       image,
+			cmd:         cmd,
+			env:         append(append([]string{}, env...), forkEnv...),
+			caps:        caps,
+			securityOpt: securityOpt,
+			clusterName: opts.Cluster_name,
+			client:      client,
 		}
+		ps = append(ps, &pool{rt: rt, dir: dir})
 	}
 
-	bm = &BasicManager{
-		servers: servers,
-		cid:     cid,
+	if sb.Pooled(opts, meta.PYTHON) {
+		for _, version := range sb.PythonVersions(opts) {
+			// the default version keeps the pool directory to itself,
+			// as before multiple versions were supported
+			dir := opts.Pool_dir
+			if version != meta.DEFAULT_PYTHON_VERSION {
+				dir = filepath.Join(opts.Pool_dir, "python"+version)
+			}
+			// PythonVersions only returns available versions
+			image, _ := sb.PythonImage(opts, dutil.POOL_IMAGE, version)
+			add(meta.PYTHON+version, dir, image, []string{"python", "/initservers.py"}, nil)
+		}
 	}
 
-	return bm, nil
+	// the images of these runtimes double as pool images, with their
+	// server started in pool mode
+	if sb.Pooled(opts, meta.RUBY) {
+		add(meta.RUBY, filepath.Join(opts.Pool_dir, meta.RUBY), dutil.RUBY_IMAGE,
+			[]string{"ruby", "/server.rb", "--pool"}, sb.PreloadEnv(opts, meta.RUBY))
+	}
+	if sb.Pooled(opts, meta.R) {
+		add(meta.R, filepath.Join(opts.Pool_dir, meta.R), dutil.R_IMAGE,
+			[]string{"Rscript", "/server.R", "--pool"}, sb.PreloadEnv(opts, meta.R))
+	}
+
+	return ps
 }
 
-func (bm *BasicManager) ForkEnter(sandbox sb.ContainerSandbox) (err error) {
-	fs := bm.chooseRandom()
+// poolName returns the name of the Runtime pooling the servers of a handler.
+func poolName(m *meta.HandlerMeta) string {
+	if m.Runtime == meta.PYTHON {
+		return meta.PYTHON + m.Python_version
+	}
+	return m.Runtime
+}
+
+func (bm *BasicManager) ForkEnter(ctx context.Context, sandbox sb.ContainerSandbox, m *meta.HandlerMeta) (err error) {
+	p, ok := bm.pools[poolName(m)]
+	if !ok && m.Runtime == meta.PYTHON {
+		return fmt.Errorf("no pool for python %s (available: %s)",
+			m.Python_version, strings.Join(bm.versions, ", "))
+	} else if !ok {
+		return fmt.Errorf("no pool for the %s runtime", m.Runtime)
+	}
+	fs := p.chooseRandom()
+	if fs == nil {
+		return fmt.Errorf("the %s pool is stopped", p.rt.Name())
+	}
+
+	logger := log.WithFields(log.Fields{
+		logging.SANDBOX_ID: sandbox.ID(),
+		"forkserver":       fs.sockPath,
+	})
+
+	// a fork cannot be interrupted once the forkserver is signalled
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	// signal interpreter to forkenter into sandbox's namespace
-	pid, err := sendFds(fs.sockPath, sandbox.NSPid())
+	begin := time.Now()
+	pid, err := "", faults.Inject(faults.FORK, p.rt.Name())
+	if err == nil {
+		pid, err = p.rt.Fork(fs, sandbox)
+	}
 	if err != nil {
+		forkErrorsTotal.Inc(p.rt.Name())
+		logger.WithError(err).Error("forkenter failed")
 		return err
 	}
 
 	// change cgroup of spawned lambda server
 	if err = sandbox.CGroupEnter(pid); err != nil {
+		forkErrorsTotal.Inc(p.rt.Name())
+		logger.WithError(err).WithField("pid", pid).Error("could not move forked server into sandbox cgroup")
 		return err
 	}
+	forkSeconds.Observe(time.Since(begin).Seconds(), p.rt.Name())
+	logger.WithField("pid", pid).Debug("forkenter done")
 
 	return nil
 }
 
-func initPoolContainer(poolDir, clusterName string, numServers int) (cid string, err error) {
-	client, err := docker.NewClientFromEnv()
-	if err != nil {
-		return "", err
+// monitor checks the health of the pools, restarting those that fail, until
+// the BasicManager is shut down.
+func (bm *BasicManager) monitor() {
+	ticker := time.NewTicker(HEALTH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bm.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, p := range bm.pools {
+			p.check(bm.numServers)
+		}
 	}
+}
+
+// Shutdown stops the health checks and removes the container of every pool.
+func (bm *BasicManager) Shutdown(ctx context.Context) error {
+	close(bm.stop)
 
-	if err = os.MkdirAll(poolDir, os.ModeDir); err != nil {
-		return "", err
+	var failed []string
+	for name, p := range bm.pools {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.stop(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+		}
 	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not remove pools: %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
 
-	labels := map[string]string{
-		dutil.DOCKER_LABEL_CLUSTER: clusterName,
-		dutil.DOCKER_LABEL_TYPE:    dutil.POOL,
+// start (re)starts the container of the pool, and waits for its forkservers.
+func (p *pool) start(numServers int) error {
+	p.mutex.Lock()
+	prev := p.cid
+	p.mutex.Unlock()
+
+	// sockets of a previous container would pass for new forkservers
+	servers := make([]*ForkServer, numServers, numServers)
+	for k := 0; k < numServers; k++ {
+		servers[k] = &ForkServer{
+			sockPath: fmt.Sprintf("%s/fs%d/fs.sock", p.dir, k),
+			packages: []string{},
+		}
+		os.Remove(servers[k].sockPath)
 	}
 
-	volumes := []string{
-		fmt.Sprintf("%s:%s", poolDir, "/host"),
+	cid, err := p.rt.Preload(p.dir, numServers, prev)
+	if err != nil {
+		return err
 	}
 
-	caps := []string{"SYS_ADMIN"}
+	deadline := time.Now().Add(FORKSERVER_TIMEOUT)
+	for _, fs := range servers {
+		for {
+			if _, err := os.Stat(fs.sockPath); err == nil {
+				break
+			} else if time.Now().After(deadline) {
+				return errors.New("forkservers failed to initialize")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	p.mutex.Lock()
+	p.servers, p.cid = servers, cid
+	p.mutex.Unlock()
+	poolHealthy.Set(1, p.rt.Name())
+
+	return nil
+}
+
+// stop removes the container of the pool, which is not restarted again.
+func (p *pool) stop() error {
+	p.mutex.Lock()
+	cid := p.cid
+	p.servers, p.cid, p.stopped = nil, "", true
+	p.mutex.Unlock()
 
-	cmd := []string{"python", "/initservers.py", fmt.Sprintf("%d", numServers)}
+	poolHealthy.Set(0, p.rt.Name())
+	if cid == "" {
+		return nil
+	}
+	return p.rt.Remove(cid)
+}
 
-	container, err := client.CreateContainer(
-		docker.CreateContainerOptions{
-			Config: &docker.Config{
-				Image:  dutil.POOL_IMAGE,
-				Labels: labels,
-				Cmd:    cmd,
-			},
-			HostConfig: &docker.HostConfig{
-				Binds:   volumes,
-				PidMode: "host",
-				CapAdd:  caps,
-			},
-		},
-	)
+// check restarts the pool if one of its forkservers fails its health check.
+func (p *pool) check(numServers int) {
+	p.mutex.Lock()
+	servers, cid, stopped := p.servers, p.cid, p.stopped
+	p.mutex.Unlock()
+	if stopped {
+		return
+	}
 
-	if err := client.StartContainer(container.ID, nil); err != nil {
-		return "", err
+	var err error
+	for _, fs := range servers {
+		if err = p.rt.HealthCheck(cid, fs); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		return
 	}
 
-	return container.ID, nil
+	logger := log.WithFields(log.Fields{"pool": p.rt.Name(), "container": cid})
+	logger.WithError(err).Warn("pool failed health check, restarting it")
+	poolHealthy.Set(0, p.rt.Name())
+	poolRestartsTotal.Inc(p.rt.Name())
+	if err := p.start(numServers); err != nil {
+		logger.WithError(err).Error("could not restart pool")
+	}
 }
 
-func (bm *BasicManager) chooseRandom() (server *ForkServer) {
+func (p *pool) chooseRandom() (server *ForkServer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.servers) == 0 {
+		return nil
+	}
 	rand.Seed(time.Now().Unix())
-	k := rand.Int() % len(bm.servers)
+	k := rand.Int() % len(p.servers)
 
-	return bm.servers[k]
+	return p.servers[k]
 }
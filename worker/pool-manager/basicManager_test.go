@@ -0,0 +1,111 @@
+package pmanager
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// fakeRuntime "starts" forkservers by creating their sockets.
+type fakeRuntime struct {
+	preloads int
+	prevs    []string
+	removed  []string
+	healthy  bool
+}
+
+func (rt *fakeRuntime) Name() string {
+	return "fake"
+}
+
+func (rt *fakeRuntime) Preload(poolDir string, numServers int, prev string) (string, error) {
+	rt.preloads++
+	rt.prevs = append(rt.prevs, prev)
+	for _, fs := range []string{"fs0", "fs1"}[:numServers] {
+		os.MkdirAll(filepath.Join(poolDir, fs), 0755)
+		ioutil.WriteFile(filepath.Join(poolDir, fs, "fs.sock"), nil, 0644)
+	}
+	return fmt.Sprintf("container%d", rt.preloads), nil
+}
+
+func (rt *fakeRuntime) Fork(fs *ForkServer, sandbox sb.ContainerSandbox) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (rt *fakeRuntime) HealthCheck(cid string, fs *ForkServer) error {
+	if !rt.healthy {
+		return errors.New("unhealthy")
+	}
+	return nil
+}
+
+func (rt *fakeRuntime) Remove(cid string) error {
+	rt.removed = append(rt.removed, cid)
+	return nil
+}
+
+func TestPoolRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rt := &fakeRuntime{healthy: true}
+	p := &pool{rt: rt, dir: dir}
+	if err := p.start(2); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.servers) != 2 || p.cid != "container1" {
+		t.Fatalf("unexpected pool after start: %d servers in %q", len(p.servers), p.cid)
+	}
+
+	p.check(2)
+	if rt.preloads != 1 {
+		t.Fatalf("healthy pool was restarted")
+	}
+
+	rt.healthy = false
+	p.check(2)
+	if rt.preloads != 2 || p.cid != "container2" {
+		t.Fatalf("unhealthy pool was not restarted")
+	}
+	if rt.prevs[1] != "container1" {
+		t.Fatalf("expected the old container to be replaced, got prev %q", rt.prevs[1])
+	}
+}
+
+func TestPoolStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rt := &fakeRuntime{healthy: true}
+	p := &pool{rt: rt, dir: dir}
+	if err := p.start(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.stop(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rt.removed) != 1 || rt.removed[0] != "container1" {
+		t.Fatalf("expected the pool container to be removed, got %v", rt.removed)
+	}
+	if p.chooseRandom() != nil {
+		t.Fatal("stopped pool still hands out forkservers")
+	}
+
+	// a stopped pool fails its health check, but must stay down
+	rt.healthy = false
+	p.check(2)
+	if rt.preloads != 1 {
+		t.Fatal("stopped pool was restarted")
+	}
+}
@@ -12,14 +12,23 @@ for managing an individual sandbox.
 */
 
 import (
+	"context"
+
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
 	sb "github.com/open-lambda/open-lambda/worker/sandbox"
 )
 
+// ForkServer is a process of a pool that forks lambda servers into sandboxes.
 type ForkServer struct {
 	sockPath string
 	packages []string
 }
 
 type PoolManager interface {
-	ForkEnter(sandbox sb.ContainerSandbox) error
+	// ForkEnter forks a lambda server of the runtime (and Python
+	// version) of the handler into the sandbox, unless ctx is done
+	ForkEnter(ctx context.Context, sandbox sb.ContainerSandbox, m *meta.HandlerMeta) error
+
+	// Shutdown stops the pools for good; ForkEnter fails afterwards
+	Shutdown(ctx context.Context) error
 }
@@ -0,0 +1,25 @@
+package pmanager
+
+import (
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+var (
+	forkSeconds = metrics.NewHistogram(
+		"ol_pool_fork_seconds",
+		"Time taken to fork a lambda server from a pool into a sandbox.",
+		metrics.LATENCY_BUCKETS,
+		"pool")
+	forkErrorsTotal = metrics.NewCounter(
+		"ol_pool_fork_errors_total",
+		"Number of forks of lambda servers from a pool that failed.",
+		"pool")
+	poolHealthy = metrics.NewGauge(
+		"ol_pool_healthy",
+		"Whether the forkservers of a pool passed their last health check (1) or not (0).",
+		"pool")
+	poolRestartsTotal = metrics.NewCounter(
+		"ol_pool_restarts_total",
+		"Number of times a pool was restarted after failing its health check.",
+		"pool")
+)
@@ -0,0 +1,132 @@
+package pmanager
+
+import (
+	"fmt"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	docker "github.com/fsouza/go-dockerclient"
+	dutil "github.com/open-lambda/open-lambda/worker/dockerutil"
+	sb "github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// Runtime is a language runtime whose lambda servers are forked into
+// sandboxes from a pool of forkservers, which have loaded the runtime and its
+// common libraries once. The BasicManager takes the pool of every Runtime
+// through the same lifecycle: Preload when the worker starts, Fork for each
+// new sandbox, and HealthCheck periodically, with a new Preload when it
+// fails.
+type Runtime interface {
+	// Name identifies the pool in logs and metrics, e.g. "python2.7".
+	Name() string
+
+	// Preload starts numServers forkservers, the k-th listening on
+	// <poolDir>/fs<k>/fs.sock, and returns the ID of the container
+	// running them. A previous container (prev, if not empty) is removed
+	// first.
+	Preload(poolDir string, numServers int, prev string) (cid string, err error)
+
+	// Fork forks a lambda server from the forkserver into the
+	// namespaces of the sandbox, and returns the PID of the server.
+	Fork(fs *ForkServer, sandbox sb.ContainerSandbox) (pid string, err error)
+
+	// HealthCheck returns an error if the forkserver, run by the given
+	// container, cannot take Fork requests.
+	HealthCheck(cid string, fs *ForkServer) error
+
+	// Remove kills and removes the container of a pool.
+	Remove(cid string) error
+}
+
+// dockerRuntime is a Runtime whose forkservers run in a docker container and
+// receive the namespaces of sandboxes as file descriptors over their socket
+// (see server-pool/nsmodule).
+type dockerRuntime struct {
+	name        string
+	image       string
+	cmd         []string // the number of forkservers is appended
+	env         []string
+	caps        []string // all others are dropped
+	securityOpt []string
+	clusterName string
+	client      *docker.Client
+}
+
+func (rt *dockerRuntime) Name() string {
+	return rt.name
+}
+
+func (rt *dockerRuntime) Preload(poolDir string, numServers int, prev string) (cid string, err error) {
+	if prev != "" {
+		if err := rt.Remove(prev); err != nil {
+			log.WithError(err).WithField("container", prev).Warn("could not remove pool container")
+		}
+	}
+
+	if err = os.MkdirAll(poolDir, os.ModeDir); err != nil {
+		return "", err
+	}
+
+	labels := map[string]string{
+		dutil.DOCKER_LABEL_CLUSTER: rt.clusterName,
+		dutil.DOCKER_LABEL_TYPE:    dutil.POOL,
+	}
+
+	volumes := []string{
+		fmt.Sprintf("%s:%s", poolDir, "/host"),
+	}
+
+	cmd := append(append([]string{}, rt.cmd...), fmt.Sprintf("%d", numServers))
+
+	container, err := rt.client.CreateContainer(
+		docker.CreateContainerOptions{
+			Config: &docker.Config{
+				Image:  rt.image,
+				Labels: labels,
+				Cmd:    cmd,
+				Env:    rt.env,
+			},
+			HostConfig: &docker.HostConfig{
+				Binds:       volumes,
+				PidMode:     "host",
+				CapDrop:     []string{"ALL"},
+				CapAdd:      rt.caps,
+				SecurityOpt: rt.securityOpt,
+			},
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if err := rt.client.StartContainer(container.ID, nil); err != nil {
+		return "", err
+	}
+	log.WithField("container", container.ID).Infof("started %s pool container with %d forkservers", rt.name, numServers)
+
+	return container.ID, nil
+}
+
+func (rt *dockerRuntime) Remove(cid string) error {
+	return rt.client.RemoveContainer(docker.RemoveContainerOptions{ID: cid, Force: true})
+}
+
+func (rt *dockerRuntime) Fork(fs *ForkServer, sandbox sb.ContainerSandbox) (string, error) {
+	return sendFds(fs.sockPath, sandbox.NSPid())
+}
+
+// HealthCheck only looks at the container and the socket of the forkserver:
+// connecting to the socket would count as a Fork request.
+func (rt *dockerRuntime) HealthCheck(cid string, fs *ForkServer) error {
+	container, err := rt.client.InspectContainer(cid)
+	if err != nil {
+		return err
+	}
+	if !container.State.Running {
+		return fmt.Errorf("pool container exited with status %d", container.State.ExitCode)
+	}
+	if _, err := os.Stat(fs.sockPath); err != nil {
+		return fmt.Errorf("forkserver socket is gone: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// profiler package continuously samples the stacks of handler processes with
+// py-spy and aggregates them per handler, in the folded format understood by
+// flamegraph.pl, speedscope and most other flame graph tools.
+package profiler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/logging"
+)
+
+// MAX_STACKS bounds the number of distinct stacks kept per handler; samples
+// of further stacks are dropped.
+const MAX_STACKS = 10000
+
+// Target is a process tree to profile.
+type Target struct {
+	Handler string
+	Pid     string
+}
+
+// Profiler samples targets in rounds. It can be enabled and disabled at
+// runtime; while disabled it keeps what it has collected.
+type Profiler struct {
+	mutex    sync.Mutex
+	enabled  bool
+	interval time.Duration
+	duration time.Duration
+	targets  func() []Target
+	profiles map[string]map[string]int64 // handler -> stack -> samples
+}
+
+// NewProfiler creates a Profiler that, while enabled, records each of the
+// targets for duration once every interval.
+func NewProfiler(enabled bool, interval, duration time.Duration, targets func() []Target) *Profiler {
+	return &Profiler{
+		enabled:  enabled,
+		interval: interval,
+		duration: duration,
+		targets:  targets,
+		profiles: make(map[string]map[string]int64),
+	}
+}
+
+// SetEnabled turns profiling on or off.
+func (p *Profiler) SetEnabled(enabled bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.enabled = enabled
+}
+
+// Enabled returns true if the profiler is sampling.
+func (p *Profiler) Enabled() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.enabled
+}
+
+// Run samples the targets in rounds. It never returns.
+func (p *Profiler) Run() {
+	for range time.Tick(p.interval) {
+		if !p.Enabled() {
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, t := range p.targets() {
+			wg.Add(1)
+			go func(t Target) {
+				defer wg.Done()
+				if err := p.sample(t); err != nil {
+					log.WithError(err).WithField(logging.HANDLER, t.Handler).Debug("could not profile handler")
+				}
+			}(t)
+		}
+		wg.Wait()
+	}
+}
+
+// sample records one target with py-spy and merges the result.
+func (p *Profiler) sample(t Target) error {
+	out, err := ioutil.TempFile("", "ol-profile")
+	if err != nil {
+		return err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.duration+30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "py-spy", "record",
+		"--pid", t.Pid,
+		"--subprocesses",
+		"--duration", strconv.Itoa(int(p.duration.Seconds())),
+		"--format", "raw",
+		"--output", out.Name())
+	if msg, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, msg)
+	}
+
+	folded, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		return err
+	}
+	return p.merge(t.Handler, bytes.NewReader(folded))
+}
+
+// merge adds folded stacks ("frame;frame;frame count" per line) to the
+// profile of handler.
+func (p *Profiler) merge(handler string, r io.Reader) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	profile := p.profiles[handler]
+	if profile == nil {
+		profile = make(map[string]int64)
+		p.profiles[handler] = profile
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		count, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad folded stack line %q", line)
+		}
+		stack := line[:i]
+		if _, ok := profile[stack]; !ok && len(profile) >= MAX_STACKS {
+			continue
+		}
+		profile[stack] += count
+	}
+	return scanner.Err()
+}
+
+// WriteFolded writes the profile of handler in folded format, heaviest
+// stacks first. It returns false if there is no profile for the handler.
+func (p *Profiler) WriteFolded(w io.Writer, handler string) bool {
+	p.mutex.Lock()
+	profile := p.profiles[handler]
+	stacks := make([]string, 0, len(profile))
+	for stack := range profile {
+		stacks = append(stacks, stack)
+	}
+	counts := make(map[string]int64, len(profile))
+	for stack, count := range profile {
+		counts[stack] = count
+	}
+	p.mutex.Unlock()
+
+	if profile == nil {
+		return false
+	}
+
+	sort.Slice(stacks, func(i, j int) bool {
+		if counts[stacks[i]] != counts[stacks[j]] {
+			return counts[stacks[i]] > counts[stacks[j]]
+		}
+		return stacks[i] < stacks[j]
+	})
+	for _, stack := range stacks {
+		fmt.Fprintf(w, "%s %d\n", stack, counts[stack])
+	}
+	return true
+}
+
+// Reset forgets the profile of handler.
+func (p *Profiler) Reset(handler string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.profiles, handler)
+}
@@ -0,0 +1,32 @@
+package profiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeFolded(t *testing.T) {
+	p := NewProfiler(false, time.Minute, time.Second, func() []Target { return nil })
+
+	p.merge("hello", strings.NewReader("main;handler;sleep 5\nmain;handler;json 2\n"))
+	p.merge("hello", strings.NewReader("main;handler;json 4\n"))
+
+	var buf bytes.Buffer
+	if !p.WriteFolded(&buf, "hello") {
+		t.Fatal("expected a profile")
+	}
+	expected := "main;handler;json 6\nmain;handler;sleep 5\n"
+	if buf.String() != expected {
+		t.Fatalf("unexpected profile:\n%s", buf.String())
+	}
+
+	if p.WriteFolded(&buf, "other") {
+		t.Fatal("unexpected profile for unknown handler")
+	}
+
+	if err := p.merge("hello", strings.NewReader("garbage x\n")); err == nil {
+		t.Fatal("expected error on malformed line")
+	}
+}
@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/logging"
+)
+
+// BUILD_TIMEOUT bounds how long building the code of a handler may take.
+const BUILD_TIMEOUT = 2 * time.Minute
+
+// buildEnv is the environment of the go tool, in place of the settings of
+// the worker's own environment that start with the same names: handler
+// source is untrusted, so the go tool must neither fetch code nor switch to
+// another toolchain (e.g., one named by the go.mod of the handler), and
+// flags meant for building the worker do not apply.
+var buildEnv = []string{
+	"CGO_ENABLED=0",
+	"GOOS=linux",
+	"GOTOOLCHAIN=local",
+	"GOFLAGS=",
+	"GOPROXY=off",
+	"GOENV=off",
+}
+
+// Build compiles the code of a handler that ships source rather than a
+// binary. It is a no-op for interpreted runtimes, and when the binary is
+// newer than the source. It gives up once ctx is done, or after
+// BUILD_TIMEOUT.
+//
+// Go handlers are built with the go tool of the worker, against its GOPATH,
+// into a static binary named meta.GO_BINARY, without network access to
+// modules or toolchains (see buildEnv).
+func Build(ctx context.Context, name, codeDir string, m *meta.HandlerMeta) error {
+	if m.Runtime != meta.GO {
+		return nil
+	}
+
+	bin := filepath.Join(codeDir, meta.GO_BINARY)
+	stale, err := staleBinary(codeDir, bin)
+	if err != nil || !stale {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, BUILD_TIMEOUT)
+	defer cancel()
+
+	begin := time.Now()
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", bin, ".")
+	cmd.Dir = codeDir
+	cmd.Env = withEnv(os.Environ(), buildEnv)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("could not build handler: %v", ctx.Err())
+		}
+		return fmt.Errorf("could not build handler: %v: %s", err, output)
+	}
+	log.WithFields(log.Fields{
+		logging.HANDLER: name,
+		"seconds":       time.Since(begin).Seconds(),
+	}).Info("built handler")
+	return nil
+}
+
+// withEnv returns env with the variables of overrides in place of those of
+// the same names.
+func withEnv(env, overrides []string) []string {
+	names := make(map[string]bool)
+	for _, kv := range overrides {
+		names[strings.SplitN(kv, "=", 2)[0]] = true
+	}
+	result := []string{}
+	for _, kv := range env {
+		if !names[strings.SplitN(kv, "=", 2)[0]] {
+			result = append(result, kv)
+		}
+	}
+	return append(result, overrides...)
+}
+
+// staleBinary returns true if bin is missing or older than a Go source file
+// in codeDir.
+func staleBinary(codeDir, bin string) (bool, error) {
+	binInfo, err := os.Stat(bin)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	files, err := ioutil.ReadDir(codeDir)
+	if err != nil {
+		return false, err
+	}
+	for _, fi := range files {
+		if strings.HasSuffix(fi.Name(), ".go") && fi.ModTime().After(binInfo.ModTime()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,15 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithEnv(t *testing.T) {
+	env := []string{"PATH=/bin", "GOFLAGS=-tags=worker", "GOTOOLCHAIN=auto", "HOME=/root"}
+	got := withEnv(env, buildEnv)
+	expected := append([]string{"PATH=/bin", "HOME=/root"}, buildEnv...)
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
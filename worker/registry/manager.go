@@ -2,18 +2,40 @@ package registry
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
+	"time"
 
+	log "github.com/Sirupsen/logrus"
 	r "github.com/open-lambda/open-lambda/registry/src"
 	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/tlsutil"
+)
+
+// names of the registry backends, as given in the "registry" config field
+const (
+	LOCAL   = "local"
+	OLSTORE = "olregistry"
 )
 
 // RegistryManager is the common interface for lambda code pulling functions.
+// A pull gives up once ctx is done.
 type RegistryManager interface {
-	Pull(name string) (savedAt string, err error)
+	Pull(ctx context.Context, name string) (savedAt string, err error)
+}
+
+// Versioned is implemented by registry managers that keep the previous
+// version of the code of a handler when they pull a new one.
+type Versioned interface {
+	// Rollback makes the previous version of the code of the handler
+	// current again, discarding the version pulled last.
+	Rollback(name string) error
 }
 
 // LocalManager stores lambda code in a local directory.
@@ -22,21 +44,28 @@ type LocalManager struct {
 }
 
 // OLStoreManager pulls code from olstore and stores it in a local directory.
+// Pulls and rollbacks of the same handler are serialized, as they replace
+// its code directory.
 type OLStoreManager struct {
 	regDir     string
 	pullclient *r.PullClient
+	mutex      sync.Mutex
+	handlers   map[string]*sync.Mutex
 }
 
 // NewLocalManager creates a local manager.
 func NewLocalManager(opts *config.Config) (*LocalManager, error) {
 	if err := os.MkdirAll(opts.Reg_dir, os.ModeDir); err != nil {
-		return nil, fmt.Errorf("fail to create directory at %s: ", opts.Reg_dir, err)
+		return nil, fmt.Errorf("fail to create directory at %s: %v", opts.Reg_dir, err)
 	}
 	return &LocalManager{opts.Reg_dir}, nil
 }
 
 // Pull checks the lambda handler actually exists in the registry directory.
-func (lm *LocalManager) Pull(name string) (string, error) {
+func (lm *LocalManager) Pull(ctx context.Context, name string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	handlerDir := filepath.Join(lm.regDir, name)
 	if _, err := os.Stat(handlerDir); os.IsNotExist(err) {
 		return "", fmt.Errorf("handler does not exists at %s", handlerDir)
@@ -46,28 +75,87 @@ func (lm *LocalManager) Pull(name string) (string, error) {
 	return handlerDir, nil
 }
 
-// NewOLStoreManager creates an olstore manager.
+// NewOLStoreManager creates an olstore manager, which connects to the
+// registry cluster with mutual TLS if the worker is configured for it.
 func NewOLStoreManager(opts *config.Config) (*OLStoreManager, error) {
-	pullClient := r.InitPullClient(opts.Reg_cluster, r.DATABASE, r.TABLE)
-	return &OLStoreManager{opts.Reg_dir, pullClient}, nil
+	reloader, err := tlsutil.New(opts.Tls)
+	if err != nil {
+		return nil, err
+	}
+	pullClient := r.InitPullClientTLS(opts.Reg_cluster, r.DATABASE, r.TABLE, reloader.ClientConfig())
+	removeStaged(opts.Reg_dir)
+	return &OLStoreManager{
+		regDir:     opts.Reg_dir,
+		pullclient: pullClient,
+		handlers:   make(map[string]*sync.Mutex),
+	}, nil
+}
+
+// lock locks the mutex of the named handler, and returns it.
+func (om *OLStoreManager) lock(name string) *sync.Mutex {
+	om.mutex.Lock()
+	l := om.handlers[name]
+	if l == nil {
+		l = &sync.Mutex{}
+		om.handlers[name] = l
+	}
+	om.mutex.Unlock()
+	l.Lock()
+	return l
 }
 
-// Pull pulls lambda handler tarball from olstore and decompress it to a local directory.
-func (om *OLStoreManager) Pull(name string) (string, error) {
-	handlerDir := filepath.Join(om.regDir, name)
-	if err := os.Mkdir(handlerDir, os.ModeDir); err != nil {
+// Pull pulls lambda handler tarball from olstore and decompress it to a local
+// directory. The code is extracted aside, and only published as the code
+// directory of the handler once complete and valid (see publish).
+func (om *OLStoreManager) Pull(ctx context.Context, name string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	defer om.lock(name).Unlock()
+	stageDir, err := stage(om.regDir, name)
+	if err != nil {
 		return "", err
 	}
 
+	begin := time.Now()
 	pfiles := om.pullclient.Pull(name)
 	handler := pfiles[r.HANDLER].([]byte)
 	r := bytes.NewReader(handler)
+	startup.Observe(name, startup.COLD, startup.PULL, begin)
+	pullBytesTotal.Add(float64(len(handler)), OLSTORE)
+
+	begin = time.Now()
 
 	// TODO: try to uncompress without execing - faster?
-	cmd := exec.Command("tar", "-xzf", "-", "--directory", handlerDir)
+	cmd := exec.CommandContext(ctx, "tar", "-xzf", "-", "--directory", stageDir)
 	cmd.Stdin = r
 	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(stageDir)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		log.WithField(logging.HANDLER, name).WithError(err).Error("could not extract handler")
 		return "", fmt.Errorf("%s: %s", err, string(output))
 	}
+	startup.Observe(name, startup.COLD, startup.EXTRACT, begin)
+	extractSeconds.Observe(time.Since(begin).Seconds(), OLSTORE)
+
+	handlerDir, err := publish(om.regDir, name, stageDir)
+	if err != nil {
+		os.RemoveAll(stageDir)
+		return "", err
+	}
+	log.WithFields(log.Fields{
+		logging.HANDLER: name,
+		"bytes":         len(handler),
+		"dir":           handlerDir,
+	}).Info("pulled handler from olstore")
 	return handlerDir, nil
 }
+
+// Rollback makes the version of the code of the handler pulled before the
+// last one current again.
+func (om *OLStoreManager) Rollback(name string) error {
+	defer om.lock(name).Unlock()
+	return rollback(om.regDir, name)
+}
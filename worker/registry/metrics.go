@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/faults"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+var (
+	pullSeconds = metrics.NewHistogram(
+		"ol_registry_pull_seconds",
+		"Time taken to pull the code of a handler, including extraction.",
+		metrics.LATENCY_BUCKETS,
+		"backend")
+	pullErrorsTotal = metrics.NewCounter(
+		"ol_registry_pull_errors_total",
+		"Number of pulls of handler code that failed.",
+		"backend")
+	pullBytesTotal = metrics.NewCounter(
+		"ol_registry_pull_bytes_total",
+		"Bytes of handler code transferred from the registry.",
+		"backend")
+	extractSeconds = metrics.NewHistogram(
+		"ol_registry_extract_seconds",
+		"Time taken to extract pulled handler code to disk.",
+		metrics.LATENCY_BUCKETS,
+		"backend")
+	cacheLookupsTotal = metrics.NewCounter(
+		"ol_registry_cache_lookups_total",
+		"Number of times a handler's code was needed, by whether the worker already had it.",
+		"backend", "result")
+)
+
+// instrumented records the latency and failures of the pulls of a
+// RegistryManager.
+type instrumented struct {
+	backend string
+	rm      RegistryManager
+}
+
+// instrumentedVersioned is an instrumented RegistryManager that can roll
+// back.
+type instrumentedVersioned struct {
+	*instrumented
+	Versioned
+}
+
+// Instrument wraps rm so that its pulls are reported in the registry metrics,
+// labeled with the given backend name. The wrapper is Versioned if rm is.
+func Instrument(backend string, rm RegistryManager) RegistryManager {
+	if v, ok := rm.(Versioned); ok {
+		return &instrumentedVersioned{&instrumented{backend, rm}, v}
+	}
+	return &instrumented{backend, rm}
+}
+
+// Pull pulls through the wrapped RegistryManager.
+func (i *instrumented) Pull(ctx context.Context, name string) (string, error) {
+	begin := time.Now()
+	if err := faults.Inject(faults.PULL, name); err != nil {
+		pullErrorsTotal.Inc(i.backend)
+		return "", err
+	}
+	dir, err := i.rm.Pull(ctx, name)
+	if err != nil {
+		// giving up on a cancelled request is not a failure of the backend
+		if ctx.Err() == nil {
+			pullErrorsTotal.Inc(i.backend)
+		}
+		return "", err
+	}
+	pullSeconds.Observe(time.Since(begin).Seconds(), i.backend)
+	return dir, nil
+}
+
+// CacheLookup records whether the code of a handler was already on the worker
+// when it was needed; misses result in a pull from the backend.
+func CacheLookup(backend string, hit bool) {
+	if hit {
+		cacheLookupsTotal.Inc(backend, "hit")
+	} else {
+		cacheLookupsTotal.Inc(backend, "miss")
+	}
+}
@@ -0,0 +1,177 @@
+package registry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/logging"
+)
+
+// VERSIONS_DIR is the directory, within the registry directory, holding the
+// versions of the code of each handler pulled from a remote registry; the
+// code directory of a handler is a symlink to its current version.
+const VERSIONS_DIR = ".versions"
+
+// prefixes of the directories in the versions directory of a handler: code
+// being extracted and checked is staged, and renamed to a published version
+// named after the time it was published, so that versions sort by age
+const (
+	STAGED_PREFIX    = "s"
+	PUBLISHED_PREFIX = "v"
+)
+
+// stage creates an empty directory for a new version of the code of the
+// named handler, to extract it into before it is published.
+func stage(regDir, name string) (string, error) {
+	versions := filepath.Join(regDir, VERSIONS_DIR, name)
+	if err := os.MkdirAll(versions, 0755); err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir(versions, STAGED_PREFIX)
+	if err != nil {
+		return "", err
+	}
+	// sandboxes may not run as root
+	return dir, os.Chmod(dir, 0755)
+}
+
+// publish makes the code staged in dir the code of the named handler, once
+// it passes the checks the worker makes before running it. The code
+// directory of the handler is switched to the published version by renaming
+// a symlink over it, so readers see either the old or the new version, never
+// a half-written one. Sandboxes mount the version that was current when they
+// were created, so the previous version is kept, and can be rolled back to;
+// older published ones are removed. Staged versions are left alone. The
+// caller must serialize the publishes of a handler.
+func publish(regDir, name, dir string) (string, error) {
+	m, err := meta.Load(dir)
+	if err != nil {
+		return "", err
+	}
+	if err := m.CheckEntrypoint(dir); err != nil {
+		return "", err
+	}
+
+	version := filepath.Join(filepath.Dir(dir), fmt.Sprintf("%s%020d", PUBLISHED_PREFIX, time.Now().UnixNano()))
+	if err := os.Rename(dir, version); err != nil {
+		return "", err
+	}
+	prev, err := swap(regDir, name, version)
+	if err != nil {
+		os.RemoveAll(version)
+		return "", err
+	}
+
+	prevLink := filepath.Join(regDir, VERSIONS_DIR, name+".prev")
+	os.Remove(prevLink)
+	if prev != "" {
+		if err := os.Symlink(prev, prevLink); err != nil {
+			log.WithField(logging.HANDLER, name).WithError(err).Warn("could not record previous version of handler code")
+		}
+	}
+
+	prune(filepath.Join(regDir, VERSIONS_DIR, name), version, prev)
+	return filepath.Join(regDir, name), nil
+}
+
+// rollback makes the version of the code of the named handler that was
+// current before the last publish current again, and removes the version
+// it replaces. There is nothing to roll back to after a rollback.
+func rollback(regDir, name string) error {
+	prevLink := filepath.Join(regDir, VERSIONS_DIR, name+".prev")
+	prev, err := os.Readlink(prevLink)
+	if err != nil {
+		return fmt.Errorf("no previous version of %s to roll back to", name)
+	}
+	if _, err := os.Stat(prev); err != nil {
+		return fmt.Errorf("previous version of %s is gone: %v", name, err)
+	}
+
+	bad, err := swap(regDir, name, prev)
+	if err != nil {
+		return err
+	}
+	os.Remove(prevLink)
+	if bad != "" {
+		if err := os.RemoveAll(bad); err != nil {
+			log.WithField(logging.HANDLER, name).WithError(err).Warn("could not remove rolled back version of handler code")
+		}
+	}
+	return nil
+}
+
+// swap atomically points the code directory of the named handler at dir,
+// and returns the version it pointed at before, if any. The new symlink is
+// made in a directory of its own, so concurrent swaps never share it.
+func swap(regDir, name, dir string) (string, error) {
+	handlerDir := filepath.Join(regDir, name)
+	tmp, err := ioutil.TempDir(filepath.Join(regDir, VERSIONS_DIR), name+".link")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	link := filepath.Join(tmp, "link")
+	if err := os.Symlink(dir, link); err != nil {
+		return "", err
+	}
+
+	prev := ""
+	if fi, err := os.Lstat(handlerDir); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		prev, _ = os.Readlink(handlerDir)
+	} else if err == nil {
+		// a directory extracted before versions were kept cannot be
+		// replaced atomically; no sandbox of this run uses it
+		if err := os.RemoveAll(handlerDir); err != nil {
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.Rename(link, handlerDir); err != nil {
+		return "", fmt.Errorf("could not publish %s: %v", dir, err)
+	}
+	return prev, nil
+}
+
+// prune removes the published versions in the versions directory of a
+// handler older than the previous one (or the current one, if there is no
+// previous one in the directory). Staged versions, which may still be
+// extracted or checked by another pull, are never removed.
+func prune(versions, current, prev string) {
+	dirs, err := ioutil.ReadDir(versions)
+	if err != nil {
+		return
+	}
+	oldest := filepath.Base(current)
+	if prev != "" && filepath.Dir(prev) == versions && strings.HasPrefix(filepath.Base(prev), PUBLISHED_PREFIX) {
+		oldest = filepath.Base(prev)
+	}
+	for _, fi := range dirs {
+		if !strings.HasPrefix(fi.Name(), PUBLISHED_PREFIX) || fi.Name() >= oldest {
+			continue
+		}
+		dir := filepath.Join(versions, fi.Name())
+		if err := os.RemoveAll(dir); err != nil {
+			log.WithField(logging.HANDLER, filepath.Base(versions)).WithError(err).Warn("could not remove old version of handler code")
+		}
+	}
+}
+
+// removeStaged removes the versions of every handler that were staged but
+// never published, and symlinks left over by swaps, e.g., by pulls that
+// crashed. It must only run while no pull is in progress.
+func removeStaged(regDir string) {
+	staged, _ := filepath.Glob(filepath.Join(regDir, VERSIONS_DIR, "*", STAGED_PREFIX+"*"))
+	links, _ := filepath.Glob(filepath.Join(regDir, VERSIONS_DIR, "*.link*"))
+	for _, dir := range append(staged, links...) {
+		if err := os.RemoveAll(dir); err != nil {
+			log.WithError(err).WithField("dir", dir).Warn("could not remove staged handler code")
+		}
+	}
+}
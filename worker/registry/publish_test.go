@@ -0,0 +1,143 @@
+package registry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stageCode stages a version of the code of a handler with the given source.
+func stageCode(t *testing.T, regDir, name, src string) string {
+	dir, err := stage(regDir, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "lambda_func.py"), []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// current returns the version the code directory of a handler points at.
+func current(t *testing.T, regDir, name string) string {
+	version, err := os.Readlink(filepath.Join(regDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestPublish(t *testing.T) {
+	regDir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(regDir)
+
+	// code extracted before versions were kept is replaced
+	old := filepath.Join(regDir, "hello")
+	os.MkdirAll(old, 0755)
+
+	// code staged by a pull in progress is never pruned
+	pending := stageCode(t, regDir, "hello", "pending")
+
+	var versions []string
+	for _, src := range []string{"v1", "v2", "v3"} {
+		dir := stageCode(t, regDir, "hello", src)
+		handlerDir, err := publish(regDir, "hello", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, err := ioutil.ReadFile(filepath.Join(handlerDir, "lambda_func.py"))
+		if err != nil || string(code) != src {
+			t.Fatalf("expected %s to be published, got %q (%v)", src, code, err)
+		}
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatal("staged code was not moved to the published version")
+		}
+		versions = append(versions, current(t, regDir, "hello"))
+	}
+
+	// only the current and previous versions are kept
+	for i, dir := range versions {
+		_, err := os.Stat(dir)
+		if kept := err == nil; kept != (i >= len(versions)-2) {
+			t.Fatalf("version %d kept: %v", i+1, kept)
+		}
+	}
+	if _, err := os.Stat(pending); err != nil {
+		t.Fatalf("staged code of another pull was removed: %v", err)
+	}
+
+	// invalid code is not published
+	dir, err := stage(regDir, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "lambda-config.json"), []byte("{"), 0644)
+	if _, err := publish(regDir, "hello", dir); err == nil {
+		t.Fatal("expected invalid metadata to be rejected")
+	}
+	if target := current(t, regDir, "hello"); target != versions[2] {
+		t.Fatalf("expected %s to stay current, got %s", versions[2], target)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	regDir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(regDir)
+
+	v1 := stageCode(t, regDir, "hello", "v1")
+	if _, err := publish(regDir, "hello", v1); err != nil {
+		t.Fatal(err)
+	}
+	if err := rollback(regDir, "hello"); err == nil {
+		t.Fatal("expected rollback without a previous version to fail")
+	}
+
+	if _, err := publish(regDir, "hello", stageCode(t, regDir, "hello", "v2")); err != nil {
+		t.Fatal(err)
+	}
+	v2 := current(t, regDir, "hello")
+	if err := rollback(regDir, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	code, err := ioutil.ReadFile(filepath.Join(regDir, "hello", "lambda_func.py"))
+	if err != nil || string(code) != "v1" {
+		t.Fatalf("expected v1 after rollback, got %q (%v)", code, err)
+	}
+	if _, err := os.Stat(v2); !os.IsNotExist(err) {
+		t.Fatal("rolled back version was kept")
+	}
+
+	// there is nothing left to roll back to
+	if err := rollback(regDir, "hello"); err == nil {
+		t.Fatal("expected second rollback to fail")
+	}
+}
+
+func TestRemoveStaged(t *testing.T) {
+	regDir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(regDir)
+
+	if _, err := publish(regDir, "hello", stageCode(t, regDir, "hello", "v1")); err != nil {
+		t.Fatal(err)
+	}
+	published := current(t, regDir, "hello")
+	staged := stageCode(t, regDir, "hello", "v2")
+
+	removeStaged(regDir)
+	if _, err := os.Stat(staged); !os.IsNotExist(err) {
+		t.Fatal("staged code was kept")
+	}
+	if _, err := os.Stat(published); err != nil {
+		t.Fatalf("published code was removed: %v", err)
+	}
+}
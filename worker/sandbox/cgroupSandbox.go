@@ -16,8 +16,10 @@ import (
 	"os"
 	"path/filepath"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/open-lambda/open-lambda/worker/config"
 	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/logging"
 )
 
 type CgroupSandbox struct {
@@ -37,6 +39,10 @@ func NewCgroupSandbox(opts *config.Config, root_dir string) (*CgroupSandbox, err
 	return sandbox, nil
 }
 
+func (s *CgroupSandbox) ID() string {
+	return filepath.Base(s.root_dir)
+}
+
 func (s *CgroupSandbox) State() (hstate state.HandlerState, err error) {
 	return s.status, nil
 }
@@ -52,7 +58,8 @@ func (s *CgroupSandbox) Channel() (channel *SandboxChannel, err error) {
 }
 
 func (s *CgroupSandbox) Start() error {
-	fmt.Printf("Start cgroup sandbox 1 %s\n", filepath.Join(s.root_dir, "handler"))
+	logger := log.WithField(logging.SANDBOX_ID, s.ID())
+	logger.Infof("start cgroup sandbox %s", filepath.Join(s.root_dir, "handler"))
 
 	cmd := []string{
 		s.opts.Cgroup_init_path,
@@ -65,7 +72,7 @@ func (s *CgroupSandbox) Start() error {
 		Files: []*os.File{nil, os.Stdout, os.Stderr},
 		Env:   env,
 	}
-	fmt.Printf("Use env=%v\n", attr)
+	logger.Debugf("use env=%v", attr)
 	proc, err := os.StartProcess(cmd[0], cmd, &attr)
 	if err != nil {
 		return err
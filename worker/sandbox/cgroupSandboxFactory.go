@@ -1,6 +1,7 @@
 package sandbox
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -8,7 +9,9 @@ import (
 	"path"
 	"strings"
 
+	log "github.com/Sirupsen/logrus"
 	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
 )
 
 // CgroupSBFactory is a SandboxFactory that creats docker sandboxes.
@@ -18,11 +21,17 @@ type CgroupSBFactory struct {
 
 // NewCgroupSBFactory creates a CgroupSBFactory.
 func NewCgroupSBFactory(opts *config.Config) (*CgroupSBFactory, error) {
+	if opts.Default_apparmor_profile != "" || opts.Default_selinux_label != "" {
+		return nil, fmt.Errorf("default_apparmor_profile and default_selinux_label are not supported by cgroup sandboxes")
+	}
+	if opts.Tenant_cgroups {
+		return nil, fmt.Errorf("tenant_cgroups is not supported by cgroup sandboxes")
+	}
 	return &CgroupSBFactory{opts: opts}, nil
 }
 
 func cmd(args []string) error {
-	fmt.Printf("Execute: %s\n", strings.Join(args, " "))
+	log.Debugf("execute: %s", strings.Join(args, " "))
 	c := exec.Cmd{Path: args[0], Args: args}
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
@@ -30,8 +39,54 @@ func cmd(args []string) error {
 	return err
 }
 
+// Check verifies that the handler is a Python handler of the default version
+// without layers or a security profile, the only kind cgroup sandboxes
+// support.
+func (self *CgroupSBFactory) Check(m *meta.HandlerMeta) error {
+	if m.Runtime != meta.PYTHON {
+		return &meta.IncompatibleError{Field: "runtime", Value: m.Runtime, Reason: "is not supported by cgroup sandboxes"}
+	}
+	if m.Python_version != meta.DEFAULT_PYTHON_VERSION {
+		return &meta.IncompatibleError{
+			Field:  "python_version",
+			Value:  m.Python_version,
+			Reason: fmt.Sprintf("is not supported by cgroup sandboxes (only %s is)", meta.DEFAULT_PYTHON_VERSION),
+		}
+	}
+	if len(m.Layers) > 0 {
+		return &meta.IncompatibleError{Field: "layers", Value: strings.Join(m.Layers, ", "), Reason: "are not supported by cgroup sandboxes"}
+	}
+	if m.Apparmor_profile != "" {
+		return &meta.IncompatibleError{Field: "apparmor_profile", Value: m.Apparmor_profile, Reason: "is not supported by cgroup sandboxes"}
+	}
+	if m.Selinux_label != "" {
+		return &meta.IncompatibleError{Field: "selinux_label", Value: m.Selinux_label, Reason: "is not supported by cgroup sandboxes"}
+	}
+	if m.Scratch != "" {
+		return &meta.IncompatibleError{Field: "scratch", Value: m.Scratch, Reason: "is not supported by cgroup sandboxes"}
+	}
+	if m.Syscall_audit {
+		return &meta.IncompatibleError{Field: "syscall_audit", Value: "true", Reason: "is not supported by cgroup sandboxes"}
+	}
+	if m.Profile_slow {
+		return &meta.IncompatibleError{Field: "profile_slow", Value: "true", Reason: "is not supported by cgroup sandboxes"}
+	}
+	return nil
+}
+
 // Create creates a docker sandbox from the handler and sandbox directory.
-func (self *CgroupSBFactory) Create(handlerDir string, sandboxDir string) (Sandbox, error) {
+// The root filesystem is the Python lambda image, so only Python handlers of
+// the default version are supported.
+func (self *CgroupSBFactory) Create(ctx context.Context, handlerDir string, sandboxDir string, m *meta.HandlerMeta) (Sandbox, error) {
+	if m != nil {
+		if err := self.Check(m); err != nil {
+			return nil, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	root, err := ioutil.TempDir(os.TempDir(), "sandbox_")
 	if err != nil {
 		return nil, err
@@ -2,6 +2,7 @@ package sandbox
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/open-lambda/open-lambda/worker/config"
@@ -72,7 +73,7 @@ func TestCreate(t *testing.T) {
 		t.Fatal(err.Error())
 	}
 
-	s, err := factory.Create(handler_dir, sandbox_dir)
+	s, err := factory.Create(context.Background(), handler_dir, sandbox_dir, nil)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
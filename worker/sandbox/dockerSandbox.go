@@ -14,14 +14,17 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 	"os/exec"
 	"path/filepath"
 
+	log "github.com/Sirupsen/logrus"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/open-lambda/open-lambda/worker/dockerutil"
+	"github.com/open-lambda/open-lambda/worker/faults"
 	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/logging"
 )
 
 // DockerSandbox is a sandbox inside a docker container.
@@ -31,6 +34,8 @@ type DockerSandbox struct {
 	container   *docker.Container
 	client      *docker.Client
 	controllers string
+	cgroup      string // relative to the root of each hierarchy
+	scratch     *scratchVolume
 }
 
 // NewDockerSandbox creates a DockerSandbox.
@@ -43,9 +48,32 @@ func NewDockerSandbox(sandbox_dir string, container *docker.Container, client *d
 		controllers: "memory,cpu,devices,perf_event,cpuset,blkio,pids,freezer,net_cls,net_prio,hugetlb",
 	}
 
+	// docker creates the cgroup of the container under its parent
+	parent := "/docker"
+	if container.HostConfig != nil && container.HostConfig.CgroupParent != "" {
+		parent = container.HostConfig.CgroupParent
+	}
+	sandbox.cgroup = filepath.Join(parent, container.ID)
+
+	if container.Config != nil {
+		if kind := container.Config.Labels[dockerutil.DOCKER_LABEL_SCRATCH]; kind != "" {
+			sandbox.scratch = newScratchVolume(sandbox_dir, kind)
+		}
+	}
+
 	return sandbox
 }
 
+// log returns a logger carrying the ID of the container.
+func (s *DockerSandbox) log() *log.Entry {
+	return log.WithField(logging.SANDBOX_ID, s.container.ID)
+}
+
+// ID returns the ID of the container.
+func (s *DockerSandbox) ID() string {
+	return s.container.ID
+}
+
 // dockerError adds details (sandbox log, state, etc.) to an error.
 func (s *DockerSandbox) dockerError(outer error) (err error) {
 	buf := bytes.NewBufferString(outer.Error() + ".  ")
@@ -114,14 +142,17 @@ func (s *DockerSandbox) Channel() (channel *SandboxChannel, err error) {
 
 // Start starts the container.
 func (s *DockerSandbox) Start() error {
+	if err := faults.Inject(faults.SANDBOX, "start"); err != nil {
+		return err
+	}
 	if err := s.client.StartContainer(s.container.ID, nil); err != nil {
-		log.Printf("failed to start container with err %v\n", err)
+		s.log().WithError(err).Error("failed to start container")
 		return s.dockerError(err)
 	}
 
 	container, err := s.client.InspectContainer(s.container.ID)
 	if err != nil {
-		log.Printf("failed to inpect container with err %v\n", err)
+		s.log().WithError(err).Error("failed to inspect container")
 		return s.dockerError(err)
 	}
 	s.container = container
@@ -136,7 +167,7 @@ func (s *DockerSandbox) Stop() error {
 	// before killing?  (i.e., use SIGTERM instead SIGKILL)
 	opts := docker.KillContainerOptions{ID: s.container.ID}
 	if err := s.client.KillContainer(opts); err != nil {
-		log.Printf("failed to kill container with error %v\n", err)
+		s.log().WithError(err).Error("failed to kill container")
 		return s.dockerError(err)
 	}
 
@@ -145,8 +176,11 @@ func (s *DockerSandbox) Stop() error {
 
 // Pause pauses the container.
 func (s *DockerSandbox) Pause() error {
+	if err := faults.Inject(faults.SANDBOX, "pause"); err != nil {
+		return err
+	}
 	if err := s.client.PauseContainer(s.container.ID); err != nil {
-		log.Printf("failed to pause container with error %v\n", err)
+		s.log().WithError(err).Error("failed to pause container")
 		return s.dockerError(err)
 	}
 
@@ -155,8 +189,11 @@ func (s *DockerSandbox) Pause() error {
 
 // Unpause unpauses the container.
 func (s *DockerSandbox) Unpause() error {
+	if err := faults.Inject(faults.SANDBOX, "unpause"); err != nil {
+		return err
+	}
 	if err := s.client.UnpauseContainer(s.container.ID); err != nil {
-		log.Printf("failed to unpause container %s with err %v\n", s.container.Name, err)
+		s.log().WithError(err).Error("failed to unpause container")
 		return s.dockerError(err)
 	}
 
@@ -168,10 +205,15 @@ func (s *DockerSandbox) Remove() error {
 	if err := s.client.RemoveContainer(docker.RemoveContainerOptions{
 		ID: s.container.ID,
 	}); err != nil {
-		log.Printf("failed to rm container with err %v", err)
+		s.log().WithError(err).Error("failed to rm container")
 		return s.dockerError(err)
 	}
 
+	// the contents of the scratch volume are gone with it, and with a
+	// dm-crypt one, so is its key
+	if s.scratch != nil {
+		return s.scratch.remove()
+	}
 	return nil
 }
 
@@ -199,7 +241,7 @@ func (s *DockerSandbox) Logs() (string, error) {
 
 // Put the passed process into the cgroup of this docker container.
 func (s *DockerSandbox) CGroupEnter(pid string) (err error) {
-	cgroup := fmt.Sprintf("%s:/docker/%s", s.controllers, s.container.ID)
+	cgroup := fmt.Sprintf("%s:%s", s.controllers, s.cgroup)
 	cmd := exec.Command("cgclassify", "--sticky", "-g", cgroup, pid)
 
 	if err := cmd.Run(); err != nil {
@@ -209,6 +251,11 @@ func (s *DockerSandbox) CGroupEnter(pid string) (err error) {
 	return nil
 }
 
+// Stats samples the resource usage of the cgroup of the docker container.
+func (s *DockerSandbox) Stats() (*Stats, error) {
+	return ReadCgroupStats(s.cgroup)
+}
+
 // NSPid returns the pid of the first process of the docker container.
 func (s *DockerSandbox) NSPid() string {
 	return s.nspid
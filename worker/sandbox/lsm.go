@@ -0,0 +1,183 @@
+package sandbox
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+// where the Linux security modules expose their state; variables so that
+// tests can fake them
+var (
+	apparmorFs = "/sys/kernel/security/apparmor"
+	selinuxFs  = "/sys/fs/selinux"
+)
+
+// special values that lift the confinement of a sandbox instead of naming a
+// profile or label, which handlers may only ask for if the worker allows
+// them and privilege opt-outs
+const (
+	APPARMOR_UNCONFINED = "unconfined"
+	SELINUX_DISABLE     = "disable"
+)
+
+// selinuxFields are the parts of an SELinux label docker can set.
+var selinuxFields = []string{"user", "role", "type", "level"}
+
+// securityProfile returns the AppArmor profile and SELinux label of the
+// sandbox of the handler: its own, as accepted by checkSecurityProfile, or
+// else the defaults of the worker.
+func securityProfile(opts *config.Config, m *meta.HandlerMeta) (apparmor, selinux string) {
+	apparmor, selinux = opts.Default_apparmor_profile, opts.Default_selinux_label
+	if m.Apparmor_profile != "" {
+		apparmor = m.Apparmor_profile
+	}
+	if m.Selinux_label != "" {
+		selinux = m.Selinux_label
+	}
+	return apparmor, selinux
+}
+
+// lsmOpts returns the docker security options that apply the AppArmor
+// profile and SELinux label, if set.
+func lsmOpts(apparmor, selinux string) []string {
+	var opts []string
+	if apparmor != "" {
+		opts = append(opts, "apparmor="+apparmor)
+	}
+	if selinux != "" {
+		opts = append(opts, "label="+selinux)
+	}
+	return opts
+}
+
+// checkSecurityProfile returns a *meta.IncompatibleError if the AppArmor
+// profile or SELinux label of the handler is neither the default of the
+// worker nor one it allows, lifts its confinement on a worker that does not
+// allow opt-outs, or cannot be applied on this host. Handler code does not
+// get to pick its own confinement.
+func checkSecurityProfile(opts *config.Config, m *meta.HandlerMeta) error {
+	if p := m.Apparmor_profile; p != "" && p != opts.Default_apparmor_profile && !contains(opts.Allowed_apparmor_profiles, p) {
+		return &meta.IncompatibleError{
+			Field:  "apparmor_profile",
+			Value:  p,
+			Reason: "is not allowed on this worker (not its default_apparmor_profile nor in allowed_apparmor_profiles)",
+		}
+	}
+	if l := m.Selinux_label; l != "" && l != opts.Default_selinux_label && !contains(opts.Allowed_selinux_labels, l) {
+		return &meta.IncompatibleError{
+			Field:  "selinux_label",
+			Value:  l,
+			Reason: "is not allowed on this worker (not its default_selinux_label nor in allowed_selinux_labels)",
+		}
+	}
+	if !opts.Allow_privilege_opt_out {
+		if m.Apparmor_profile == APPARMOR_UNCONFINED {
+			return &meta.IncompatibleError{
+				Field:  "apparmor_profile",
+				Value:  m.Apparmor_profile,
+				Reason: "is not allowed on this worker (no allow_privilege_opt_out)",
+			}
+		}
+		if m.Selinux_label == SELINUX_DISABLE {
+			return &meta.IncompatibleError{
+				Field:  "selinux_label",
+				Value:  m.Selinux_label,
+				Reason: "is not allowed on this worker (no allow_privilege_opt_out)",
+			}
+		}
+	}
+	if m.Apparmor_profile != "" {
+		if reason := apparmorUnavailable(m.Apparmor_profile); reason != "" {
+			return &meta.IncompatibleError{Field: "apparmor_profile", Value: m.Apparmor_profile, Reason: reason}
+		}
+	}
+	if m.Selinux_label != "" {
+		if reason := selinuxUnavailable(m.Selinux_label); reason != "" {
+			return &meta.IncompatibleError{Field: "selinux_label", Value: m.Selinux_label, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// checkDefaultSecurityProfile verifies that the default AppArmor profile and
+// SELinux label of the worker can be applied on this host.
+func checkDefaultSecurityProfile(opts *config.Config) error {
+	if p := opts.Default_apparmor_profile; p != "" {
+		if reason := apparmorUnavailable(p); reason != "" {
+			return fmt.Errorf("default_apparmor_profile %q %s", p, reason)
+		}
+	}
+	if l := opts.Default_selinux_label; l != "" {
+		if reason := selinuxUnavailable(l); reason != "" {
+			return fmt.Errorf("default_selinux_label %q %s", l, reason)
+		}
+	}
+	return nil
+}
+
+// contains reports whether the list has the value.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// apparmorUnavailable returns why the AppArmor profile cannot be applied on
+// this host, or "" if it can, i.e. AppArmor is enabled and the profile is
+// loaded.
+func apparmorUnavailable(profile string) string {
+	f, err := os.Open(filepath.Join(apparmorFs, "profiles"))
+	if os.IsNotExist(err) {
+		return "cannot be applied, as AppArmor is not enabled on this host"
+	} else if err != nil {
+		return fmt.Sprintf("cannot be applied, as the AppArmor profiles could not be listed: %v", err)
+	}
+	defer f.Close()
+
+	if profile == APPARMOR_UNCONFINED {
+		return ""
+	}
+	// each line is "<name> (<mode>)"
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.LastIndex(line, " ("); i >= 0 && line[:i] == profile {
+			return ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Sprintf("cannot be applied, as the AppArmor profiles could not be listed: %v", err)
+	}
+	return "is not loaded on this host (load it with apparmor_parser)"
+}
+
+// selinuxUnavailable returns why the SELinux label cannot be applied on this
+// host, or "" if it can. Labels are given as docker takes them, i.e. as
+// <field>:<value> where field is user, role, type or level, or "disable".
+func selinuxUnavailable(label string) string {
+	if label != SELINUX_DISABLE {
+		parts := strings.SplitN(label, ":", 2)
+		known := false
+		for _, field := range selinuxFields {
+			known = known || parts[0] == field
+		}
+		if !known || len(parts) < 2 || parts[1] == "" {
+			return "is not a label docker can set (<field>:<value>, where field is one of " + strings.Join(selinuxFields, ", ") + ")"
+		}
+	}
+	if _, err := os.Stat(filepath.Join(selinuxFs, "enforce")); os.IsNotExist(err) {
+		return "cannot be applied, as SELinux is not enabled on this host"
+	} else if err != nil {
+		return fmt.Sprintf("cannot be applied, as the state of SELinux is unknown: %v", err)
+	}
+	return ""
+}
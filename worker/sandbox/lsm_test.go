@@ -0,0 +1,92 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+func TestSecurityProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lsm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(a, s string) { apparmorFs, selinuxFs = a, s }(apparmorFs, selinuxFs)
+	apparmorFs, selinuxFs = filepath.Join(dir, "apparmor"), filepath.Join(dir, "selinux")
+
+	opts := &config.Config{
+		Default_apparmor_profile:  "ol-sandbox",
+		Allowed_apparmor_profiles: []string{"ol-handler", "docker-default", APPARMOR_UNCONFINED},
+		Allowed_selinux_labels:    []string{"type:container_t", "container_t", "level:s0:c1,c2"},
+	}
+	m := meta.Default()
+	m.Apparmor_profile = "ol-handler"
+
+	// neither module enabled
+	if err := checkDefaultSecurityProfile(opts); err == nil {
+		t.Fatal("expected default profile to be refused without AppArmor")
+	}
+	if _, ok := checkSecurityProfile(opts, m).(*meta.IncompatibleError); !ok {
+		t.Fatal("expected handler profile to be refused without AppArmor")
+	}
+
+	os.MkdirAll(apparmorFs, 0755)
+	ioutil.WriteFile(filepath.Join(apparmorFs, "profiles"), []byte("ol-sandbox (enforce)\ndocker-default (enforce)\n"), 0644)
+	if err := checkDefaultSecurityProfile(opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSecurityProfile(opts, m); err == nil {
+		t.Fatal("expected profile that is not loaded to be refused")
+	}
+	m.Apparmor_profile = "docker-default"
+	if err := checkSecurityProfile(opts, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// handlers cannot pick a profile or label the worker does not allow
+	m.Apparmor_profile = "docker-unconfined"
+	if err, ok := checkSecurityProfile(opts, m).(*meta.IncompatibleError); !ok || err.Field != "apparmor_profile" {
+		t.Fatalf("expected profile outside the allowlist to be refused, got %v", err)
+	}
+	m.Apparmor_profile, m.Selinux_label = "", "type:spc_t"
+	if err, ok := checkSecurityProfile(opts, m).(*meta.IncompatibleError); !ok || err.Field != "selinux_label" {
+		t.Fatalf("expected label outside the allowlist to be refused, got %v", err)
+	}
+	m.Selinux_label = ""
+
+	// lifting confinement is an opt-out
+	m.Apparmor_profile = APPARMOR_UNCONFINED
+	if err := checkSecurityProfile(opts, m); err == nil {
+		t.Fatal("expected unconfined to be refused")
+	}
+	opts.Allow_privilege_opt_out = true
+	if err := checkSecurityProfile(opts, m); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Apparmor_profile, m.Selinux_label = "", "type:container_t"
+	if err := checkSecurityProfile(opts, m); err == nil {
+		t.Fatal("expected label to be refused without SELinux")
+	}
+	os.MkdirAll(selinuxFs, 0755)
+	ioutil.WriteFile(filepath.Join(selinuxFs, "enforce"), []byte("1"), 0644)
+	if err := checkSecurityProfile(opts, m); err != nil {
+		t.Fatal(err)
+	}
+	m.Selinux_label = "container_t"
+	if err := checkSecurityProfile(opts, m); err == nil {
+		t.Fatal("expected malformed label to be refused")
+	}
+
+	m.Selinux_label = "level:s0:c1,c2"
+	got := lsmOpts(securityProfile(opts, m))
+	if want := []string{"apparmor=ol-sandbox", "label=level:s0:c1,c2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
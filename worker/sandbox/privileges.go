@@ -0,0 +1,136 @@
+package sandbox
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+// NO_NEW_PRIVILEGES is the docker security option that keeps the processes
+// of a container from gaining privileges, e.g. through setuid binaries.
+const NO_NEW_PRIVILEGES = "no-new-privileges"
+
+// FORK_DROP_CAPS_ENV tells the forkservers of a pool which capabilities the
+// servers they fork drop (see PoolPrivileges).
+const FORK_DROP_CAPS_ENV = "OL_FORK_DROP_CAPS"
+
+// forkCaps are the capabilities pool containers need on top of the
+// sandbox_caps to join the namespaces of sandboxes (setns takes SYS_ADMIN,
+// and SYS_CHROOT for the mount namespace), and the number of each. SETPCAP
+// lets forked servers drop them from their bounding set, and so goes last.
+var forkCaps = []struct {
+	name string
+	num  int
+}{
+	{"SYS_ADMIN", 21},
+	{"SYS_CHROOT", 18},
+	{"SETPCAP", 8},
+}
+
+// privileges returns the Linux capabilities kept by the container of a
+// sandbox for the handler, all others being dropped, and its docker security
+// options. The sandbox_caps of the worker are kept, along with those the
+// handler adds if the worker allows privilege opt-outs; the handler's
+// new_privileges opt-out is likewise ignored unless allowed (Check refuses
+// such handlers beforehand).
+func privileges(opts *config.Config, m *meta.HandlerMeta) (caps []string, securityOpt []string) {
+	seen := make(map[string]bool)
+	add := func(names []string) {
+		for _, name := range names {
+			name = capName(name)
+			if !seen[name] {
+				seen[name] = true
+				caps = append(caps, name)
+			}
+		}
+	}
+	add(opts.Sandbox_caps)
+
+	p := m.Privileges
+	if p != nil && opts.Allow_privilege_opt_out {
+		add(p.Cap_add)
+	}
+	if p == nil || !p.New_privileges || !opts.Allow_privilege_opt_out {
+		securityOpt = append(securityOpt, NO_NEW_PRIVILEGES)
+	}
+	return caps, securityOpt
+}
+
+// checkPrivileges returns a *meta.IncompatibleError if the handler opts out
+// of the hardening of its sandbox on a worker that does not allow it.
+func checkPrivileges(opts *config.Config, m *meta.HandlerMeta) error {
+	p := m.Privileges
+	if p == nil || opts.Allow_privilege_opt_out {
+		return nil
+	}
+	if len(p.Cap_add) > 0 {
+		return &meta.IncompatibleError{
+			Field:  "privileges.cap_add",
+			Value:  strings.Join(p.Cap_add, ", "),
+			Reason: "are not allowed on this worker (no allow_privilege_opt_out)",
+		}
+	}
+	if p.New_privileges {
+		return &meta.IncompatibleError{
+			Field:  "privileges.new_privileges",
+			Reason: "is not allowed on this worker (no allow_privilege_opt_out)",
+		}
+	}
+	return nil
+}
+
+// PoolPrivileges returns the capabilities kept by the container of a pool,
+// all others being dropped, its docker security options and the environment
+// of its forkservers. Handler code of pooled runtimes runs in servers forked
+// from the pool rather than in the container of the sandbox, so the pool
+// container gets the hardening of sandboxes, and the forkenter capabilities
+// it needs besides, which forked servers drop before running handler code
+// (see server-pool/nsmodule). Their confinement is that of the pool, i.e.,
+// the default AppArmor profile and SELinux label of the worker.
+func PoolPrivileges(opts *config.Config) (caps []string, securityOpt []string, env []string) {
+	caps, securityOpt = privileges(opts, &meta.HandlerMeta{})
+	drop := []string{}
+	for _, fc := range forkCaps {
+		if !contains(caps, fc.name) {
+			caps = append(caps, fc.name)
+			drop = append(drop, strconv.Itoa(fc.num))
+		}
+	}
+	securityOpt = append(securityOpt, lsmOpts(opts.Default_apparmor_profile, opts.Default_selinux_label)...)
+	env = []string{FORK_DROP_CAPS_ENV + "=" + strings.Join(drop, ",")}
+	return caps, securityOpt, env
+}
+
+// checkPooled returns a *meta.IncompatibleError if a handler of a pooled
+// runtime asks for privileges or confinement of its own: its servers are
+// forked from the pool, and get those of every pooled server (see
+// PoolPrivileges).
+func checkPooled(opts *config.Config, m *meta.HandlerMeta) error {
+	if !Pooled(opts, m.Runtime) {
+		return nil
+	}
+	var field, value string
+	switch {
+	case m.Privileges != nil:
+		field = "privileges"
+	case m.Apparmor_profile != "" && m.Apparmor_profile != opts.Default_apparmor_profile:
+		field, value = "apparmor_profile", m.Apparmor_profile
+	case m.Selinux_label != "" && m.Selinux_label != opts.Default_selinux_label:
+		field, value = "selinux_label", m.Selinux_label
+	default:
+		return nil
+	}
+	return &meta.IncompatibleError{
+		Field:  field,
+		Value:  value,
+		Reason: "is not supported for pooled runtimes (see pool_runtimes)",
+	}
+}
+
+// capName normalizes the name of a capability to the form docker expects,
+// e.g. "cap_net_raw" to "NET_RAW".
+func capName(name string) string {
+	return strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "CAP_")
+}
@@ -0,0 +1,90 @@
+package sandbox
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+func TestPrivileges(t *testing.T) {
+	opts := &config.Config{Sandbox_caps: []string{"CHOWN", "cap_kill"}}
+	m := meta.Default()
+
+	caps, securityOpt := privileges(opts, m)
+	if !reflect.DeepEqual(caps, []string{"CHOWN", "KILL"}) {
+		t.Fatalf("unexpected capabilities %v", caps)
+	}
+	if !reflect.DeepEqual(securityOpt, []string{NO_NEW_PRIVILEGES}) {
+		t.Fatalf("unexpected security options %v", securityOpt)
+	}
+	if err := checkPrivileges(opts, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// opt-outs are refused, and ignored, unless the worker allows them
+	m.Privileges = &meta.Privileges{Cap_add: []string{"NET_RAW", "KILL"}, New_privileges: true}
+	if err := checkPrivileges(opts, m); err == nil {
+		t.Fatal("expected opt-out to be refused")
+	} else if _, ok := err.(*meta.IncompatibleError); !ok {
+		t.Fatalf("expected IncompatibleError, got %v", err)
+	}
+	caps, securityOpt = privileges(opts, m)
+	if len(caps) != 2 || len(securityOpt) != 1 {
+		t.Fatalf("opt-out honored without allow_privilege_opt_out: %v %v", caps, securityOpt)
+	}
+
+	opts.Allow_privilege_opt_out = true
+	if err := checkPrivileges(opts, m); err != nil {
+		t.Fatal(err)
+	}
+	caps, securityOpt = privileges(opts, m)
+	if !reflect.DeepEqual(caps, []string{"CHOWN", "KILL", "NET_RAW"}) {
+		t.Fatalf("unexpected capabilities %v", caps)
+	}
+	if len(securityOpt) != 0 {
+		t.Fatalf("unexpected security options %v", securityOpt)
+	}
+}
+
+func TestPoolPrivileges(t *testing.T) {
+	opts := &config.Config{
+		Pool:                     "docker",
+		Pool_runtimes:            []string{meta.PYTHON},
+		Sandbox_caps:             []string{"CHOWN", "SETPCAP"},
+		Default_apparmor_profile: "ol-sandbox",
+	}
+
+	// forked servers drop what the pool only needs to join sandboxes
+	caps, securityOpt, env := PoolPrivileges(opts)
+	if !reflect.DeepEqual(caps, []string{"CHOWN", "SETPCAP", "SYS_ADMIN", "SYS_CHROOT"}) {
+		t.Fatalf("unexpected capabilities %v", caps)
+	}
+	if !reflect.DeepEqual(securityOpt, []string{NO_NEW_PRIVILEGES, "apparmor=ol-sandbox"}) {
+		t.Fatalf("unexpected security options %v", securityOpt)
+	}
+	if !reflect.DeepEqual(env, []string{FORK_DROP_CAPS_ENV + "=21,18"}) {
+		t.Fatalf("unexpected environment %v", env)
+	}
+
+	// pooled handlers cannot have privileges or confinement of their own
+	opts.Allow_privilege_opt_out = true
+	m := meta.Default()
+	m.Privileges = &meta.Privileges{Cap_add: []string{"NET_RAW"}}
+	if err, ok := checkPooled(opts, m).(*meta.IncompatibleError); !ok || err.Field != "privileges" {
+		t.Fatalf("expected privileges of a pooled handler to be refused, got %v", err)
+	}
+	m.Privileges, m.Apparmor_profile = nil, "ol-sandbox"
+	if err := checkPooled(opts, m); err != nil {
+		t.Fatal(err)
+	}
+	m.Apparmor_profile = "ol-handler"
+	if err, ok := checkPooled(opts, m).(*meta.IncompatibleError); !ok || err.Field != "apparmor_profile" {
+		t.Fatalf("expected profile of a pooled handler to be refused, got %v", err)
+	}
+	m.Runtime = meta.NODEJS
+	if err := checkPooled(opts, m); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,29 @@
+package sandbox
+
+import (
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+// checkProfileSlow returns a *meta.IncompatibleError if the handler asks for
+// stack dumps of its slow requests (profile_slow) on a worker that does not
+// allow them, or that could not take them: dumps are taken with py-spy from
+// the host, of the processes of the sandbox container, so only Python
+// handlers whose code runs in their own container can have them.
+func checkProfileSlow(opts *config.Config, m *meta.HandlerMeta) error {
+	if !m.Profile_slow {
+		return nil
+	}
+	reason := ""
+	switch {
+	case !opts.Allow_profile_slow:
+		reason = "is not allowed on this worker (no allow_profile_slow)"
+	case m.Runtime != meta.PYTHON:
+		reason = "is only supported for python handlers"
+	case Pooled(opts, m.Runtime):
+		reason = "is not supported for pooled runtimes (see pool_runtimes)"
+	default:
+		return nil
+	}
+	return &meta.IncompatibleError{Field: "profile_slow", Value: "true", Reason: reason}
+}
@@ -0,0 +1,37 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+func TestCheckProfileSlow(t *testing.T) {
+	opts := &config.Config{Pool: "docker", Pool_runtimes: []string{meta.RUBY}}
+	m := meta.Default()
+	if err := checkProfileSlow(opts, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// dumps run on the host, so the worker must allow them
+	m.Profile_slow = true
+	if _, ok := checkProfileSlow(opts, m).(*meta.IncompatibleError); !ok {
+		t.Fatal("expected profile_slow to be refused without allow_profile_slow")
+	}
+	opts.Allow_profile_slow = true
+	if err := checkProfileSlow(opts, m); err != nil {
+		t.Fatal(err)
+	}
+
+	// py-spy only dumps Python, and cannot find the servers of pools
+	m.Runtime = meta.NODEJS
+	if _, ok := checkProfileSlow(opts, m).(*meta.IncompatibleError); !ok {
+		t.Fatal("expected profile_slow to be refused for nodejs")
+	}
+	m.Runtime = meta.PYTHON
+	opts.Pool_runtimes = []string{meta.PYTHON}
+	if _, ok := checkProfileSlow(opts, m).(*meta.IncompatibleError); !ok {
+		t.Fatal("expected profile_slow to be refused for a pooled runtime")
+	}
+}
@@ -0,0 +1,26 @@
+package sandbox
+
+import (
+	"fmt"
+	"time"
+)
+
+// READY_TIMEOUT is how long a lambda server may take to start listening.
+const READY_TIMEOUT = 10 * time.Second
+
+// WaitReady waits until the lambda server behind ch accepts connections, which
+// is how runtimes signal that they are ready to serve.
+func WaitReady(ch *SandboxChannel, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := ch.Transport.Dial("unix", SOCK_PATH)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("lambda server not listening on %s after %v: %v", SOCK_PATH, timeout, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
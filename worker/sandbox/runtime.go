@@ -0,0 +1,116 @@
+package sandbox
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/dockerutil"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+// Runtime describes how to start the lambda server for handlers written in
+// one language.
+type Runtime struct {
+	// docker image containing the language and its lambda server
+	Image string
+
+	// command starting the lambda server in the image
+	Cmd []string
+
+	// environment of the lambda server, in addition to ol.config
+	Env []string
+
+	// command of sandboxes whose lambda server is forked in by the pool
+	// manager, for runtimes that can be pooled
+	PoolCmd []string
+}
+
+// paths of the custom runtime contract (see the README)
+const (
+	SOCK_PATH    = "/host/ol.sock"
+	HANDLER_PATH = "/handler"
+)
+
+var runtimes = map[string]*Runtime{
+	meta.PYTHON: {
+		Image:   dockerutil.BASE_IMAGE,
+		Cmd:     []string{"/usr/bin/python", "/server.py"},
+		PoolCmd: []string{"/init"},
+	},
+	meta.RUBY: {
+		Image:   dockerutil.RUBY_IMAGE,
+		Cmd:     []string{"ruby", "/server.rb"},
+		PoolCmd: []string{"sleep", "infinity"},
+	},
+	meta.R: {
+		Image:   dockerutil.R_IMAGE,
+		Cmd:     []string{"Rscript", "/server.R"},
+		PoolCmd: []string{"sleep", "infinity"},
+	},
+	meta.NODEJS: {Image: dockerutil.NODEJS_IMAGE, Cmd: []string{"node", "/server.js"}},
+	meta.GO:     {Image: dockerutil.GO_IMAGE, Cmd: []string{HANDLER_PATH + "/" + meta.GO_BINARY}},
+	meta.JAVA:   {Image: dockerutil.JAVA_IMAGE, Cmd: []string{"/start.sh"}},
+	meta.DOTNET: {Image: dockerutil.DOTNET_IMAGE, Cmd: []string{"dotnet", "/opt/ol/Dispatcher.dll"}},
+	meta.EXEC:   {Image: dockerutil.EXEC_IMAGE, Cmd: []string{"/ol-exec"}},
+	meta.CUSTOM: {
+		Image: dockerutil.CUSTOM_IMAGE,
+		Cmd:   []string{HANDLER_PATH + "/" + meta.CUSTOM_ENTRYPOINT},
+		Env:   []string{"OL_SOCK=" + SOCK_PATH, "OL_HANDLER_DIR=" + HANDLER_PATH},
+	},
+}
+
+// LookupRuntime returns the Runtime of the given name.
+func LookupRuntime(name string) (*Runtime, error) {
+	rt, ok := runtimes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown runtime %q", name)
+	}
+	return rt, nil
+}
+
+// Pooled returns true if the lambda servers of the runtime are forked into
+// sandboxes by the pool manager.
+func Pooled(opts *config.Config, runtime string) bool {
+	if opts.Pool == "" || runtimes[runtime] == nil || runtimes[runtime].PoolCmd == nil {
+		return false
+	}
+	for _, name := range opts.Pool_runtimes {
+		if name == runtime {
+			return true
+		}
+	}
+	return false
+}
+
+// PreloadEnv returns the environment telling the servers of a runtime which
+// libraries to preload, if it supports preloading.
+func PreloadEnv(opts *config.Config, runtime string) []string {
+	switch runtime {
+	case meta.RUBY:
+		return []string{"OL_PRELOAD=" + strings.Join(opts.Ruby_preload, ",")}
+	case meta.R:
+		return []string{"OL_PRELOAD=" + strings.Join(opts.R_preload, ",")}
+	}
+	return nil
+}
+
+// PythonVersions returns the Python versions available on a worker.
+func PythonVersions(opts *config.Config) []string {
+	return append([]string{meta.DEFAULT_PYTHON_VERSION}, opts.Python_versions...)
+}
+
+// PythonImage returns the name of the image (prefixed by base, e.g.
+// dockerutil.BASE_IMAGE) that provides the given Python version.
+func PythonImage(opts *config.Config, base, version string) (string, error) {
+	if version == "" || version == meta.DEFAULT_PYTHON_VERSION {
+		return base, nil
+	}
+	for _, v := range opts.Python_versions {
+		if v == version {
+			return base + "-python" + version, nil
+		}
+	}
+	return "", fmt.Errorf("python %s is not available on this worker (available: %s)",
+		version, strings.Join(PythonVersions(opts), ", "))
+}
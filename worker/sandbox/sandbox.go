@@ -19,6 +19,9 @@ type SandboxChannel struct {
 }
 
 type Sandbox interface {
+	// Unique identifier of a given sandbox (e.g., container ID)
+	ID() string
+
 	// Starts a given sandbox
 	Start() error
 
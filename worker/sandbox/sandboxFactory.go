@@ -1,27 +1,60 @@
 package sandbox
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/open-lambda/open-lambda/worker/config"
 	"github.com/open-lambda/open-lambda/worker/dockerutil"
+	"github.com/open-lambda/open-lambda/worker/faults"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/layers"
+	"github.com/open-lambda/open-lambda/worker/tlsutil"
 )
 
 // SandboxFactory is the common interface for all sandbox creation functions.
+// The metadata of the handler selects its runtime, among other things; nil
+// means the defaults. Creation gives up once ctx is done, without leaving a
+// sandbox behind.
 type SandboxFactory interface {
-	Create(handlerDir string, sandboxDir string) (sandbox Sandbox, err error)
+	Create(ctx context.Context, handlerDir string, sandboxDir string, m *meta.HandlerMeta) (sandbox Sandbox, err error)
+
+	// Check verifies that sandboxes can be created for the handler, i.e.
+	// that the worker provides its runtime, version and layers, returning
+	// a *meta.IncompatibleError otherwise.
+	Check(m *meta.HandlerMeta) error
+}
+
+// Recoverable is implemented by SandboxFactories whose sandboxes outlive the
+// worker, so that a restarted worker can adopt or remove them.
+type Recoverable interface {
+	// Leftovers returns the sandboxes created for this worker by earlier
+	// runs, by ID.
+	Leftovers() (map[string]Sandbox, error)
+}
+
+// LayerCache is implemented by SandboxFactories that keep the layers mounted
+// in their sandboxes on the worker.
+type LayerCache interface {
+	// PruneLayers removes the cached layers not referenced by keep, and
+	// returns how many it removed.
+	PruneLayers(keep []string) (int, error)
 }
 
 // DockerSBFactory is a SandboxFactory that creats docker sandboxes.
 type DockerSBFactory struct {
-	client *docker.Client
-	cmd    []string
-	labels map[string]string
-	env    []string
+	client  *docker.Client
+	opts    *config.Config
+	labels  map[string]string
+	env     []string
+	layers  *layers.Store  // nil if no layer registry is configured
+	tenants *tenantCgroups // nil unless tenant_cgroups is set
 }
 
 // emptySBInfo wraps sandbox information necessary for the buffer.
@@ -34,9 +67,13 @@ type emptySBInfo struct {
 // BufferedSBFactory maintains a buffer of sandboxes created by another factory.
 type BufferedSBFactory struct {
 	delegate SandboxFactory
+	tenants  bool // whether sandboxes are placed in tenant cgroups
 	buffer   chan *emptySBInfo
 	errors   chan error
 	mntDir   string
+
+	mutex    sync.Mutex
+	buffered map[string]bool // IDs of the sandboxes waiting in the buffer
 }
 
 // NewDockerSBFactory creates a DockerSBFactory.
@@ -49,46 +86,262 @@ func NewDockerSBFactory(opts *config.Config) (*DockerSBFactory, error) {
 	labels := map[string]string{
 		dockerutil.DOCKER_LABEL_CLUSTER: opts.Cluster_name,
 		dockerutil.DOCKER_LABEL_TYPE:    dockerutil.SANDBOX,
+		dockerutil.DOCKER_LABEL_WORKER:  opts.WorkerId(),
 	}
 	env := []string{fmt.Sprintf("ol.config=%s", opts.SandboxConfJson())}
-	var cmd []string
-	if opts.Pool == "" {
-		cmd = []string{"/usr/bin/python", "/server.py"}
-	} else {
-		cmd = []string{"/init"}
+
+	if err := checkDefaultSecurityProfile(opts); err != nil {
+		return nil, err
 	}
 
-	df := &DockerSBFactory{c, cmd, labels, env}
+	df := &DockerSBFactory{client: c, opts: opts, labels: labels, env: env}
+	df.tenants = newTenantCgroups(opts)
+	if opts.Layer_registry != "" {
+		df.layers = layers.NewStore(opts.Layer_registry, filepath.Join(opts.Worker_dir, "layers"))
+		if reloader, err := tlsutil.New(opts.Tls); err != nil {
+			return nil, err
+		} else if reloader != nil {
+			df.layers.UseTLS(reloader.ClientConfig())
+		}
+	}
 	return df, nil
 }
 
 // Create creates a docker sandbox from the handler and sandbox directory.
-func (df *DockerSBFactory) Create(handlerDir string, sandboxDir string) (Sandbox, error) {
+func (df *DockerSBFactory) Create(ctx context.Context, handlerDir string, sandboxDir string, m *meta.HandlerMeta) (sb Sandbox, err error) {
+	if m == nil {
+		m = meta.Default()
+	}
+	rt, err := LookupRuntime(m.Runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	image := rt.Image
+	if m.Runtime == meta.PYTHON {
+		if image, err = PythonImage(df.opts, rt.Image, m.Python_version); err != nil {
+			return nil, err
+		}
+	}
+
+	// servers of pooled runtimes are forked into the container by the
+	// pool manager; the container only runs a dummy process
+	cmd := rt.Cmd
+	if Pooled(df.opts, m.Runtime) {
+		cmd = rt.PoolCmd
+	}
+
+	env := append(append([]string{}, df.env...), rt.Env...)
+	env = append(env, PreloadEnv(df.opts, m.Runtime)...)
+
 	volumes := []string{
 		fmt.Sprintf("%s:%s:ro,slave", handlerDir, "/handler"),
 		fmt.Sprintf("%s:%s:slave", sandboxDir, "/host"),
 	}
+
+	if len(m.Layers) > 0 {
+		if df.layers == nil {
+			return nil, fmt.Errorf("handler uses layers, but no layer_registry is configured")
+		}
+		ls, err := df.layers.Resolve(m.Layers)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range ls {
+			volumes = append(volumes, fmt.Sprintf("%s:%s:ro", l.Dir, l.MountPoint()))
+		}
+		env = append(env, layers.Env(ls)...)
+	}
+
+	// the scratch volume is created before the container, and removed with
+	// it; the label lets Leftovers find the volumes of old containers
+	labels := df.labels
+	var scratch *scratchVolume
+	if m.Scratch != "" {
+		scratch = newScratchVolume(sandboxDir, m.Scratch)
+		if err := scratch.create(m.Scratch_mb); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				scratch.remove()
+			}
+		}()
+		volumes = append(volumes, fmt.Sprintf("%s:%s", scratch.dir, SCRATCH_DIR))
+		env = append(env, "TMPDIR="+SCRATCH_DIR)
+		labels = make(map[string]string, len(df.labels)+1)
+		for k, v := range df.labels {
+			labels[k] = v
+		}
+		labels[dockerutil.DOCKER_LABEL_SCRATCH] = m.Scratch
+	}
+
+	// handler code is untrusted: drop the capabilities it does not need
+	caps, securityOpt := privileges(df.opts, m)
+	securityOpt = append(securityOpt, lsmOpts(securityProfile(df.opts, m))...)
+	if m.Syscall_audit {
+		securityOpt = append(securityOpt, auditProfile())
+	}
+
+	hostConfig := &docker.HostConfig{
+		Binds:       volumes,
+		CapDrop:     []string{"ALL"},
+		CapAdd:      caps,
+		SecurityOpt: securityOpt,
+	}
+	if df.tenants != nil {
+		if hostConfig.CgroupParent, err = df.tenants.parent(tenantOf(m)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the docker client cannot be interrupted, so check before and after
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := faults.Inject(faults.SANDBOX, "create"); err != nil {
+		return nil, err
+	}
+	config := &docker.Config{
+		Image:  image,
+		Labels: labels,
+		Env:    env,
+		Cmd:    cmd,
+	}
 	container, err := df.client.CreateContainer(
 		docker.CreateContainerOptions{
-			Config: &docker.Config{
-				Image:  dockerutil.BASE_IMAGE,
-				Labels: df.labels,
-				Env:    df.env,
-				Cmd:    df.cmd,
-			},
-			HostConfig: &docker.HostConfig{
-				Binds: volumes,
-			},
+			Config:     config,
+			HostConfig: hostConfig,
 		},
 	)
 	if err != nil {
 		return nil, err
 	}
+	// the response only has the ID
+	container.Config, container.HostConfig = config, hostConfig
+	if err := ctx.Err(); err != nil {
+		df.client.RemoveContainer(docker.RemoveContainerOptions{ID: container.ID, Force: true})
+		return nil, err
+	}
 
 	sandbox := NewDockerSandbox(sandboxDir, container, df.client)
 	return sandbox, nil
 }
 
+// Check verifies that the image of the runtime of the handler is available,
+// that layers can be mounted if it needs them, that the worker allows the
+// privileges it asks for, that its AppArmor profile or SELinux label can be
+// applied (and that a pooled runtime asks for neither), that its scratch volume is no larger than the worker allows and
+// can be created, that it can run in syscall audit mode if asked, and that
+// its tenant can name a cgroup if sandboxes are placed in tenant cgroups.
+func (df *DockerSBFactory) Check(m *meta.HandlerMeta) error {
+	if err := checkPrivileges(df.opts, m); err != nil {
+		return err
+	}
+	if err := checkPooled(df.opts, m); err != nil {
+		return err
+	}
+	if err := checkSecurityProfile(df.opts, m); err != nil {
+		return err
+	}
+	if err := checkScratch(df.opts, m); err != nil {
+		return err
+	}
+	if err := checkSyscallAudit(df.opts, m); err != nil {
+		return err
+	}
+	if err := checkProfileSlow(df.opts, m); err != nil {
+		return err
+	}
+	if df.tenants != nil {
+		if err := checkTenant(m); err != nil {
+			return err
+		}
+	}
+
+	rt, err := LookupRuntime(m.Runtime)
+	if err != nil {
+		return &meta.IncompatibleError{Field: "runtime", Value: m.Runtime, Reason: "is not supported by this worker"}
+	}
+
+	field, value, image := "runtime", m.Runtime, rt.Image
+	if m.Runtime == meta.PYTHON {
+		field, value = "python_version", m.Python_version
+		if image, err = PythonImage(df.opts, rt.Image, m.Python_version); err != nil {
+			return &meta.IncompatibleError{
+				Field:  field,
+				Value:  value,
+				Reason: "is not available on this worker (available: " + strings.Join(PythonVersions(df.opts), ", ") + ")",
+			}
+		}
+	}
+	if ok, err := dockerutil.ImageExists(df.client, image); err != nil {
+		return err
+	} else if !ok {
+		return &meta.IncompatibleError{
+			Field:  field,
+			Value:  value,
+			Reason: fmt.Sprintf("is not available on this worker (no %s image)", image),
+		}
+	}
+
+	if len(m.Layers) > 0 && df.layers == nil {
+		return &meta.IncompatibleError{
+			Field:  "layers",
+			Value:  strings.Join(m.Layers, ", "),
+			Reason: "cannot be mounted, as this worker has no layer_registry",
+		}
+	}
+	return nil
+}
+
+// Leftovers returns the containers labeled with the ID of this worker. The
+// sandbox directory of each is found from its mount at /host.
+func (df *DockerSBFactory) Leftovers() (map[string]Sandbox, error) {
+	opts := docker.ListContainersOptions{
+		All: true,
+		Filters: map[string][]string{
+			"label": []string{
+				dockerutil.DOCKER_LABEL_CLUSTER + "=" + df.opts.Cluster_name,
+				dockerutil.DOCKER_LABEL_TYPE + "=" + dockerutil.SANDBOX,
+				dockerutil.DOCKER_LABEL_WORKER + "=" + df.opts.WorkerId(),
+			},
+		},
+	}
+	containers, err := df.client.ListContainers(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	sandboxes := make(map[string]Sandbox)
+	for _, info := range containers {
+		container, err := df.client.InspectContainer(info.ID)
+		if _, ok := err.(*docker.NoSuchContainer); ok {
+			continue // removed since it was listed
+		} else if err != nil {
+			return nil, err
+		}
+		sandboxDir := ""
+		for _, mount := range container.Mounts {
+			if mount.Destination == "/host" {
+				sandboxDir = mount.Source
+			}
+		}
+		sandbox := NewDockerSandbox(sandboxDir, container, df.client)
+		sandbox.nspid = fmt.Sprintf("%d", container.State.Pid)
+		sandboxes[container.ID] = sandbox
+	}
+	return sandboxes, nil
+}
+
+// PruneLayers removes cached layers that are not in keep.
+func (df *DockerSBFactory) PruneLayers(keep []string) (int, error) {
+	if df.layers == nil {
+		return 0, nil
+	}
+	return df.layers.Prune(keep)
+}
+
 // mkSBDirs makes the handler and sandbox directories and tries to unmount them.
 func mkSBDirs(bufDir string) (string, string, error) {
 	if err := os.MkdirAll(bufDir, os.ModeDir); err != nil {
@@ -114,7 +367,7 @@ func mkSBDirs(bufDir string) (string, string, error) {
 // NewBufferedSBFactory creates a BufferedSBFactory and starts a go routine to
 // fill the sandbox buffer.
 func NewBufferedSBFactory(opts *config.Config, delegate SandboxFactory) (*BufferedSBFactory, error) {
-	bf := &BufferedSBFactory{}
+	bf := &BufferedSBFactory{buffered: make(map[string]bool), tenants: opts.Tenant_cgroups}
 	bf.delegate = delegate
 	bf.buffer = make(chan *emptySBInfo, opts.Sandbox_buffer-1) // -1 for the last one blocking the channel
 	bf.errors = make(chan error, opts.Sandbox_buffer-1)
@@ -132,7 +385,7 @@ func NewBufferedSBFactory(opts *config.Config, delegate SandboxFactory) (*Buffer
 			if handlerDir, sandboxDir, err := mkSBDirs(bufDir); err != nil {
 				bf.buffer <- nil
 				bf.errors <- err
-			} else if sandbox, err := bf.delegate.Create(handlerDir, sandboxDir); err != nil {
+			} else if sandbox, err := bf.delegate.Create(context.Background(), handlerDir, sandboxDir, nil); err != nil {
 				bf.buffer <- nil
 				bf.errors <- err
 			} else if err := sandbox.Start(); err != nil {
@@ -142,6 +395,7 @@ func NewBufferedSBFactory(opts *config.Config, delegate SandboxFactory) (*Buffer
 				bf.buffer <- nil
 				bf.errors <- err
 			} else {
+				bf.setBuffered(sandbox.ID(), true)
 				bf.buffer <- &emptySBInfo{sandbox, handlerDir, sandboxDir}
 				bf.errors <- nil
 			}
@@ -154,10 +408,27 @@ func NewBufferedSBFactory(opts *config.Config, delegate SandboxFactory) (*Buffer
 
 // Create mounts the handler and sandbox directories to the ones already
 // mounted in the sandbox, and returns that sandbox. The sandbox would be in
-// Paused state, instead of Stopped.
-func (bf *BufferedSBFactory) Create(handlerDir string, sandboxDir string) (Sandbox, error) {
+// Paused state, instead of Stopped. Handlers a buffered sandbox does not fit
+// (see needsDedicatedSandbox) get one created by the delegate on demand.
+func (bf *BufferedSBFactory) Create(ctx context.Context, handlerDir string, sandboxDir string, m *meta.HandlerMeta) (Sandbox, error) {
+	if bf.needsDedicatedSandbox(m) {
+		return bf.delegate.Create(ctx, handlerDir, sandboxDir, m)
+	}
+
+	// wait for the buffer to be refilled, unless the caller gives up
+	var info *emptySBInfo
+	select {
+	case info = <-bf.buffer:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if info != nil {
+		bf.setBuffered(info.sandbox.ID(), false)
+	}
+
 	mntFlag := uintptr(syscall.MS_BIND | syscall.MS_REC)
-	if info, err := <-bf.buffer, <-bf.errors; err != nil {
+	if err := <-bf.errors; err != nil {
 		return nil, err
 	} else if err := syscall.Mount(handlerDir, info.handlerDir, "", mntFlag, ""); err != nil {
 		return nil, err
@@ -167,3 +438,73 @@ func (bf *BufferedSBFactory) Create(handlerDir string, sandboxDir string) (Sandb
 		return info.sandbox, nil
 	}
 }
+
+// Check checks with the delegate.
+func (bf *BufferedSBFactory) Check(m *meta.HandlerMeta) error {
+	return bf.delegate.Check(m)
+}
+
+// needsDedicatedSandbox reports whether a handler needs a sandbox of its own
+// rather than a buffered one. Only sandboxes of the default runtime and
+// Python version, without layers, are buffered, so handlers of other
+// runtimes or versions, or with layers, need their own, as do those asking
+// for privileges, their own AppArmor profile or SELinux label, a scratch
+// volume or syscall audit mode, or whose sandboxes go in the cgroup of a
+// tenant.
+func (bf *BufferedSBFactory) needsDedicatedSandbox(m *meta.HandlerMeta) bool {
+	if m == nil {
+		return false
+	}
+	switch {
+	case m.Runtime != meta.PYTHON, m.Python_version != meta.DEFAULT_PYTHON_VERSION, len(m.Layers) > 0:
+		return true
+	case m.Privileges != nil, m.Apparmor_profile != "", m.Selinux_label != "":
+		return true
+	case m.Scratch != "", m.Syscall_audit:
+		return true
+	case bf.tenants && m.Tenant != "":
+		return true
+	}
+	return false
+}
+
+// Leftovers returns the leftovers of the delegate, if it is Recoverable,
+// except the sandboxes waiting in the buffer. Those buffered by earlier runs
+// are included.
+func (bf *BufferedSBFactory) Leftovers() (map[string]Sandbox, error) {
+	r, ok := bf.delegate.(Recoverable)
+	if !ok {
+		return map[string]Sandbox{}, nil
+	}
+	sandboxes, err := r.Leftovers()
+	if err != nil {
+		return nil, err
+	}
+
+	bf.mutex.Lock()
+	defer bf.mutex.Unlock()
+	for id := range bf.buffered {
+		delete(sandboxes, id)
+	}
+	return sandboxes, nil
+}
+
+// PruneLayers prunes the layers of the delegate, if it caches any.
+func (bf *BufferedSBFactory) PruneLayers(keep []string) (int, error) {
+	if lc, ok := bf.delegate.(LayerCache); ok {
+		return lc.PruneLayers(keep)
+	}
+	return 0, nil
+}
+
+// setBuffered records whether the sandbox with the given ID is waiting in
+// the buffer.
+func (bf *BufferedSBFactory) setBuffered(id string, buffered bool) {
+	bf.mutex.Lock()
+	defer bf.mutex.Unlock()
+	if buffered {
+		bf.buffered[id] = true
+	} else {
+		delete(bf.buffered, id)
+	}
+}
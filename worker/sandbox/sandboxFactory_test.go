@@ -1,6 +1,7 @@
 package sandbox
 
 import (
+	"context"
 	"log"
 	"os"
 	"testing"
@@ -8,6 +9,7 @@ import (
 
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
 )
 
 func getConf() *config.Config {
@@ -63,7 +65,7 @@ func TestWriteToDirs(t *testing.T) {
 		t.Fatalf("cannot create sandbox directory: ", err)
 	}
 
-	if sandbox, err := dockerSbFactory.Create(handlerDir, sandboxDir); err != nil {
+	if sandbox, err := dockerSbFactory.Create(context.Background(), handlerDir, sandboxDir, nil); err != nil {
 		t.Fatalf("fail to create sandbox: ", err)
 	} else if err := sandbox.Start(); err != nil {
 		t.Fatalf("fail to start sandbox: ", err)
@@ -87,3 +89,23 @@ func TestWriteToDirs(t *testing.T) {
 		time.Sleep(time.Second)
 	}
 }
+
+func TestNeedsDedicatedSandbox(t *testing.T) {
+	bf := &BufferedSBFactory{tenants: true}
+	if bf.needsDedicatedSandbox(nil) || bf.needsDedicatedSandbox(meta.Default()) {
+		t.Fatalf("default handlers should use buffered sandboxes")
+	}
+	for _, m := range []*meta.HandlerMeta{
+		{Runtime: meta.PYTHON, Python_version: meta.DEFAULT_PYTHON_VERSION, Syscall_audit: true},
+		{Runtime: meta.PYTHON, Python_version: meta.DEFAULT_PYTHON_VERSION, Tenant: "t"},
+		{Runtime: "go"},
+	} {
+		if !bf.needsDedicatedSandbox(m) {
+			t.Fatalf("expected %+v to need a dedicated sandbox", m)
+		}
+	}
+	bf.tenants = false
+	if bf.needsDedicatedSandbox(&meta.HandlerMeta{Runtime: meta.PYTHON, Python_version: meta.DEFAULT_PYTHON_VERSION, Tenant: "t"}) {
+		t.Fatalf("tenants only need their own sandboxes with tenant cgroups")
+	}
+}
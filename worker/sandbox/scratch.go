@@ -0,0 +1,190 @@
+package sandbox
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+// SCRATCH_DIR is where the scratch volume of a sandbox is mounted in it. It
+// is also the TMPDIR of the sandbox.
+const SCRATCH_DIR = "/scratch"
+
+// SCRATCH_KEY_BYTES is the size of the random key of a dm-crypt scratch
+// volume (AES-256 in XTS mode takes two 256-bit keys).
+const SCRATCH_KEY_BYTES = 64
+
+// scratchVolume is a writable volume of a sandbox whose contents never touch
+// the disk in plaintext: a tmpfs, or a dm-crypt mapping of a sparse image
+// file, encrypted with a random key that is handed to the kernel and then
+// forgotten, so the contents are lost with the mapping.
+type scratchVolume struct {
+	kind  string // meta.SCRATCH_TMPFS or meta.SCRATCH_DMCRYPT
+	dir   string // mount point on the worker
+	image string // backing file of a dm-crypt volume
+	name  string // dm-crypt mapping
+}
+
+// newScratchVolume returns the scratch volume of the kind for the sandbox
+// directory, without creating it. The paths are derived from the sandbox
+// directory, so that the volumes of leftover sandboxes can be found.
+func newScratchVolume(sandboxDir, kind string) *scratchVolume {
+	sum := sha1.Sum([]byte(sandboxDir))
+	return &scratchVolume{
+		kind:  kind,
+		dir:   sandboxDir + ".scratch",
+		image: sandboxDir + ".scratch.img",
+		name:  "ol-scratch-" + hex.EncodeToString(sum[:8]),
+	}
+}
+
+// checkScratch returns a *meta.IncompatibleError if the scratch volume of
+// the handler is larger than the worker allows, or the worker lacks the
+// tools to create it.
+func checkScratch(opts *config.Config, m *meta.HandlerMeta) error {
+	if m.Scratch != "" && m.Scratch_mb > opts.Max_scratch_mb {
+		return &meta.IncompatibleError{
+			Field:  "scratch_mb",
+			Value:  fmt.Sprintf("%d", m.Scratch_mb),
+			Reason: fmt.Sprintf("exceeds the max_scratch_mb of this worker (%d)", opts.Max_scratch_mb),
+		}
+	}
+	if m.Scratch != meta.SCRATCH_DMCRYPT {
+		return nil
+	}
+	for _, tool := range []string{"cryptsetup", "losetup", "mkfs.ext4"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return &meta.IncompatibleError{
+				Field:  "scratch",
+				Value:  m.Scratch,
+				Reason: fmt.Sprintf("is not available on this worker (no %s)", tool),
+			}
+		}
+	}
+	return nil
+}
+
+// create creates and mounts the volume, of the given size. On failure, what
+// was created is removed.
+func (v *scratchVolume) create(mb int) (err error) {
+	defer func() {
+		if err != nil {
+			v.remove()
+		}
+	}()
+
+	if err := os.MkdirAll(v.dir, 0700); err != nil {
+		return err
+	}
+	if v.kind == meta.SCRATCH_TMPFS {
+		opts := fmt.Sprintf("size=%dm,mode=1777", mb)
+		return runTool(nil, "mount", "-t", "tmpfs", "-o", opts, "tmpfs", v.dir)
+	}
+
+	// a sparse image only takes the space actually written
+	f, err := os.OpenFile(v.image, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(int64(mb) << 20)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	loop, err := toolOutput("losetup", "--find", "--show", v.image)
+	if err != nil {
+		return err
+	}
+	key := make([]byte, SCRATCH_KEY_BYTES)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	err = runTool(key, "cryptsetup", "open", "--type", "plain", "--cipher", "aes-xts-plain64",
+		"--key-size", fmt.Sprintf("%d", SCRATCH_KEY_BYTES*8), "--key-file", "-", loop, v.name)
+	for i := range key {
+		key[i] = 0
+	}
+	if err != nil {
+		return err
+	}
+
+	device := "/dev/mapper/" + v.name
+	if err := runTool(nil, "mkfs.ext4", "-q", device); err != nil {
+		return err
+	}
+	if err := runTool(nil, "mount", device, v.dir); err != nil {
+		return err
+	}
+	return os.Chmod(v.dir, 01777)
+}
+
+// remove unmounts the volume and destroys it. Steps that find nothing to
+// undo are skipped, so it cleans up after partial creations too.
+func (v *scratchVolume) remove() error {
+	var first error
+	fail := func(err error) {
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	if err := syscall.Unmount(v.dir, 0); err != nil && err != syscall.EINVAL && err != syscall.ENOENT {
+		fail(fmt.Errorf("could not unmount scratch volume: %v", err))
+	}
+	if v.kind == meta.SCRATCH_DMCRYPT {
+		if _, err := os.Stat("/dev/mapper/" + v.name); err == nil {
+			fail(runTool(nil, "cryptsetup", "close", v.name))
+		}
+		if loops, err := toolOutput("losetup", "--associated", v.image); err == nil {
+			for _, line := range strings.Split(loops, "\n") {
+				// each line is "<device>: <details>"
+				if device := strings.SplitN(line, ":", 2)[0]; device != "" {
+					fail(runTool(nil, "losetup", "--detach", device))
+				}
+			}
+		}
+		if err := os.Remove(v.image); err != nil && !os.IsNotExist(err) {
+			fail(err)
+		}
+	}
+	if err := os.Remove(v.dir); err != nil && !os.IsNotExist(err) {
+		fail(err)
+	}
+
+	if first != nil {
+		log.WithError(first).WithField("volume", v.dir).Error("could not remove scratch volume")
+	}
+	return first
+}
+
+// runTool runs a command with stdin as its input, failing with its output.
+func runTool(stdin []byte, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// toolOutput runs a command, and returns its trimmed output.
+func toolOutput(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %v", name, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,58 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+func TestScratchVolume(t *testing.T) {
+	a := newScratchVolume("/sandboxes/1", meta.SCRATCH_DMCRYPT)
+	if b := newScratchVolume("/sandboxes/1", meta.SCRATCH_DMCRYPT); *a != *b {
+		t.Fatal("volumes of the same sandbox directory differ")
+	}
+	if b := newScratchVolume("/sandboxes/2", meta.SCRATCH_DMCRYPT); a.name == b.name || a.dir == b.dir {
+		t.Fatal("volumes of different sandbox directories collide")
+	}
+
+	// scratch areas larger than the worker allows are refused
+	opts := &config.Config{Max_scratch_mb: 1024}
+	if err, ok := checkScratch(opts, &meta.HandlerMeta{Scratch: meta.SCRATCH_TMPFS, Scratch_mb: 2048}).(*meta.IncompatibleError); !ok || err.Field != "scratch_mb" {
+		t.Fatalf("expected scratch_mb over the limit to be refused, got %v", err)
+	}
+
+	// without the tools, dm-crypt volumes are refused, tmpfs ones are not
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", "")
+	if err, ok := checkScratch(opts, &meta.HandlerMeta{Scratch: meta.SCRATCH_DMCRYPT}).(*meta.IncompatibleError); !ok || err.Field != "scratch" {
+		t.Fatalf("expected dm-crypt to be refused without cryptsetup, got %v", err)
+	}
+	if err := checkScratch(opts, &meta.HandlerMeta{Scratch: meta.SCRATCH_TMPFS}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PATH", path)
+
+	// removing a volume that was partially created (or not at all) cleans
+	// up what is there
+	dir, err := ioutil.TempDir("", "scratch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	v := newScratchVolume(filepath.Join(dir, "sb"), meta.SCRATCH_TMPFS)
+	if err := v.remove(); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(v.dir, 0700)
+	if err := v.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(v.dir); !os.IsNotExist(err) {
+		t.Fatal("scratch directory not removed")
+	}
+}
@@ -0,0 +1,126 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"syscall"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+// USUAL_SYSCALLS are the syscalls the runtimes of handlers make in the
+// normal course of serving requests; sandboxes in syscall audit mode make
+// them without a trace. Opening connections (socket, connect, bind, listen)
+// is deliberately not among them.
+var USUAL_SYSCALLS = []string{
+	"accept", "accept4", "access", "alarm", "arch_prctl", "brk", "capget", "chdir",
+	"chmod", "chown", "clock_getres", "clock_gettime", "clock_nanosleep", "close", "dup",
+	"dup2", "dup3", "epoll_create", "epoll_create1", "epoll_ctl", "epoll_pwait",
+	"epoll_wait", "eventfd", "eventfd2", "execve", "exit", "exit_group", "faccessat",
+	"faccessat2", "fadvise64", "fchdir", "fchmod", "fchmodat", "fchown", "fchownat",
+	"fcntl", "fdatasync", "fgetxattr", "flock", "fork", "fstat", "fstatfs", "fsync",
+	"ftruncate", "futex", "get_robust_list", "getcwd", "getdents", "getdents64",
+	"getegid", "geteuid", "getgid", "getgroups", "getitimer", "getpeername", "getpgid",
+	"getpgrp", "getpid", "getppid", "getpriority", "getrandom", "getrlimit", "getrusage",
+	"getsid", "getsockname", "getsockopt", "gettid", "gettimeofday", "getuid", "getxattr",
+	"ioctl", "kill", "lchown", "lgetxattr", "link", "linkat", "lseek", "lstat", "madvise",
+	"membarrier", "mincore", "mkdir", "mkdirat", "mlock", "mmap", "mprotect", "mremap",
+	"msync", "munlock", "munmap", "nanosleep", "newfstatat", "open", "openat", "pipe",
+	"pipe2", "poll", "ppoll", "prctl", "pread64", "prlimit64", "pselect6", "pwrite64",
+	"read", "readlink", "readlinkat", "readv", "recvfrom", "recvmsg", "rename",
+	"renameat", "renameat2", "restart_syscall", "rmdir", "rseq", "rt_sigaction",
+	"rt_sigprocmask", "rt_sigreturn", "sched_getaffinity", "sched_getparam",
+	"sched_getscheduler", "sched_setaffinity", "sched_yield", "select", "sendmsg",
+	"sendto", "set_robust_list", "set_tid_address", "setitimer", "setsockopt", "shutdown",
+	"sigaltstack", "signalfd4", "socketpair", "stat", "statfs", "statx", "symlink",
+	"symlinkat", "sysinfo", "tgkill", "time", "timerfd_create", "timerfd_gettime",
+	"timerfd_settime", "times", "tkill", "truncate", "umask", "uname", "unlink",
+	"unlinkat", "utimensat", "vfork", "wait4", "waitid", "write", "writev",
+}
+
+// REFUSED_SYSCALLS are refused (with EPERM) in syscall audit mode, as docker's
+// default profile, which the audit profile replaces, refuses them in
+// unprivileged containers. The attempts are recorded all the same.
+var REFUSED_SYSCALLS = []string{
+	"acct", "add_key", "bpf", "clock_adjtime", "clock_settime", "delete_module",
+	"finit_module", "init_module", "ioperm", "iopl", "kcmp", "kexec_file_load",
+	"kexec_load", "keyctl", "lookup_dcookie", "mount", "move_pages", "name_to_handle_at",
+	"open_by_handle_at", "perf_event_open", "pivot_root", "process_vm_readv",
+	"process_vm_writev", "ptrace", "quotactl", "reboot", "request_key", "setns",
+	"settimeofday", "swapoff", "swapon", "umount2", "unshare", "userfaultfd",
+}
+
+// CLONE_NEWUSER is the flag of clone creating a user namespace, in which an
+// unprivileged process regains capabilities.
+const CLONE_NEWUSER = 0x10000000
+
+// seccompProfile is a seccomp profile, in the format of docker.
+type seccompProfile struct {
+	DefaultAction string        `json:"defaultAction"`
+	Flags         []string      `json:"flags,omitempty"`
+	Syscalls      []seccompRule `json:"syscalls"`
+}
+
+type seccompRule struct {
+	Names    []string     `json:"names"`
+	Action   string       `json:"action"`
+	ErrnoRet *uint        `json:"errnoRet,omitempty"`
+	Args     []seccompArg `json:"args,omitempty"`
+}
+
+type seccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo"`
+	Op       string `json:"op"`
+}
+
+// auditProfile returns the seccomp profile of sandboxes in syscall audit
+// mode, as the value of docker's seccomp security option. Syscalls other
+// than the USUAL_SYSCALLS are logged by the kernel, for sysaudit to record,
+// and let through, but for the REFUSED_SYSCALLS and clones into new user
+// namespaces, which fail (the flag has refusals logged too). As with docker's
+// default profile, clone3 is reported as not implemented, since its flags
+// cannot be inspected, and libc falls back to clone.
+func auditProfile() string {
+	eperm, enosys := uint(syscall.EPERM), uint(syscall.ENOSYS)
+	p := seccompProfile{
+		DefaultAction: "SCMP_ACT_LOG",
+		Flags:         []string{"SECCOMP_FILTER_FLAG_LOG"},
+		Syscalls: []seccompRule{
+			{Names: USUAL_SYSCALLS, Action: "SCMP_ACT_ALLOW"},
+			{Names: REFUSED_SYSCALLS, Action: "SCMP_ACT_ERRNO", ErrnoRet: &eperm},
+			{
+				Names:  []string{"clone"},
+				Action: "SCMP_ACT_ALLOW",
+				Args:   []seccompArg{{Index: 0, Value: CLONE_NEWUSER, ValueTwo: 0, Op: "SCMP_CMP_MASKED_EQ"}},
+			},
+			{
+				Names:    []string{"clone"},
+				Action:   "SCMP_ACT_ERRNO",
+				ErrnoRet: &eperm,
+				Args:     []seccompArg{{Index: 0, Value: CLONE_NEWUSER, ValueTwo: CLONE_NEWUSER, Op: "SCMP_CMP_MASKED_EQ"}},
+			},
+			{Names: []string{"clone3"}, Action: "SCMP_ACT_ERRNO", ErrnoRet: &enosys},
+		},
+	}
+	buf, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+	return "seccomp=" + string(buf)
+}
+
+// checkSyscallAudit returns a *meta.IncompatibleError if the sandboxes of
+// the handler cannot run in syscall audit mode: the processes of pooled
+// runtimes are forked from the pool, outside of the profile of the sandbox.
+func checkSyscallAudit(opts *config.Config, m *meta.HandlerMeta) error {
+	if m.Syscall_audit && Pooled(opts, m.Runtime) {
+		return &meta.IncompatibleError{
+			Field:  "syscall_audit",
+			Value:  "true",
+			Reason: "is not supported for pooled runtimes (see pool_runtimes)",
+		}
+	}
+	return nil
+}
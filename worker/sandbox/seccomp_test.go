@@ -0,0 +1,51 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+func TestAuditProfile(t *testing.T) {
+	opt := auditProfile()
+	if !strings.HasPrefix(opt, "seccomp=") {
+		t.Fatalf("unexpected security option %q", opt)
+	}
+	var p seccompProfile
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(opt, "seccomp=")), &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.DefaultAction != "SCMP_ACT_LOG" {
+		t.Fatalf("unusual syscalls not logged: %s", p.DefaultAction)
+	}
+
+	// a syscall is either usual or refused, and connections are neither
+	actions := make(map[string]string)
+	for _, rule := range p.Syscalls {
+		for _, name := range rule.Names {
+			if prev, ok := actions[name]; ok && len(rule.Args) == 0 {
+				t.Fatalf("%s is both %s and %s", name, prev, rule.Action)
+			}
+			actions[name] = rule.Action
+		}
+	}
+	for _, name := range []string{"socket", "connect", "bind", "listen"} {
+		if action, ok := actions[name]; ok {
+			t.Fatalf("%s is %s rather than logged", name, action)
+		}
+	}
+
+	opts := &config.Config{Pool: "/pool", Pool_runtimes: []string{meta.PYTHON}}
+	m := meta.Default()
+	m.Syscall_audit = true
+	if _, ok := checkSyscallAudit(opts, m).(*meta.IncompatibleError); !ok {
+		t.Fatal("expected syscall audit of pooled runtime to be refused")
+	}
+	opts.Pool = ""
+	if err := checkSyscallAudit(opts, m); err != nil {
+		t.Fatal(err)
+	}
+}
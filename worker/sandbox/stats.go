@@ -0,0 +1,65 @@
+package sandbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// CGROUP_ROOT is where the cgroup (v1) hierarchies are mounted.
+const CGROUP_ROOT = "/sys/fs/cgroup"
+
+// Stats is a sample of the resource usage of a sandbox.
+type Stats struct {
+	MemoryBytes int64   `json:"memory_bytes"`
+	CPUSeconds  float64 `json:"cpu_seconds"` // cumulative
+	Pids        int64   `json:"pids"`
+}
+
+// StatsSandbox is implemented by sandboxes that can report their resource
+// usage.
+type StatsSandbox interface {
+	Sandbox
+
+	// Sample current resource usage
+	Stats() (*Stats, error)
+}
+
+// ReadCgroupStats reads the usage of the cgroup at path (relative to the root
+// of each hierarchy, e.g., "/docker/<id>") from the memory, cpuacct and pids
+// controllers.
+func ReadCgroupStats(path string) (*Stats, error) {
+	mem, err := readCgroupInt("memory", path, "memory.usage_in_bytes")
+	if err != nil {
+		return nil, err
+	}
+	cpu, err := readCgroupInt("cpuacct", path, "cpuacct.usage")
+	if err != nil {
+		return nil, err
+	}
+	pids, err := readCgroupInt("pids", path, "pids.current")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stats{
+		MemoryBytes: mem,
+		CPUSeconds:  float64(cpu) / 1e9,
+		Pids:        pids,
+	}, nil
+}
+
+// readCgroupInt reads a file holding a single integer from a cgroup.
+func readCgroupInt(controller, path, file string) (int64, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(CGROUP_ROOT, controller, path, file))
+	if err != nil {
+		return 0, err
+	}
+	val, err := strconv.ParseInt(strings.TrimSpace(string(buf)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse %s of cgroup %s: %v", file, path, err)
+	}
+	return val, nil
+}
@@ -0,0 +1,131 @@
+package sandbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+// DEFAULT_TENANT is the tenant whose cgroup holds the sandboxes of handlers
+// that do not name one.
+const DEFAULT_TENANT = "default"
+
+// CFS_PERIOD_US is the period over which the CPU time of a tenant is capped.
+const CFS_PERIOD_US = 100000
+
+// tenant names become cgroup names, so they must be safe path components
+var tenantName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// tenantCgroups manages a parent cgroup (v1) per tenant, under which the
+// cgroups of the sandboxes of its handlers are created, so that limits on
+// the parent apply to all of them together.
+type tenantCgroups struct {
+	fs     string // where the hierarchies are mounted, CGROUP_ROOT but in tests
+	root   string
+	limits map[string]config.TenantLimits
+
+	mutex sync.Mutex
+	ready map[string]bool // tenants whose cgroup has its limits
+}
+
+// newTenantCgroups creates a tenantCgroups for the config, or returns nil if
+// tenant cgroups are disabled.
+func newTenantCgroups(opts *config.Config) *tenantCgroups {
+	if !opts.Tenant_cgroups {
+		return nil
+	}
+	return &tenantCgroups{
+		fs:     CGROUP_ROOT,
+		root:   opts.Tenant_cgroup_root,
+		limits: opts.Tenant_limits,
+		ready:  make(map[string]bool),
+	}
+}
+
+// tenantOf returns the tenant whose cgroup holds the sandboxes of the
+// handler.
+func tenantOf(m *meta.HandlerMeta) string {
+	if m.Tenant == "" {
+		return DEFAULT_TENANT
+	}
+	return m.Tenant
+}
+
+// checkTenant returns a *meta.IncompatibleError if the tenant of the handler
+// cannot name a cgroup.
+func checkTenant(m *meta.HandlerMeta) error {
+	if m.Tenant != "" && !tenantName.MatchString(m.Tenant) {
+		return &meta.IncompatibleError{
+			Field:  "tenant",
+			Value:  m.Tenant,
+			Reason: "cannot name a cgroup (letters, digits, '_', '.' and '-' only)",
+		}
+	}
+	return nil
+}
+
+// parent returns the cgroup of the tenant, relative to the root of each
+// hierarchy, creating it and applying its limits the first time. Tenants
+// not listed in the tenant_limits get those of "*", if any.
+func (tc *tenantCgroups) parent(tenant string) (string, error) {
+	cgroup := filepath.Join(tc.root, tenant)
+
+	tc.mutex.Lock()
+	defer tc.mutex.Unlock()
+	if tc.ready[tenant] {
+		return cgroup, nil
+	}
+
+	limits, ok := tc.limits[tenant]
+	if !ok {
+		limits = tc.limits["*"]
+	}
+
+	// limits left by an earlier run are overwritten, so that removing
+	// them from the config lifts them
+	memory, quota, shares := int64(-1), int64(-1), int64(1024)
+	if limits.Memory_mb > 0 {
+		memory = int64(limits.Memory_mb) << 20
+	}
+	if limits.Cpus > 0 {
+		quota = int64(limits.Cpus * CFS_PERIOD_US)
+	}
+	if limits.Cpu_shares > 0 {
+		shares = int64(limits.Cpu_shares)
+	}
+
+	memDir := filepath.Join(tc.fs, "memory", cgroup)
+	cpuDir := filepath.Join(tc.fs, "cpu", cgroup)
+	for _, dir := range []string{memDir, cpuDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("could not create cgroup of tenant %s: %v", tenant, err)
+		}
+	}
+	// hierarchical accounting is always on in recent kernels, which
+	// refuse to change it
+	ioutil.WriteFile(filepath.Join(memDir, "memory.use_hierarchy"), []byte("1"), 0644)
+
+	files := []struct {
+		dir, name string
+		value     int64
+	}{
+		{memDir, "memory.limit_in_bytes", memory},
+		{cpuDir, "cpu.cfs_period_us", CFS_PERIOD_US},
+		{cpuDir, "cpu.cfs_quota_us", quota},
+		{cpuDir, "cpu.shares", shares},
+	}
+	for _, f := range files {
+		if err := ioutil.WriteFile(filepath.Join(f.dir, f.name), []byte(fmt.Sprintf("%d", f.value)), 0644); err != nil {
+			return "", fmt.Errorf("could not limit cgroup of tenant %s: %v", tenant, err)
+		}
+	}
+
+	tc.ready[tenant] = true
+	return cgroup, nil
+}
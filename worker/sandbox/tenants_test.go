@@ -0,0 +1,81 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+func TestTenantCgroups(t *testing.T) {
+	fs, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(fs)
+
+	if newTenantCgroups(&config.Config{}) != nil {
+		t.Fatal("tenant cgroups without tenant_cgroups")
+	}
+	tc := newTenantCgroups(&config.Config{
+		Tenant_cgroups:     true,
+		Tenant_cgroup_root: "/openlambda",
+		Tenant_limits: map[string]config.TenantLimits{
+			"acme": {Memory_mb: 512, Cpus: 1.5, Cpu_shares: 2048},
+			"*":    {Memory_mb: 256},
+		},
+	})
+	tc.fs = fs
+
+	read := func(controller, cgroup, file string) string {
+		buf, err := ioutil.ReadFile(filepath.Join(fs, controller, cgroup, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(buf))
+	}
+
+	cgroup, err := tc.parent("acme")
+	if err != nil {
+		t.Fatal(err)
+	} else if cgroup != "/openlambda/acme" {
+		t.Fatalf("unexpected cgroup %s", cgroup)
+	}
+	if v := read("memory", cgroup, "memory.limit_in_bytes"); v != "536870912" {
+		t.Fatalf("unexpected memory limit %s", v)
+	}
+	if v := read("cpu", cgroup, "cpu.cfs_quota_us"); v != "150000" {
+		t.Fatalf("unexpected CPU quota %s", v)
+	}
+	if v := read("cpu", cgroup, "cpu.shares"); v != "2048" {
+		t.Fatalf("unexpected CPU shares %s", v)
+	}
+
+	// tenants not listed get the limits of "*"
+	m := meta.Default()
+	cgroup, err = tc.parent(tenantOf(m))
+	if err != nil {
+		t.Fatal(err)
+	} else if cgroup != "/openlambda/"+DEFAULT_TENANT {
+		t.Fatalf("unexpected cgroup %s", cgroup)
+	}
+	if v := read("memory", cgroup, "memory.limit_in_bytes"); v != "268435456" {
+		t.Fatalf("unexpected memory limit %s", v)
+	}
+	if v := read("cpu", cgroup, "cpu.cfs_quota_us"); v != "-1" {
+		t.Fatalf("unexpected CPU quota %s", v)
+	}
+
+	m.Tenant = "../escape"
+	if _, ok := checkTenant(m).(*meta.IncompatibleError); !ok {
+		t.Fatal("expected tenant that cannot name a cgroup to be refused")
+	}
+	m.Tenant = "team-a.prod"
+	if err := checkTenant(m); err != nil {
+		t.Fatal(err)
+	}
+}
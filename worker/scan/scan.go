@@ -0,0 +1,347 @@
+// scan package vets handler code before it runs. An external scanner,
+// run as a command or called over HTTP, inspects each version of the code
+// pulled from the registry, e.g. for malware, leaked secrets or banned
+// packages, and may veto it. Verdicts are cached by the digest of the code,
+// so each version is scanned once per worker.
+//
+// A command scanner is run as "<path> <handler> <code dir>", with the digest
+// in the OL_DIGEST environment variable. Exiting with 0 allows the code, with
+// 1 vetoes it, its output being the reason; anything else is a failure of
+// the scanner. An HTTP scanner is POSTed the code as a tar.gz, with the
+// handler and digest in the HANDLER_HEADER and DIGEST_HEADER, and answers
+// 200 with a JSON Verdict.
+package scan
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+const (
+	EXEC_PREFIX    = "exec:"
+	HANDLER_HEADER = "X-OL-Handler"
+	DIGEST_HEADER  = "X-OL-Digest"
+
+	// number of verdicts cached before arbitrary ones are forgotten
+	CACHE_SIZE = 1024
+)
+
+// Results of a scan.
+const (
+	ALLOWED = "allowed"
+	VETOED  = "vetoed"
+	FAILED  = "failed"
+)
+
+var scansTotal = metrics.NewCounter(
+	"ol_scans_total",
+	"Number of scans of handler code, by result; cached verdicts are not counted.",
+	"result")
+
+// Verdict is the decision of a scanner on a version of handler code.
+type Verdict struct {
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Scanner inspects the code of a handler in dir, whose digest is given.
+type Scanner interface {
+	Scan(ctx context.Context, handler, dir, digest string) (*Verdict, error)
+}
+
+// VetoError is returned for code that the scanner vetoed.
+type VetoError struct {
+	Handler string
+	Digest  string
+	Reason  string
+}
+
+func (e *VetoError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("code of %s (%s) vetoed by scanner", e.Handler, e.Digest)
+	}
+	return fmt.Sprintf("code of %s (%s) vetoed by scanner: %s", e.Handler, e.Digest, e.Reason)
+}
+
+// Checker runs the scanner on code it has no verdict for. A nil *Checker is
+// valid and allows all code.
+type Checker struct {
+	scanner  Scanner
+	timeout  time.Duration
+	failOpen bool
+
+	mutex    sync.Mutex
+	verdicts map[string]*Verdict // by digest
+}
+
+// New creates a Checker for the scanner of the config, or returns nil if
+// none is configured.
+func New(conf *config.Config) (*Checker, error) {
+	var scanner Scanner
+	switch {
+	case conf.Scanner == "":
+		return nil, nil
+	case strings.HasPrefix(conf.Scanner, EXEC_PREFIX):
+		scanner = &execScanner{path: strings.TrimPrefix(conf.Scanner, EXEC_PREFIX)}
+	case strings.HasPrefix(conf.Scanner, "http://") || strings.HasPrefix(conf.Scanner, "https://"):
+		scanner = &httpScanner{url: conf.Scanner, client: &http.Client{}}
+	default:
+		return nil, fmt.Errorf("scanner must be exec:<path> or an http(s) URL, got %q", conf.Scanner)
+	}
+	return NewChecker(scanner, time.Duration(conf.Scan_timeout)*time.Second, conf.Scan_fail_open), nil
+}
+
+// NewChecker creates a Checker for the scanner. Scans taking longer than
+// timeout fail; failed scans allow the code if failOpen is set.
+func NewChecker(scanner Scanner, timeout time.Duration, failOpen bool) *Checker {
+	return &Checker{
+		scanner:  scanner,
+		timeout:  timeout,
+		failOpen: failOpen,
+		verdicts: make(map[string]*Verdict),
+	}
+}
+
+// Check returns a *VetoError if the scanner vetoes the code of the handler
+// in dir, and an error if it cannot be scanned, unless the Checker fails
+// open.
+func (c *Checker) Check(ctx context.Context, handler, dir string) error {
+	if c == nil {
+		return nil
+	}
+	digest, err := Digest(dir)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	v := c.verdicts[digest]
+	c.mutex.Unlock()
+
+	if v == nil {
+		if v, err = c.scan(ctx, handler, dir, digest); err != nil {
+			scansTotal.Inc(FAILED)
+			logger := log.WithFields(log.Fields{"handler": handler, "digest": digest}).WithError(err)
+			if c.failOpen {
+				logger.Warn("could not scan handler code, running it anyway")
+				return nil
+			}
+			return fmt.Errorf("could not scan code of %s: %v", handler, err)
+		}
+	}
+
+	if !v.Allowed {
+		return &VetoError{Handler: handler, Digest: digest, Reason: v.Reason}
+	}
+	return nil
+}
+
+// scan runs the scanner, and caches its verdict.
+func (c *Checker) scan(ctx context.Context, handler, dir, digest string) (*Verdict, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	v, err := c.scanner.Scan(ctx, handler, dir, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Allowed {
+		scansTotal.Inc(ALLOWED)
+	} else {
+		scansTotal.Inc(VETOED)
+		log.WithFields(log.Fields{"handler": handler, "digest": digest, "reason": v.Reason}).Warn("scanner vetoed handler code")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.verdicts) >= CACHE_SIZE {
+		for d := range c.verdicts {
+			delete(c.verdicts, d)
+			break
+		}
+	}
+	c.verdicts[digest] = v
+	return v, nil
+}
+
+// Digest returns the digest of the code in dir: a SHA-256 of the paths,
+// modes and contents of its files, and the targets of its links.
+func Digest(dir string) (string, error) {
+	hash := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hash, "%s\x00%o\x00", filepath.ToSlash(rel), info.Mode())
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			io.WriteString(hash, target)
+		case info.Mode().IsRegular():
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(hash, f); err != nil {
+				return err
+			}
+		}
+		hash.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "sha256-" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// execScanner runs a command on the code.
+type execScanner struct {
+	path string
+}
+
+func (s *execScanner) Scan(ctx context.Context, handler, dir, digest string) (*Verdict, error) {
+	cmd := exec.Command(s.path, handler, dir)
+	cmd.Env = append(os.Environ(), "OL_DIGEST="+digest)
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// in a process group of its own, so that processes it started are
+	// killed along with it on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return nil, ctx.Err()
+	}
+
+	reason := strings.TrimSpace(out.String())
+	if err == nil {
+		return &Verdict{Allowed: true}, nil
+	} else if exit, ok := err.(*exec.ExitError); ok && exit.Sys().(syscall.WaitStatus).ExitStatus() == 1 {
+		return &Verdict{Allowed: false, Reason: reason}, nil
+	}
+	return nil, fmt.Errorf("%v: %s", err, reason)
+}
+
+// httpScanner POSTs the code to a URL.
+type httpScanner struct {
+	url    string
+	client *http.Client
+}
+
+func (s *httpScanner) Scan(ctx context.Context, handler, dir, digest string) (*Verdict, error) {
+	var body bytes.Buffer
+	if err := archive(&body, dir); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", s.url, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set(HANDLER_HEADER, handler)
+	req.Header.Set(DIGEST_HEADER, digest)
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scanner returned %s", resp.Status)
+	}
+
+	v := &Verdict{}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("could not parse verdict: %v", err)
+	}
+	return v, nil
+}
+
+// archive writes the code in dir to w as a tar.gz.
+func archive(w io.Writer, dir string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
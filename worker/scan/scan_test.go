@@ -0,0 +1,186 @@
+package scan
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// countingScanner vetoes code containing a "banned" file, and counts scans.
+type countingScanner struct {
+	scans int
+	err   error
+}
+
+func (s *countingScanner) Scan(ctx context.Context, handler, dir, digest string) (*Verdict, error) {
+	s.scans++
+	if s.err != nil {
+		return nil, s.err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "banned")); err == nil {
+		return &Verdict{Allowed: false, Reason: "banned file"}, nil
+	}
+	return &Verdict{Allowed: true}, nil
+}
+
+func codeDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCheckCachesVerdicts(t *testing.T) {
+	good := codeDir(t, map[string]string{"lambda_func.py": "def handler(): pass"})
+	defer os.RemoveAll(good)
+	bad := codeDir(t, map[string]string{"lambda_func.py": "def handler(): pass", "banned": ""})
+	defer os.RemoveAll(bad)
+
+	scanner := &countingScanner{}
+	c := NewChecker(scanner, time.Second, false)
+	for i := 0; i < 2; i++ {
+		if err := c.Check(context.Background(), "good", good); err != nil {
+			t.Fatal(err)
+		}
+		if err, ok := c.Check(context.Background(), "bad", bad).(*VetoError); !ok || err.Reason != "banned file" {
+			t.Fatalf("expected veto, got %v", err)
+		}
+	}
+	if scanner.scans != 2 {
+		t.Fatalf("expected each version to be scanned once, got %d scans", scanner.scans)
+	}
+
+	// the same code under another name has the same verdict
+	if err := c.Check(context.Background(), "copy", good); err != nil || scanner.scans != 2 {
+		t.Fatalf("expected cached verdict, got %v after %d scans", err, scanner.scans)
+	}
+
+	// a new version is scanned again
+	ioutil.WriteFile(filepath.Join(good, "banned"), nil, 0644)
+	if _, ok := c.Check(context.Background(), "good", good).(*VetoError); !ok || scanner.scans != 3 {
+		t.Fatal("expected new version to be scanned and vetoed")
+	}
+
+	var nilChecker *Checker
+	if err := nilChecker.Check(context.Background(), "bad", bad); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckFailures(t *testing.T) {
+	dir := codeDir(t, map[string]string{"lambda_func.py": ""})
+	defer os.RemoveAll(dir)
+
+	scanner := &countingScanner{err: errors.New("scanner down")}
+	if err := NewChecker(scanner, time.Second, false).Check(context.Background(), "f", dir); err == nil {
+		t.Fatal("expected failed scan to refuse the code")
+	} else if _, ok := err.(*VetoError); ok {
+		t.Fatal("failed scan reported as veto")
+	}
+	if err := NewChecker(scanner, time.Second, true).Check(context.Background(), "f", dir); err != nil {
+		t.Fatalf("expected failed scan to allow the code when failing open, got %v", err)
+	}
+
+	// failures are not cached
+	scanner.err = nil
+	if err := NewChecker(scanner, time.Second, false).Check(context.Background(), "f", dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExecScanner(t *testing.T) {
+	dir := codeDir(t, map[string]string{
+		"scanner": "#!/bin/sh\nif [ -e \"$2/banned\" ]; then echo \"$1: banned in $OL_DIGEST\"; exit 1; fi\n",
+		"slow":    "#!/bin/sh\nsleep 5\n",
+		"broken":  "#!/bin/sh\necho oops; exit 2\n",
+	})
+	defer os.RemoveAll(dir)
+
+	c, err := New(&config.Config{Scanner: EXEC_PREFIX + filepath.Join(dir, "scanner"), Scan_timeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Check(context.Background(), "f", dir); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "banned"), nil, 0644)
+	digest, _ := Digest(dir)
+	if err, ok := c.Check(context.Background(), "f", dir).(*VetoError); !ok || err.Reason != "f: banned in "+digest {
+		t.Fatalf("expected veto with the output of the scanner, got %v", err)
+	}
+
+	broken := NewChecker(&execScanner{path: filepath.Join(dir, "broken")}, time.Second, false)
+	if err := broken.Check(context.Background(), "f", dir); err == nil {
+		t.Fatal("expected scanner exiting with 2 to fail")
+	} else if _, ok := err.(*VetoError); ok {
+		t.Fatal("scanner exiting with 2 reported as veto")
+	}
+
+	slow := NewChecker(&execScanner{path: filepath.Join(dir, "slow")}, 100*time.Millisecond, false)
+	begin := time.Now()
+	if err := slow.Check(context.Background(), "f", dir); err == nil {
+		t.Fatal("expected slow scanner to time out")
+	} else if time.Since(begin) > 2*time.Second {
+		t.Fatal("slow scanner not killed at its timeout")
+	}
+}
+
+func TestHTTPScanner(t *testing.T) {
+	dir := codeDir(t, map[string]string{"lambda_func.py": "SECRET_KEY = 'hunter2'"})
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HANDLER_HEADER) != "f" || r.Header.Get(DIGEST_HEADER) == "" {
+			http.Error(w, "missing headers", http.StatusBadRequest)
+			return
+		}
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tr := tar.NewReader(gz)
+		v := Verdict{Allowed: true}
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			body, _ := ioutil.ReadAll(tr)
+			if hdr.Name == "lambda_func.py" && string(body) == "SECRET_KEY = 'hunter2'" {
+				v = Verdict{Allowed: false, Reason: "secret in lambda_func.py"}
+			}
+		}
+		json.NewEncoder(w).Encode(v)
+	}))
+	defer ts.Close()
+
+	c, err := New(&config.Config{Scanner: ts.URL, Scan_timeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err, ok := c.Check(context.Background(), "f", dir).(*VetoError); !ok || err.Reason != "secret in lambda_func.py" {
+		t.Fatalf("expected veto, got %v", err)
+	}
+
+	if _, err := New(&config.Config{Scanner: "ftp://scanner"}); err == nil {
+		t.Fatal("expected unknown scanner to be refused")
+	}
+}
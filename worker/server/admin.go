@@ -0,0 +1,259 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/open-lambda/open-lambda/worker/audit"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/faults"
+	"github.com/open-lambda/open-lambda/worker/handler"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+// admin API paths
+const (
+	ADMIN_PATH     = "/admin/"
+	EVICT_PATH     = "/admin/evict/"
+	AUDIT_PATH     = "/admin/audit"
+	EVICTIONS_PATH = "/admin/evictions"
+	USAGE_PATH     = "/admin/usage"
+	FAULTS_PATH    = "/admin/faults"
+	DEPLOY_PATH    = "/admin/deploy/"
+	DEPLOYS_PATH   = "/admin/deploys"
+	SYSCALLS_PATH  = "/admin/syscalls/"
+	LOGS_PATH      = "/admin/logs/"
+)
+
+// authorize authenticates r, checks that its actor has the required role
+// and records the decision in the audit log. It returns the actor, or
+// responds with an error and returns false.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, required string) (actor string, ok bool) {
+	actor, role, err := s.adminActor(r)
+	if err == nil && !allows(role, required) {
+		err = fmt.Errorf("%s requires the %s role", r.URL.Path, required)
+	}
+	params := map[string]string{"method": r.Method, "path": r.URL.Path, "role": role, "required": required}
+	if actor == "" {
+		actor = ANONYMOUS
+		params["remote"] = r.RemoteAddr
+	}
+	s.auditLog.Record(actor, audit.ACCESS, params, err)
+	if err != nil && actor == ANONYMOUS {
+		w.Header().Set("WWW-Authenticate", `Basic realm="OpenLambda admin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return actor, false
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return actor, false
+	}
+	return actor, true
+}
+
+// viewer wraps a handler serving state outside of the admin API (e.g.,
+// /stats), so that it is authorized like reads of the admin API.
+func (s *Server) viewer(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := s.authorize(w, r, VIEWER); ok {
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
+// Admin serves the admin API. Every request is authorized according to the
+// role of its actor (see requiredRole), and every action is recorded in the
+// audit log, whether it succeeds or not, as are the authorization decisions.
+//
+// curl -X POST localhost:8080/admin/evict/<lambda-name>
+// curl localhost:8080/admin/audit?limit=<n>
+// curl localhost:8080/admin/evictions
+// curl localhost:8080/admin/usage?top=<n>&by=<memory|cpu>
+// curl localhost:8080/admin/init/<lambda-name>
+// curl localhost:8080/admin/faults
+// curl -X POST localhost:8080/admin/faults -d '{"enabled": true, "error_rate": 0.1}'
+// curl -X POST localhost:8080/admin/deploy/<lambda-name> [-d '<probe input>']
+// curl localhost:8080/admin/deploys
+// curl localhost:8080/admin/syscalls/<lambda-name>
+// curl -X POST localhost:8080/admin/syscalls/<lambda-name> -d '{"enabled": true}'
+// curl localhost:8080/admin/logs/<invocation-id>
+//
+// The continuous profiler is controlled under /admin/profiler and
+// /admin/profile/ (see profilerAdmin).
+//
+// A dashboard for browsers is served at /admin/dashboard/.
+func (s *Server) Admin(w http.ResponseWriter, r *http.Request) {
+	actor, ok := s.authorize(w, r, requiredRole(r))
+	if !ok {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, EVICT_PATH) && r.Method == "POST":
+		name := strings.TrimPrefix(r.URL.Path, EVICT_PATH)
+		err := s.handlers.Evict(name)
+		s.auditLog.Record(actor, audit.EVICT, map[string]string{"handler": name}, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.URL.Path == AUDIT_PATH && r.Method == "GET":
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		entries, err := s.auditLog.Entries(limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		wbody, err := json.Marshal(entries)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(wbody)
+
+	case r.URL.Path == EVICTIONS_PATH && r.Method == "GET":
+		wbody, err := json.Marshal(s.handlers.Evictions())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(wbody)
+
+	case strings.HasPrefix(r.URL.Path, DASHBOARD_PATH) && r.Method == "GET":
+		s.dashboard(w, r)
+
+	case r.URL.Path == USAGE_PATH && r.Method == "GET":
+		top, _ := strconv.Atoi(r.URL.Query().Get("top"))
+		by := r.URL.Query().Get("by")
+		if by == "" {
+			by = handler.BY_MEMORY
+		} else if by != handler.BY_MEMORY && by != handler.BY_CPU {
+			http.Error(w, "by must be memory or cpu", http.StatusBadRequest)
+			return
+		}
+		wbody, err := json.Marshal(s.handlers.TopUsage(top, by))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(wbody)
+
+	case strings.HasPrefix(r.URL.Path, INIT_PATH) && r.Method == "GET":
+		s.initStats(w, r)
+
+	case r.URL.Path == FAULTS_PATH && r.Method == "GET":
+		wbody, err := json.Marshal(faults.Current())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(wbody)
+
+	case r.URL.Path == FAULTS_PATH && r.Method == "POST":
+		rbody, err := ioutil.ReadAll(r.Body)
+		var settings config.FaultInjection
+		if err == nil {
+			err = json.Unmarshal(rbody, &settings)
+		}
+		if err == nil {
+			err = faults.Configure(settings)
+		}
+		s.auditLog.Record(actor, audit.CONFIG_CHANGE, map[string]string{"faults": string(rbody)}, err)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case strings.HasPrefix(r.URL.Path, DEPLOY_PATH) && r.Method == "POST":
+		name := strings.TrimPrefix(r.URL.Path, DEPLOY_PATH)
+		probe, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(probe) == 0 {
+			probe = nil
+		}
+		d := s.handlers.Deploy(r.Context(), name, probe)
+		var derr error
+		if d.Result != handler.DEPLOYED {
+			derr = errors.New(d.Error)
+		}
+		s.auditLog.Record(actor, audit.DEPLOY, map[string]string{"handler": name, "result": d.Result}, derr)
+		wbody, err := json.Marshal(d)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if derr != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+		w.Write(wbody)
+
+	case r.URL.Path == DEPLOYS_PATH && r.Method == "GET":
+		wbody, err := json.Marshal(s.handlers.Deploys())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(wbody)
+
+	case strings.HasPrefix(r.URL.Path, SYSCALLS_PATH) && r.Method == "GET":
+		name := strings.TrimPrefix(r.URL.Path, SYSCALLS_PATH)
+		mode, err := s.handlers.SyscallAudit(name)
+		if _, ok := err.(*handler.UnknownHandlerError); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		wbody, err := json.Marshal(mode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(wbody)
+
+	case strings.HasPrefix(r.URL.Path, SYSCALLS_PATH) && r.Method == "POST":
+		name := strings.TrimPrefix(r.URL.Path, SYSCALLS_PATH)
+		var mode handler.SyscallAudit
+		if err := json.NewDecoder(r.Body).Decode(&mode); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := s.handlers.SetSyscallAudit(name, mode.Enabled)
+		s.auditLog.Record(actor, audit.SYSCALL_AUDIT, map[string]string{"handler": name, "enabled": strconv.FormatBool(mode.Enabled)}, err)
+		if ie, ok := err.(*meta.IncompatibleError); ok {
+			http.Error(w, ie.Error(), http.StatusNotImplemented)
+			return
+		} else if _, ok := err.(*handler.UnknownHandlerError); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case strings.HasPrefix(r.URL.Path, LOGS_PATH) && r.Method == "GET":
+		s.Logs(w, r)
+
+	case r.URL.Path == PROFILER_PATH || strings.HasPrefix(r.URL.Path, PROFILE_PATH):
+		s.profilerAdmin(w, r, actor)
+
+	default:
+		http.Error(w, "unknown admin operation", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/anomaly"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/webhook"
+)
+
+// newDetector creates the error-rate detector of the server, or returns nil
+// if no alert thresholds are configured. Alerts are sent as webhook events.
+func newDetector(conf *config.Config, notifier *webhook.Notifier) *anomaly.Detector {
+	if conf.Alert_error_rate == 0 && conf.Alert_timeout_rate == 0 {
+		return nil
+	}
+
+	th := anomaly.Thresholds{
+		Window:      time.Duration(conf.Alert_window) * time.Second,
+		MinRequests: conf.Alert_min_requests,
+		ErrorRate:   conf.Alert_error_rate,
+		TimeoutRate: conf.Alert_timeout_rate,
+		ClearRatio:  conf.Alert_clear_ratio,
+	}
+	return anomaly.NewDetector(th, func(a anomaly.Alert) {
+		log.WithField(logging.HANDLER, a.Handler).Warn(a.String())
+		notifier.Notify(webhook.HANDLER_ANOMALY, a.Handler, map[string]string{
+			"kind":      a.Kind,
+			"firing":    fmt.Sprint(a.Firing),
+			"rate":      fmt.Sprintf("%.4f", a.Rate),
+			"threshold": fmt.Sprintf("%.4f", a.Threshold),
+			"requests":  fmt.Sprint(a.Requests),
+		})
+	})
+}
+
+// recordOutcome feeds the status of an invocation to the detector. Only
+// handlers whose code the worker has loaded are tracked, so requests for
+// names that do not exist do not grow the detector.
+func (s *Server) recordOutcome(name string, code int) {
+	if s.detector == nil || code == http.StatusTooManyRequests {
+		return
+	}
+	if h := s.handlers.Lookup(name); h == nil || h.Meta() == nil {
+		return
+	}
+
+	outcome := anomaly.OK
+	if code == http.StatusGatewayTimeout {
+		outcome = anomaly.TIMEOUT
+	} else if code >= 500 {
+		outcome = anomaly.ERROR
+	}
+	s.detector.Record(name, outcome)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/coord"
+	dutil "github.com/open-lambda/open-lambda/worker/dockerutil"
+	"github.com/open-lambda/open-lambda/worker/logging"
+)
+
+const (
+	// how long leadership and membership survive without renewal
+	leaderTTL    = 15 * time.Second
+	heartbeatTTL = 15 * time.Second
+
+	// how often the leader looks for sandboxes of dead workers, and how
+	// long a worker must have been dead before they are removed
+	gcInterval = time.Minute
+	gcGrace    = 2 * time.Minute
+)
+
+// initCoordination joins the coordination store of the cluster, if one is
+// configured, and creates an elector with the cluster-singleton tasks.
+func initCoordination(conf *config.Config) (*coord.Store, *coord.Elector, error) {
+	if conf.Coord_dir == "" {
+		return nil, nil, nil
+	}
+
+	store, err := coord.NewStore(conf.Coord_dir, conf.WorkerId())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	elector := coord.NewElector(store, leaderTTL)
+	elector.Register("cluster-gc", func(stop <-chan struct{}) {
+		clusterGC(conf, store, stop)
+	})
+
+	return store, elector, nil
+}
+
+// runCoordination keeps the heartbeat of this worker fresh and campaigns for
+// leadership until stop is closed. It then gives up leadership, if held, and
+// leaves the cluster, so that other workers need not wait for its heartbeat
+// to expire.
+func runCoordination(store *coord.Store, elector *coord.Elector, stop <-chan struct{}) {
+	resigned := make(chan struct{})
+	go func() {
+		elector.Run(stop)
+		close(resigned)
+	}()
+
+	for {
+		if err := store.Heartbeat(heartbeatTTL); err != nil {
+			log.WithError(err).Warn("could not write heartbeat")
+		}
+		select {
+		case <-stop:
+			<-resigned
+			if err := store.Leave(); err != nil {
+				log.WithError(err).Warn("could not leave the cluster")
+			}
+			return
+		case <-time.After(heartbeatTTL / 3):
+		}
+	}
+}
+
+// clusterGC periodically removes the sandbox containers of this cluster
+// whose owning worker has stopped sending heartbeats.
+func clusterGC(conf *config.Config, store *coord.Store, stop <-chan struct{}) {
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		log.WithError(err).Error("cluster GC disabled, failed to get docker client")
+		return
+	}
+
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		members, err := store.Members()
+		if err != nil {
+			log.WithError(err).Warn("cluster GC could not list members")
+			continue
+		}
+
+		opts := docker.ListContainersOptions{
+			All: true,
+			Filters: map[string][]string{
+				"label": []string{
+					dutil.DOCKER_LABEL_CLUSTER + "=" + conf.Cluster_name,
+					dutil.DOCKER_LABEL_TYPE + "=" + dutil.SANDBOX,
+				},
+			},
+		}
+		containers, err := client.ListContainers(opts)
+		if err != nil {
+			log.WithError(err).Warn("cluster GC could not list containers")
+			continue
+		}
+
+		now := time.Now()
+		for _, container := range containers {
+			owner := container.Labels[dutil.DOCKER_LABEL_WORKER]
+			if owner == "" {
+				continue // created before workers were labeled
+			}
+
+			// a missing heartbeat is only trusted once the container
+			// is old enough for its worker to have joined
+			created := time.Unix(container.Created, 0)
+			if lease, ok := members[owner]; ok {
+				if now.Sub(lease.Expires) < gcGrace {
+					continue
+				}
+			} else if now.Sub(created) < gcGrace {
+				continue
+			}
+
+			log.WithFields(log.Fields{
+				logging.SANDBOX_ID: container.ID,
+				"worker":           owner,
+			}).Info("cluster GC: remove sandbox of dead worker")
+			if err := dutil.SafeRemove(client, container.ID); err != nil {
+				log.WithError(err).WithField(logging.SANDBOX_ID, container.ID).Warn("cluster GC could not remove sandbox")
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/handler"
+	"github.com/open-lambda/open-lambda/worker/logging"
+)
+
+const (
+	DASHBOARD_PATH      = "/admin/dashboard"
+	DASHBOARD_DATA_PATH = "/admin/dashboard/data"
+
+	// number of recent failed requests shown on the dashboard
+	ERROR_HISTORY = 50
+)
+
+// RequestError is a request that failed.
+type RequestError struct {
+	Time      time.Time `json:"time"`
+	Handler   string    `json:"handler"`
+	RequestId string    `json:"request_id"`
+	Code      int       `json:"code"`
+	Msg       string    `json:"msg"`
+}
+
+// errorLog keeps the most recent failed requests.
+type errorLog struct {
+	mutex  sync.Mutex
+	errors []RequestError
+}
+
+func (l *errorLog) add(e RequestError) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.errors) == ERROR_HISTORY {
+		l.errors = l.errors[1:]
+	}
+	l.errors = append(l.errors, e)
+}
+
+func (l *errorLog) list() []RequestError {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]RequestError{}, l.errors...)
+}
+
+// DashboardData is everything shown on the dashboard.
+type DashboardData struct {
+	Worker    string                `json:"worker"`
+	Pool      string                `json:"pool"`
+	Handlers  []handler.HandlerInfo `json:"handlers"`
+	Usage     []handler.Usage       `json:"usage"`
+	Evictions handler.EvictionStats `json:"evictions"`
+	Errors    []RequestError        `json:"errors"`
+	Logs      []string              `json:"logs"`
+}
+
+// dashboard serves the HTML page of the dashboard, or its data as JSON.
+func (s *Server) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == DASHBOARD_DATA_PATH {
+		pool := "none"
+		if s.config.Pool != "" {
+			pool = s.config.Pool
+		}
+		data := DashboardData{
+			Worker:    s.config.WorkerId(),
+			Pool:      pool,
+			Handlers:  s.handlers.List(),
+			Usage:     s.handlers.TopUsage(10, handler.BY_MEMORY),
+			Evictions: s.handlers.Evictions(),
+			Errors:    s.errors.list(),
+			Logs:      logging.Recent(100),
+		}
+		wbody, err := json.Marshal(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(wbody)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+// dashboardHTML polls the data endpoint and renders it. It is self-contained
+// so the worker can serve it without any static files.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>OpenLambda worker</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { margin-top: 1.5em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 12px; text-align: left; }
+tr:nth-child(even) { background: #f4f4f4; }
+pre { background: #111; color: #ddd; padding: 1em; max-height: 30em; overflow: auto; }
+.Running { color: green; } .Paused { color: #a60; } .Stopped { color: #888; }
+</style>
+</head>
+<body>
+<h1>Worker <span id="worker"></span></h1>
+<div>Pool: <span id="pool"></span> &middot; refreshed <span id="refreshed"></span></div>
+
+<h2>Handlers</h2>
+<table id="handlers"><tr><th>Name</th><th>State</th><th>Runners</th><th>Invocations</th><th>Cold</th><th>Warm</th><th>Cold rate</th></tr></table>
+
+<h2>Heaviest sandboxes</h2>
+<table id="usage"><tr><th>Handler</th><th>Memory (MB)</th><th>CPU (cores)</th><th>Pids</th></tr></table>
+
+<h2>Evictions</h2>
+<div id="evictions"></div>
+
+<h2>Recent errors</h2>
+<table id="errors"><tr><th>Time</th><th>Handler</th><th>Request</th><th>Code</th><th>Message</th></tr></table>
+
+<h2>Log</h2>
+<pre id="logs"></pre>
+
+<script>
+function esc(s) {
+  return String(s).replace(/[&<>"]/g, function(c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"}[c];
+  });
+}
+
+function rows(id, items, cells) {
+  var table = document.getElementById(id);
+  while (table.rows.length > 1) table.deleteRow(1);
+  (items || []).forEach(function(item) {
+    var row = table.insertRow();
+    cells(item).forEach(function(cell) {
+      row.insertCell().innerHTML = cell;
+    });
+  });
+}
+
+function refresh() {
+  fetch("/admin/dashboard/data", {credentials: "same-origin"}).then(function(resp) {
+    return resp.json();
+  }).then(function(d) {
+    document.getElementById("worker").textContent = d.worker;
+    document.getElementById("pool").textContent = d.pool;
+    document.getElementById("refreshed").textContent = new Date().toLocaleTimeString();
+    rows("handlers", d.handlers, function(h) {
+      var rate = h.invocations ? (100 * h.cold_starts / h.invocations).toFixed(1) + "%" : "";
+      return [esc(h.name), '<span class="' + esc(h.state) + '">' + esc(h.state) + "</span>",
+              h.runners, h.invocations, h.cold_starts, h.warm_starts, rate];
+    });
+    rows("usage", d.usage, function(u) {
+      return [esc(u.handler), (u.memory_bytes / (1 << 20)).toFixed(1), u.cpu_rate.toFixed(2), u.pids];
+    });
+    var ev = Object.keys(d.evictions.counts || {}).map(function(k) {
+      return esc(k) + ": " + d.evictions.counts[k];
+    });
+    document.getElementById("evictions").innerHTML = ev.length ? ev.join(" &middot; ") : "none";
+    rows("errors", (d.errors || []).slice().reverse(), function(e) {
+      return [esc(new Date(e.time).toLocaleTimeString()), esc(e.handler), esc(e.request_id), e.code, esc(e.msg)];
+    });
+    var logs = document.getElementById("logs");
+    logs.textContent = (d.logs || []).join("");
+    logs.scrollTop = logs.scrollHeight;
+  });
+}
+
+refresh();
+setInterval(refresh, 2000);
+</script>
+</body>
+</html>
+`
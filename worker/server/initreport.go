@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+const (
+	// INIT_HEADER carries, on the first response of a sandbox, how long
+	// the lambda server took to initialize and import the handler.
+	INIT_HEADER = "X-OL-Init"
+
+	INIT_PATH = "/admin/init/"
+)
+
+var initSeconds = metrics.NewHistogram(
+	"ol_handler_init_seconds",
+	"Time the runtime in a sandbox took to initialize and import the handler.",
+	metrics.LATENCY_BUCKETS,
+	"handler")
+
+// initReport is the body of INIT_HEADER.
+type initReport struct {
+	InitMs  float64 `json:"init_ms"`
+	Imports []struct {
+		Module  string  `json:"module"`
+		SelfMs  float64 `json:"self_ms"`
+		TotalMs float64 `json:"total_ms"`
+	} `json:"imports"`
+}
+
+// ImportStats is the mean time spent importing a module over the starts of a
+// handler that imported it. Self time excludes the modules it imported.
+type ImportStats struct {
+	Module      string  `json:"module"`
+	Starts      int     `json:"starts"`
+	MeanSelfMs  float64 `json:"mean_self_ms"`
+	MeanTotalMs float64 `json:"mean_total_ms"`
+}
+
+// InitStats summarizes the initialization of the sandboxes of a handler.
+// Imports are sorted by mean self time, slowest first.
+type InitStats struct {
+	Handler    string        `json:"handler"`
+	Starts     int           `json:"starts"`
+	MeanInitMs float64       `json:"mean_init_ms"`
+	LastInitMs float64       `json:"last_init_ms"`
+	Imports    []ImportStats `json:"imports"`
+}
+
+// initLog aggregates the init reports of sandboxes per handler.
+type initLog struct {
+	mutex    sync.Mutex
+	handlers map[string]*initTotals
+}
+
+type initTotals struct {
+	starts  int
+	initMs  float64
+	lastMs  float64
+	imports map[string]*ImportStats // means hold sums until reported
+}
+
+// add records the report in INIT_HEADER of a response from the sandbox of
+// handler, if there is one.
+func (il *initLog) add(handler string, w2 *http.Response) {
+	raw := w2.Header.Get(INIT_HEADER)
+	if raw == "" {
+		return
+	}
+	var report initReport
+	if err := json.Unmarshal([]byte(raw), &report); err != nil {
+		log.WithError(err).WithField(logging.HANDLER, handler).Warn("bad init report from sandbox")
+		return
+	}
+	initSeconds.Observe(report.InitMs/1000, handler)
+
+	il.mutex.Lock()
+	defer il.mutex.Unlock()
+
+	if il.handlers == nil {
+		il.handlers = make(map[string]*initTotals)
+	}
+	totals := il.handlers[handler]
+	if totals == nil {
+		totals = &initTotals{imports: make(map[string]*ImportStats)}
+		il.handlers[handler] = totals
+	}
+	totals.starts += 1
+	totals.initMs += report.InitMs
+	totals.lastMs = report.InitMs
+	for _, imp := range report.Imports {
+		stats := totals.imports[imp.Module]
+		if stats == nil {
+			stats = &ImportStats{Module: imp.Module}
+			totals.imports[imp.Module] = stats
+		}
+		stats.Starts += 1
+		stats.MeanSelfMs += imp.SelfMs
+		stats.MeanTotalMs += imp.TotalMs
+	}
+}
+
+// get returns the stats of handler, or nil if none of its sandboxes reported.
+func (il *initLog) get(handler string) *InitStats {
+	il.mutex.Lock()
+	defer il.mutex.Unlock()
+
+	totals := il.handlers[handler]
+	if totals == nil {
+		return nil
+	}
+	stats := &InitStats{
+		Handler:    handler,
+		Starts:     totals.starts,
+		MeanInitMs: totals.initMs / float64(totals.starts),
+		LastInitMs: totals.lastMs,
+		Imports:    make([]ImportStats, 0, len(totals.imports)),
+	}
+	for _, imp := range totals.imports {
+		mean := *imp
+		mean.MeanSelfMs /= float64(imp.Starts)
+		mean.MeanTotalMs /= float64(imp.Starts)
+		stats.Imports = append(stats.Imports, mean)
+	}
+	sort.Slice(stats.Imports, func(i, j int) bool {
+		return stats.Imports[i].MeanSelfMs > stats.Imports[j].MeanSelfMs
+	})
+	return stats
+}
+
+// initStats serves the init stats of a handler.
+//
+// curl localhost:8080/admin/init/<lambda-name>
+func (s *Server) initStats(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, INIT_PATH)
+	stats := s.inits.get(name)
+	if stats == nil {
+		http.Error(w, "no init reports for "+name, http.StatusNotFound)
+		return
+	}
+	wbody, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(wbody)
+}
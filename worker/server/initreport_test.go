@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+)
+
+func initResponse(report string) *http.Response {
+	w2 := &http.Response{Header: http.Header{}}
+	w2.Header.Set(INIT_HEADER, report)
+	return w2
+}
+
+func TestInitLog(t *testing.T) {
+	var il initLog
+
+	il.add("hello", initResponse(`{"init_ms": 100, "imports": [
+		{"module": "numpy", "self_ms": 60, "total_ms": 80},
+		{"module": "json", "self_ms": 10, "total_ms": 10}]}`))
+	il.add("hello", initResponse(`{"init_ms": 200, "imports": [
+		{"module": "numpy", "self_ms": 100, "total_ms": 120}]}`))
+	il.add("hello", &http.Response{Header: http.Header{}})
+
+	stats := il.get("hello")
+	if stats == nil {
+		t.Fatal("expected stats")
+	}
+	if stats.Starts != 2 || stats.MeanInitMs != 150 || stats.LastInitMs != 200 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+	if len(stats.Imports) != 2 || stats.Imports[0].Module != "numpy" {
+		t.Fatalf("unexpected imports: %+v", stats.Imports)
+	}
+	if numpy := stats.Imports[0]; numpy.Starts != 2 || numpy.MeanSelfMs != 80 || numpy.MeanTotalMs != 100 {
+		t.Fatalf("unexpected numpy stats: %+v", numpy)
+	}
+
+	if il.get("other") != nil {
+		t.Fatal("unexpected stats for unknown handler")
+	}
+}
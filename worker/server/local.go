@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/handler"
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+	"github.com/open-lambda/open-lambda/worker/registry"
+	"github.com/open-lambda/open-lambda/worker/scan"
+	"github.com/open-lambda/open-lambda/worker/sysaudit"
+)
+
+// LocalResult is the outcome of an invocation run by InvokeLocal.
+type LocalResult struct {
+	Status  int              `json:"status"`
+	Body    string           `json:"body"`
+	Output  string           `json:"output"` // what the handler wrote to stdout and stderr
+	Timings *startup.Timings `json:"timings"`
+}
+
+// InvokeLocal runs a single invocation of a handler, with the registry,
+// sandbox backend and pools of the config but without serving HTTP, and
+// tears everything down afterwards. The code is that of the handler of the
+// given name, or of the directory dir if set (the handler is then named
+// after it). A worker running with the same config is not disturbed (see
+// localConfig).
+func InvokeLocal(ctx context.Context, conf *config.Config, name, dir string, input []byte) (*LocalResult, error) {
+	workerDir, err := ioutil.TempDir("", "ol-invoke-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workerDir)
+	c, name, err := localConfig(conf, workerDir, name, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	regMgr, err := initRegManager(c)
+	if err != nil {
+		return nil, err
+	}
+	sbFactory, err := initSBFactory(c)
+	if err != nil {
+		return nil, err
+	}
+	poolMgr, err := initPManager(c)
+	if err != nil {
+		return nil, err
+	}
+	if poolMgr != nil {
+		defer func() {
+			if err := poolMgr.Shutdown(context.Background()); err != nil {
+				log.WithError(err).Warn("could not stop pools")
+			}
+		}()
+	}
+	scanner, err := scan.New(c)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		config: c,
+		handlers: handler.NewHandlerSet(handler.HandlerSetOpts{
+			RegMgr:    regMgr,
+			SbFactory: sbFactory,
+			PoolMgr:   poolMgr,
+			Config:    c,
+			Scanner:   scanner,
+			Recorder:  sysaudit.New(c),
+		}),
+		poolMgr: poolMgr,
+	}
+	defer s.handlers.StopSandboxes(context.Background(), false)
+
+	r, err := http.NewRequest("POST", "/runLambda/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(DEBUG_HEADER, "1")
+
+	wbody, w2, herr := s.ForwardToSandbox(s.handlers.Get(name), r, input)
+	if herr != nil {
+		return nil, fmt.Errorf("%s", herr.msg)
+	}
+	result := &LocalResult{
+		Status: w2.StatusCode,
+		Body:   string(wbody),
+		Timings: &startup.Timings{
+			Handler: name,
+			Start:   w2.Header.Get(startup.HEADER),
+			Phases:  startup.ParseServerTiming(w2.Header.Get(startup.TIMING_HEADER)),
+		},
+	}
+	if encoded := w2.Header.Get(LOGS_HEADER); encoded != "" {
+		if output, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+			result.Output = string(output)
+		}
+	}
+	return result, nil
+}
+
+// localConfig returns the config InvokeLocal runs with, and the name of the
+// handler. The worker directory, the pool directory holding the sockets of
+// the forkservers, and the registry directory where code is pulled to, are
+// under workerDir. The invocation gets a cluster name of its own, so that
+// its containers and those of a worker running with conf do not take each
+// other for leaked ones.
+func localConfig(conf *config.Config, workerDir, name, dir string) (*config.Config, string, error) {
+	c := *conf
+	c.Worker_dir = workerDir
+	c.Pool_dir = filepath.Join(workerDir, "pool")
+	c.Cluster_name = conf.Cluster_name + "-" + filepath.Base(workerDir)
+
+	// buffered sandboxes would outlive the invocation
+	c.Sandbox_buffer = 0
+
+	// the output of the handler is printed along with the response
+	c.Allow_debug_header = true
+
+	if dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, "", err
+		}
+		c.Registry = registry.LOCAL
+		c.Reg_dir = filepath.Dir(abs)
+		name = filepath.Base(abs)
+	} else if c.Registry != registry.LOCAL {
+		c.Reg_dir = filepath.Join(workerDir, "registry")
+	}
+	return &c, name, nil
+}
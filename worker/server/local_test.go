@@ -0,0 +1,58 @@
+package server
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/registry"
+)
+
+func TestLocalConfig(t *testing.T) {
+	conf := &config.Config{
+		Cluster_name:   "default",
+		Worker_dir:     "/var/ol/worker",
+		Pool_dir:       "/var/ol/pool",
+		Registry:       registry.LOCAL,
+		Reg_dir:        "/var/ol/registry",
+		Sandbox_buffer: 4,
+	}
+	workerDir := "/tmp/ol-invoke-123"
+
+	c, name, err := localConfig(conf, workerDir, "hello", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "hello" || c.Reg_dir != conf.Reg_dir {
+		t.Fatalf("expected hello from %s, got %s from %s", conf.Reg_dir, name, c.Reg_dir)
+	}
+
+	// nothing a running worker owns is shared with the invocation
+	for _, d := range []string{c.Worker_dir, c.Pool_dir} {
+		if !strings.HasPrefix(d, workerDir) {
+			t.Errorf("%s is not under %s", d, workerDir)
+		}
+	}
+	if c.Cluster_name == conf.Cluster_name {
+		t.Errorf("cluster name %s is shared", c.Cluster_name)
+	}
+	if c.Sandbox_buffer != 0 {
+		t.Errorf("sandboxes are buffered")
+	}
+	if !c.Allow_debug_header {
+		t.Errorf("handler output is not returned")
+	}
+	if conf.Pool_dir != "/var/ol/pool" || conf.Cluster_name != "default" {
+		t.Errorf("input config was modified")
+	}
+
+	c, name, err = localConfig(conf, workerDir, "", "code/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	abs, _ := filepath.Abs("code")
+	if name != "echo" || c.Reg_dir != abs {
+		t.Fatalf("expected echo from %s, got %s from %s", abs, name, c.Reg_dir)
+	}
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/logging"
+)
+
+const (
+	// CAPTURE_HEADER asks the lambda server in the sandbox to capture the
+	// stdout and stderr of the handler while it serves the request.
+	CAPTURE_HEADER = "X-OL-Capture-Logs"
+
+	// LOGS_HEADER carries the captured output, base64 encoded, on the
+	// response from the sandbox. Clients that set DEBUG_HEADER receive it
+	// on the response of the worker as well, if allowed (see debugAllowed).
+	LOGS_HEADER  = "X-OL-Logs"
+	DEBUG_HEADER = "X-OL-Debug"
+
+	// INVOCATION_HEADER carries the ID the worker gives an invocation whose
+	// output it keeps, to get it from LOGS_PATH/<invocation id>. Unlike
+	// request IDs, which clients choose, these cannot be guessed or reused
+	// to read or replace the output of other invocations.
+	INVOCATION_HEADER = "X-OL-Invocation-Id"
+)
+
+// CapturedLog is the output of one invocation of a handler.
+type CapturedLog struct {
+	InvocationId string    `json:"invocation_id"`
+	RequestId    string    `json:"request_id"`
+	Handler      string    `json:"handler"`
+	Time         time.Time `json:"time"`
+	Output       string    `json:"output"`
+}
+
+// logStore keeps the output of the most recent invocations, indexed by
+// invocation ID.
+type logStore struct {
+	mutex    sync.Mutex
+	capacity int
+	order    []string
+	logs     map[string]*CapturedLog
+}
+
+// newLogStore creates a logStore holding up to capacity invocations.
+func newLogStore(capacity int) *logStore {
+	return &logStore{
+		capacity: capacity,
+		logs:     make(map[string]*CapturedLog),
+	}
+}
+
+// Put stores entry, evicting the oldest entry if the store is full.
+func (ls *logStore) Put(entry *CapturedLog) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	if _, ok := ls.logs[entry.InvocationId]; !ok {
+		if len(ls.order) == ls.capacity {
+			delete(ls.logs, ls.order[0])
+			ls.order = ls.order[1:]
+		}
+		ls.order = append(ls.order, entry.InvocationId)
+	}
+	ls.logs[entry.InvocationId] = entry
+}
+
+// Get returns the output of the invocation with the given invocation ID.
+func (ls *logStore) Get(invocationId string) *CapturedLog {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	return ls.logs[invocationId]
+}
+
+// newInvocationId returns a random invocation ID.
+func newInvocationId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// debugAllowed reports whether the client asked for the output of the
+// handler on the response to r with DEBUG_HEADER, and may get it: any client
+// may if the worker config has allow_debug_header, otherwise only actors of
+// the admin API with the operator role.
+func (s *Server) debugAllowed(r *http.Request) bool {
+	if r.Header.Get(DEBUG_HEADER) == "" {
+		return false
+	}
+	if s.config.Allow_debug_header {
+		return true
+	}
+	_, role, err := s.adminActor(r)
+	return err == nil && allows(role, OPERATOR)
+}
+
+// wantsLogs returns true if the sandbox should capture handler output for r.
+func (s *Server) wantsLogs(r *http.Request) bool {
+	return s.logs != nil || logging.ForwardingHandlerOutput() || s.debugAllowed(r)
+}
+
+// collectLogs saves the output the sandbox captured for r, with the ID it
+// returns in INVOCATION_HEADER, and forwards it to the log sinks, and copies
+// it to the response if the client asked for it and may get it.
+func (s *Server) collectLogs(img string, r *http.Request, w http.ResponseWriter, w2 *http.Response) {
+	encoded := w2.Header.Get(LOGS_HEADER)
+	if encoded == "" {
+		return
+	}
+
+	if s.debugAllowed(r) {
+		w.Header().Set(LOGS_HEADER, encoded)
+	}
+
+	if s.logs == nil && !logging.ForwardingHandlerOutput() {
+		return
+	}
+
+	output, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		requestLogger(r).WithError(err).Warn("could not decode captured logs")
+		return
+	}
+
+	if len(output) > 0 && logging.ForwardingHandlerOutput() {
+		logging.ForwardHandlerOutput(img, logging.RequestId(r), string(output))
+	}
+
+	if s.logs != nil {
+		invocationId, err := newInvocationId()
+		if err != nil {
+			requestLogger(r).WithError(err).Warn("could not keep captured logs")
+			return
+		}
+		s.logs.Put(&CapturedLog{
+			InvocationId: invocationId,
+			RequestId:    logging.RequestId(r),
+			Handler:      img,
+			Time:         time.Now(),
+			Output:       string(output),
+		})
+		w.Header().Set(INVOCATION_HEADER, invocationId)
+	}
+}
+
+// Logs writes the captured output of an invocation as JSON, for GET requests
+// to LOGS_PATH/<invocation-id> of the admin API.
+func (s *Server) Logs(w http.ResponseWriter, r *http.Request) {
+	if s.logs == nil {
+		http.Error(w, "log capture is disabled", http.StatusNotFound)
+		return
+	}
+
+	invocationId := strings.TrimPrefix(r.URL.Path, LOGS_PATH)
+	entry := s.logs.Get(invocationId)
+	if entry == nil {
+		http.Error(w, "no logs for invocation "+invocationId, http.StatusNotFound)
+		return
+	}
+
+	wbody, err := json.Marshal(entry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(wbody); err != nil {
+		log.WithError(err).Warn("could not write logs")
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+func TestLogStore(t *testing.T) {
+	ls := newLogStore(2)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := newInvocationId()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// clients choosing the same request ID do not share entries
+		ls.Put(&CapturedLog{InvocationId: id, RequestId: "same", Output: id})
+		ids = append(ids, id)
+	}
+	if ls.Get(ids[0]) != nil {
+		t.Fatal("expected the oldest entry to be evicted")
+	}
+	for _, id := range ids[1:] {
+		if entry := ls.Get(id); entry == nil || entry.Output != id {
+			t.Fatalf("expected the output of %s, got %v", id, entry)
+		}
+	}
+	if ls.Get("same") != nil {
+		t.Fatal("expected entries not to be found by request ID")
+	}
+}
+
+func TestDebugAllowed(t *testing.T) {
+	s := &Server{config: &config.Config{
+		Admin_keys:  map[string]string{"k1": "alice", "k2": "bob"},
+		Admin_roles: map[string]string{"alice": VIEWER, "bob": OPERATOR},
+	}}
+	for _, c := range []struct {
+		key     string
+		allowed bool
+	}{
+		{"", false},
+		{"k1", false},
+		{"k2", true},
+	} {
+		r := httptest.NewRequest("POST", "/runLambda/f", nil)
+		r.Header.Set(DEBUG_HEADER, "1")
+		if c.key != "" {
+			r.Header.Set("Authorization", "Bearer "+c.key)
+		}
+		if allowed := s.debugAllowed(r); allowed != c.allowed {
+			t.Errorf("key %q: expected %v, got %v", c.key, c.allowed, allowed)
+		}
+	}
+
+	// the worker can let any client have it
+	s.config.Allow_debug_header = true
+	r := httptest.NewRequest("POST", "/runLambda/f", nil)
+	if s.debugAllowed(r) {
+		t.Fatal("expected output not to be returned unless asked for")
+	}
+	r.Header.Set(DEBUG_HEADER, "1")
+	if !s.debugAllowed(r) {
+		t.Fatal("expected allow_debug_header to allow any client")
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+// MIRROR_HEADER marks mirrored requests, which are never mirrored again.
+const MIRROR_HEADER = "X-OL-Mirrored"
+
+const (
+	// MIRROR_TIMEOUT bounds how long a mirrored request may take.
+	MIRROR_TIMEOUT = 30 * time.Second
+
+	// MAX_MIRRORS bounds the mirrored requests in flight; copies beyond it
+	// are dropped, so a slow mirror target cannot pile up goroutines.
+	MAX_MIRRORS = 64
+)
+
+var (
+	mirrorRequestsTotal = metrics.NewCounter(
+		"ol_mirror_requests_total",
+		"Number of requests copied to a mirror target.",
+		"handler", "target")
+	mirrorErrorsTotal = metrics.NewCounter(
+		"ol_mirror_errors_total",
+		"Number of mirrored requests that failed or returned a 5xx status.",
+		"handler", "target")
+	mirrorDroppedTotal = metrics.NewCounter(
+		"ol_mirror_dropped_total",
+		"Number of copies of requests not mirrored, as MAX_MIRRORS were in flight.",
+		"handler", "target")
+)
+
+// maybeMirror sends a copy of a request to the mirror target of the handler
+// with the configured probability. The copy runs in the background, unless
+// MAX_MIRRORS are in flight already, in which case it is dropped.
+func (s *Server) maybeMirror(name string, m *meta.HandlerMeta, r *http.Request, input []byte) {
+	if m == nil || m.Mirror == nil || r.Header.Get(MIRROR_HEADER) != "" {
+		return
+	}
+	if rand.Float64()*100 >= m.Mirror.Percent {
+		return
+	}
+
+	target := m.Mirror.Handler
+	if target == "" {
+		target = name
+	}
+	if target == name && m.Mirror.Worker == "" {
+		// refused at deploy (see meta.CheckMirror); never loop
+		return
+	}
+	label := target
+	if m.Mirror.Worker != "" {
+		label = fmt.Sprintf("%s@%s", target, m.Mirror.Worker)
+	}
+
+	if atomic.AddInt32(&s.mirrors, 1) > MAX_MIRRORS {
+		atomic.AddInt32(&s.mirrors, -1)
+		mirrorDroppedTotal.Inc(name, label)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	go func() {
+		defer atomic.AddInt32(&s.mirrors, -1)
+		ctx, cancel := context.WithTimeout(context.Background(), MIRROR_TIMEOUT)
+		defer cancel()
+		mirrorRequestsTotal.Inc(name, label)
+		if err := s.mirror(ctx, target, m.Mirror.Worker, contentType, input); err != nil {
+			mirrorErrorsTotal.Inc(name, label)
+			log.WithError(err).WithFields(log.Fields{
+				logging.HANDLER: name,
+				"target":        label,
+			}).Warn("mirror failed")
+		}
+	}()
+}
+
+// mirror runs one mirrored request and discards its response.
+func (s *Server) mirror(ctx context.Context, target string, worker string, contentType string, input []byte) error {
+	path := fmt.Sprintf("/runLambda/%s", target)
+
+	if worker != "" {
+		r2, err := http.NewRequest("POST", fmt.Sprintf("%s://%s%s", s.tls.Scheme(), worker, path), bytes.NewReader(input))
+		if err != nil {
+			return err
+		}
+		r2 = r2.WithContext(ctx)
+		r2.Header.Set("Content-Type", contentType)
+		r2.Header.Set(MIRROR_HEADER, "1")
+		w2, err := s.mirrorClient.Do(r2)
+		if err != nil {
+			return err
+		}
+		defer w2.Body.Close()
+		io.Copy(ioutil.Discard, w2.Body)
+		if w2.StatusCode >= 500 {
+			return fmt.Errorf("status %s", w2.Status)
+		}
+		return nil
+	}
+
+	r2, err := http.NewRequest("POST", path, nil)
+	if err != nil {
+		return err
+	}
+	r2 = r2.WithContext(ctx)
+	r2.Header.Set("Content-Type", contentType)
+	r2.Header.Set(MIRROR_HEADER, "1")
+	_, w2, herr := s.ForwardToSandbox(s.handlers.Get(target), r2, input)
+	if herr != nil {
+		return fmt.Errorf("%s", herr.msg)
+	}
+	if w2.StatusCode >= 500 {
+		return fmt.Errorf("status %s", w2.Status)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+)
+
+func TestMirrorLimits(t *testing.T) {
+	release := make(chan struct{})
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer target.Close()
+	defer close(release)
+
+	s := &Server{mirrorClient: &http.Client{}}
+	worker := strings.TrimPrefix(target.URL, "http://")
+
+	// mirrored requests give up once their context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.mirror(ctx, "hello", worker, "application/json", nil); err == nil {
+		t.Fatal("expected a mirror to a hanging target to time out")
+	}
+
+	// copies beyond MAX_MIRRORS in flight are dropped
+	m := &meta.HandlerMeta{Mirror: &meta.Mirror{Percent: 100, Worker: worker}}
+	r := httptest.NewRequest("POST", "/runLambda/hello", nil)
+	s.mirrors = MAX_MIRRORS
+	s.maybeMirror("hello", m, r, nil)
+	if s.mirrors != MAX_MIRRORS {
+		t.Fatalf("expected the copy to be dropped, %d in flight", s.mirrors)
+	}
+}
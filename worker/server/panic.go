@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+var panicsTotal = metrics.NewCounter(
+	"ol_panics_total",
+	"Number of panics recovered while handling requests to run a lambda.",
+	"handler")
+
+// PanicResponse is the body of the response to a request whose handling
+// panicked.
+type PanicResponse struct {
+	Error     string `json:"error"`
+	RequestId string `json:"request_id"`
+	Handler   string `json:"handler,omitempty"`
+}
+
+// panicErr creates the httpErr of a request whose handling panicked with
+// value p, and logs the panic with stack. The worker is at fault, not the
+// handler.
+func panicErr(r *http.Request, name string, p interface{}, stack []byte) *httpErr {
+	panicsTotal.Inc(name)
+	requestLogger(r).WithField(logging.HANDLER, name).WithField("stack", string(stack)).Errorf("recovered panic: %v", p)
+	herr := newHttpErr(fmt.Sprintf("internal error: %v", p), http.StatusInternalServerError)
+	herr.panicked = true
+	return herr
+}
+
+// runLambdaRecovered runs RunLambdaErr, failing the request with an httpErr
+// rather than dropping its connection if it panics.
+func (s *Server) runLambdaRecovered(w http.ResponseWriter, r *http.Request) (herr *httpErr) {
+	defer func() {
+		if p := recover(); p != nil {
+			name := ""
+			if parts := getUrlComponents(r); len(parts) >= 2 {
+				name = parts[1]
+			}
+			herr = panicErr(r, name, p, debug.Stack())
+		}
+	}()
+	return s.RunLambdaErr(w, r)
+}
+
+// writePanic writes the structured 500 response of a request whose handling
+// panicked.
+func writePanic(w http.ResponseWriter, r *http.Request, herr *httpErr) {
+	resp := PanicResponse{Error: herr.msg, RequestId: logging.RequestId(r)}
+	if parts := getUrlComponents(r); len(parts) >= 2 {
+		resp.Handler = parts[1]
+	}
+	body, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(herr.code)
+	w.Write(body)
+}
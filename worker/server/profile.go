@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/open-lambda/open-lambda/worker/audit"
+	"github.com/open-lambda/open-lambda/worker/handler/state"
+	"github.com/open-lambda/open-lambda/worker/profiler"
+)
+
+// admin API paths of the continuous profiler
+const (
+	PROFILER_PATH = "/admin/profiler"
+	PROFILE_PATH  = "/admin/profile/"
+)
+
+// profileTargets returns the handlers whose sandboxes are running; paused
+// sandboxes do not use CPU and are not worth sampling.
+func (s *Server) profileTargets() []profiler.Target {
+	targets := []profiler.Target{}
+	for _, info := range s.handlers.List() {
+		if info.State != state.Running.String() {
+			continue
+		}
+		// the handler may have been evicted since
+		h := s.handlers.Lookup(info.Name)
+		if h == nil {
+			continue
+		}
+		if pid := h.RunningPid(); pid != "" {
+			targets = append(targets, profiler.Target{Handler: info.Name, Pid: pid})
+		}
+	}
+	return targets
+}
+
+// profilerAdmin serves the admin API of the continuous profiler.
+//
+// curl localhost:8080/admin/profiler
+// curl -X POST localhost:8080/admin/profiler?enabled=<true|false>
+// curl localhost:8080/admin/profile/<lambda-name>
+// curl -X DELETE localhost:8080/admin/profile/<lambda-name>
+func (s *Server) profilerAdmin(w http.ResponseWriter, r *http.Request, actor string) {
+	switch {
+	case r.URL.Path == PROFILER_PATH && r.Method == "GET":
+		w.Write([]byte(strconv.FormatBool(s.profiler.Enabled()) + "\n"))
+
+	case r.URL.Path == PROFILER_PATH && r.Method == "POST":
+		raw := r.URL.Query().Get("enabled")
+		enabled, err := strconv.ParseBool(raw)
+		s.auditLog.Record(actor, audit.CONFIG_CHANGE, map[string]string{"profiling": raw}, err)
+		if err != nil {
+			http.Error(w, "enabled must be true or false", http.StatusBadRequest)
+			return
+		}
+		s.profiler.SetEnabled(enabled)
+		w.WriteHeader(http.StatusNoContent)
+
+	case strings.HasPrefix(r.URL.Path, PROFILE_PATH) && r.Method == "GET":
+		name := strings.TrimPrefix(r.URL.Path, PROFILE_PATH)
+		w.Header().Set("Content-Type", "text/plain")
+		if !s.profiler.WriteFolded(w, name) {
+			http.Error(w, "no profile for "+name, http.StatusNotFound)
+		}
+
+	case strings.HasPrefix(r.URL.Path, PROFILE_PATH) && r.Method == "DELETE":
+		s.profiler.Reset(strings.TrimPrefix(r.URL.Path, PROFILE_PATH))
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "unknown admin operation", http.StatusNotFound)
+	}
+}
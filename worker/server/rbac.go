@@ -0,0 +1,183 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// roles of the actors of the admin API, each allowed what the previous one
+// is and more
+const (
+	VIEWER   = "viewer"   // reads state
+	OPERATOR = "operator" // also acts on handlers
+	ADMIN    = "admin"    // also changes settings and reads the audit log
+)
+
+var roleRanks = map[string]int{VIEWER: 1, OPERATOR: 2, ADMIN: 3}
+
+// ANONYMOUS is the actor recorded for admin requests that fail to
+// authenticate.
+const ANONYMOUS = "anonymous"
+
+// requiredRole returns the role needed for an admin request. Reads need
+// viewer, but for the audit log; changes of settings need admin, and other
+// actions operator.
+func requiredRole(r *http.Request) string {
+	switch {
+	case r.URL.Path == AUDIT_PATH:
+		return ADMIN
+	case r.Method == "GET" || r.Method == "HEAD":
+		return VIEWER
+	case r.URL.Path == FAULTS_PATH || r.URL.Path == PROFILER_PATH:
+		return ADMIN
+	default:
+		return OPERATOR
+	}
+}
+
+// allows reports whether an actor of the role may make a request needing
+// the other.
+func allows(role, required string) bool {
+	return roleRanks[role] > 0 && roleRanks[role] >= roleRanks[required]
+}
+
+// adminActor authenticates an admin request and returns the name of the actor
+// that made it, and its role. Requests carry a key of admin_keys or a JWT as
+// "Authorization: Bearer <key>"; if neither is configured, only requests from
+// localhost are accepted, as admin.
+func (s *Server) adminActor(r *http.Request) (actor, role string, err error) {
+	if len(s.config.Admin_keys) == 0 && s.config.Admin_jwt == nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return "", "", err
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			return "", "", errors.New("only local requests are accepted")
+		}
+		return "local", ADMIN, nil
+	}
+
+	// browsers (e.g., the dashboard) can pass the key as the password of
+	// basic auth instead
+	key := ""
+	if _, password, ok := r.BasicAuth(); ok {
+		key = password
+	} else if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimPrefix(auth, "Bearer ")
+	} else {
+		return "", "", errors.New("no credentials")
+	}
+
+	if actor, ok := s.config.Admin_keys[key]; ok {
+		if s.config.Admin_roles == nil {
+			return actor, ADMIN, nil
+		}
+		if role, ok := s.config.Admin_roles[actor]; ok {
+			return actor, role, nil
+		}
+		return actor, "", fmt.Errorf("%s has no role", actor)
+	}
+	if s.config.Admin_jwt != nil && strings.Count(key, ".") == 2 {
+		return verifyJWT(key, s.config.Admin_jwt, time.Now())
+	}
+	return "", "", errors.New("unknown key")
+}
+
+// verifyJWT checks the signature (HS256 only), lifetime, issuer and
+// audience of a JWT, and returns the actor and role its claims name. Tokens
+// must expire. A claim listing several roles grants the highest.
+func verifyJWT(token string, conf *config.AdminJWT, now time.Time) (actor, role string, err error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", "", errors.New("malformed JWT")
+	}
+	decode := func(part string, v interface{}) error {
+		buf, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return fmt.Errorf("malformed JWT: %v", err)
+		}
+		if err := json.Unmarshal(buf, v); err != nil {
+			return fmt.Errorf("malformed JWT: %v", err)
+		}
+		return nil
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decode(parts[0], &header); err != nil {
+		return "", "", err
+	}
+	if header.Alg != "HS256" {
+		return "", "", fmt.Errorf("JWT signed with %q, not HS256", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", "", fmt.Errorf("malformed JWT: %v", err)
+	}
+	mac := hmac.New(sha256.New, []byte(conf.Secret))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return "", "", errors.New("bad JWT signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decode(parts[1], &claims); err != nil {
+		return "", "", err
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", "", errors.New("JWT does not expire")
+	} else if now.Unix() >= int64(exp) {
+		return "", "", errors.New("JWT expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Unix() < int64(nbf) {
+		return "", "", errors.New("JWT not valid yet")
+	}
+	if conf.Issuer != "" && claims["iss"] != conf.Issuer {
+		return "", "", fmt.Errorf("JWT not issued by %s", conf.Issuer)
+	}
+	if conf.Audience != "" && !hasClaim(claims["aud"], conf.Audience) {
+		return "", "", fmt.Errorf("JWT not meant for %s", conf.Audience)
+	}
+
+	actor, _ = claims[conf.Actor_claim].(string)
+	if actor == "" {
+		return "", "", fmt.Errorf("JWT has no %s claim", conf.Actor_claim)
+	}
+	for name := range roleRanks {
+		if hasClaim(claims[conf.Role_claim], name) && roleRanks[name] > roleRanks[role] {
+			role = name
+		}
+	}
+	if role == "" {
+		return actor, "", fmt.Errorf("%s has no role", actor)
+	}
+	return actor, role, nil
+}
+
+// hasClaim reports whether a claim, a string or a list of them, holds the
+// value.
+func hasClaim(claim interface{}, value string) bool {
+	switch c := claim.(type) {
+	case string:
+		return c == value
+	case []interface{}:
+		for _, v := range c {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/audit"
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// signJWT returns an HS256 JWT of the claims.
+func signJWT(secret string, claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, _ := json.Marshal(claims)
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestRequiredRole(t *testing.T) {
+	for _, c := range []struct {
+		method, path, role string
+	}{
+		{"GET", USAGE_PATH, VIEWER},
+		{"GET", DASHBOARD_DATA_PATH, VIEWER},
+		{"GET", AUDIT_PATH, ADMIN},
+		{"POST", EVICT_PATH + "f", OPERATOR},
+		{"POST", DEPLOY_PATH + "f", OPERATOR},
+		{"POST", FAULTS_PATH, ADMIN},
+		{"POST", PROFILER_PATH, ADMIN},
+		{"DELETE", PROFILE_PATH + "f", OPERATOR},
+	} {
+		if role := requiredRole(httptest.NewRequest(c.method, c.path, nil)); role != c.role {
+			t.Errorf("%s %s: expected %s, got %s", c.method, c.path, c.role, role)
+		}
+	}
+	if !allows(ADMIN, OPERATOR) || allows(VIEWER, OPERATOR) || allows("", VIEWER) {
+		t.Fatal("unexpected role ordering")
+	}
+}
+
+func TestAdminActor(t *testing.T) {
+	conf := &config.Config{
+		Admin_keys:  map[string]string{"k1": "alice", "k2": "bob"},
+		Admin_roles: map[string]string{"alice": OPERATOR},
+		Admin_jwt:   &config.AdminJWT{Secret: "s3cret", Audience: "ol", Actor_claim: "sub", Role_claim: "role"},
+	}
+	s := &Server{config: conf}
+	request := func(token string) (string, string, error) {
+		r := httptest.NewRequest("GET", USAGE_PATH, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return s.adminActor(r)
+	}
+
+	if actor, role, err := request("k1"); err != nil || actor != "alice" || role != OPERATOR {
+		t.Fatalf("unexpected %s/%s/%v", actor, role, err)
+	}
+	if actor, _, err := request("k2"); err == nil || actor != "bob" {
+		t.Fatal("expected actor without a role to be refused")
+	}
+	if _, _, err := request(""); err == nil {
+		t.Fatal("expected request without credentials to be refused")
+	}
+
+	exp := float64(time.Now().Add(time.Hour).Unix())
+	token := signJWT("s3cret", map[string]interface{}{"sub": "carol", "aud": []string{"ol"}, "role": []string{VIEWER, ADMIN}, "exp": exp})
+	if actor, role, err := request(token); err != nil || actor != "carol" || role != ADMIN {
+		t.Fatalf("unexpected %s/%s/%v", actor, role, err)
+	}
+
+	for name, claims := range map[string]map[string]interface{}{
+		"expired":       {"sub": "carol", "aud": "ol", "role": ADMIN, "exp": float64(time.Now().Add(-time.Minute).Unix())},
+		"never expires": {"sub": "carol", "aud": "ol", "role": ADMIN},
+		"wrong aud":     {"sub": "carol", "aud": "other", "role": ADMIN, "exp": exp},
+		"unknown role":  {"sub": "carol", "aud": "ol", "role": "root", "exp": exp},
+	} {
+		if _, _, err := request(signJWT("s3cret", claims)); err == nil {
+			t.Errorf("%s: expected JWT to be refused", name)
+		}
+	}
+	if _, _, err := request(signJWT("guess", map[string]interface{}{"sub": "carol", "aud": "ol", "role": ADMIN, "exp": exp})); err == nil {
+		t.Fatal("expected JWT with a bad signature to be refused")
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"carol","aud":"ol","role":"admin","exp":9999999999}`)) + "."
+	if _, _, err := request(none); err == nil {
+		t.Fatal("expected unsigned JWT to be refused")
+	}
+
+	// without keys or JWTs, only local requests are accepted
+	s.config = &config.Config{}
+	r := httptest.NewRequest("POST", FAULTS_PATH, nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if _, _, err := s.adminActor(r); err == nil {
+		t.Fatal("expected remote request to be refused")
+	}
+	r.RemoteAddr = "127.0.0.1:1234"
+	if actor, role, err := s.adminActor(r); err != nil || actor != "local" || role != ADMIN {
+		t.Fatalf("unexpected %s/%s/%v", actor, role, err)
+	}
+}
+
+func TestViewer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auditLog, err := audit.Open(filepath.Join(dir, "audit.log"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		config: &config.Config{
+			Admin_keys:  map[string]string{"k1": "alice", "k2": "bob"},
+			Admin_roles: map[string]string{"alice": VIEWER},
+		},
+		auditLog: auditLog,
+	}
+	stats := s.viewer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("stats"))
+	}))
+
+	for _, c := range []struct {
+		key  string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{"k2", http.StatusForbidden},
+		{"k1", http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", "/stats", nil)
+		if c.key != "" {
+			r.Header.Set("Authorization", "Bearer "+c.key)
+		}
+		w := httptest.NewRecorder()
+		stats(w, r)
+		if w.Code != c.code {
+			t.Errorf("key %q: expected %d, got %d", c.key, c.code, w.Code)
+		}
+	}
+
+	// every decision is audited
+	entries, err := auditLog.Entries(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[2].Actor != "alice" || entries[2].Params["path"] != "/stats" {
+		t.Fatalf("unexpected audit entries %v", entries)
+	}
+	if !strings.Contains(entries[0].Result, "no credentials") {
+		t.Fatalf("expected the anonymous request to be recorded as refused, got %v", entries[0])
+	}
+}
@@ -2,19 +2,44 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/accounting"
+	"github.com/open-lambda/open-lambda/worker/anomaly"
+	"github.com/open-lambda/open-lambda/worker/audit"
 	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/coord"
+	"github.com/open-lambda/open-lambda/worker/faults"
 	"github.com/open-lambda/open-lambda/worker/handler"
+	"github.com/open-lambda/open-lambda/worker/handler/meta"
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/metrics"
 	pmanager "github.com/open-lambda/open-lambda/worker/pool-manager"
+	"github.com/open-lambda/open-lambda/worker/profiler"
 	"github.com/open-lambda/open-lambda/worker/registry"
 	"github.com/open-lambda/open-lambda/worker/sandbox"
+	"github.com/open-lambda/open-lambda/worker/scan"
+	"github.com/open-lambda/open-lambda/worker/sysaudit"
+	"github.com/open-lambda/open-lambda/worker/tlsutil"
+	"github.com/open-lambda/open-lambda/worker/trace"
+	"github.com/open-lambda/open-lambda/worker/webhook"
 )
 
 // Server is a worker server that listens to run lambda requests and forward
@@ -22,19 +47,88 @@ import (
 type Server struct {
 	config   *config.Config
 	handlers *handler.HandlerSet
+	store    *coord.Store
+	elector  *coord.Elector
+	logs     *logStore
+	notifier *webhook.Notifier
+	auditLog *audit.Log
+	meter    *accounting.Meter
+	errors   errorLog
+	inits    initLog
+	dumps    dumpLimiter
+	detector *anomaly.Detector
+	profiler *profiler.Profiler
+	poolMgr  pmanager.PoolManager
+	pusher   *metrics.StatsdPusher
+	tls      *tlsutil.Reloader // nil unless mutual TLS is configured
+
+	// sends mirrored requests to other workers
+	mirrorClient *http.Client
+
+	// mirrored requests in flight (accessed atomically)
+	mirrors int32
+
+	// set once the worker is shutting down (accessed atomically)
+	draining int32
+
+	// closed to stop the background sources, which mark themselves done
+	// in sources
+	stop    chan struct{}
+	sources sync.WaitGroup
 }
 
+// WorkerStats is the body of the response to a stats request.
+type WorkerStats struct {
+	Handlers []handler.HandlerInfo `json:"handlers"`
+}
+
+var (
+	invocationsTotal = metrics.NewCounter(
+		"ol_invocations_total",
+		"Number of requests to run a lambda.",
+		"handler")
+	invocationErrorsTotal = metrics.NewCounter(
+		"ol_invocation_errors_total",
+		"Number of requests to run a lambda that failed or returned a 5xx status.",
+		"handler")
+)
+
 // httpErr is a wrapper for an http error and the return code of the request.
 type httpErr struct {
 	msg  string
 	code int
+
+	// seconds the client should wait before retrying, sent as Retry-After
+	retryAfter int
+
+	// the request was abandoned by its client, so the error says nothing
+	// about the handler
+	abandoned bool
+
+	// the worker panicked handling the request, which is not the fault
+	// of the handler either
+	panicked bool
 }
 
+// STATUS_CLIENT_CLOSED is the (nonstandard) status of requests whose client
+// disconnected before they completed.
+const STATUS_CLIENT_CLOSED = 499
+
 // newHttpErr creates an httpErr.
 func newHttpErr(msg string, code int) *httpErr {
 	return &httpErr{msg: msg, code: code}
 }
 
+// abandonedErr creates the httpErr of a request whose context is done.
+func abandonedErr(err error) *httpErr {
+	herr := newHttpErr(err.Error(), STATUS_CLIENT_CLOSED)
+	if err == context.DeadlineExceeded {
+		herr.code = http.StatusGatewayTimeout
+	}
+	herr.abandoned = true
+	return herr
+}
+
 // initPManager creates a pool manager according to config.
 func initPManager(config *config.Config) (pm pmanager.PoolManager, err error) {
 	if config.Pool == "basic" {
@@ -50,16 +144,19 @@ func initPManager(config *config.Config) (pm pmanager.PoolManager, err error) {
 
 // initRegManager creates a registry manager according to config.
 func initRegManager(config *config.Config) (rm registry.RegistryManager, err error) {
-	if config.Registry == "olregistry" {
+	if config.Registry == registry.OLSTORE {
 		rm, err = registry.NewOLStoreManager(config)
-	} else if config.Registry == "local" {
+	} else if config.Registry == registry.LOCAL {
 		rm, err = registry.NewLocalManager(config)
 	} else {
 		return nil, errors.New("invalid 'registry' field in config")
 		return nil, errors.New(fmt.Sprintf("invalid 'registry' field in config: %v", config.Registry))
 	}
+	if err != nil {
+		return nil, err
+	}
 
-	return rm, nil
+	return registry.Instrument(config.Registry, rm), nil
 }
 
 // initSBFactory creates a sandbox factory according to config.
@@ -106,41 +203,191 @@ func NewServer(config *config.Config) (*Server, error) {
 		return nil, err
 	}
 
+	store, elector, err := initCoordination(config)
+	if err != nil {
+		return nil, err
+	}
+
+	auditLog, err := audit.Open(config.Audit_log, config.Audit_sink)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := faults.Configure(config.Faults); err != nil {
+		return nil, fmt.Errorf("invalid faults config: %v", err)
+	}
+	if err := checkShutdownTimeouts(config.Shutdown_timeouts); err != nil {
+		return nil, err
+	}
+	scanner, err := scan.New(config)
+	if err != nil {
+		return nil, err
+	}
+	tls, err := tlsutil.New(config.Tls)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := webhook.NewNotifier(config)
 	opts := handler.HandlerSetOpts{
 		RegMgr:    regMgr,
 		SbFactory: sbFactory,
 		PoolMgr:   poolMgr,
 		Config:    config,
 		Lru:       handler.NewHandlerLRU(100), // TODO(tyler)
+		Notifier:  notifier,
+		Scanner:   scanner,
+		Recorder:  sysaudit.New(config),
+	}
+	if store != nil {
+		opts.Limiter = coord.NewSemaphore(store, leaderTTL)
 	}
 	server := &Server{
 		config:   config,
 		handlers: handler.NewHandlerSet(opts),
+		store:    store,
+		elector:  elector,
+		notifier: notifier,
+		auditLog: auditLog,
+		meter:    accounting.NewMeter(config),
+		detector: newDetector(config, notifier),
+		poolMgr:  poolMgr,
+		tls:      tls,
+		stop:     make(chan struct{}),
+
+		mirrorClient: tls.Client(30 * time.Second),
+	}
+	// sandboxes and directories left by a previous run are adopted or
+	// removed before any request comes in
+	if _, err := server.handlers.Reconcile(); err != nil {
+		log.WithError(err).Warn("could not reconcile state left by the previous run")
+	}
+	if server.meter != nil {
+		go server.meter.Run(time.Duration(config.Accounting_interval) * time.Second)
+	}
+	if config.Statsd_addr != "" {
+		server.pusher, err = metrics.Default.NewStatsdPusher(
+			config.Statsd_addr, config.Statsd_prefix, config.Statsd_tags, config.Statsd_dogstatsd)
+		if err != nil {
+			return nil, err
+		}
+		server.sources.Add(1)
+		go func() {
+			defer server.sources.Done()
+			server.pusher.Run(time.Duration(config.Statsd_interval)*time.Second, server.stop)
+		}()
+	}
+	if server.detector != nil {
+		server.sources.Add(1)
+		go func() {
+			defer server.sources.Done()
+			server.detector.Run(server.stop)
+		}()
+	}
+	if config.Sandbox_stats_interval > 0 {
+		go server.handlers.CollectUsage(time.Duration(config.Sandbox_stats_interval) * time.Second)
+	}
+	if config.Leak_check_interval > 0 {
+		go server.handlers.DetectLeaks(time.Duration(config.Leak_check_interval) * time.Second)
+	}
+	if config.Watchdog_interval > 0 {
+		go server.handlers.Watchdog(time.Duration(config.Watchdog_interval) * time.Second)
+	}
+	if config.Disk_min_free > 0 {
+		go server.handlers.MonitorDisk()
+	}
+	if config.Fd_budget > 0 {
+		go server.handlers.MonitorFds()
+	}
+	server.profiler = profiler.NewProfiler(config.Profiling,
+		time.Duration(config.Profile_interval)*time.Second,
+		time.Duration(config.Profile_duration)*time.Second,
+		server.profileTargets)
+	go server.profiler.Run()
+	if config.Log_capture > 0 {
+		server.logs = newLogStore(config.Log_capture)
+	}
+
+	if store != nil {
+		server.sources.Add(1)
+		go func() {
+			defer server.sources.Done()
+			runCoordination(store, elector, server.stop)
+		}()
 	}
 
 	return server, nil
 }
 
-// ForwardToSandbox forwards a run lambda request to a sandbox.
-func (s *Server) ForwardToSandbox(handler *handler.Handler, r *http.Request, input []byte) ([]byte, *http.Response, *httpErr) {
-	channel, err := handler.RunStart()
-	if err != nil {
+// ForwardToSandbox forwards a run lambda request to a sandbox. Failures to
+// start the sandbox, forwarding errors and 5xx responses count against the
+// circuit breaker of the handler. Starting the sandbox and forwarding are
+// abandoned once the context of r is done.
+func (s *Server) ForwardToSandbox(h *handler.Handler, r *http.Request, input []byte) (wbody []byte, w2 *http.Response, herr *httpErr) {
+	received := time.Now()
+	ctx := r.Context()
+	channel, timings, err := h.RunStart(ctx)
+	if err != nil && ctx.Err() != nil {
+		return nil, nil, abandonedErr(ctx.Err())
+	} else if err == coord.ErrLimitReached {
+		return nil, nil, newHttpErr(
+			err.Error(),
+			http.StatusTooManyRequests)
+	} else if _, ok := err.(*handler.DiskPressureError); ok {
+		return nil, nil, newHttpErr(
+			err.Error(),
+			http.StatusInsufficientStorage)
+	} else if _, ok := err.(*scan.VetoError); ok {
+		return nil, nil, newHttpErr(
+			err.Error(),
+			http.StatusForbidden)
+	} else if _, ok := err.(*meta.IncompatibleError); ok {
+		// another worker may provide what the handler needs
+		return nil, nil, newHttpErr(
+			err.Error(),
+			http.StatusNotImplemented)
+	} else if perr, ok := err.(*handler.PanicError); ok {
+		return nil, nil, panicErr(r, perr.Handler, perr.Value, perr.Stack)
+	} else if coe, ok := err.(*handler.CircuitOpenError); ok {
+		herr = newHttpErr(err.Error(), http.StatusServiceUnavailable)
+		herr.retryAfter = int(math.Ceil(coe.RetryAfter.Seconds()))
+		return nil, nil, herr
+	} else if err != nil {
+		// the sandbox machinery failing is not the handler's fault
+		if _, ok := err.(*handler.InfraError); !ok {
+			h.Report(false)
+		}
 		return nil, nil, newHttpErr(
 			err.Error(),
 			http.StatusInternalServerError)
 	}
 
-	defer handler.RunFinish()
+	// a panic while forwarding fails the request only; RunFinish, deferred
+	// below, runs first, so the Handler is left consistent
+	defer func() {
+		if p := recover(); p != nil {
+			wbody, w2, herr = nil, nil, panicErr(r, h.Name(), p, debug.Stack())
+		}
+		if herr == nil || !(herr.abandoned || herr.panicked) {
+			h.Report(herr == nil && w2.StatusCode < 500)
+		}
+	}()
+	defer h.RunFinish()
+	defer s.watchSlow(h, r, received)()
 
 	// forward request to sandbox.  r and w are the server
 	// request and response respectively.  r2 and w2 are the
 	// sandbox request and response respectively.
 	url := fmt.Sprintf("%s%s", channel.Url, r.URL.Path)
 
+	// the sandbox joins the trace of the caller as a child of this hop
+	span := trace.FromRequest(r)
+
 	// TODO(tyler): some sort of smarter backoff.  Or, a better
 	// way to detect a started sandbox.
 	max_tries := 10
 	errors := []error{}
+	begin := time.Now()
 	for tries := 1; ; tries++ {
 		r2, err := http.NewRequest(r.Method, url, bytes.NewReader(input))
 		if err != nil {
@@ -148,38 +395,72 @@ func (s *Server) ForwardToSandbox(handler *handler.Handler, r *http.Request, inp
 				err.Error(),
 				http.StatusInternalServerError)
 		}
+		r2 = r2.WithContext(ctx)
 
 		r2.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+		r2.Header.Set(logging.REQUEST_HEADER, r.Header.Get(logging.REQUEST_HEADER))
+		span.Inject(r2)
+		if s.wantsLogs(r) {
+			r2.Header.Set(CAPTURE_HEADER, "1")
+		}
 		client := &http.Client{Transport: &channel.Transport}
 		w2, err := client.Do(r2)
-		if err != nil {
+		if err != nil && ctx.Err() != nil {
+			return nil, nil, abandonedErr(ctx.Err())
+		} else if err != nil {
 			errors = append(errors, err)
 			if tries == max_tries {
-				log.Printf("Forwarding request to container failed after %v tries\n", max_tries)
+				logger := requestLogger(r).WithFields(log.Fields{
+					logging.HANDLER:  h.Name(),
+					logging.TRACE_ID: span.TraceId,
+				})
+				logger.Errorf("forwarding request to sandbox failed after %v tries", max_tries)
 				for i, item := range errors {
-					log.Printf("Attempt %v: %v\n", i, item.Error())
+					logger.WithError(item).Debugf("attempt %v", i)
 				}
-				return nil, nil, newHttpErr(
-					err.Error(),
-					http.StatusInternalServerError)
+				s.notifier.Notify(webhook.SANDBOX_CRASHED, h.Name(), map[string]string{
+					"error":     err.Error(),
+					"requestID": logging.RequestId(r),
+				})
+				code := http.StatusInternalServerError
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					code = http.StatusGatewayTimeout
+				}
+				return nil, nil, newHttpErr(err.Error(), code)
+			}
+			select {
+			case <-time.After(time.Duration(tries*100) * time.Millisecond):
+			case <-ctx.Done():
+				return nil, nil, abandonedErr(ctx.Err())
 			}
-			time.Sleep(time.Duration(tries*100) * time.Millisecond)
 			continue
 		}
+		timings.Observe(startup.FIRST_BYTE, begin)
+		w2.Header.Set(startup.HEADER, timings.Start)
+		w2.Header.Set(startup.TIMING_HEADER, timings.ServerTiming())
 
 		defer w2.Body.Close()
 		wbody, err := ioutil.ReadAll(w2.Body)
-		if err != nil {
+		if err != nil && ctx.Err() != nil {
+			return nil, nil, abandonedErr(ctx.Err())
+		} else if err != nil {
 			return nil, nil, newHttpErr(
 				err.Error(),
 				http.StatusInternalServerError)
 		}
+		s.reportSlow(h, r, timings, time.Since(received))
 		return wbody, w2, nil
 	}
 }
 
 // RunLambdaErr handles the run lambda request and return an http error if any.
 func (s *Server) RunLambdaErr(w http.ResponseWriter, r *http.Request) *httpErr {
+	if s.isDraining() {
+		herr := newHttpErr("worker is shutting down", http.StatusServiceUnavailable)
+		herr.retryAfter = 1
+		return herr
+	}
+
 	// components represent runLambda[0]/<name_of_sandbox>[1]/<extra_things>...
 	// ergo we want [1] for name of sandbox
 	urlParts := getUrlComponents(r)
@@ -208,12 +489,35 @@ func (s *Server) RunLambdaErr(w http.ResponseWriter, r *http.Request) *httpErr {
 	}
 
 	// forward to sandbox
+	invocationsTotal.Inc(img)
 	handler := s.handlers.Get(img)
+	begin := time.Now()
 	wbody, w2, err := s.ForwardToSandbox(handler, r, rbody)
+	// usage is billed to the tenant the worker config assigns, never
+	// one the handler names itself
+	if m := handler.Meta(); m != nil && w2 != nil {
+		s.meter.Add(img, s.config.TenantOf(img), time.Since(begin), m.Memory_mb)
+	}
 	if err != nil {
+		invocationErrorsTotal.Inc(img)
+		// requests failed fast by an open circuit, or abandoned by
+		// their client, would only inflate the error rate of the handler
+		if err.code != http.StatusServiceUnavailable && !err.abandoned && !err.panicked {
+			s.recordOutcome(img, err.code)
+		}
 		return err
 	}
+	s.recordOutcome(img, w2.StatusCode)
+	s.inits.add(img, w2)
+	if w2.StatusCode >= 500 {
+		invocationErrorsTotal.Inc(img)
+		s.recordError(r, w2.StatusCode, string(wbody))
+	}
+	s.maybeMirror(img, handler.Meta(), r, rbody)
+	s.collectLogs(img, r, w, w2)
 
+	w.Header().Set(startup.HEADER, w2.Header.Get(startup.HEADER))
+	w.Header().Set(startup.TIMING_HEADER, w2.Header.Get(startup.TIMING_HEADER))
 	w.WriteHeader(w2.StatusCode)
 
 	if _, err := w.Write(wbody); err != nil {
@@ -229,9 +533,11 @@ func (s *Server) RunLambdaErr(w http.ResponseWriter, r *http.Request) *httpErr {
 //
 // curl -X POST localhost:8080/runLambda/<lambda-name> -d '{}'
 func (s *Server) RunLambda(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Receive request to %s\n", r.URL.Path)
+	logger := requestLogger(r)
+	logger.Debug("receive request")
 
 	// write response headers
+	w.Header().Set(logging.REQUEST_HEADER, logging.RequestId(r))
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods",
 		"GET, PUT, POST, DELETE, OPTIONS")
@@ -241,9 +547,17 @@ func (s *Server) RunLambda(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
 	} else {
-		if err := s.RunLambdaErr(w, r); err != nil {
-			log.Printf("could not handle request: %s\n", err.msg)
-			http.Error(w, err.msg, err.code)
+		if err := s.runLambdaRecovered(w, r); err != nil {
+			logger.WithField("code", err.code).Warnf("could not handle request: %s", err.msg)
+			s.recordError(r, err.code, err.msg)
+			if err.retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(err.retryAfter))
+			}
+			if err.panicked {
+				writePanic(w, r, err)
+			} else {
+				http.Error(w, err.msg, err.code)
+			}
 		}
 	}
 
@@ -251,7 +565,13 @@ func (s *Server) RunLambda(w http.ResponseWriter, r *http.Request) {
 
 // Status writes "ready" to the response.
 func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Receive request to %s\n", r.URL.Path)
+	requestLogger(r).Debug("receive request")
+
+	// tell load balancers to send requests elsewhere
+	if s.isDraining() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
 
 	wbody := []byte("ready")
 	if _, err := w.Write(wbody); err != nil {
@@ -259,6 +579,47 @@ func (s *Server) Status(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Stats writes the state of every handler of the worker as JSON.
+func (s *Server) Stats(w http.ResponseWriter, r *http.Request) {
+	stats := WorkerStats{Handlers: s.handlers.List()}
+	wbody, err := json.Marshal(stats)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(wbody); err != nil {
+		requestLogger(r).WithError(err).Warn("could not write stats")
+	}
+}
+
+// recordError remembers a failed request for the dashboard.
+func (s *Server) recordError(r *http.Request, code int, msg string) {
+	name := ""
+	if parts := getUrlComponents(r); len(parts) >= 2 {
+		name = parts[1]
+	}
+	if len(msg) > 500 {
+		msg = msg[:500]
+	}
+	s.errors.add(RequestError{
+		Time:      time.Now(),
+		Handler:   name,
+		RequestId: logging.RequestId(r),
+		Code:      code,
+		Msg:       msg,
+	})
+}
+
+// requestLogger returns a logger tagged with the ID and path of r.
+func requestLogger(r *http.Request) *log.Entry {
+	return log.WithFields(log.Fields{
+		logging.REQUEST_ID: logging.RequestId(r),
+		"path":             r.URL.Path,
+	})
+}
+
 // getUrlComponents parses request URL into its "/" delimated components
 func getUrlComponents(r *http.Request) []string {
 	path := r.URL.Path
@@ -279,14 +640,17 @@ func getUrlComponents(r *http.Request) []string {
 
 // Main starts a server.
 func Main(config_path string) {
-	log.Printf("Parse config\n")
+	log.Info("Parse config")
 	conf, err := config.ParseConfig(config_path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := logging.Init(conf); err != nil {
+		log.Fatal(err)
+	}
 
 	// start serving
-	log.Printf("Create server\n")
+	log.Info("Create server")
 	server, err := NewServer(conf)
 	if err != nil {
 		log.Fatal(err)
@@ -295,9 +659,48 @@ func Main(config_path string) {
 	port := fmt.Sprintf(":%s", conf.Worker_port)
 	run_path := "/runLambda/"
 	status_path := "/status"
+	stats_path := "/stats"
+	metrics_path := "/metrics"
 	http.HandleFunc(run_path, server.RunLambda)
 	http.HandleFunc(status_path, server.Status)
-	log.Printf("Execute handler by POSTing to localhost%s%s%s\n", port, run_path, "<lambda>")
-	log.Printf("Get status by sending request to localhost%s%s\n", port, status_path)
-	log.Fatal(http.ListenAndServe(port, nil))
+	http.Handle(stats_path, server.viewer(http.HandlerFunc(server.Stats)))
+	if conf.Public_metrics {
+		http.Handle(metrics_path, metrics.Default)
+	} else {
+		http.Handle(metrics_path, server.viewer(metrics.Default))
+	}
+	http.HandleFunc(ADMIN_PATH, server.Admin)
+	log.Infof("Execute handler by POSTing to localhost%s%s%s", port, run_path, "<lambda>")
+	log.Infof("Get status by sending request to localhost%s%s", port, status_path)
+	log.Infof("Get handler stats by sending request to localhost%s%s", port, stats_path)
+	log.Infof("Scrape metrics from localhost%s%s", port, metrics_path)
+	log.Infof("Get captured handler output from localhost%s%s%s", port, LOGS_PATH, "<invocation-id>")
+
+	httpServer := &http.Server{Addr: port, TLSConfig: server.tls.ServerConfig()}
+	go func() {
+		// with mutual TLS, the certificates come from the TLSConfig
+		serve := httpServer.ListenAndServe
+		if server.tls != nil {
+			serve = func() error { return httpServer.ListenAndServeTLS("", "") }
+		}
+		if err := serve(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// shut down in order on the first signal; a second one forces the
+	// worker to exit without waiting for the remaining stages
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	log.WithField("signal", sig.String()).Info("shutting down, signal again to force")
+	force := make(chan struct{})
+	go func() {
+		<-signals
+		close(force)
+	}()
+	if !server.Shutdown(httpServer, sig.String(), force) {
+		os.Exit(1)
+	}
+	os.Exit(0)
 }
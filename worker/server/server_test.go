@@ -111,6 +111,94 @@ func TestHello(t *testing.T) {
 	}
 }
 
+func TestHelloNodejs(t *testing.T) {
+	recv, err := testReq("hello-nodejs", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"hello\""
+	if recv != expected {
+		t.Fatalf("Expected '%v' from hello-nodejs but got back '%v'\n", expected, recv)
+	}
+}
+
+func TestHelloRuby(t *testing.T) {
+	recv, err := testReq("hello-ruby", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"hello\""
+	if recv != expected {
+		t.Fatalf("Expected '%v' from hello-ruby but got back '%v'\n", expected, recv)
+	}
+}
+
+func TestHelloR(t *testing.T) {
+	recv, err := testReq("hello-r", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"hello\""
+	if recv != expected {
+		t.Fatalf("Expected '%v' from hello-r but got back '%v'\n", expected, recv)
+	}
+}
+
+func TestHelloGo(t *testing.T) {
+	recv, err := testReq("hello-go", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"hello\""
+	if recv != expected {
+		t.Fatalf("Expected '%v' from hello-go but got back '%v'\n", expected, recv)
+	}
+}
+
+func TestHelloJava(t *testing.T) {
+	recv, err := testReq("hello-java", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"hello\""
+	if recv != expected {
+		t.Fatalf("Expected '%v' from hello-java but got back '%v'\n", expected, recv)
+	}
+}
+
+func TestHelloDotnet(t *testing.T) {
+	recv, err := testReq("hello-dotnet", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"hello\""
+	if recv != expected {
+		t.Fatalf("Expected '%v' from hello-dotnet but got back '%v'\n", expected, recv)
+	}
+}
+
+func TestHelloCustom(t *testing.T) {
+	recv, err := testReq("hello-custom", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"hello\""
+	if recv != expected {
+		t.Fatalf("Expected '%v' from hello-custom but got back '%v'\n", expected, recv)
+	}
+}
+
+func TestHelloExec(t *testing.T) {
+	recv, err := testReq("hello-exec", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "\"hello\""
+	if recv != expected {
+		t.Fatalf("Expected '%v' from hello-exec but got back '%v'\n", expected, recv)
+	}
+}
+
 func TestEcho(t *testing.T) {
 	values := []string{
 		"{}",
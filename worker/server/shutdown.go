@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/audit"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/shutdown"
+	"github.com/open-lambda/open-lambda/worker/webhook"
+)
+
+// shutdown stages, in the order they run
+const (
+	STOP_ACCEPTING = "stop_accepting" // new requests fail with 503
+	DRAIN          = "drain"          // wait for running requests
+	STOP_SOURCES   = "stop_sources"   // coordination, profiling, statsd pushes and alert checks
+	STOP_POOLS     = "stop_pools"     // pool containers are removed
+	STOP_SANDBOXES = "stop_sandboxes" // sandboxes are removed
+	FLUSH          = "flush"          // webhooks, usage, metrics, logs
+)
+
+// SHUTDOWN_STAGES are the stages of a shutdown, in order, with their default
+// timeouts.
+var SHUTDOWN_STAGES = []struct {
+	Name    string
+	Timeout time.Duration
+}{
+	{STOP_ACCEPTING, 5 * time.Second},
+	{DRAIN, 60 * time.Second},
+	{STOP_SOURCES, 10 * time.Second},
+	{STOP_POOLS, 30 * time.Second},
+	{STOP_SANDBOXES, 60 * time.Second},
+	{FLUSH, 10 * time.Second},
+}
+
+// checkShutdownTimeouts verifies that the shutdown_timeouts of the config
+// name known stages.
+func checkShutdownTimeouts(timeouts map[string]int) error {
+	for name, seconds := range timeouts {
+		known := false
+		for _, stage := range SHUTDOWN_STAGES {
+			known = known || stage.Name == name
+		}
+		if !known {
+			return fmt.Errorf("shutdown_timeouts: unknown stage %q", name)
+		} else if seconds <= 0 {
+			return fmt.Errorf("shutdown_timeouts: timeout of %s must be positive", name)
+		}
+	}
+	return nil
+}
+
+// isDraining reports whether the worker is shutting down.
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) != 0
+}
+
+// Shutdown stops the worker, subsystem by subsystem, in the order of
+// SHUTDOWN_STAGES, so that none is stopped while another still uses it:
+// requests are turned away and those running are waited for before the
+// sandboxes they run in are removed, and the events and records of all of
+// that are flushed last. httpServer is the server of the worker API, and
+// reason is recorded in the drain event. Stages that overrun their timeout
+// are abandoned, and closing force skips the remaining ones. Shutdown
+// reports whether every stage completed.
+func (s *Server) Shutdown(httpServer *http.Server, reason string, force <-chan struct{}) bool {
+	run := map[string]func(ctx context.Context) error{
+		STOP_ACCEPTING: func(ctx context.Context) error {
+			atomic.StoreInt32(&s.draining, 1)
+			s.notifier.Notify(webhook.WORKER_DRAINING, "", map[string]string{"reason": reason})
+			return s.auditLog.Record("signal", audit.DRAIN, map[string]string{"reason": reason}, nil)
+		},
+		DRAIN: func(ctx context.Context) error {
+			return httpServer.Shutdown(ctx)
+		},
+		STOP_SOURCES: func(ctx context.Context) error {
+			close(s.stop)
+			s.profiler.SetEnabled(false)
+			done := make(chan struct{})
+			go func() {
+				s.sources.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		STOP_POOLS: func(ctx context.Context) error {
+			if s.poolMgr == nil {
+				return nil
+			}
+			return s.poolMgr.Shutdown(ctx)
+		},
+		STOP_SANDBOXES: func(ctx context.Context) error {
+			removed, err := s.handlers.StopSandboxes(ctx, s.config.Shutdown_keep_sandboxes)
+			log.WithField("removed", removed).Info("stopped sandboxes")
+			return err
+		},
+		FLUSH: s.flush,
+	}
+
+	stages := make([]shutdown.Stage, 0, len(SHUTDOWN_STAGES))
+	for _, stage := range SHUTDOWN_STAGES {
+		timeout := stage.Timeout
+		if seconds, ok := s.config.Shutdown_timeouts[stage.Name]; ok {
+			timeout = time.Duration(seconds) * time.Second
+		}
+		stages = append(stages, shutdown.Stage{Name: stage.Name, Timeout: timeout, Run: run[stage.Name]})
+	}
+	return shutdown.Run(stages, force)
+}
+
+// flush delivers what the worker still holds in memory: webhook events, usage
+// records, metrics and forwarded logs. The audit log is closed last.
+func (s *Server) flush(ctx context.Context) error {
+	deadline, _ := ctx.Deadline()
+	s.notifier.Flush(time.Until(deadline))
+	if err := s.meter.Flush(); err != nil {
+		log.WithError(err).Warn("could not flush usage records")
+	}
+	if s.pusher != nil {
+		if err := s.pusher.Push(); err != nil {
+			log.WithError(err).Warn("could not push metrics")
+		}
+	}
+	logging.Flush(time.Until(deadline))
+	return s.auditLog.Close()
+}
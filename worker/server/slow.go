@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/handler"
+	"github.com/open-lambda/open-lambda/worker/handler/startup"
+	"github.com/open-lambda/open-lambda/worker/logging"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+	"github.com/open-lambda/open-lambda/worker/sandbox"
+)
+
+// PROFILE_TIMEOUT bounds how long a stack dump of a sandbox may take.
+const PROFILE_TIMEOUT = 30 * time.Second
+
+// safeRequestId matches request IDs that can be used as file names; IDs come
+// from clients, and others could point outside of the profiles directory.
+var safeRequestId = regexp.MustCompile("^[0-9a-zA-Z-]+$")
+
+var (
+	slowInvocationsTotal = metrics.NewCounter(
+		"ol_slow_invocations_total",
+		"Number of requests that took longer than the slow_ms of their handler.",
+		"handler")
+	slowDumpsSkippedTotal = metrics.NewCounter(
+		"ol_slow_dumps_skipped_total",
+		"Number of stack dumps of slow requests skipped, as one of the handler was running or taken too recently.",
+		"handler")
+)
+
+// dumpLimiter lets stack dumps of the slow requests of a handler be taken
+// one at a time, and no more often than every slow_profile_interval.
+type dumpLimiter struct {
+	mutex   sync.Mutex
+	last    map[string]time.Time // start of the latest dump of each handler
+	running map[string]bool
+}
+
+// begin reports whether a dump of the named handler may be taken now, and
+// if so, records it as running until end is called.
+func (l *dumpLimiter) begin(name string, interval time.Duration) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.running[name] || time.Since(l.last[name]) < interval {
+		return false
+	}
+	if l.last == nil {
+		l.last = make(map[string]time.Time)
+		l.running = make(map[string]bool)
+	}
+	l.last[name] = time.Now()
+	l.running[name] = true
+	return true
+}
+
+func (l *dumpLimiter) end(name string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.running, name)
+}
+
+// watchSlow arms a stack dump of the sandbox of h, taken if the request r,
+// received at the given time, is still running once the slow threshold of the
+// handler passes. The returned function disarms it.
+func (s *Server) watchSlow(h *handler.Handler, r *http.Request, received time.Time) (stop func()) {
+	m := h.Meta()
+	if m == nil || m.Slow_ms == 0 || !m.Profile_slow || !s.config.Allow_profile_slow {
+		return func() {}
+	}
+
+	requestId := logging.RequestId(r)
+	threshold := time.Duration(m.Slow_ms) * time.Millisecond
+	timer := time.AfterFunc(threshold-time.Since(received), func() {
+		s.dumpStacks(h, requestId)
+	})
+	return func() { timer.Stop() }
+}
+
+// reportSlow logs the phases of a request that took longer than the slow
+// threshold of its handler.
+func (s *Server) reportSlow(h *handler.Handler, r *http.Request, t *startup.Timings, d time.Duration) {
+	m := h.Meta()
+	if m == nil || m.Slow_ms == 0 || d < time.Duration(m.Slow_ms)*time.Millisecond {
+		return
+	}
+
+	slowInvocationsTotal.Inc(h.Name())
+	fields := log.Fields{
+		logging.HANDLER: h.Name(),
+		"start":         t.Start,
+		"seconds":       d.Seconds(),
+	}
+	for _, phase := range t.Phases {
+		fields[phase.Name] = phase.Seconds
+	}
+	requestLogger(r).WithFields(fields).Warnf("slow invocation (threshold %d ms)", m.Slow_ms)
+}
+
+// dumpStacks saves the Python stacks of the processes of the sandbox of h to
+// <worker_dir>/profiles/<handler>/<request id>.txt, using py-spy. Request IDs
+// unfit for a file name are replaced by the time of the dump. Dumps are
+// skipped while the limiter of the server does not allow them, and only the
+// latest max_slow_profiles of a handler are kept.
+func (s *Server) dumpStacks(h *handler.Handler, requestId string) {
+	logger := log.WithFields(log.Fields{
+		logging.HANDLER:    h.Name(),
+		logging.REQUEST_ID: requestId,
+	})
+
+	interval := time.Duration(s.config.Slow_profile_interval) * time.Second
+	if !s.dumps.begin(h.Name(), interval) {
+		slowDumpsSkippedTotal.Inc(h.Name())
+		logger.Debug("skipped stack dump of slow invocation")
+		return
+	}
+	defer s.dumps.end(h.Name())
+
+	csb, ok := h.Sandbox().(sandbox.ContainerSandbox)
+	if !ok {
+		logger.Warn("stack dumps are only supported for container sandboxes")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PROFILE_TIMEOUT)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "py-spy", "dump", "--pid", csb.NSPid(), "--subprocesses").CombinedOutput()
+	if err != nil {
+		logger.WithError(err).Warnf("py-spy failed: %s", out)
+		return
+	}
+
+	dir := filepath.Join(s.config.Worker_dir, "profiles", h.Name())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.WithError(err).Warn("could not save stack dump")
+		return
+	}
+	name := requestId
+	if !safeRequestId.MatchString(name) {
+		name = strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	path := filepath.Join(dir, name+".txt")
+	if err := ioutil.WriteFile(path, out, 0644); err != nil {
+		logger.WithError(err).Warn("could not save stack dump")
+		return
+	}
+	logger.WithField("path", path).Info("saved stack dump of slow invocation")
+	rotateDumps(dir, s.config.Max_slow_profiles)
+}
+
+// rotateDumps removes the oldest stack dumps in dir beyond the given number.
+func rotateDumps(dir string, keep int) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) <= keep {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, fi := range files[:len(files)-keep] {
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			log.WithError(err).Warn("could not remove stack dump")
+		}
+	}
+}
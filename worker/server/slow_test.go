@@ -0,0 +1,51 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDumpLimiter(t *testing.T) {
+	var l dumpLimiter
+	if !l.begin("f", time.Hour) {
+		t.Fatal("expected the first dump to be taken")
+	}
+	if l.begin("f", 0) {
+		t.Fatal("expected a second dump to wait for the first")
+	}
+	if !l.begin("g", time.Hour) {
+		t.Fatal("expected dumps of other handlers to be taken")
+	}
+	l.end("f")
+	if l.begin("f", time.Hour) {
+		t.Fatal("expected dumps to be rate-limited")
+	}
+	if !l.begin("f", 0) {
+		t.Fatal("expected a dump once the interval passed")
+	}
+}
+
+func TestRotateDumps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for i, name := range []string{"a", "b", "c", "d"} {
+		path := filepath.Join(dir, name+".txt")
+		ioutil.WriteFile(path, nil, 0644)
+		mtime := now.Add(time.Duration(i) * time.Second)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	rotateDumps(dir, 2)
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 || files[0].Name() != "c.txt" || files[1].Name() != "d.txt" {
+		t.Fatalf("expected the latest two dumps to be kept, got %v", files)
+	}
+}
@@ -0,0 +1,72 @@
+// shutdown package runs the shutdown of the worker as a sequence of stages,
+// so that each subsystem stops after those that depend on it, and no stage
+// can hold up the exit of the process for longer than its timeout.
+package shutdown
+
+import (
+	"context"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Stage is one step of a shutdown.
+type Stage struct {
+	Name    string
+	Timeout time.Duration
+
+	// Run stops a subsystem. It should return once ctx is done, but
+	// a stage that does not is abandoned rather than waited for.
+	Run func(ctx context.Context) error
+}
+
+// Run runs the stages in order. Each gets a context that is done after its
+// timeout, or once force is closed. A stage that fails is logged and the
+// next one started, as is one that has not returned by its timeout, which is
+// left running. Once force is closed, the remaining stages are skipped. Run
+// reports whether every stage completed in time.
+func Run(stages []Stage, force <-chan struct{}) bool {
+	clean := true
+	for i, stage := range stages {
+		logger := log.WithField("stage", stage.Name)
+		select {
+		case <-force:
+			logger.WithField("skipped", len(stages)-i).Warn("shutdown forced, skipping remaining stages")
+			return false
+		default:
+		}
+
+		if err := runStage(stage, force); err != nil {
+			logger.WithError(err).Warn("shutdown stage did not complete")
+			clean = false
+		}
+	}
+	return clean
+}
+
+// runStage runs one stage, returning early if it exceeds its timeout or the
+// shutdown is forced.
+func runStage(stage Stage, force <-chan struct{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stage.Timeout)
+	defer cancel()
+
+	begin := time.Now()
+	log.WithField("stage", stage.Name).Info("shutdown stage starting")
+	done := make(chan error, 1)
+	go func() {
+		done <- stage.Run(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+		log.WithField("stage", stage.Name).WithField("took", time.Since(begin).String()).Info("shutdown stage done")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-force:
+		return context.Canceled
+	}
+}
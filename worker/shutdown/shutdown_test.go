@@ -0,0 +1,66 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	order := []string{}
+	record := func(name string, err error) func(context.Context) error {
+		return func(ctx context.Context) error {
+			order = append(order, name)
+			return err
+		}
+	}
+	stuck := func(ctx context.Context) error {
+		select {} // ignores ctx
+	}
+
+	stages := []Stage{
+		{Name: "first", Timeout: time.Second, Run: record("first", nil)},
+		{Name: "failing", Timeout: time.Second, Run: record("failing", errors.New("oops"))},
+		{Name: "stuck", Timeout: 10 * time.Millisecond, Run: stuck},
+		{Name: "last", Timeout: time.Second, Run: record("last", nil)},
+	}
+	if Run(stages, make(chan struct{})) {
+		t.Fatal("expected unclean shutdown")
+	}
+	if len(order) != 3 || order[0] != "first" || order[2] != "last" {
+		t.Fatalf("stages ran out of order, or not at all: %v", order)
+	}
+
+	order = []string{}
+	if !Run(stages[:1], make(chan struct{})) || len(order) != 1 {
+		t.Fatalf("expected clean shutdown, ran %v", order)
+	}
+}
+
+func TestRunForced(t *testing.T) {
+	force := make(chan struct{})
+	ran := false
+	stages := []Stage{
+		{Name: "stuck", Timeout: time.Hour, Run: func(ctx context.Context) error {
+			close(force)
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		{Name: "skipped", Timeout: time.Hour, Run: func(ctx context.Context) error {
+			ran = true
+			return nil
+		}},
+	}
+
+	done := make(chan bool)
+	go func() { done <- Run(stages, force) }()
+	select {
+	case clean := <-done:
+		if clean || ran {
+			t.Fatalf("forced shutdown ran all stages (clean %v)", clean)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("forced shutdown waited for its stage")
+	}
+}
@@ -0,0 +1,43 @@
+package sysaudit
+
+import "syscall"
+
+// syscallNames names the syscalls that sandboxes in syscall audit mode are
+// expected to record, by number on the architecture of the worker. Others
+// are reported by number.
+var syscallNames = map[int]string{
+	syscall.SYS_SOCKET:          "socket",
+	syscall.SYS_CONNECT:         "connect",
+	syscall.SYS_BIND:            "bind",
+	syscall.SYS_LISTEN:          "listen",
+	syscall.SYS_PTRACE:          "ptrace",
+	syscall.SYS_MOUNT:           "mount",
+	syscall.SYS_UMOUNT2:         "umount2",
+	syscall.SYS_PIVOT_ROOT:      "pivot_root",
+	syscall.SYS_UNSHARE:         "unshare",
+	syscall.SYS_CLONE:           "clone",
+	syscall.SYS_KEXEC_LOAD:      "kexec_load",
+	syscall.SYS_INIT_MODULE:     "init_module",
+	syscall.SYS_DELETE_MODULE:   "delete_module",
+	syscall.SYS_REBOOT:          "reboot",
+	syscall.SYS_SWAPON:          "swapon",
+	syscall.SYS_SWAPOFF:         "swapoff",
+	syscall.SYS_ACCT:            "acct",
+	syscall.SYS_ADD_KEY:         "add_key",
+	syscall.SYS_REQUEST_KEY:     "request_key",
+	syscall.SYS_KEYCTL:          "keyctl",
+	syscall.SYS_PERF_EVENT_OPEN: "perf_event_open",
+	syscall.SYS_SETTIMEOFDAY:    "settimeofday",
+	syscall.SYS_CLOCK_SETTIME:   "clock_settime",
+	syscall.SYS_PERSONALITY:     "personality",
+	syscall.SYS_CHROOT:          "chroot",
+	syscall.SYS_SETHOSTNAME:     "sethostname",
+	syscall.SYS_SETDOMAINNAME:   "setdomainname",
+	syscall.SYS_MKNODAT:         "mknodat",
+	syscall.SYS_SETUID:          "setuid",
+	syscall.SYS_SETGID:          "setgid",
+	syscall.SYS_SETRESUID:       "setresuid",
+	syscall.SYS_SETRESGID:       "setresgid",
+	syscall.SYS_SETGROUPS:       "setgroups",
+	syscall.SYS_CAPSET:          "capset",
+}
@@ -0,0 +1,275 @@
+// sysaudit package records the syscalls that sandboxes in syscall audit mode
+// make outside of what handlers usually need.
+//
+// Such sandboxes run under a seccomp profile (see the sandbox package) that
+// lets those syscalls through, or refuses them, and has the kernel log each
+// one. The Recorder follows the kernel log, attributes each record to a
+// sandbox through the cgroup of the process that made the syscall, and keeps
+// the latest records of every handler for retrieval.
+package sysaudit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+// RETRY_DELAY is how long the Recorder waits before reopening a log it
+// could not read, and between reads of a log with nothing new.
+const RETRY_DELAY = time.Second
+
+var syscallsTotal = metrics.NewCounter(
+	"ol_audited_syscalls_total",
+	"Number of unusual syscalls recorded in sandboxes in syscall audit mode, by handler and action.",
+	"handler", "action")
+
+// audit records are "type=1326" in the kernel log, and "type=SECCOMP" in
+// the audit.log of auditd
+var (
+	seccompRecord = regexp.MustCompile(`type=(1326|SECCOMP)\b`)
+	recordField   = regexp.MustCompile(`(\w+)=("[^"]*"|\S+)`)
+	recordTime    = regexp.MustCompile(`audit\((\d+)\.(\d+):\d+\)`)
+	containerId   = regexp.MustCompile(`[0-9a-f]{64}`)
+)
+
+// seccomp return actions, in the high bits of the code of a record; the
+// low bits are the data of the action, e.g. the errno
+const (
+	SECCOMP_RET_KILL_THREAD  = 0x00000000
+	SECCOMP_RET_KILL_PROCESS = 0x80000000
+	SECCOMP_RET_ERRNO        = 0x00050000
+	SECCOMP_RET_LOG          = 0x7ffc0000
+	SECCOMP_RET_ACTION       = 0xffff0000
+)
+
+var actions = map[uint64]string{
+	SECCOMP_RET_LOG:          "logged",
+	SECCOMP_RET_ERRNO:        "refused",
+	SECCOMP_RET_KILL_PROCESS: "killed",
+	SECCOMP_RET_KILL_THREAD:  "killed",
+}
+
+// Event is one syscall recorded in a sandbox.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Sandbox string    `json:"sandbox"`
+	Pid     int       `json:"pid"`
+	Comm    string    `json:"comm"`
+	Exe     string    `json:"exe,omitempty"`
+	Syscall string    `json:"syscall"` // name, or number if unknown
+	Action  string    `json:"action"`  // logged (allowed), refused or killed
+}
+
+// Recorder keeps the syscalls recorded in the sandboxes it watches, by
+// handler. A nil *Recorder is valid, and records nothing.
+type Recorder struct {
+	path  string
+	limit int
+	proc  string // where procfs is mounted, but in tests
+
+	once      sync.Once
+	mutex     sync.Mutex
+	sandboxes map[string]string   // ID of each watched sandbox -> handler
+	watched   map[string]string   // handler -> ID of its watched sandbox
+	events    map[string][]*Event // by handler, oldest first
+}
+
+// New creates a Recorder reading the syscall_audit_log of the config. The
+// log is only opened once a sandbox is watched.
+func New(conf *config.Config) *Recorder {
+	return &Recorder{
+		path:      conf.Syscall_audit_log,
+		limit:     conf.Syscall_audit_events,
+		proc:      "/proc",
+		sandboxes: make(map[string]string),
+		watched:   make(map[string]string),
+		events:    make(map[string][]*Event),
+	}
+}
+
+// Watch records the syscalls of the sandbox with the given ID for the
+// handler, in place of its previous sandbox.
+func (r *Recorder) Watch(handler, sandboxId string) {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() { go r.follow() })
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sandboxes, r.watched[handler])
+	r.sandboxes[sandboxId] = handler
+	r.watched[handler] = sandboxId
+}
+
+// Unwatch stops recording the syscalls of the sandbox of the handler. The
+// events already recorded are kept.
+func (r *Recorder) Unwatch(handler string) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.sandboxes, r.watched[handler])
+	delete(r.watched, handler)
+}
+
+// Events returns the events recorded for the handler, oldest first.
+func (r *Recorder) Events(handler string) []*Event {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]*Event{}, r.events[handler]...)
+}
+
+// follow reads the log forever, starting from its end.
+func (r *Recorder) follow() {
+	for {
+		if err := r.read(); err != nil {
+			log.WithError(err).WithField("log", r.path).Error("could not read syscall audit records")
+		}
+		time.Sleep(RETRY_DELAY)
+	}
+}
+
+// read reads new lines from the log until it fails.
+func (r *Recorder) read() error {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(f)
+	partial := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// a file, not the kernel log; wait for it to grow
+			partial += line
+			time.Sleep(RETRY_DELAY)
+			continue
+		} else if pe, ok := err.(*os.PathError); ok && pe.Err == syscall.EPIPE {
+			// records of the kernel log were overwritten before
+			// they could be read
+			continue
+		} else if err != nil {
+			return err
+		}
+		r.record(partial + line)
+		partial = ""
+	}
+}
+
+// record adds the event of the line to the events of its handler, if it
+// is a seccomp record of a watched sandbox.
+func (r *Recorder) record(line string) {
+	e := parse(line)
+	if e == nil {
+		return
+	}
+	e.Sandbox = r.sandboxOf(e.Pid)
+	if e.Sandbox == "" {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	handler, ok := r.sandboxes[e.Sandbox]
+	if !ok {
+		return
+	}
+	events := append(r.events[handler], e)
+	if len(events) > r.limit {
+		events = events[len(events)-r.limit:]
+	}
+	r.events[handler] = events
+	syscallsTotal.Inc(handler, e.Action)
+}
+
+// sandboxOf returns the ID of the container of the process, from the path
+// of its cgroup, or "" if it is not in one (or is gone).
+func (r *Recorder) sandboxOf(pid int) string {
+	buf, err := ioutil.ReadFile(filepath.Join(r.proc, strconv.Itoa(pid), "cgroup"))
+	if err != nil {
+		return ""
+	}
+	return containerId.FindString(string(buf))
+}
+
+// parse returns the event of a seccomp record of the kernel or audit log, or
+// nil if the line is not one.
+func parse(line string) *Event {
+	if !seccompRecord.MatchString(line) {
+		return nil
+	}
+	fields := make(map[string]string)
+	for _, match := range recordField.FindAllStringSubmatch(line, -1) {
+		fields[match[1]] = match[2]
+	}
+
+	pid, err := strconv.Atoi(fields["pid"])
+	if err != nil {
+		return nil
+	}
+	number, err := strconv.Atoi(fields["syscall"])
+	if err != nil {
+		return nil
+	}
+	code, err := strconv.ParseUint(fields["code"], 0, 32)
+	if err != nil {
+		return nil
+	}
+	// syscalls reported as not implemented (clone3) are retried in
+	// another way, and are not interesting
+	if code == SECCOMP_RET_ERRNO|uint64(syscall.ENOSYS) {
+		return nil
+	}
+
+	e := &Event{
+		Time:    time.Now(),
+		Pid:     pid,
+		Comm:    unquote(fields["comm"]),
+		Exe:     unquote(fields["exe"]),
+		Syscall: strconv.Itoa(number),
+		Action:  actions[code&SECCOMP_RET_ACTION],
+	}
+	if m := recordTime.FindStringSubmatch(line); m != nil {
+		sec, _ := strconv.ParseInt(m[1], 10, 64)
+		ms, _ := strconv.ParseInt(m[2], 10, 64)
+		e.Time = time.Unix(sec, ms*int64(time.Millisecond))
+	}
+	if name, ok := syscallNames[number]; ok {
+		e.Syscall = name
+	}
+	if e.Action == "" {
+		e.Action = fmt.Sprintf("%#x", code)
+	}
+	return e
+}
+
+// unquote strips the quotes of a field; unquoted values are hex-encoded by
+// the kernel, and kept as they are.
+func unquote(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,150 @@
+package sysaudit
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+const (
+	sandboxA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	sandboxB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+// kmsgRecord returns a seccomp record of the kernel log.
+func kmsgRecord(pid, number int, code uint64) string {
+	return fmt.Sprintf(`6,1234,5678,-;audit: type=1326 audit(1700000000.250:42): auid=4294967295 uid=0 gid=0 ses=4294967295 pid=%d comm="python3" exe="/usr/bin/python3.8" sig=0 arch=c000003e syscall=%d compat=0 ip=0x7f0000000000 code=%#x`,
+		pid, number, code)
+}
+
+func TestParse(t *testing.T) {
+	e := parse(kmsgRecord(7, syscall.SYS_CONNECT, SECCOMP_RET_LOG))
+	if e == nil {
+		t.Fatal("kernel log record not parsed")
+	}
+	if e.Pid != 7 || e.Comm != "python3" || e.Exe != "/usr/bin/python3.8" || e.Syscall != "connect" || e.Action != "logged" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+	if !e.Time.Equal(time.Unix(1700000000, 250*int64(time.Millisecond))) {
+		t.Fatalf("unexpected time %v", e.Time)
+	}
+
+	// auditd writes the same fields
+	line := `type=SECCOMP msg=audit(1700000000.250:42): auid=4294967295 uid=0 gid=0 ses=4294967295 pid=8 comm="sh" exe="/bin/dash" sig=0 arch=c000003e syscall=9999 compat=0 ip=0x7f0000000000 code=0x50001`
+	if e := parse(line); e == nil || e.Pid != 8 || e.Syscall != "9999" || e.Action != "refused" {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	for _, line := range []string{
+		"6,1235,5679,-;eth0: link up",
+		kmsgRecord(7, syscall.SYS_CLONE, SECCOMP_RET_ERRNO|uint64(syscall.ENOSYS)),
+	} {
+		if e := parse(line); e != nil {
+			t.Fatalf("expected %q to be ignored, got %+v", line, e)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysaudit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// processes 1 and 2 are in the sandboxes, 3 is not in a container
+	cgroups := map[int]string{
+		1: "12:memory:/docker/" + sandboxA + "\n",
+		2: "12:memory:/openlambda/acme/" + sandboxB + "\n",
+		3: "12:memory:/user.slice\n",
+	}
+	for pid, cgroup := range cgroups {
+		os.MkdirAll(filepath.Join(dir, fmt.Sprint(pid)), 0755)
+		ioutil.WriteFile(filepath.Join(dir, fmt.Sprint(pid), "cgroup"), []byte(cgroup), 0644)
+	}
+
+	r := New(&config.Config{Syscall_audit_log: filepath.Join(dir, "kmsg"), Syscall_audit_events: 2})
+	r.proc = dir
+	r.Watch("f", sandboxA)
+	r.Watch("g", sandboxB)
+	for _, pid := range []int{1, 2, 3, 4} {
+		r.record(kmsgRecord(pid, syscall.SYS_SOCKET, SECCOMP_RET_LOG))
+	}
+	if events := r.Events("f"); len(events) != 1 || events[0].Sandbox != sandboxA || events[0].Syscall != "socket" {
+		t.Fatalf("unexpected events of f: %+v", events)
+	}
+	if events := r.Events("g"); len(events) != 1 || events[0].Pid != 2 {
+		t.Fatalf("unexpected events of g: %+v", events)
+	}
+
+	// only the latest events are kept
+	r.record(kmsgRecord(1, syscall.SYS_CONNECT, SECCOMP_RET_LOG))
+	r.record(kmsgRecord(1, syscall.SYS_PTRACE, SECCOMP_RET_ERRNO|1))
+	if events := r.Events("f"); len(events) != 2 || events[0].Syscall != "connect" || events[1].Action != "refused" {
+		t.Fatalf("expected the 2 latest events, got %+v", events)
+	}
+
+	// a new sandbox replaces the old one, and events are kept after
+	// Unwatch
+	r.Watch("f", "new")
+	r.record(kmsgRecord(1, syscall.SYS_BIND, SECCOMP_RET_LOG))
+	r.Unwatch("g")
+	r.record(kmsgRecord(2, syscall.SYS_BIND, SECCOMP_RET_LOG))
+	if len(r.Events("f")) != 2 || len(r.Events("g")) != 1 {
+		t.Fatal("events recorded for sandboxes no longer watched")
+	}
+
+	var nilRecorder *Recorder
+	nilRecorder.Watch("f", sandboxA)
+	if nilRecorder.Events("f") != nil {
+		t.Fatal("nil Recorder has events")
+	}
+}
+
+func TestFollowFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sysaudit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "1"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "1", "cgroup"), []byte("1:cpu:/docker/"+sandboxA+"\n"), 0644)
+
+	// records already in the log are skipped
+	path := filepath.Join(dir, "audit.log")
+	old := strings.Replace(kmsgRecord(1, syscall.SYS_MOUNT, SECCOMP_RET_LOG), "type=1326", "type=SECCOMP", 1)
+	ioutil.WriteFile(path, []byte(old+"\n"), 0644)
+
+	r := New(&config.Config{Syscall_audit_log: path, Syscall_audit_events: 10})
+	r.proc = dir
+	r.Watch("f", sandboxA)
+	time.Sleep(100 * time.Millisecond)
+
+	// lines may be written in pieces
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	line := kmsgRecord(1, syscall.SYS_CONNECT, SECCOMP_RET_LOG) + "\n"
+	f.WriteString(line[:40])
+	time.Sleep(RETRY_DELAY + 100*time.Millisecond)
+	f.WriteString(line[40:])
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if events := r.Events("f"); len(events) > 0 {
+			if len(events) != 1 || events[0].Syscall != "connect" {
+				t.Fatalf("unexpected events %+v", events)
+			}
+			return
+		}
+	}
+	t.Fatal("record appended to the log not picked up")
+}
@@ -0,0 +1,198 @@
+// tlsutil package secures the connections between the components of a
+// cluster (workers, balancer, registry) with mutual TLS: each presents a
+// certificate from the config, and accepts peers whose certificates are
+// signed by the configured CAs.
+//
+// The certificate, key and CA files are checked for changes at most every
+// reload interval, when connections are made, and re-read if they changed,
+// so certificates can be rotated without restarting anything. Files that
+// fail to load are logged, and the previous ones kept in use.
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+	"github.com/open-lambda/open-lambda/worker/metrics"
+)
+
+var reloadsTotal = metrics.NewCounter(
+	"ol_tls_reloads_total",
+	"Number of times rotated TLS certificates were loaded, by result.",
+	"result")
+
+// Reloader holds the certificate and CAs of a component, reloading them
+// when their files change. A nil *Reloader is valid, and means plain HTTP.
+type Reloader struct {
+	conf     config.TLSConfig
+	interval time.Duration
+
+	mutex   sync.Mutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	stamp   string // modification times and sizes of the files loaded
+	checked time.Time
+}
+
+// New loads the certificate and CAs of the config, or returns nil if TLS is
+// not configured.
+func New(conf *config.TLSConfig) (*Reloader, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	r := &Reloader{conf: *conf, interval: time.Duration(conf.Reload_interval) * time.Second}
+	stamp, err := r.fingerprint()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(stamp); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// fingerprint returns the modification times and sizes of the files, which change
+// when they are rotated.
+func (r *Reloader) fingerprint() (string, error) {
+	stamp := ""
+	for _, name := range []string{r.conf.Cert, r.conf.Key, r.conf.Ca} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		stamp += fmt.Sprintf("%s:%d:%d;", name, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return stamp, nil
+}
+
+// load reads the files. The caller must hold the mutex, unless r is not
+// shared yet.
+func (r *Reloader) load(stamp string) error {
+	cert, err := tls.LoadX509KeyPair(r.conf.Cert, r.conf.Key)
+	if err != nil {
+		return fmt.Errorf("could not load TLS certificate: %v", err)
+	}
+	pem, err := ioutil.ReadFile(r.conf.Ca)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no CA certificates found in %s", r.conf.Ca)
+	}
+	r.cert, r.pool, r.stamp = &cert, pool, stamp
+	return nil
+}
+
+// current returns the certificate and CAs in use, reloading them first if
+// the interval passed since the last check and the files changed.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if time.Since(r.checked) >= r.interval {
+		r.checked = time.Now()
+		if err := r.reload(); err != nil {
+			reloadsTotal.Inc("error")
+			log.WithError(err).Error("could not reload TLS certificates, keeping the previous ones")
+		}
+	}
+	return r.cert, r.pool
+}
+
+// reload loads the files if they changed. The caller must hold the mutex.
+func (r *Reloader) reload() error {
+	stamp, err := r.fingerprint()
+	if err != nil || stamp == r.stamp {
+		return err
+	}
+	if err := r.load(stamp); err != nil {
+		return err
+	}
+	reloadsTotal.Inc("ok")
+	log.WithField("cert", r.conf.Cert).Info("reloaded TLS certificates")
+	return nil
+}
+
+// ServerConfig returns the TLS config of a server that requires clients to
+// present certificates signed by the CAs.
+func (r *Reloader) ServerConfig() *tls.Config {
+	if r == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns the TLS config of a client that presents its
+// certificate, and accepts servers whose certificates are signed by the CAs
+// for the name it connects to.
+func (r *Reloader) ClientConfig() *tls.Config {
+	if r == nil {
+		return nil
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		// the CAs may be rotated, so the server is verified against the
+		// current ones rather than a fixed RootCAs
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return fmt.Errorf("server presented no certificate")
+			}
+			_, pool := r.current()
+			opts := x509.VerifyOptions{
+				DNSName:       cs.ServerName,
+				Roots:         pool,
+				Intermediates: x509.NewCertPool(),
+			}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		},
+	}
+}
+
+// Client returns an HTTP client that connects with the ClientConfig.
+func (r *Reloader) Client(timeout time.Duration) *http.Client {
+	if r == nil {
+		return &http.Client{Timeout: timeout}
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{TLSClientConfig: r.ClientConfig()},
+	}
+}
+
+// Scheme returns the scheme of URLs to components secured by r.
+func (r *Reloader) Scheme() string {
+	if r == nil {
+		return "http"
+	}
+	return "https"
+}
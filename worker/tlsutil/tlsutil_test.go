@@ -0,0 +1,161 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+var serial int64
+
+// issue creates a certificate for 127.0.0.1 signed by parent (self-signed
+// if nil), and returns it with its key.
+func issue(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial++
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// write writes a certificate signed by a CA, its key and the CA to dir, and
+// returns the config locating them.
+func write(t *testing.T, dir string, ca *x509.Certificate, caKey *ecdsa.PrivateKey) *config.TLSConfig {
+	cert, key := issue(t, ca, caKey, false)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := &config.TLSConfig{
+		Cert: filepath.Join(dir, "cert.pem"),
+		Key:  filepath.Join(dir, "key.pem"),
+		Ca:   filepath.Join(dir, "ca.pem"),
+	}
+	files := map[string]*pem.Block{
+		conf.Cert: {Type: "CERTIFICATE", Bytes: cert.Raw},
+		conf.Key:  {Type: "EC PRIVATE KEY", Bytes: keyDer},
+		conf.Ca:   {Type: "CERTIFICATE", Bytes: ca.Raw},
+	}
+	// rotated files must look changed, even within the resolution of
+	// modification times
+	later := time.Now().Add(time.Duration(serial) * time.Second)
+	for name, block := range files {
+		if err := ioutil.WriteFile(name, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(name, later, later)
+	}
+	return conf
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	serverDir, clientDir := filepath.Join(dir, "server"), filepath.Join(dir, "client")
+	os.Mkdir(serverDir, 0700)
+	os.Mkdir(clientDir, 0700)
+
+	if r, err := New(nil); err != nil || r != nil || r.Scheme() != "http" || r.ServerConfig() != nil {
+		t.Fatal("expected nil Reloader without TLS config")
+	}
+
+	ca, caKey := issue(t, nil, nil, true)
+	server, err := New(write(t, serverDir, ca, caKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := New(write(t, clientDir, ca, caKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	ts.TLS = server.ServerConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	get := func(c *http.Client) error {
+		resp, err := c.Get(ts.URL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if c, ok := c.Transport.(*http.Transport); ok {
+			c.CloseIdleConnections()
+		}
+		return nil
+	}
+
+	if err := get(client.Client(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	anonymous := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	if err := get(anonymous); err == nil {
+		t.Fatal("expected client without certificate to be refused")
+	}
+
+	// rotate to a new CA on both sides; a client left on the old one is
+	// refused
+	stale, err := New(write(t, dir, ca, caKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca2, caKey2 := issue(t, nil, nil, true)
+	write(t, serverDir, ca2, caKey2)
+	write(t, clientDir, ca2, caKey2)
+	if err := get(client.Client(time.Second)); err != nil {
+		t.Fatalf("rotated certificates not picked up: %v", err)
+	}
+	if err := get(stale.Client(time.Second)); err == nil {
+		t.Fatal("expected client with a certificate of the old CA to be refused")
+	}
+
+	// files that fail to load keep the previous ones in use
+	ioutil.WriteFile(filepath.Join(clientDir, "key.pem"), []byte("garbage"), 0600)
+	if err := get(client.Client(time.Second)); err != nil {
+		t.Fatalf("broken rotation not ignored: %v", err)
+	}
+}
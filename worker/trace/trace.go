@@ -0,0 +1,86 @@
+// trace package implements the W3C Trace Context headers, so requests
+// passing through the worker stay part of the caller's trace.
+//
+// See https://www.w3.org/TR/trace-context/.
+package trace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	TRACEPARENT_HEADER = "traceparent"
+	TRACESTATE_HEADER  = "tracestate"
+)
+
+// Context identifies a span of a trace.
+type Context struct {
+	TraceId string // 32 hex digits
+	SpanId  string // 16 hex digits
+	Flags   string // 2 hex digits; "01" if sampled
+	State   string // vendor-specific tracestate, passed through unchanged
+}
+
+// Parse reads the trace context of a traceparent header value.
+func Parse(traceparent string) (*Context, error) {
+	// later versions may append fields, which are ignored
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	version := parts[0]
+	if !isHex(version, 2) || version == "ff" || len(parts) < 4 || version == "00" && len(parts) != 4 {
+		return nil, fmt.Errorf("malformed traceparent %q", traceparent)
+	}
+	c := &Context{TraceId: parts[1], SpanId: parts[2], Flags: parts[3]}
+	if !isHex(c.TraceId, 32) || !isHex(c.SpanId, 16) || !isHex(c.Flags, 2) {
+		return nil, fmt.Errorf("malformed traceparent %q", traceparent)
+	}
+	if c.TraceId == strings.Repeat("0", 32) || c.SpanId == strings.Repeat("0", 16) {
+		return nil, fmt.Errorf("invalid traceparent %q", traceparent)
+	}
+	return c, nil
+}
+
+// FromRequest returns a new span that is a child of the trace context of r,
+// or the root of a new trace if r carries none (or an invalid one).
+func FromRequest(r *http.Request) *Context {
+	parent, err := Parse(r.Header.Get(TRACEPARENT_HEADER))
+	if err != nil {
+		return &Context{TraceId: randomHex(16), SpanId: randomHex(8), Flags: "01"}
+	}
+	return &Context{
+		TraceId: parent.TraceId,
+		SpanId:  randomHex(8),
+		Flags:   parent.Flags,
+		State:   r.Header.Get(TRACESTATE_HEADER),
+	}
+}
+
+// Traceparent formats c as a traceparent header value.
+func (c *Context) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%s", c.TraceId, c.SpanId, c.Flags)
+}
+
+// Inject sets the trace headers of r so the receiver joins the span c.
+func (c *Context) Inject(r *http.Request) {
+	r.Header.Set(TRACEPARENT_HEADER, c.Traceparent())
+	if c.State != "" {
+		r.Header.Set(TRACESTATE_HEADER, c.State)
+	}
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+func randomHex(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
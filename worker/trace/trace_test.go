@@ -0,0 +1,37 @@
+package trace
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestChildOfRequest(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/runLambda/hello", nil)
+	r.Header.Set(TRACEPARENT_HEADER, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set(TRACESTATE_HEADER, "congo=t61rcWkgMzE")
+
+	c := FromRequest(r)
+	if c.TraceId != "4bf92f3577b34da6a3ce929d0e0e4736" || c.Flags != "01" || c.State != "congo=t61rcWkgMzE" {
+		t.Fatalf("unexpected context %+v", c)
+	}
+	if c.SpanId == "00f067aa0ba902b7" {
+		t.Fatal("child should have a new span id")
+	}
+	if _, err := Parse(c.Traceparent()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewTrace(t *testing.T) {
+	for _, tp := range []string{"", "garbage", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		r, _ := http.NewRequest("POST", "/runLambda/hello", nil)
+		r.Header.Set(TRACEPARENT_HEADER, tp)
+		c := FromRequest(r)
+		if _, err := Parse(c.Traceparent()); err != nil {
+			t.Fatalf("%q: %v", tp, err)
+		}
+		if c.State != "" {
+			t.Fatalf("%q: unexpected tracestate %q", tp, c.State)
+		}
+	}
+}
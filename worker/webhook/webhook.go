@@ -0,0 +1,200 @@
+// webhook package notifies external systems of lifecycle events of a worker
+// by POSTing JSON to configured URLs.
+//
+// Deliveries are asynchronous and retried with exponential backoff. If a
+// secret is configured, the body is signed with HMAC-SHA256 and the hex
+// digest is sent in the SIGNATURE_HEADER as "sha256=<digest>".
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+// Event types.
+const (
+	HANDLER_DEPLOYED = "handler.deployed"
+	HANDLER_EVICTED  = "handler.evicted"
+	SANDBOX_CRASHED  = "sandbox.crashed"
+	WORKER_DRAINING  = "worker.draining"
+	HANDLER_ANOMALY  = "handler.anomaly"
+	CIRCUIT_OPENED   = "handler.circuit_opened"
+	DEPLOY_FAILED    = "handler.deploy_failed"
+	CODE_VETOED      = "handler.code_vetoed"
+)
+
+const (
+	SIGNATURE_HEADER = "X-OL-Signature"
+	EVENT_HEADER     = "X-OL-Event"
+
+	// number of undelivered events kept per hook before new ones are dropped
+	QUEUE_SIZE = 256
+)
+
+// Event is the JSON body of a notification.
+type Event struct {
+	Type    string            `json:"type"`
+	Time    time.Time         `json:"time"`
+	Worker  string            `json:"worker"`
+	Handler string            `json:"handler,omitempty"`
+	Detail  map[string]string `json:"detail,omitempty"`
+}
+
+// hook delivers events to one URL.
+type hook struct {
+	n      *Notifier
+	conf   config.Webhook
+	events map[string]bool // empty means all events
+	queue  chan *Event
+	client *http.Client
+}
+
+// Notifier fans events out to all configured hooks. A nil *Notifier is valid
+// and drops every event.
+type Notifier struct {
+	worker  string
+	hooks   []*hook
+	pending sync.WaitGroup
+}
+
+// NewNotifier creates a Notifier for the webhooks of the config, or returns
+// nil if there are none.
+func NewNotifier(conf *config.Config) *Notifier {
+	if len(conf.Webhooks) == 0 {
+		return nil
+	}
+
+	n := &Notifier{worker: conf.WorkerId()}
+	for _, wc := range conf.Webhooks {
+		h := &hook{
+			n:      n,
+			conf:   wc,
+			events: make(map[string]bool),
+			queue:  make(chan *Event, QUEUE_SIZE),
+			client: &http.Client{Timeout: 10 * time.Second},
+		}
+		for _, e := range wc.Events {
+			h.events[e] = true
+		}
+		n.hooks = append(n.hooks, h)
+		go h.run()
+	}
+	return n
+}
+
+// Notify queues an event of the given type for delivery. It never blocks; if
+// the queue of a hook is full, the event is dropped for that hook.
+func (n *Notifier) Notify(typ, handler string, detail map[string]string) {
+	if n == nil {
+		return
+	}
+
+	e := &Event{
+		Type:    typ,
+		Time:    time.Now(),
+		Worker:  n.worker,
+		Handler: handler,
+		Detail:  detail,
+	}
+	for _, h := range n.hooks {
+		if len(h.events) > 0 && !h.events[typ] {
+			continue
+		}
+		n.pending.Add(1)
+		select {
+		case h.queue <- e:
+		default:
+			n.pending.Done()
+			log.WithField("url", h.conf.Url).Warnf("webhook queue full, drop %s event", typ)
+		}
+	}
+}
+
+// Flush waits until all queued events are delivered (or given up on), or
+// timeout expires.
+func (n *Notifier) Flush(timeout time.Duration) {
+	if n == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		n.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// run delivers the events of the queue one at a time, in order.
+func (h *hook) run() {
+	for e := range h.queue {
+		h.deliver(e)
+		h.n.pending.Done()
+	}
+}
+
+// deliver sends one event, retrying with exponential backoff.
+func (h *hook) deliver(e *Event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.WithError(err).Error("could not marshal webhook event")
+		return
+	}
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := h.send(e.Type, body)
+		if err == nil {
+			return
+		}
+		if attempt >= h.conf.Max_retries {
+			log.WithError(err).WithField("url", h.conf.Url).Errorf("give up delivering %s event", e.Type)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// send POSTs body to the hook once.
+func (h *hook) send(typ string, body []byte) error {
+	req, err := http.NewRequest("POST", h.conf.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EVENT_HEADER, typ)
+	if h.conf.Secret != "" {
+		req.Header.Set(SIGNATURE_HEADER, "sha256="+Sign(h.conf.Secret, body))
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body under secret.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
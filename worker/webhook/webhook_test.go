@@ -0,0 +1,69 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/open-lambda/open-lambda/worker/config"
+)
+
+func TestSignedDeliveryWithRetry(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	events := []Event{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(SIGNATURE_HEADER) != "sha256="+Sign("secret", body) {
+			t.Errorf("bad signature %q", r.Header.Get(SIGNATURE_HEADER))
+		}
+		var e Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Error(err)
+		}
+		events = append(events, e)
+	}))
+	defer ts.Close()
+
+	conf := &config.Config{
+		Worker_id: "w1",
+		Webhooks: []config.Webhook{{
+			Url:         ts.URL,
+			Secret:      "secret",
+			Events:      []string{HANDLER_EVICTED},
+			Max_retries: 1,
+		}},
+	}
+	n := NewNotifier(conf)
+	n.Notify(HANDLER_DEPLOYED, "hello", nil)
+	n.Notify(HANDLER_EVICTED, "hello", nil)
+	n.Flush(5 * time.Second)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	}
+	if len(events) != 1 || events[0].Type != HANDLER_EVICTED || events[0].Worker != "w1" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Notify(HANDLER_DEPLOYED, "hello", nil)
+	n.Flush(time.Second)
+}